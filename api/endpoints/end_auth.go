@@ -1,15 +1,19 @@
 package endpoints
 
 import (
+	"dapp/api/middlewares"
 	"dapp/lib"
 	"dapp/repo"
 	"dapp/schema"
 	"dapp/schema/dto"
-	"dapp/schema/mapper"
-	"dapp/schema/models"
 	"dapp/service"
 	"dapp/service/auth"
 	"dapp/service/utils"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/kataras/iris/v12"
@@ -21,9 +25,13 @@ type HAuth struct {
 	response  *utils.SvcResponse
 	appConf   *utils.SvcConfig
 	providers map[string]bool
+	policy    *auth.Policy
 	validate  *validator.Validate // handle validations for structs and individual fields based on tags
 }
 
+// oidcStateCookie cookie binding the state of a single sign-on login to the browser that started it
+const oidcStateCookie = "oidc_state"
+
 // NewAuthHandler create and register the authentication handlers for the App. For the moment, all the
 // auth handlers emulates the Oauth2 "password" grant-type using the "client-credentials" flow.
 //
@@ -31,26 +39,50 @@ type HAuth struct {
 //
 // - MdwAuthChecker [*context.Handler] ~ Authentication checker middleware
 //
+// - policy [*auth.Policy] ~ Permissions of the roles
+//
 // - svcR [*utils.SvcResponse] ~ GrantIntentResponse service instance
 //
 // - svcC [utils.SvcConfig] ~ Configuration service instance
-func NewAuthHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR *utils.SvcResponse, svcC *utils.SvcConfig, validate *validator.Validate) HAuth { // --- VARS SETUP ---
-	h := HAuth{svcR, svcC, make(map[string]bool), validate}
+func NewAuthHandler(app *iris.Application, mdwAuthChecker *context.Handler, policy *auth.Policy, svcR *utils.SvcResponse, svcC *utils.SvcConfig, validate *validator.Validate) HAuth { // --- VARS SETUP ---
+	h := HAuth{svcR, svcC, make(map[string]bool), policy, validate}
+	require := middlewares.NewPolicyMiddleware(policy)
 	// filling providers
-	h.providers["dapp_provider"] = true
+	h.providers[schema.ProviderDapp] = true
+	if svcC.LDAPEnabled {
+		h.providers[schema.ProviderLDAP] = true
+	}
+	if svcC.OIDCEnabled {
+		h.providers[schema.ProviderOIDC] = true
+	}
 
 	repoUser := repo.NewRepoUser(svcC)
-	svcAuth := auth.NewSvcAuthentication(h.providers, repoUser) // instantiating authentication Service
-	svcUser := service.NewSvcUserReqs(repoUser)
+	svcAuth := auth.NewSvcAuthentication(h.providers, repoUser, svcC) // instantiating authentication Service
+	svcToken := auth.NewSvcToken(repoUser, repo.NewRepoBlocklist(svcC), svcC)
+	svcUser := service.NewSvcUserReqs(repoUser, svcC)
+	svcTOTP := auth.NewSvcTOTP(repoUser, svcC)
+	mailer := utils.NewMailer(svcC)
+	svcPassword := auth.NewSvcPassword(repoUser, svcToken, mailer, svcC)
+	svcInvitation := auth.NewSvcInvitation(repoUser, mailer, svcC)
+	svcUserImport := service.NewSvcUserImport(repoUser, svcUser, svcInvitation, validate, svcC)
+	svcRole := service.NewSvcRole(repoUser, policy)
 
 	// --- DEPENDENCIES ---
 	hero.Register(depObtainUserCred)
 	hero.Register(lib.DepObtainUserDid)
+	hero.Register(lib.DepObtainTokenData)
 	hero.Register(svcAuth) // as an alternative, we can put these dependencies as property in the struct HAuth, as we are doing in the rest of the endpoints / handlers
 	hero.Register(svcUser)
+	hero.Register(svcToken)
+	hero.Register(svcTOTP)
+	hero.Register(svcPassword)
+	hero.Register(svcInvitation)
+	hero.Register(svcUserImport)
+	hero.Register(svcRole)
 	hero.Register(repoUser)
 
 	app.Get("/status", h.statusServer)
+	app.Get("/.well-known/jwks.json", h.jwks) // public keys, for the services verifying our access tokens
 
 	// Simple group: v1
 	v1 := app.Party("/api/v1")
@@ -63,6 +95,13 @@ func NewAuthHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR
 			// --- REGISTERING ENDPOINTS ---
 			// authRouter.Post("/<provider>")	// provider is the auth provider to be used.
 			authRouter.Post("/", hero.Handler(h.authIntent))
+			authRouter.Post("/refresh", hero.Handler(h.refreshToken))
+			authRouter.Get("/oidc/login", hero.Handler(h.oidcLogin))
+			authRouter.Get("/oidc/callback", hero.Handler(h.oidcCallback))
+			authRouter.Post("/oidc/otp", hero.Handler(h.oidcSecondFactor))
+			authRouter.Post("/password/forgot", hero.Handler(h.forgotPassword))
+			authRouter.Post("/password/reset", hero.Handler(h.resetPassword))
+			authRouter.Post("/activate", hero.Handler(h.activateAccount))
 		}
 
 		// registering protected router
@@ -74,6 +113,18 @@ func NewAuthHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR
 			// --- REGISTERING ENDPOINTS ---
 			guardAuthRouter.Get("/logout", h.logout)
 			guardAuthRouter.Get("/profile", hero.Handler(h.getUserProfile))
+			guardAuthRouter.Get("/permissions", hero.Handler(h.getPermissions))
+			guardAuthRouter.Put("/password", hero.Handler(h.changePassword))
+
+			// sessions of the logged user
+			guardAuthRouter.Get("/sessions", hero.Handler(h.getSessions))
+			guardAuthRouter.Delete("/sessions", hero.Handler(h.deleteSessions))
+			guardAuthRouter.Delete("/sessions/{session:string}", hero.Handler(h.deleteSession))
+
+			// TOTP second factor of the logged user
+			guardAuthRouter.Post("/totp", hero.Handler(h.enrolTOTP))
+			guardAuthRouter.Post("/totp/confirm", hero.Handler(h.confirmTOTP))
+			guardAuthRouter.Post("/totp/recovery_codes", hero.Handler(h.regenerateRecoveryCodes))
 		}
 
 		// User management CRUD
@@ -82,13 +133,28 @@ func NewAuthHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR
 			// --- GROUP / PARTY MIDDLEWARES ---
 			guardUserManagerRouter.Use(*mdwAuthChecker) // registering access token checker middleware
 
-			guardUserManagerRouter.Get("", hero.Handler(h.getUsers))
-			guardUserManagerRouter.Post("", hero.Handler(h.postUser))
-			guardUserManagerRouter.Get("/{id:string}", hero.Handler(h.getUserById))
-			guardUserManagerRouter.Put("/{id:string}", hero.Handler(h.putUserById))
-			guardUserManagerRouter.Delete("/{id:string}", hero.Handler(h.deleteUserById))
-			guardUserManagerRouter.Get("/roles", hero.Handler(h.getRoles))
-			guardUserManagerRouter.Put("/invalidate_user/{id:string}", hero.Handler(h.invalidateUser))
+			guardUserManagerRouter.Get("", require(schema.PermUsersRead), hero.Handler(h.getUsers))
+			guardUserManagerRouter.Post("", require(schema.PermUsersWrite), hero.Handler(h.postUser))
+			guardUserManagerRouter.Post("/invite", require(schema.PermUsersWrite), hero.Handler(h.inviteUser))
+			guardUserManagerRouter.Post("/import", require(schema.PermUsersWrite), hero.Handler(h.importUsers))
+			guardUserManagerRouter.Get("/export", require(schema.PermUsersRead), hero.Handler(h.exportUsers))
+			guardUserManagerRouter.Put("/resend_invitation/{id:string}", require(schema.PermUsersWrite), hero.Handler(h.resendInvitation))
+			guardUserManagerRouter.Get("/{id:string}", require(schema.PermUsersRead), hero.Handler(h.getUserById))
+			guardUserManagerRouter.Put("/{id:string}", require(schema.PermUsersWrite), hero.Handler(h.putUserById))
+			guardUserManagerRouter.Delete("/{id:string}", require(schema.PermUsersWrite), hero.Handler(h.deleteUserById))
+			guardUserManagerRouter.Post("/{id:string}/restore", require(schema.PermUsersWrite), hero.Handler(h.restoreUserById))
+			guardUserManagerRouter.Get("/{id:string}/history", require(schema.PermUsersRead), hero.Handler(h.getUserHistory))
+			guardUserManagerRouter.Get("/roles", require(schema.PermRolesRead), hero.Handler(h.getRoles))
+			guardUserManagerRouter.Get("/roles/{label:string}", require(schema.PermRolesRead), hero.Handler(h.getRole))
+			guardUserManagerRouter.Post("/roles", require(schema.PermRolesWrite), hero.Handler(h.postRole))
+			guardUserManagerRouter.Put("/roles/{label:string}", require(schema.PermRolesWrite), hero.Handler(h.putRole))
+			guardUserManagerRouter.Delete("/roles/{label:string}", require(schema.PermRolesWrite), hero.Handler(h.deleteRole))
+			guardUserManagerRouter.Put("/invalidate_user/{id:string}", require(schema.PermUsersWrite), hero.Handler(h.invalidateUser))
+			guardUserManagerRouter.Put("/revoke_tokens/{id:string}", require(schema.PermUsersWrite), hero.Handler(h.revokeUserTokens))
+			guardUserManagerRouter.Get("/sessions/{id:string}", require(schema.PermUsersRead), hero.Handler(h.getUserSessions))
+			guardUserManagerRouter.Delete("/sessions/{id:string}/{session:string}", require(schema.PermUsersWrite), hero.Handler(h.deleteUserSession))
+			guardUserManagerRouter.Put("/unlock/{id:string}", require(schema.PermUsersWrite), hero.Handler(h.unlockUser))
+			guardUserManagerRouter.Put("/reset_totp/{id:string}", require(schema.PermUsersWrite), hero.Handler(h.resetUserTOTP))
 		}
 	}
 
@@ -104,15 +170,21 @@ func NewAuthHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR
 // @Accept multipart/form-data
 // @Produce json
 // @Param 	credential 	body 	dto.UserCredIn 	true	"User Login Credential"
-// @Success 200 "OK"
+// @Success 200 {object} dto.TokenPair "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 401 {object} dto.Problem "err.otp_required"
 // @Failure 400 {object} dto.Problem "err.wrong_auth_provider"
+// @Failure 400 {object} dto.Problem "err.invalid_scope"
+// @Failure 429 {object} dto.Problem "err.too_many_login_attempts"
 // @Failure 504 {object} dto.Problem "err.network"
 // @Failure 500 {object} dto.Problem "err.json_parse"
 // @Router /auth [post]
-func (h HAuth) authIntent(ctx iris.Context, uCred *dto.UserCredIn, svcAuth *auth.SvcAuthentication) {
-	// using a provider named 'dapp_provider', also injecting dependencies
-	provider := "dapp_provider"
+func (h HAuth) authIntent(ctx iris.Context, uCred *dto.UserCredIn, svcAuth *auth.SvcAuthentication, svcToken auth.ISvcToken) {
+	// using the requested provider, 'dapp_provider' by default, also injecting dependencies
+	provider := uCred.Provider
+	if provider == "" {
+		provider = schema.ProviderDapp
+	}
 
 	// ej: Aqui podemos comprobar si la bd esta poblada
 	//populate := r.IsPopulateDBSvc()
@@ -121,22 +193,261 @@ func (h HAuth) authIntent(ctx iris.Context, uCred *dto.UserCredIn, svcAuth *auth
 	//	return
 	//}
 
-	authGrantedData, problem := svcAuth.AuthProviders[provider].GrantIntent(uCred, nil) // requesting authorization to evote (provider) mechanisms in this case
-	if problem != nil {                                                                 // check for errors
+	authGrantedData, wait, problem := svcAuth.GrantIntent(provider, uCred, ctx.RemoteAddr()) // requesting authorization to evote (provider) mechanisms in this case
+	if problem != nil {                                                                      // check for errors
+		if wait > 0 { // locked by too many failed attempts
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		}
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+
+	// if so far so good, we are going to create the access and refresh tokens
+	tokens, problem := svcToken.IssueTokens(authGrantedData, ctx.RemoteAddr(), ctx.GetHeader("User-Agent"))
+	if problem != nil {
 		h.response.ResErr(problem, &ctx)
 		return
 	}
 
-	// TODO: pass this to the service
-	// if so far so good, we are going to create the auth token
-	tokenData := mapper.ToAccessTokenDataV(authGrantedData)
-	accessToken, err := lib.MkAccessToken(tokenData, []byte(h.appConf.JWTSignKey), h.appConf.TkMaxAge)
-	if err != nil {
-		h.response.ResErr(&dto.Problem{Status: iris.StatusInternalServerError, Title: schema.ErrJwtGen, Detail: err.Error()}, &ctx)
+	h.response.ResOKWithData(tokens, &ctx)
+}
+
+// refreshToken Exchange a refresh token for a new access and refresh token pair
+// @Summary Refresh the access token
+// @Description Exchange a refresh token for a new token pair. Every refresh token can be used once, presenting a used refresh token again revokes all the refresh tokens issued since the login
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param 	refresh 	body 	dto.RefreshTokenIn 	true	"Refresh token"
+// @Success 200 {object} dto.TokenPair "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 500 {object} dto.Problem "err.jwt_generation"
+// @Router /auth/refresh [post]
+func (h HAuth) refreshToken(ctx iris.Context, svcToken auth.ISvcToken) {
+	var req dto.RefreshTokenIn
+	if err := ctx.ReadJSON(&req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	tokens, problem := svcToken.Refresh(req.RefreshToken)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+
+	h.response.ResOKWithData(tokens, &ctx)
+}
+
+// forgotPassword Email a password reset token
+// @Summary Forgotten password
+// @Description Email a single use password reset token, valid for PasswordResetTTL minutes, to the accounts with the email. The response is the same whether an account has the email or not
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param email body dto.PasswordForgotIn true "Email of the account"
+// @Success 204 "Everything went fine, nothing to return"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /auth/password/forgot [post]
+func (h HAuth) forgotPassword(ctx iris.Context, svcPassword auth.ISvcPassword) {
+	var req dto.PasswordForgotIn
+	if err := ctx.ReadJSON(&req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	if problem := svcPassword.Forgot(req.Email); problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOK(&ctx)
+}
+
+// resetPassword Set a new password with the reset token
+// @Summary Reset the password
+// @Description Set a new password with the token received by email. The token can be used once, and all the tokens of the user are revoked
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param reset body dto.PasswordResetIn true "Reset token and new password"
+// @Success 204 "Everything went fine, nothing to return"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 400 {object} dto.Problem "err.invalid_data"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /auth/password/reset [post]
+func (h HAuth) resetPassword(ctx iris.Context, svcPassword auth.ISvcPassword) {
+	var req dto.PasswordResetIn
+	if err := ctx.ReadJSON(&req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	if problem := svcPassword.Reset(req.Token, req.NewPassword); problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOK(&ctx)
+}
+
+// activateAccount Activate the account of an invited user
+// @Summary Activate the account
+// @Description Set the password of the invited user with the activation token received by email, which verifies the email too. The user can log in from now on
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param activation body dto.ActivationIn true "Activation token and password"
+// @Success 204 "Everything went fine, nothing to return"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 400 {object} dto.Problem "err.invalid_data"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /auth/activate [post]
+func (h HAuth) activateAccount(ctx iris.Context, svcInvitation auth.ISvcInvitation) {
+	var req dto.ActivationIn
+	if err := ctx.ReadJSON(&req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	if problem := svcInvitation.Activate(req.Token, req.Password); problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOK(&ctx)
+}
+
+// oidcLogin Start the single sign-on through the OpenID Connect identity provider
+// @Summary Single sign-on login
+// @Description Redirects the user agent to the OpenID Connect identity provider (authorization code flow with PKCE). Once the user logs in there, the identity provider redirects it to the callback
+// @Tags Auth
+// @Param 	scope 	query 	string 	false	"Space separated scopes of the access token, all of them when empty"
+// @Success 302 "Redirection to the identity provider"
+// @Failure 400 {object} dto.Problem "err.wrong_auth_provider"
+// @Failure 400 {object} dto.Problem "err.invalid_scope"
+// @Failure 502 {object} dto.Problem "err.bad_gateway"
+// @Router /auth/oidc/login [get]
+func (h HAuth) oidcLogin(ctx iris.Context, svcAuth *auth.SvcAuthentication) {
+	authURL, state, problem := svcAuth.OIDCAuthorizationURL(ctx.URLParam("scope"))
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.setOIDCState(ctx, state)
+	ctx.Redirect(authURL, iris.StatusFound)
+}
+
+// oidcCallback Complete the single sign-on with the authorization response of the OpenID Connect identity provider
+// @Summary Single sign-on callback
+// @Description The identity provider redirects the user agent here with the authorization code. The code is exchanged for the ID token, whose claims give the user role, and our own token pair is issued. The login must be completed by the browser that started it, which keeps its state in the oidc_state cookie. The users with the TOTP second factor enrolled get err.otp_required, and complete the login sending the code to /auth/oidc/otp with the same state
+// @Tags Auth
+// @Produce json
+// @Param 	code 	query 	string 	true	"Authorization code"
+// @Param 	state 	query 	string 	true	"Login state"
+// @Success 200 {object} dto.TokenPair "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 401 {object} dto.Problem "err.otp_required"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 429 {object} dto.Problem "err.too_many_attempts"
+// @Failure 502 {object} dto.Problem "err.bad_gateway"
+// @Router /auth/oidc/callback [get]
+func (h HAuth) oidcCallback(ctx iris.Context, svcAuth *auth.SvcAuthentication, svcToken auth.ISvcToken) {
+	if idpErr := ctx.URLParam("error"); idpErr != "" { // the user, or the identity provider, refused the login
+		ctx.RemoveCookie(oidcStateCookie)
+		h.response.ResErr(&dto.Problem{Status: iris.StatusUnauthorized, Title: schema.ErrUnauthorized, Detail: idpErr + ": " + ctx.URLParam("error_description")}, &ctx)
+		return
+	}
+
+	callback := dto.OIDCCallbackIn{Code: ctx.URLParam("code"), State: ctx.URLParam("state"), BrowserState: ctx.GetCookie(oidcStateCookie)}
+	if err := h.validate.Struct(callback); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	grant, wait, problem := svcAuth.GrantCallback(&callback, ctx.RemoteAddr())
+	h.completeOIDC(ctx, svcToken, callback.State, grant, wait, problem)
+}
+
+// oidcSecondFactor Complete the single sign-on with the code of the TOTP second factor
+// @Summary Single sign-on second factor
+// @Description The users with the TOTP second factor enrolled send here its code, or a recovery code, after the callback answered err.otp_required. The login can be retried with another code until it expires
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param 	login 	body 	dto.OIDCSecondFactorIn 	true	"Login state and code of the second factor"
+// @Success 200 {object} dto.TokenPair "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 429 {object} dto.Problem "err.too_many_attempts"
+// @Router /auth/oidc/otp [post]
+func (h HAuth) oidcSecondFactor(ctx iris.Context, svcAuth *auth.SvcAuthentication, svcToken auth.ISvcToken) {
+	var req dto.OIDCSecondFactorIn
+	if err := ctx.ReadJSON(&req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	req.BrowserState = ctx.GetCookie(oidcStateCookie)
+
+	grant, wait, problem := svcAuth.GrantSecondFactor(&req, ctx.RemoteAddr())
+	h.completeOIDC(ctx, svcToken, req.State, grant, wait, problem)
+}
+
+// completeOIDC issue the token pair of the single sign-on. The state cookie is kept while the login waits for the
+// second factor, its code can be retried
+func (h HAuth) completeOIDC(ctx iris.Context, svcToken auth.ISvcToken, state string, grant *dto.GrantIntentResponse, wait time.Duration, problem *dto.Problem) {
+	if problem != nil {
+		if wait > 0 { // locked by too many failed attempts
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		}
+		if problem.Title == schema.ErrOTPRequired || problem.Detail == schema.ErrDetInvalidOTP {
+			h.setOIDCState(ctx, state)
+		} else {
+			ctx.RemoveCookie(oidcStateCookie)
+		}
+		h.response.ResErr(problem, &ctx)
 		return
 	}
+	ctx.RemoveCookie(oidcStateCookie)
 
-	h.response.ResOKWithData(string(accessToken), &ctx)
+	tokens, problem := svcToken.IssueTokens(grant, ctx.RemoteAddr(), ctx.GetHeader("User-Agent"))
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(tokens, &ctx)
+}
+
+// setOIDCState bind the single sign-on login to the browser, only it can complete the login
+func (h HAuth) setOIDCState(ctx iris.Context, state string) {
+	ctx.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(auth.OIDCLoginMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.appConf.OIDCRedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode, // sent on the top-level redirect of the identity provider
+	})
 }
 
 // logout this endpoint invalidated a previously granted access token
@@ -181,169 +492,660 @@ func (h HAuth) getUserProfile(ctx iris.Context, params dto.InjectedParam, servic
 	h.response.ResOKWithData(user, &ctx)
 }
 
-// getUsers Get all users from the BD.
-// @Summary Get users
-// @description.markdown GetAllUsers
-// @Tags Users
+// enrolTOTP Start the TOTP second factor enrolment of the logged user.
+// @Summary Enrol the TOTP second factor
+// @Description Returns a new TOTP secret and its otpauth URI, to be added to the authenticator app. The second factor is pending until it is confirmed with a first code
+// @Tags Auth
 // @Security ApiKeyAuth
 // @Produce  json
-// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
-// @Param limit         query  int      false  "Items limit per page"
-// @Param page          query  int      false  "Page displayed"
-// @Param sort          query  string   false  "Sort items by"
-// @Success 200 {object} []dto.UserResponse "OK"
+// @Param Authorization header string true "Insert access token" default(Bearer <Add access token here>)
+// @Success 200 {object} dto.TOTPEnrolment "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
-// @Failure 500 {object} dto.Problem "err.generic
-// @Router /users [get]
-func (h HAuth) getUsers(ctx iris.Context, params dto.InjectedParam, service service.ISvcUser) {
-	if params.Role != models.Role_SystemAdmin {
-		(*h.response).ResUnauthorized(&ctx)
+// @Failure 409 {object} dto.Problem "err.duplicate_key"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /auth/totp [post]
+func (h HAuth) enrolTOTP(ctx iris.Context, params dto.InjectedParam, svcTOTP auth.ISvcTOTP) {
+	enrolment, problem := svcTOTP.Enrol(params.Username)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
 		return
 	}
+	h.response.ResOKWithData(enrolment, &ctx)
+}
 
-	pagination := new(dto.Pagination)
-	lib.ParamsToStruct(ctx, pagination)
+// confirmTOTP Confirm the TOTP second factor enrolment of the logged user.
+// @Summary Confirm the TOTP second factor
+// @Description Enable the pending TOTP second factor with a first code of the authenticator app. Returns the single use recovery codes, they are only shown once. From now on the logins require the code, log in again to get a token issued with the second factor
+// @Tags Auth
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce  json
+// @Param Authorization header string         true "Insert access token" default(Bearer <Add access token here>)
+// @Param code          body   dto.TOTPCodeIn true "Code of the authenticator app"
+// @Success 200 {object} dto.RecoveryCodes "OK"
+// @Failure 400 {object} dto.Problem "err.invalid_data"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /auth/totp/confirm [post]
+func (h HAuth) confirmTOTP(ctx iris.Context, params dto.InjectedParam, svcTOTP auth.ISvcTOTP) {
+	var req dto.TOTPCodeIn
+	if err := ctx.ReadJSON(&req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	if err := h.validate.Struct(req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
 
-	users, problem := service.GetUsersSvc(pagination)
+	codes, problem := svcTOTP.Confirm(params.Username, req.Code)
 	if problem != nil {
 		(*h.response).ResErr(problem, &ctx)
 		return
 	}
-	h.response.ResOKWithData(users, &ctx)
+	h.response.ResOKWithData(codes, &ctx)
 }
 
-// getRoles Get all roles from the BD.
-// @Summary Get roles
-// @Tags Users
+// regenerateRecoveryCodes Replace the recovery codes of the logged user.
+// @Summary Regenerate the recovery codes
+// @Description Replace the recovery codes of the TOTP second factor, the previous ones stop working. Requires a token issued with the second factor
+// @Tags Auth
 // @Security ApiKeyAuth
 // @Produce  json
-// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
-// @Success 200 {object} []dto.UserResponse "OK"
+// @Param Authorization header string true "Insert access token" default(Bearer <Add access token here>)
+// @Success 200 {object} dto.RecoveryCodes "OK"
+// @Failure 400 {object} dto.Problem "err.processing_param"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
-// @Failure 500 {object} dto.Problem "err.generic
-// @Router /users/roles [get]
-func (h HAuth) getRoles(ctx iris.Context, params dto.InjectedParam, service service.ISvcUser) {
-	if params.Role != models.Role_SystemAdmin {
-		(*h.response).ResUnauthorized(&ctx)
+// @Failure 403 {object} dto.Problem "err.mfa_required"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /auth/totp/recovery_codes [post]
+func (h HAuth) regenerateRecoveryCodes(ctx iris.Context, params dto.InjectedParam, svcTOTP auth.ISvcTOTP) {
+	if !params.MFA {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusForbidden, Title: schema.ErrMFARequired, Detail: schema.ErrDetMFARequired}, &ctx)
 		return
 	}
-	resp, problem := service.GetRolesSvc()
+
+	codes, problem := svcTOTP.RegenerateRecoveryCodes(params.Username)
 	if problem != nil {
 		(*h.response).ResErr(problem, &ctx)
 		return
 	}
-	h.response.ResOKWithData(resp, &ctx)
+	h.response.ResOKWithData(codes, &ctx)
 }
 
-// invalidateUser Remove user permissions .
-// @Summary Remove user permissions
-// @Tags Users
+// getPermissions Get the permissions of the logged user.
+// @Summary Get the permissions of the logged user
+// @Description Returns the effective permissions of the logged user, as checked by the endpoints: the ones of the role covered by the scope of the access token
+// @Tags Auth
 // @Security ApiKeyAuth
 // @Produce  json
-// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
-// @Param id 			path   int      true   "User ID"
-// @Success 200 {object} []dto.UserResponse "OK"
+// @Param Authorization header string true "Insert access token" default(Bearer <Add access token here>)
+// @Success 200 {object} dto.PermissionsResponse "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
-// @Failure 500 {object} dto.Problem "err.generic
-// @Router /users/invalidate_user/{id} [put]
-func (h HAuth) invalidateUser(ctx iris.Context, params dto.InjectedParam, service service.ISvcUser) {
-	if params.Role != models.Role_SystemAdmin {
-		(*h.response).ResUnauthorized(&ctx)
+// @Router /auth/permissions [get]
+func (h HAuth) getPermissions(ctx iris.Context, tkData *dto.AccessTokenData) {
+	role := tkData.Claims.Role
+	h.response.ResOKWithData(dto.PermissionsResponse{Role: role, Scope: tkData.Scope, Permissions: h.policy.Permissions(role, tkData.Scope)}, &ctx)
+}
+
+// changePassword Change the password of the logged user.
+// @Summary Change my password
+// @Description Set a new password after checking the current one. All the tokens of the user are revoked, log in again with the new password
+// @Tags Auth
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce  json
+// @Param Authorization header string               true "Insert access token" default(Bearer <Add access token here>)
+// @Param password      body   dto.PasswordChangeIn true "Current and new password"
+// @Success 204 "Everything went fine, nothing to return"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 400 {object} dto.Problem "err.invalid_data"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /auth/password [put]
+func (h HAuth) changePassword(ctx iris.Context, params dto.InjectedParam, svcPassword auth.ISvcPassword) {
+	var req dto.PasswordChangeIn
+	if err := ctx.ReadJSON(&req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
 		return
 	}
-	id := ctx.Params().GetIntDefault("id", -1)
-	if id == -1 {
-		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+	if err := h.validate.Struct(req); err != nil {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
 		return
 	}
 
-	resp, problem := service.InvalidateUserSvc(id)
-	if problem != nil {
-		(*h.response).ResErr(problem, &ctx)
+	if problem := svcPassword.Change(params.Username, req.CurrentPassword, req.NewPassword); problem != nil {
+		h.response.ResErr(problem, &ctx)
 		return
 	}
-	h.response.ResOKWithData(resp, &ctx)
+	h.response.ResOK(&ctx)
 }
 
-// getUserById Get user by ID
-// @Summary Get user by ID
-// @Description Returns information about the user with the specified ID
-// @Tags Users
+// getSessions Get the active sessions of the logged user.
+// @Summary Get my sessions
+// @Description Returns where the logged user is logged in: the active sessions with their IP, user agent and last use. The session of the request is flagged as current
+// @Tags Auth
 // @Security ApiKeyAuth
 // @Produce  json
-// @Param Authorization header string  true "Insert access token" default(Bearer <Add access token here>)
-// @Param   id          path   int     true "The unique identifier for the user within the account"     Format(int)
-// @Success 200 {object} dto.UserResponse "OK"
-// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Param Authorization header string true "Insert access token" default(Bearer <Add access token here>)
+// @Success 200 {object} []dto.SessionResponse "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
-// @Failure 500 {object} dto.Problem "err.generic
-// @Router /users/{id} [get]
-func (h HAuth) getUserById(ctx iris.Context, params dto.InjectedParam, service service.ISvcUser) {
-	if params.Role != models.Role_SystemAdmin {
-		(*h.response).ResUnauthorized(&ctx)
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /auth/sessions [get]
+func (h HAuth) getSessions(ctx iris.Context, tkData *dto.AccessTokenData, svcToken auth.ISvcToken) {
+	sessions, problem := svcToken.Sessions(tkData.Claims.Username, tkData.Session)
+	if problem != nil {
+		h.response.ResErr(problem, &ctx)
 		return
 	}
-	// checking param
-	userID := ctx.Params().GetIntDefault("id", -1)
-	if userID == -1 {
-		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+	h.response.ResOKWithData(sessions, &ctx)
+}
+
+// deleteSessions Revoke all the sessions of the logged user.
+// @Summary Revoke all my sessions
+// @Description Revoke all the sessions of the logged user, the current one included, so the user has to log in again everywhere
+// @Tags Auth
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string true "Insert access token" default(Bearer <Add access token here>)
+// @Success 204 "Everything went fine, nothing to return"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /auth/sessions [delete]
+func (h HAuth) deleteSessions(ctx iris.Context, params dto.InjectedParam, svcToken auth.ISvcToken) {
+	if problem := svcToken.RevokeUser(params.Username); problem != nil {
+		h.response.ResErr(problem, &ctx)
 		return
 	}
+	h.response.ResOK(&ctx)
+}
 
-	user, problem := service.GetUserSvc(userID)
-	if problem != nil {
-		(*h.response).ResErr(problem, &ctx)
+// deleteSession Revoke a session of the logged user.
+// @Summary Revoke one of my sessions
+// @Description Revoke the session of the logged user, e.g. the one of a lost device. Its tokens are refused right away
+// @Tags Auth
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string true "Insert access token" default(Bearer <Add access token here>)
+// @Param session path string true "Session ID"
+// @Success 204 "Everything went fine, nothing to return"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 404 {object} dto.Problem "err.not_found"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /auth/sessions/{session} [delete]
+func (h HAuth) deleteSession(ctx iris.Context, params dto.InjectedParam, svcToken auth.ISvcToken) {
+	if problem := svcToken.RevokeSession(params.Username, ctx.Params().GetString("session")); problem != nil {
+		h.response.ResErr(problem, &ctx)
 		return
 	}
-	h.response.ResOKWithData(user, &ctx)
+	h.response.ResOK(&ctx)
 }
 
-// putUserById Update user.
-// @Summary Update user
-// @Description Update data from user with the specified ID. Fields that are not passed will not be modified.
+// getUsers Get all users from the BD.
+// @Summary Get users
+// @description.markdown GetAllUsers
 // @Tags Users
 // @Security ApiKeyAuth
 // @Produce  json
-// @Param Authorization header string           true "Insert access token" default(Bearer <Add access token here>)
-// @Param   id          path   int     	        true "The unique identifier for the user within the account"     Format(int)
-// @Param 	Transaction	body   dto.EditUserData	true	"User Data"
-// @Success 200 {object} dto.UserResponse "OK"
+// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
+// @Param limit         query  int      false  "Items limit per page, at most 100"
+// @Param page          query  int      false  "Page displayed"
+// @Param sort          query  string   false  "Sort items by: id, username, firstname, lastname, email, rol or createdAt, followed by asc or desc"
+// @Param rol           query  string   false  "Role of the users"
+// @Param name          query  string   false  "Substring of the username, the first or the last name"
+// @Param email         query  string   false  "Substring of the email"
+// @Param status        query  string   false  "Status of the users"  Enums(active, pending, invalid)
+// @Param created_from  query  string   false  "Created on or after the date"  Format(date)
+// @Param created_to    query  string   false  "Created on or before the date"  Format(date)
+// @Param deleted       query  bool     false  "List the deleted users instead"
+// @Success 200 {object} []dto.UserResponse "OK"
 // @Failure 400 {object} dto.Problem "err.processing_param"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
 // @Failure 500 {object} dto.Problem "err.generic
-// @Router /users/{id} [put]
-func (h HAuth) putUserById(ctx iris.Context, params dto.InjectedParam, service service.ISvcUser) {
-	if params.Role != models.Role_SystemAdmin {
-		(*h.response).ResUnauthorized(&ctx)
+// @Router /users [get]
+func (h HAuth) getUsers(ctx iris.Context, service service.ISvcUser) {
+
+	pagination := new(dto.Pagination)
+	var filter dto.UserFilter
+	if err := lib.ParamsToStruct(ctx, pagination); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
 		return
 	}
-	// checking param
-	userID := ctx.Params().GetIntDefault("id", -1)
-	if userID == -1 {
-		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+	if err := lib.ParamsToStruct(ctx, &filter); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
 		return
 	}
-
-	// getting data from client
-	var requestData dto.EditUserData
-
-	// unmarshalling the json and check
-	if err := ctx.ReadJSON(&requestData); err != nil {
+	if err := h.validate.Struct(filter); err != nil {
 		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
 		return
 	}
 
-	response, problem := service.PutUserSvc(userID, requestData)
+	users, problem := service.GetUsersSvc(pagination, filter)
 	if problem != nil {
 		(*h.response).ResErr(problem, &ctx)
 		return
 	}
-	h.response.ResOKWithData(response, &ctx)
+	users.SetLinks(*ctx.Request().URL)
+	h.response.ResOKWithData(users, &ctx)
 }
 
-// postUser Create user.
-// @Summary Create user
-// @Description Create a new User.
+// getRoles Get all roles from the BD.
+// @Summary Get roles
+// @Description The roles with their permissions
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
+// @Success 200 {object} []dto.RoleResponse "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 500 {object} dto.Problem "err.generic
+// @Router /users/roles [get]
+func (h HAuth) getRoles(ctx iris.Context, svcRole service.ISvcRole) {
+	resp, problem := svcRole.GetRolesSvc()
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(resp, &ctx)
+}
+
+// getRole Get a role.
+// @Summary Get role
+// @Description The role with the label, with its permissions
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
+// @Param label         path   string   true   "Role label"
+// @Success 200 {object} dto.RoleResponse "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 404 {object} dto.Problem "err.not_found"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /users/roles/{label} [get]
+func (h HAuth) getRole(ctx iris.Context, svcRole service.ISvcRole) {
+	resp, problem := svcRole.GetRoleSvc(ctx.Params().Get("label"))
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(resp, &ctx)
+}
+
+// postRole Create a role.
+// @Summary Create role
+// @Description Create a role with its permissions, the policy applies them right away
+// @Tags Users
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce  json
+// @Param Authorization header string       true  "Insert access token" default(Bearer <Add access token here>)
+// @Param 	role        body   dto.RoleData true  "Role Data"
+// @Success 200 {object} dto.RoleResponse "OK"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 409 {object} dto.Problem "err.duplicate_key"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /users/roles [post]
+func (h HAuth) postRole(ctx iris.Context, svcRole service.ISvcRole) {
+	var req dto.RoleData
+	if err := ctx.ReadJSON(&req); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	if err := h.validate.Struct(req); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	resp, problem := svcRole.PostRoleSvc(req)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(resp, &ctx)
+}
+
+// putRole Update a role.
+// @Summary Update role
+// @Description Update the name, the description or the permissions of the role. Fields that are not passed will not be modified, and an empty permission list leaves the role without permissions. The invalid role has no permissions, and the sysadmin role keeps roles.write
+// @Tags Users
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce  json
+// @Param Authorization header string           true  "Insert access token" default(Bearer <Add access token here>)
+// @Param label         path   string           true  "Role label"
+// @Param 	role        body   dto.EditRoleData true  "Role Data"
+// @Success 200 {object} dto.RoleResponse "OK"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 404 {object} dto.Problem "err.not_found"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /users/roles/{label} [put]
+func (h HAuth) putRole(ctx iris.Context, svcRole service.ISvcRole) {
+	var req dto.EditRoleData
+	if err := ctx.ReadJSON(&req); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	if err := h.validate.Struct(req); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	resp, problem := svcRole.PutRoleSvc(ctx.Params().Get("label"), req)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(resp, &ctx)
+}
+
+// deleteRole Delete a role.
+// @Summary Delete role
+// @Description Delete the role and its permissions. The built-in roles and the roles assigned to users, the deleted ones included, can't be deleted
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
+// @Param label         path   string   true   "Role label"
+// @Success 204 "Everything went fine, nothing to return"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 404 {object} dto.Problem "err.not_found"
+// @Failure 409 {object} dto.Problem "err.processing_param"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /users/roles/{label} [delete]
+func (h HAuth) deleteRole(ctx iris.Context, svcRole service.ISvcRole) {
+	if problem := svcRole.DeleteRoleSvc(ctx.Params().Get("label")); problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOK(&ctx)
+}
+
+// invalidateUser Remove user permissions .
+// @Summary Remove user permissions
+// @Description Set the invalid role to the user and revoke its tokens, the user can't use the API anymore
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
+// @Param id 			path   int      true   "User ID"
+// @Success 200 {object} []dto.UserResponse "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 500 {object} dto.Problem "err.generic
+// @Router /users/invalidate_user/{id} [put]
+func (h HAuth) invalidateUser(ctx iris.Context, params dto.InjectedParam, service service.ISvcUser) {
+	id := ctx.Params().GetIntDefault("id", -1)
+	if id == -1 {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	resp, problem := service.InvalidateUserSvc(id, params.Username)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(resp, &ctx)
+}
+
+// revokeUserTokens Revoke all the tokens of the user.
+// @Summary Revoke all the tokens of the user
+// @Description Revoke all the access and refresh tokens issued to the user, the user has to log in again
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
+// @Param id 			path   int      true   "User ID"
+// @Success 204 "Everything went fine, nothing to return"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 500 {object} dto.Problem "err.generic
+// @Router /users/revoke_tokens/{id} [put]
+func (h HAuth) revokeUserTokens(ctx iris.Context, service service.ISvcUser, svcToken auth.ISvcToken) {
+	id := ctx.Params().GetIntDefault("id", -1)
+	if id == -1 {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	user, problem := service.GetUserSvc(id)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	if problem = svcToken.RevokeUser(user.Username); problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOK(&ctx)
+}
+
+// getUserSessions Get the active sessions of the user.
+// @Summary Get the user sessions
+// @Description Returns where the user is logged in: the active sessions with their IP, user agent and last use
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
+// @Param id 			path   int      true   "User ID"
+// @Success 200 {object} []dto.SessionResponse "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 500 {object} dto.Problem "err.generic
+// @Router /users/sessions/{id} [get]
+func (h HAuth) getUserSessions(ctx iris.Context, service service.ISvcUser, svcToken auth.ISvcToken) {
+	id := ctx.Params().GetIntDefault("id", -1)
+	if id == -1 {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	user, problem := service.GetUserSvc(id)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	sessions, problem := svcToken.Sessions(user.Username, "")
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(sessions, &ctx)
+}
+
+// deleteUserSession Revoke a session of the user.
+// @Summary Revoke a user session
+// @Description Revoke the session of the user, its tokens are refused right away. All the sessions are revoked with /users/revoke_tokens/{id}
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
+// @Param id 			path   int      true   "User ID"
+// @Param session 		path   string   true   "Session ID"
+// @Success 204 "Everything went fine, nothing to return"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 404 {object} dto.Problem "err.not_found"
+// @Failure 500 {object} dto.Problem "err.generic
+// @Router /users/sessions/{id}/{session} [delete]
+func (h HAuth) deleteUserSession(ctx iris.Context, service service.ISvcUser, svcToken auth.ISvcToken) {
+	id := ctx.Params().GetIntDefault("id", -1)
+	if id == -1 {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	user, problem := service.GetUserSvc(id)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	if problem = svcToken.RevokeSession(user.Username, ctx.Params().GetString("session")); problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOK(&ctx)
+}
+
+// unlockUser Unlock the account locked by too many failed logins.
+// @Summary Unlock the user account
+// @Description Forget the failed logins of the user, so the account locked by too many failed logins can log in again right away
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
+// @Param id 			path   int      true   "User ID"
+// @Success 204 "Everything went fine, nothing to return"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /users/unlock/{id} [put]
+func (h HAuth) unlockUser(ctx iris.Context, service service.ISvcUser, svcAuth *auth.SvcAuthentication) {
+	id := ctx.Params().GetIntDefault("id", -1)
+	if id == -1 {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	user, problem := service.GetUserSvc(id)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	if problem = svcAuth.Unlock(user.Username); problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOK(&ctx)
+}
+
+// resetUserTOTP Remove the TOTP second factor of the user.
+// @Summary Reset the user second factor
+// @Description Remove the TOTP second factor and the recovery codes of the user, e.g. when they are lost. The user logs in with the password only, and can enrol again
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
+// @Param id 			path   int      true   "User ID"
+// @Success 204 "Everything went fine, nothing to return"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden | err.mfa_required"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /users/reset_totp/{id} [put]
+func (h HAuth) resetUserTOTP(ctx iris.Context, service service.ISvcUser, svcTOTP auth.ISvcTOTP) {
+	id := ctx.Params().GetIntDefault("id", -1)
+	if id == -1 {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	user, problem := service.GetUserSvc(id)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	if problem = svcTOTP.Reset(user.Username); problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOK(&ctx)
+}
+
+// getUserById Get user by ID
+// @Summary Get user by ID
+// @Description Returns information about the user with the specified ID
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string  true "Insert access token" default(Bearer <Add access token here>)
+// @Param   id          path   int     true "The unique identifier for the user within the account"     Format(int)
+// @Success 200 {object} dto.UserResponse "OK"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 500 {object} dto.Problem "err.generic
+// @Router /users/{id} [get]
+func (h HAuth) getUserById(ctx iris.Context, service service.ISvcUser) {
+	// checking param
+	userID := ctx.Params().GetIntDefault("id", -1)
+	if userID == -1 {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	user, problem := service.GetUserSvc(userID)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(user, &ctx)
+}
+
+// putUserById Update user.
+// @Summary Update user
+// @Description Update data from user with the specified ID. Fields that are not passed will not be modified. The new username must not be used by another user, even a deleted one. A change of the username, the password or the role revokes the tokens of the user.
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string           true "Insert access token" default(Bearer <Add access token here>)
+// @Param   id          path   int     	        true "The unique identifier for the user within the account"     Format(int)
+// @Param 	Transaction	body   dto.EditUserData	true	"User Data"
+// @Success 200 {object} dto.UserResponse "OK"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 409 {object} dto.Problem "err.duplicate_key"
+// @Failure 500 {object} dto.Problem "err.generic
+// @Router /users/{id} [put]
+func (h HAuth) putUserById(ctx iris.Context, params dto.InjectedParam, service service.ISvcUser) {
+	// checking param
+	userID := ctx.Params().GetIntDefault("id", -1)
+	if userID == -1 {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	// getting data from client
+	var requestData dto.EditUserData
+
+	// unmarshalling the json and check
+	if err := ctx.ReadJSON(&requestData); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	response, problem := service.PutUserSvc(userID, requestData, params.Username)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(response, &ctx)
+}
+
+// postUser Create user.
+// @Summary Create user
+// @Description Create a new User.
 // @Tags Users
 // @Security ApiKeyAuth
 // @Produce  json
@@ -352,13 +1154,12 @@ func (h HAuth) putUserById(ctx iris.Context, params dto.InjectedParam, service s
 // @Success 204 "Everything went fine, nothing to return."
 // @Failure 400 {object} dto.Problem "err.processing_param"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 409 {object} dto.Problem "err.duplicate_key"
 // @Failure 500 {object} dto.Problem "err.generic
 // @Router /users [post]
 func (h HAuth) postUser(ctx iris.Context, params dto.InjectedParam, service service.ISvcUser) {
-	if params.Role != models.Role_SystemAdmin {
-		(*h.response).ResUnauthorized(&ctx)
-		return
-	}
 	// getting data from client
 	var requestData dto.UserData
 
@@ -368,7 +1169,7 @@ func (h HAuth) postUser(ctx iris.Context, params dto.InjectedParam, service serv
 		return
 	}
 
-	_, problem := service.PostUserSvc(requestData)
+	_, problem := service.PostUserSvc(requestData, params.Username)
 	if problem != nil {
 		(*h.response).ResErr(problem, &ctx)
 		return
@@ -376,9 +1177,158 @@ func (h HAuth) postUser(ctx iris.Context, params dto.InjectedParam, service serv
 	h.response.ResOK(&ctx)
 }
 
+// inviteUser Invite a user.
+// @Summary Invite user
+// @Description Create a pending user without a password and email it an activation link, valid for InvitationTTL hours. The user sets its own password activating the account, until then it can't log in
+// @Tags Users
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce  json
+// @Param Authorization header string             true  "Insert access token" default(Bearer <Add access token here>)
+// @Param 	user        body   dto.InvitationData true  "User Data"
+// @Success 200 {object} dto.UserResponse "OK"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 409 {object} dto.Problem "err.duplicate_key"
+// @Failure 502 {object} dto.Problem "err.bad_gateway"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /users/invite [post]
+func (h HAuth) inviteUser(ctx iris.Context, params dto.InjectedParam, svcInvitation auth.ISvcInvitation) {
+	var req dto.InvitationData
+	if err := ctx.ReadJSON(&req); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	if err := h.validate.Struct(req); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	user, problem := svcInvitation.Invite(req, params.Username)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(user, &ctx)
+}
+
+// importUsers Import users from CSV or JSON.
+// @Summary Import users
+// @Description Create the new users and update the existing ones, matched by username, from a CSV with a header row (Content-Type text/csv) or a JSON array of users. The CSV columns are username, passphrase, firstname, lastname, email and rol. Every row is validated against dto.UserData and reported on its own. A new user without a passphrase fails, or is invited with invite=true. With dry_run=true nothing is written
+// @Tags Users
+// @Security ApiKeyAuth
+// @Accept json
+// @Accept text/csv
+// @Produce  json
+// @Param Authorization header string         true  "Insert access token" default(Bearer <Add access token here>)
+// @Param   dry_run     query  bool           false "Validate and report the actions, without writing"
+// @Param   invite      query  bool           false "Invite the new users without a passphrase"
+// @Param 	users       body   []dto.UserData true  "Users"
+// @Success 200 {object} dto.ImportReport "OK"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /users/import [post]
+func (h HAuth) importUsers(ctx iris.Context, params dto.InjectedParam, svcUserImport service.ISvcUserImport) {
+	var rows []dto.UserData
+	var err error
+	if ctx.GetContentTypeRequested() == "text/csv" {
+		rows, err = service.ReadUsersCSV(ctx.Request().Body)
+	} else {
+		err = ctx.ReadJSON(&rows)
+	}
+	if err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	report, problem := svcUserImport.Import(rows, ctx.URLParamBoolDefault("dry_run", false), ctx.URLParamBoolDefault("invite", false), params.Username)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(report, &ctx)
+}
+
+// exportUsers Export users to CSV.
+// @Summary Export users
+// @Description The users as CSV, without their passphrases, with the filters of GET /users. The columns of the import are kept, so the file can be edited and imported again
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce text/csv
+// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
+// @Param rol           query  string   false  "Role of the users"
+// @Param name          query  string   false  "Substring of the username, the first or the last name"
+// @Param email         query  string   false  "Substring of the email"
+// @Param status        query  string   false  "Status of the users"  Enums(active, pending, invalid)
+// @Param created_from  query  string   false  "Created on or after the date"  Format(date)
+// @Param created_to    query  string   false  "Created on or before the date"  Format(date)
+// @Param deleted       query  bool     false  "Export the deleted users instead"
+// @Success 200 {file} file "users.csv"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /users/export [get]
+func (h HAuth) exportUsers(ctx iris.Context, svcUserImport service.ISvcUserImport) {
+	var filter dto.UserFilter
+	if err := lib.ParamsToStruct(ctx, &filter); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	if err := h.validate.Struct(filter); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	data, problem := svcUserImport.Export(filter)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	ctx.ContentType("text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", `attachment; filename="users.csv"`)
+	_, _ = ctx.Write(data)
+}
+
+// resendInvitation Send a new invitation to a pending user.
+// @Summary Resend the invitation
+// @Description Email a new activation link to the pending user, e.g. when the invitation expired. The previous links stop working
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string   true   "Insert access token"   default(Bearer <Add access token here>)
+// @Param id 			path   int      true   "User ID"
+// @Success 204 "Everything went fine, nothing to return"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 502 {object} dto.Problem "err.bad_gateway"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /users/resend_invitation/{id} [put]
+func (h HAuth) resendInvitation(ctx iris.Context, svcInvitation auth.ISvcInvitation) {
+	id := ctx.Params().GetIntDefault("id", -1)
+	if id == -1 {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	if problem := svcInvitation.Resend(id); problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOK(&ctx)
+}
+
 // deleteUser Delete user.
 // @Summary Delete user
-// @Description Delete user with specified ID from DB.
+// @Description Soft delete the user with specified ID, and revoke its tokens. The certificates keep referencing its username, and it can be restored with /users/{id}/restore
 // @Tags Users
 // @Security ApiKeyAuth
 // @Produce  json
@@ -386,13 +1336,11 @@ func (h HAuth) postUser(ctx iris.Context, params dto.InjectedParam, service serv
 // @Param   id          path   int       true  "The unique identifier for the user within the account"     Format(int)
 // @Success 204 "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
 // @Failure 500 {object} dto.Problem "err.generic
 // @Router /users/{id} [delete]
 func (h HAuth) deleteUserById(ctx iris.Context, params dto.InjectedParam, service service.ISvcUser) {
-	if params.Role != models.Role_SystemAdmin {
-		(*h.response).ResUnauthorized(&ctx)
-		return
-	}
 	// checking param
 	userID := ctx.Params().GetIntDefault("id", -1)
 	if userID == -1 {
@@ -400,7 +1348,7 @@ func (h HAuth) deleteUserById(ctx iris.Context, params dto.InjectedParam, servic
 		return
 	}
 
-	_, problem := service.DeleteUserSvc(userID)
+	_, problem := service.DeleteUserSvc(userID, params.Username)
 	if problem != nil {
 		(*h.response).ResErr(problem, &ctx)
 		return
@@ -408,6 +1356,79 @@ func (h HAuth) deleteUserById(ctx iris.Context, params dto.InjectedParam, servic
 	h.response.ResOK(&ctx)
 }
 
+// restoreUserById Restore a deleted user.
+// @Summary Restore user
+// @Description Undo the deletion of the user with specified ID, it logs in again with its previous password and role
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string    true  "Insert access token" default(Bearer <Add access token here>)
+// @Param   id          path   int       true  "The unique identifier for the user within the account"     Format(int)
+// @Success 200 {object} dto.UserResponse "OK"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 404 {object} dto.Problem "err.not_found"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /users/{id}/restore [post]
+func (h HAuth) restoreUserById(ctx iris.Context, params dto.InjectedParam, service service.ISvcUser) {
+	userID := ctx.Params().GetIntDefault("id", -1)
+	if userID == -1 {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	user, problem := service.RestoreUserSvc(userID, params.Username)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(user, &ctx)
+}
+
+// getUserHistory Get the change history of the user.
+// @Summary Get the user history
+// @Description Every change of the profile and the role of the user, the last one first, with who made it. The values of the password changes are not recorded
+// @Tags Users
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param Authorization header string    true  "Insert access token" default(Bearer <Add access token here>)
+// @Param   id          path   int       true  "The unique identifier for the user within the account"     Format(int)
+// @Success 200 {object} []dto.UserChangeResponse "OK"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 500 {object} dto.Problem "err.repo_ops"
+// @Router /users/{id}/history [get]
+func (h HAuth) getUserHistory(ctx iris.Context, service service.ISvcUser) {
+	userID := ctx.Params().GetIntDefault("id", -1)
+	if userID == -1 {
+		h.response.ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+
+	history, problem := service.GetUserHistorySvc(userID)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	h.response.ResOKWithData(history, &ctx)
+}
+
+// jwks Public keys that verify the access tokens
+// @Summary JSON Web Key Set
+// @Description Public keys (RS256 and EdDSA) that verify the access tokens, selected by the kid header of the token. During a key rotation the previous keys are listed too. HS256 keys are secret and never listed
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} dto.JWKSet "OK"
+// @Router /.well-known/jwks.json [get]
+func (h HAuth) jwks(ctx iris.Context) {
+	ctx.Header("Cache-Control", "public, max-age=300")
+	h.response.ResOKWithData(h.appConf.JWT.JWKS(), &ctx)
+}
+
 func (h HAuth) statusServer(ctx iris.Context) {
 	h.response.ResOKWithData(dto.StatusMsg{OK: true}, &ctx)
 }
@@ -424,6 +1445,9 @@ func depObtainUserCred(ctx iris.Context) dto.UserCredIn {
 	// Getting data
 	cred.Username = ctx.PostValue("username")
 	cred.Password = ctx.PostValue("password")
+	cred.Provider = ctx.PostValue("provider")
+	cred.OTP = ctx.PostValue("otp")
+	cred.Scope = ctx.PostValue("scope")
 
 	// TIP: We can do some validation here if we want
 	return cred
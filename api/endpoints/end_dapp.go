@@ -1,27 +1,51 @@
 package endpoints
 
 import (
+	"dapp/api/middlewares"
 	"dapp/lib"
 	"dapp/repo"
 	"dapp/schema"
 	"dapp/schema/dto"
-	"dapp/schema/models"
 	"dapp/service"
+	"dapp/service/auth"
 	"dapp/service/utils"
+	"encoding/json"
 
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/kataras/iris/v12/hero"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // DappHandler  endpoint handler struct for Dapp
 type DappHandler struct {
-	response *utils.SvcResponse
-	service  *service.ISvcDapp
-	validate *validator.Validate // handle validations for structs and individual fields based on tags
-	uTrans   *ut.UniversalTranslator
+	response    *utils.SvcResponse
+	service     *service.ISvcDapp
+	svcIdentity *service.ISvcIdentity
+	policy      *auth.Policy
+	validate    *validator.Validate // handle validations for structs and individual fields based on tags
+	uTrans      *ut.UniversalTranslator
+}
+
+// certificate operations that can be signed offline by the client
+const (
+	offlineCreate     = "create"
+	offlineUpdate     = "update"
+	offlineValidate   = "validate"
+	offlineInvalidate = "invalidate"
+	offlineDelete     = "delete"
+)
+
+// offlineOperationPermissions permission required to prepare each offline operation, the same as the certificate
+// endpoints
+var offlineOperationPermissions = map[string]string{
+	offlineCreate:     schema.PermCertCreate,
+	offlineUpdate:     schema.PermCertUpdate,
+	offlineValidate:   schema.PermCertValidate,
+	offlineInvalidate: schema.PermCertInvalidate,
+	offlineDelete:     schema.PermCertDelete,
 }
 
 // NewDappHandler create and register the handler for Dapp
@@ -30,17 +54,25 @@ type DappHandler struct {
 //
 // - MdwAuthChecker [*context.Handler] ~ Authentication checker middleware
 //
+// - policy [*auth.Policy] ~ Permissions of the roles
+//
 // - svcR [*utils.SvcResponse] ~ GrantIntentResponse service instance
 //
 // - svcC [utils.SvcConfig] ~ Configuration service instance
-func NewDappHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR *utils.SvcResponse, svcC *utils.SvcConfig, validate *validator.Validate, uT *ut.UniversalTranslator) DappHandler { // --- VARS SETUP ---
+func NewDappHandler(app *iris.Application, mdwAuthChecker *context.Handler, policy *auth.Policy, svcR *utils.SvcResponse, svcC *utils.SvcConfig, validate *validator.Validate, uT *ut.UniversalTranslator) DappHandler { // --- VARS SETUP ---
 	repoDapp := repo.NewRepoDapp(svcC)
-	svc := service.NewSvcDappReqs(repoDapp)
+	svc := service.NewSvcDappReqs(repoDapp, policy)
+	svcIdentity := service.NewSvcIdentityReqs(svcC, repoDapp)
 	// registering protected / guarded router
-	h := DappHandler{svcR, &svc, validate, uT}
+	h := DappHandler{svcR, &svc, &svcIdentity, policy, validate, uT}
+	require := middlewares.NewPolicyMiddleware(policy)
 
 	// --- DEPENDENCIES ---
 	hero.Register(lib.DepObtainUserDid)
+	hero.Register(lib.DepObtainTokenData)
+
+	// prometheus metrics, only scraped from the MetricsAllowedNets networks
+	app.Get("/metrics", middlewares.NewNetworkAllowlistMiddleware(svcC.MetricsNets), iris.FromStd(promhttp.Handler()))
 
 	// Simple group: v1
 	v1 := app.Party("/api/v1")
@@ -57,13 +89,23 @@ func NewDappHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR
 			// --- GROUP / PARTY MIDDLEWARES ---
 			protectedAPI.Use(*mdwAuthChecker)
 
-			protectedAPI.Post("/query", hero.Handler(h.postQuery))
-			protectedAPI.Post("/transaction", hero.Handler(h.postTransaction))
-			protectedAPI.Post("/certificates", hero.Handler(h.postCreateAsset))
-			protectedAPI.Put("/certificates", hero.Handler(h.putUpdateAsset))
-			protectedAPI.Put("/validate_certificate", hero.Handler(h.putValidateCertificate))
-			protectedAPI.Put("/invalidate_certificate", hero.Handler(h.putInvalidateCertificate))
-			protectedAPI.Delete("/certificates/{id: string}", hero.Handler(h.deleteAssetById))
+			protectedAPI.Post("/query", require(schema.PermDappQuery), hero.Handler(h.postQuery))
+			protectedAPI.Post("/transaction", require(schema.PermDappTransaction), hero.Handler(h.postTransaction))
+			protectedAPI.Post("/certificates", require(schema.PermCertCreate), hero.Handler(h.postCreateAsset))
+			protectedAPI.Put("/certificates", require(schema.PermCertUpdate), hero.Handler(h.putUpdateAsset))
+			// the legal status of the certificates only changes with a token issued after the second factor: the policy
+			// enforces it on certificates.validate and certificates.invalidate unless TOTPEnforcedPermissions says otherwise
+			protectedAPI.Put("/validate_certificate", require(schema.PermCertValidate), hero.Handler(h.putValidateCertificate))
+			protectedAPI.Put("/invalidate_certificate", require(schema.PermCertInvalidate), hero.Handler(h.putInvalidateCertificate))
+			protectedAPI.Delete("/certificates/{id: string}", require(schema.PermCertDelete), hero.Handler(h.deleteAssetById))
+
+			// offline signing: the client signs the proposal and the transaction with its own enrolled identity. The
+			// permission to prepare depends on the operation, it is checked by the handler
+			protectedAPI.Post("/offline/prepare/{operation: string}", hero.Handler(h.postOfflinePrepare))
+			protectedAPI.Post("/offline/endorse", require(schema.PermOfflineSign), hero.Handler(h.postOfflineEndorse))
+			protectedAPI.Post("/offline/submit", require(schema.PermOfflineSign), hero.Handler(h.postOfflineSubmit))
+
+			protectedAPI.Get("/identities/expiry", require(schema.PermIdentitiesRead), hero.Handler(h.getIdentitiesExpiry))
 		}
 	}
 	return h
@@ -82,6 +124,8 @@ func NewDappHandler(app *iris.Application, mdwAuthChecker *context.Handler, svcR
 // @Param 	Query		    body 	dto.Transaction 	true	"Data as a JSON object"
 // @Success 200 {object} dto.QueryResult "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
 // @Failure 400 {object} dto.Problem "err.processing_param"
 // @Failure 502 {object} dto.Problem "err.bad_gateway"
 // @Failure 504 {object} dto.Problem "err.network"
@@ -113,17 +157,16 @@ func (h DappHandler) postQuery(ctx *context.Context, params dto.InjectedParam) {
 // @Produce json
 // @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
 // @Param 	Transaction		body 	dto.Transaction	true	"Transaction Data"
+// @Param   dryRun          query   bool            false   "Only collect the endorsements (dto.TxSimulation), nothing is sent to the orderer" default(false)
 // @Success 202 {object} dto.Transaction "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
 // @Failure 400 {object} dto.Problem "err.processing_param"
 // @Failure 502 {object} dto.Problem "err.bad_gateway"
 // @Failure 504 {object} dto.Problem "err.network"
 // @Router /dapp/transaction [post]
 func (h DappHandler) postTransaction(ctx iris.Context, params dto.InjectedParam) {
-	if params.Role != models.Role_CertificateAdmin {
-		(*h.response).ResUnauthorized(&ctx)
-		return
-	}
 	// getting data from client
 	var requestData dto.Transaction
 
@@ -132,8 +175,14 @@ func (h DappHandler) postTransaction(ctx iris.Context, params dto.InjectedParam)
 		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
 		return
 	}
-	// trying to submit the transaction
-	bcRes, problem := (*h.service).Invoke(requestData, params.Username)
+	// trying to submit the transaction, or only to simulate it (dry run)
+	var bcRes interface{}
+	var problem *dto.Problem
+	if ctx.URLParamBoolDefault("dryRun", false) {
+		bcRes, problem = (*h.service).Simulate(requestData, &params)
+	} else {
+		bcRes, problem = (*h.service).Invoke(requestData, &params)
+	}
 	if problem != nil {
 		(*h.response).ResErr(problem, &ctx)
 		return
@@ -153,24 +202,24 @@ func (h DappHandler) postTransaction(ctx iris.Context, params dto.InjectedParam)
 // @Param   channel         query   string          true  "Insert channel" default(mychannel)"
 // @Param   chaincode       query   string          true  "Insert chaincode id" default(certificate)"
 // @Param   signer          query   string          true  "Insert signer" default(User1)"
+// @Param   dryRun          query   bool            false "Only collect the endorsements (dto.TxSimulation), nothing is sent to the orderer" default(false)
 // @Param 	Transaction		body 	dto.CreateAsset	true  "Transaction Data"
 // @Success 202 {object} dto.Asset "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
 // @Failure 400 {object} dto.Problem "err.processing_param"
 // @Failure 502 {object} dto.Problem "err.bad_gateway"
 // @Failure 504 {object} dto.Problem "err.network"
 // @Router /dapp/certificates [post]
 func (h DappHandler) postCreateAsset(ctx iris.Context, params dto.InjectedParam) {
-	if params.Role != models.Role_CertificateAdmin {
-		(*h.response).ResUnauthorized(&ctx)
-		return
-	}
 	queryParams := new(dto.QueryParamChaincode)
 	err := lib.ParamsToStruct(ctx, queryParams)
 	if err != nil {
 		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
 		return
 	}
+	queryParams.DryRun = ctx.URLParamBoolDefault("dryRun", false)
 
 	var requestData dto.CreateAsset
 	// unmarshalling the json and check
@@ -199,24 +248,24 @@ func (h DappHandler) postCreateAsset(ctx iris.Context, params dto.InjectedParam)
 // @Param   channel         query   string          true  "Insert channel" default(mychannel)"
 // @Param   chaincode       query   string          true  "Insert chaincode id" default(certificate)"
 // @Param   signer          query   string          true  "Insert signer" default(User1)"
+// @Param   dryRun          query   bool            false "Only collect the endorsements (dto.TxSimulation), nothing is sent to the orderer" default(false)
 // @Param 	Transaction		body 	dto.Asset    	true  "Transaction Data"
 // @Success 202 {object} dto.Asset "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
 // @Failure 400 {object} dto.Problem "err.processing_param"
 // @Failure 502 {object} dto.Problem "err.bad_gateway"
 // @Failure 504 {object} dto.Problem "err.network"
 // @Router /dapp/certificates [put]
 func (h DappHandler) putUpdateAsset(ctx iris.Context, params dto.InjectedParam) {
-	if params.Role != models.Role_CertificateAdmin {
-		(*h.response).ResUnauthorized(&ctx)
-		return
-	}
 	queryParams := new(dto.QueryParamChaincode)
 	err := lib.ParamsToStruct(ctx, queryParams)
 	if err != nil {
 		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
 		return
 	}
+	queryParams.DryRun = ctx.URLParamBoolDefault("dryRun", false)
 
 	var requestData dto.Asset
 	// unmarshalling the json and check
@@ -280,24 +329,25 @@ func (h DappHandler) getAssetById(ctx iris.Context) {
 // @Param   channel         query   string          true  "Insert channel" default(mychannel)"
 // @Param   chaincode       query   string          true  "Insert chaincode id" default(certificate)"
 // @Param   signer          query   string          true  "Insert signer" default(User1)"
+// @Param   dryRun          query   bool            false "Only collect the endorsements (dto.TxSimulation), nothing is sent to the orderer" default(false)
 // @Param 	Transaction		body 	dto.SignAsset	true  "Transaction Data"
 // @Success 202 {object} dto.Asset "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 403 {object} dto.Problem "err.mfa_required"
 // @Failure 400 {object} dto.Problem "err.processing_param"
 // @Failure 502 {object} dto.Problem "err.bad_gateway"
 // @Failure 504 {object} dto.Problem "err.network"
 // @Router /dapp/validate_certificate [put]
 func (h DappHandler) putValidateCertificate(ctx iris.Context, params dto.InjectedParam) {
-	if !lib.Contains([]string{models.Role_Secretary, models.Role_Dean, models.Role_Rector}, params.Role) {
-		(*h.response).ResUnauthorized(&ctx)
-		return
-	}
 	queryParams := new(dto.QueryParamChaincode)
 	err := lib.ParamsToStruct(ctx, queryParams)
 	if err != nil {
 		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
 		return
 	}
+	queryParams.DryRun = ctx.URLParamBoolDefault("dryRun", false)
 
 	var requestData dto.SignAsset
 	// unmarshalling the json and check
@@ -326,24 +376,25 @@ func (h DappHandler) putValidateCertificate(ctx iris.Context, params dto.Injecte
 // @Param   channel         query   string               true  "Insert channel" default(mychannel)"
 // @Param   chaincode       query   string               true  "Insert chaincode id" default(certificate)"
 // @Param   signer          query   string               true  "Insert signer" default(User1)"
+// @Param   dryRun          query   bool                 false "Only collect the endorsements (dto.TxSimulation), nothing is sent to the orderer" default(false)
 // @Param 	Transaction		body 	dto.InvalidateAsset	 true  "Transaction Data"
 // @Success 202 {object} dto.Asset "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 403 {object} dto.Problem "err.mfa_required"
 // @Failure 400 {object} dto.Problem "err.processing_param"
 // @Failure 502 {object} dto.Problem "err.bad_gateway"
 // @Failure 504 {object} dto.Problem "err.network"
 // @Router /dapp/invalidate_certificate [put]
 func (h DappHandler) putInvalidateCertificate(ctx iris.Context, params dto.InjectedParam) {
-	if !lib.Contains([]string{models.Role_Secretary, models.Role_Dean, models.Role_Rector, models.Role_CertificateAdmin}, params.Role) {
-		(*h.response).ResUnauthorized(&ctx)
-		return
-	}
 	queryParams := new(dto.QueryParamChaincode)
 	err := lib.ParamsToStruct(ctx, queryParams)
 	if err != nil {
 		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
 		return
 	}
+	queryParams.DryRun = ctx.URLParamBoolDefault("dryRun", false)
 
 	var requestData dto.InvalidateAsset
 	// unmarshalling the json and check
@@ -373,17 +424,16 @@ func (h DappHandler) putInvalidateCertificate(ctx iris.Context, params dto.Injec
 // @Param   channel         query   string     true  "Insert channel" default(mychannel)"
 // @Param   chaincode       query   string     true  "Insert chaincode id" default(certificate)"
 // @Param   signer          query   string     true  "Insert signer" default(User1)"
+// @Param   dryRun          query   bool       false "Only collect the endorsements (dto.TxSimulation), nothing is sent to the orderer" default(false)
 // @Success 202 {object} dto.Asset "OK"
 // @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
 // @Failure 400 {object} dto.Problem "err.processing_param"
 // @Failure 502 {object} dto.Problem "err.bad_gateway"
 // @Failure 504 {object} dto.Problem "err.network"
 // @Router /dapp/certificates/{id} [delete]
 func (h DappHandler) deleteAssetById(ctx iris.Context, params dto.InjectedParam) {
-	if params.Role != models.Role_CertificateAdmin {
-		(*h.response).ResUnauthorized(&ctx)
-		return
-	}
 	id := ctx.Params().GetString("id")
 	queryParams := new(dto.QueryParamChaincode)
 	err := lib.ParamsToStruct(ctx, queryParams)
@@ -391,8 +441,9 @@ func (h DappHandler) deleteAssetById(ctx iris.Context, params dto.InjectedParam)
 		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
 		return
 	}
+	queryParams.DryRun = ctx.URLParamBoolDefault("dryRun", false)
 
-	bcRes, problem := (*h.service).DeleteAsset(id, params.Username, queryParams)
+	bcRes, problem := (*h.service).DeleteAsset(id, &params, queryParams)
 	if problem != nil {
 		(*h.response).ResErr(problem, &ctx)
 		return
@@ -479,6 +530,211 @@ func (h DappHandler) getCertificatesByAccredited(ctx *context.Context) {
 	(*h.response).ResOKWithData(bcRes, &ctx)
 }
 
+// postOfflinePrepare Prepare the unsigned proposal of a certificate operation
+// @Summary Prepare Certificate operation for offline signing
+// @Description First step of the offline signing flow. Returns the unsigned proposal bytes of the certificate operation (create, update, validate, invalidate or delete), created by the given client identity. The "data" property holds the request body of the same operation on the certificate endpoints ({"id": "..."} for delete).
+// @Tags Offline signing
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	                    true  "Insert access token" default(Bearer <Add access token here>)
+// @Param 	operation	    path 	string                      true  "Certificate operation" Enums(create, update, validate, invalidate, delete)
+// @Param   channel         query   string                      true  "Insert channel" default(mychannel)"
+// @Param   chaincode       query   string                      true  "Insert chaincode id" default(certificate)"
+// @Param   signer          query   string                      true  "Insert signer" default(User1)"
+// @Param 	Transaction		body 	dto.OfflinePrepareRequest	true  "Client identity and operation data"
+// @Success 200 {object} dto.OfflineProposal "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 403 {object} dto.Problem "err.mfa_required"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 502 {object} dto.Problem "err.bad_gateway"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /dapp/offline/prepare/{operation} [post]
+func (h DappHandler) postOfflinePrepare(ctx iris.Context, tkData *dto.AccessTokenData) {
+	params := tkData.Claims
+	operation := ctx.Params().GetString("operation")
+	permission, ok := offlineOperationPermissions[operation]
+	if !ok {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: schema.ErrDetInvalidField}, &ctx)
+		return
+	}
+	if problem := h.policy.Check(tkData, permission); problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	queryParams := new(dto.QueryParamChaincode)
+	err := lib.ParamsToStruct(ctx, queryParams)
+	if err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	var requestData dto.OfflinePrepareRequest
+	// unmarshalling the json and check
+	if err := ctx.ReadJSON(&requestData); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+	if err := h.validate.Struct(requestData.Identity); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrValidationField, Detail: err.Error()}, &ctx)
+		return
+	}
+	queryParams.Offline = &requestData.Identity
+
+	// preparing the same transaction as the certificate endpoints, but only up to the unsigned proposal
+	var bcRes interface{}
+	var problem *dto.Problem
+	switch operation {
+	case offlineCreate:
+		var data dto.CreateAsset
+		if problem = h.decodeOfflineData(requestData.Data, &data); problem == nil {
+			bcRes, problem = (*h.service).CreateAsset(&data, params.Username, queryParams)
+		}
+	case offlineUpdate:
+		var data dto.Asset
+		if problem = h.decodeOfflineData(requestData.Data, &data); problem == nil {
+			bcRes, problem = (*h.service).UpdateAsset(&data, params.Username, queryParams)
+		}
+	case offlineValidate:
+		var data dto.SignAsset
+		if problem = h.decodeOfflineData(requestData.Data, &data); problem == nil {
+			bcRes, problem = (*h.service).ValidateAsset(&data, &params, queryParams)
+		}
+	case offlineInvalidate:
+		var data dto.InvalidateAsset
+		if problem = h.decodeOfflineData(requestData.Data, &data); problem == nil {
+			bcRes, problem = (*h.service).InvalidateAsset(&data, &params, queryParams)
+		}
+	case offlineDelete:
+		var data dto.GetRequestCC
+		if problem = h.decodeOfflineData(requestData.Data, &data); problem == nil {
+			bcRes, problem = (*h.service).DeleteAsset(data.ID, &params, queryParams)
+		}
+	}
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+
+	(*h.response).ResOKWithData(bcRes, &ctx)
+}
+
+// postOfflineEndorse Endorse the proposal signed by the client
+// @Summary Endorse offline signed proposal
+// @Description Second step of the offline signing flow. The "payload" holds the base64 "proposal" returned by the prepare step and the "signature" is the base64 client signature over those bytes. Returns the unsigned transaction to be signed by the client. The proposal must invoke the channel and the chaincode of the request, and the user also needs the permission of the chaincode function of the proposal, as on its endpoint.
+// @Tags Offline signing
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	            true  "Insert access token" default(Bearer <Add access token here>)
+// @Param   channel         query   string              true  "Insert channel" default(mychannel)"
+// @Param   chaincode       query   string              true  "Insert chaincode id" default(certificate)"
+// @Param 	Transaction		body 	dto.TxDataRequest	true  "Signed proposal"
+// @Success 200 {object} dto.OfflineTransaction "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 403 {object} dto.Problem "err.mfa_required"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 502 {object} dto.Problem "err.bad_gateway"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /dapp/offline/endorse [post]
+func (h DappHandler) postOfflineEndorse(ctx iris.Context, tkData *dto.AccessTokenData) {
+	h.offlineSignedStep(ctx, tkData, (*h.service).EndorseOffline)
+}
+
+// postOfflineSubmit Submit the transaction signed by the client
+// @Summary Submit offline signed transaction
+// @Description Last step of the offline signing flow. The "payload" holds the base64 "transaction" returned by the endorse step and the "signature" is the base64 client signature over those bytes. The transaction must invoke the channel and the chaincode of the request, and the user also needs the permission of the chaincode function of the transaction. The transaction is sent to the orderer.
+// @Tags Offline signing
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param	Authorization	header	string	            true  "Insert access token" default(Bearer <Add access token here>)
+// @Param   channel         query   string              true  "Insert channel" default(mychannel)"
+// @Param   chaincode       query   string              true  "Insert chaincode id" default(certificate)"
+// @Param 	Transaction		body 	dto.TxDataRequest	true  "Signed transaction"
+// @Success 200 {object} dto.OfflineReceipt "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 403 {object} dto.Problem "err.mfa_required"
+// @Failure 400 {object} dto.Problem "err.processing_param"
+// @Failure 502 {object} dto.Problem "err.bad_gateway"
+// @Failure 504 {object} dto.Problem "err.network"
+// @Router /dapp/offline/submit [post]
+func (h DappHandler) postOfflineSubmit(ctx iris.Context, tkData *dto.AccessTokenData) {
+	h.offlineSignedStep(ctx, tkData, (*h.service).SubmitOffline)
+}
+
+// offlineSignedStep read the bytes signed by the client and pass them to the given offline signing step
+func (h DappHandler) offlineSignedStep(ctx iris.Context, tkData *dto.AccessTokenData, step func(*dto.TxDataRequest, *dto.AccessTokenData, *dto.QueryParamChaincode) (interface{}, *dto.Problem)) {
+	queryParams := new(dto.QueryParamChaincode)
+	err := lib.ParamsToStruct(ctx, queryParams)
+	if err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	var requestData dto.TxDataRequest
+	// unmarshalling the json and check
+	if err := ctx.ReadJSON(&requestData); err != nil {
+		(*h.response).ResErr(&dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}, &ctx)
+		return
+	}
+
+	bcRes, problem := step(&requestData, tkData, queryParams)
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+
+	(*h.response).ResOKWithData(bcRes, &ctx)
+}
+
+// decodeOfflineData unmarshal and validate the operation data of an offline prepare request
+func (h DappHandler) decodeOfflineData(data json.RawMessage, target any) *dto.Problem {
+	if err := json.Unmarshal(data, target); err != nil {
+		return &dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrProcParam, Detail: err.Error()}
+	}
+	if err := h.validate.Struct(target); err != nil {
+		return &dto.Problem{Status: iris.StatusBadRequest, Title: schema.ErrValidationField, Detail: err.Error()}
+	}
+	return nil
+}
+
 // region ======== LOCAL DEPENDENCIES ====================================================
 
 // endregion =============================================================================
+
+// getIdentitiesExpiry Expiry state of the certificates of the wallet identities
+// @Summary Expiry state of the wallet identities
+// @Description Days to expiry of the X.509 certificate of every wallet identity, as of the last scheduled check. Use check=true to check right now
+// @Tags DApp
+// @Security ApiKeyAuth
+// @Produce json
+// @Param	Authorization	header	string	true 	"Insert access token" default(Bearer <Add access token here>)
+// @Param   check           query   bool    false   "Check the identities right now instead of returning the last check" default(false)
+// @Success 200 {object} dto.IdentitiesExpiry "OK"
+// @Failure 401 {object} dto.Problem "err.unauthorized"
+// @Failure 403 {object} dto.Problem "err.forbidden"
+// @Failure 403 {object} dto.Problem "err.insufficient_scope"
+// @Failure 500 {object} dto.Problem "err.crypt_material_processing"
+// @Router /dapp/identities/expiry [get]
+func (h DappHandler) getIdentitiesExpiry(ctx iris.Context) {
+
+	var status *dto.IdentitiesExpiry
+	var problem *dto.Problem
+	if ctx.URLParamBoolDefault("check", false) {
+		status, problem = (*h.svcIdentity).CheckExpiry()
+	} else {
+		status, problem = (*h.svcIdentity).ExpiryStatus()
+	}
+	if problem != nil {
+		(*h.response).ResErr(problem, &ctx)
+		return
+	}
+	(*h.response).ResOKWithData(status, &ctx)
+}
@@ -1,20 +1,63 @@
 package middlewares
 
 import (
+	"dapp/lib"
+	"dapp/schema/dto"
+
+	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/kataras/iris/v12/middleware/jwt"
-	"dapp/schema/dto"
 )
 
-// NewAuthCheckerMiddleware Bearer Authentication token verification middleware
-func NewAuthCheckerMiddleware(sigKey []byte) context.Handler {
-	checker := jwt.NewVerifier(jwt.HS256, sigKey)
-	checker.WithDefaultBlocklist() // Enable server-side token block feature (even before its expiration time):
-	// checker.WithDecryption()
+// SessionValidator checks the session of the access tokens, refusing the revoked ones
+type SessionValidator interface {
+	ValidateSession(sessionID string) error
+}
+
+// NewAuthCheckerMiddleware Bearer Authentication token verification middleware. The token is verified with the key
+// of its kid header, so the tokens signed with a rotated key keep working until they expire
+//
+// - keys [*lib.JWTKeys] ~ JWT signing and verification keys
+//
+// - blocklist [jwt.Blocklist] ~ Revoked tokens storage, shared by all the API replicas
+//
+// - sessions [SessionValidator] ~ Sessions storage, the tokens of a revoked session are refused
+func NewAuthCheckerMiddleware(keys *lib.JWTKeys, blocklist jwt.Blocklist, sessions SessionValidator) context.Handler {
+	extractors := []jwt.TokenExtractor{jwt.FromHeader, jwt.FromQuery}
+
+	return func(ctx *context.Context) {
+		var token string
+		for _, extract := range extractors {
+			if token = extract(ctx); token != "" {
+				break
+			}
+		}
 
-	return checker.Verify(func() interface{} {
-		// We can add login here
+		verifiedToken, err := keys.Verify([]byte(token), blocklist) // the blocklist validates the token server-side
+		if err != nil {
+			ctx.StopWithError(iris.StatusUnauthorized, context.PrivateError(err))
+			return
+		}
+		claims := new(dto.AccessTokenData)
+		if err = verifiedToken.Claims(claims); err != nil {
+			ctx.StopWithError(iris.StatusUnauthorized, context.PrivateError(err))
+			return
+		}
+		if claims.Session != "" { // the tokens issued before the sessions were recorded expire on their own
+			if err = sessions.ValidateSession(claims.Session); err != nil {
+				ctx.StopWithError(iris.StatusUnauthorized, context.PrivateError(err))
+				return
+			}
+		}
 
-		return new(dto.AccessTokenData)
-	})
+		ctx.SetUser(claims)
+		ctx.Values().Set(lib.TokenClaimsKey, claims)
+		ctx.SetLogoutFunc(func(ctx *context.Context) {
+			_ = blocklist.InvalidateToken(verifiedToken.Token, verifiedToken.StandardClaims)
+			ctx.Values().Remove(lib.TokenClaimsKey)
+			ctx.SetUser(nil)
+			ctx.SetLogoutFunc(nil)
+		})
+		ctx.Next()
+	}
 }
@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"dapp/lib"
+	"dapp/schema/dto"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/jwt"
+)
+
+// fakeSessions the sessions not in revoked are active
+type fakeSessions struct {
+	revoked map[string]bool
+}
+
+func (f fakeSessions) ValidateSession(sessionID string) error {
+	if f.revoked[sessionID] {
+		return jwt.ErrBlocked
+	}
+	return nil
+}
+
+func TestAuthCheckerSession(t *testing.T) {
+	t.Setenv("TEST_JWT_KEY", strings.Repeat("s", 32))
+	keys, err := lib.LoadJWTKeys(nil, "", "TEST_JWT_KEY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := fakeSessions{revoked: map[string]bool{"revoked": true}}
+
+	app := iris.New()
+	app.Get("/", NewAuthCheckerMiddleware(keys, jwt.NewBlocklist(0), sessions), func(ctx iris.Context) {
+		ctx.StatusCode(iris.StatusNoContent)
+	})
+	if err = app.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		session string
+		status  int
+	}{
+		{"active session", "active", iris.StatusNoContent},
+		{"revoked session", "revoked", iris.StatusUnauthorized},
+		// issued before the sessions were recorded
+		{"without session", "", iris.StatusNoContent},
+	}
+	for _, tt := range tests {
+		token, err := lib.MkAccessToken(&dto.AccessTokenData{Session: tt.session, Claims: dto.InjectedParam{Username: "richard"}}, keys, 15)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+string(token))
+		res := httptest.NewRecorder()
+		app.ServeHTTP(res, req)
+		if res.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, res.Code, tt.status)
+		}
+	}
+}
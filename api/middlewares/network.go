@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"net"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// NewNetworkAllowlistMiddleware only let through the requests coming from the allowed networks, e.g. to keep the
+// metrics internal. The peer address of the connection is checked, never the forwarding headers, which the clients
+// set at will
+//
+// - networks [[]*net.IPNet] ~ Networks of the allowed clients
+func NewNetworkAllowlistMiddleware(networks []*net.IPNet) context.Handler {
+	return func(ctx *context.Context) {
+		host, _, err := net.SplitHostPort(ctx.Request().RemoteAddr)
+		if err != nil {
+			host = ctx.Request().RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			for _, network := range networks {
+				if network.Contains(ip) {
+					ctx.Next()
+					return
+				}
+			}
+		}
+		ctx.StopWithStatus(iris.StatusForbidden)
+	}
+}
@@ -0,0 +1,54 @@
+package middlewares
+
+import (
+	"dapp/lib"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kataras/iris/v12"
+)
+
+func TestNetworkAllowlist(t *testing.T) {
+	networks, err := lib.ParseNetworks([]string{"127.0.0.0/8", "::1/128", "10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := iris.New()
+	app.Get("/metrics", NewNetworkAllowlistMiddleware(networks), func(ctx iris.Context) {
+		ctx.StatusCode(iris.StatusNoContent)
+	})
+	if err = app.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded string
+		status    int
+	}{
+		{"loopback", "127.0.0.1:41234", "", iris.StatusNoContent},
+		{"loopback v6", "[::1]:41234", "", iris.StatusNoContent},
+		{"internal network", "10.1.4.2:41234", "", iris.StatusNoContent},
+		{"other network", "10.2.4.2:41234", "", iris.StatusForbidden},
+		// the forwarding headers are set by the client, they can't open the access
+		{"forged header", "203.0.113.7:41234", "127.0.0.1", iris.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = tt.peer
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		res := httptest.NewRecorder()
+		app.ServeHTTP(res, req)
+		if res.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, res.Code, tt.status)
+		}
+	}
+
+	if _, err = lib.ParseNetworks([]string{"10.1.0.0"}); err == nil {
+		t.Error("ParseNetworks() of an address without prefix length must fail")
+	}
+}
@@ -0,0 +1,35 @@
+package middlewares
+
+import (
+	"dapp/lib"
+	"dapp/schema/dto"
+	"dapp/service/auth"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// PolicyMiddleware returns the middleware requiring the permission, to be registered with the route
+type PolicyMiddleware func(permission string) context.Handler
+
+// NewPolicyMiddleware role based access control middleware. The requests of the roles lacking the permission of the
+// route, or with an access token whose scope doesn't cover it, are refused with a 403 problem. It must run after the
+// auth checker middleware
+//
+// - policy [*auth.Policy] ~ Permissions of the roles
+func NewPolicyMiddleware(policy *auth.Policy) PolicyMiddleware {
+	return func(permission string) context.Handler {
+		return func(ctx *context.Context) {
+			claims, ok := ctx.Values().Get(lib.TokenClaimsKey).(*dto.AccessTokenData)
+			if !ok {
+				ctx.StopWithStatus(iris.StatusUnauthorized)
+				return
+			}
+			if problem := policy.Check(claims, permission); problem != nil {
+				ctx.StopWithProblem(int(problem.Status), iris.NewProblem().Title(problem.Title).Detail(problem.Detail))
+				return
+			}
+			ctx.Next()
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Command walletmigrate migrates the identities of the dapp wallet folder.
+//
+// By default the plaintext identities are encrypted in place; once migrated, set WalletEncrypted to true in the
+// configuration file. With -to-db the identities of the folder (plaintext or encrypted, as WalletEncrypted says) are
+// copied into the users database; once copied, set WalletStore to "db" in the configuration file.
+//
+// The key-encryption key is taken from the SERVER_WALLET_KEK environment variable or from the WalletKEKFile of the
+// configuration file (SERVER_CONFIG).
+//
+//	go run ./cmd/walletmigrate [-wallet ./wallet/wallet] [-to-db]
+package main
+
+import (
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/service/utils"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+	"gorm.io/gorm"
+)
+
+func main() {
+	svcConf := utils.NewSvcConfig()
+
+	wallet := flag.String("wallet", filepath.Join(svcConf.WalletFolder, schema.WalletStr), "wallet folder to migrate")
+	toDB := flag.Bool("to-db", false, "copy the identities into the users database instead of encrypting them in place")
+	flag.Parse()
+
+	kek, err := lib.LoadKey(schema.EnvWalletKEK, svcConf.WalletKEKFile)
+	if err != nil {
+		exit("loading the wallet key-encryption key:", err)
+	}
+
+	if !*toDB {
+		migrated, err := repo.EncryptWallet(*wallet, kek)
+		for _, label := range migrated {
+			fmt.Println("encrypted identity:", label)
+		}
+		if err != nil {
+			exit("encrypting the wallet:", err)
+		}
+		fmt.Printf("%d identities encrypted in %s\n", len(migrated), *wallet)
+		return
+	}
+
+	copied, err := copyToDatabase(*wallet, svcConf.WalletEncrypted, repo.NewRepoUser(svcConf).DB, kek)
+	for _, label := range copied {
+		fmt.Println("copied identity:", label)
+	}
+	if err != nil {
+		exit("copying the wallet:", err)
+	}
+	fmt.Printf("%d identities copied from %s into the database\n", len(copied), *wallet)
+}
+
+// copyToDatabase copies the identities of the wallet folder, plaintext or encrypted, into the wallet table of the
+// database. Returns the labels of the copied identities
+func copyToDatabase(folder string, encrypted bool, db *gorm.DB, kek []byte) ([]string, error) {
+	var src *gateway.Wallet
+	var err error
+	if encrypted {
+		src, err = repo.NewEncryptedFileSystemWallet(folder, kek)
+	} else {
+		src, err = gateway.NewFileSystemWallet(folder)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening the wallet folder: %w", err)
+	}
+	dst, err := repo.NewDatabaseWallet(db, kek)
+	if err != nil {
+		return nil, fmt.Errorf("opening the database wallet: %w", err)
+	}
+	return repo.CopyWallet(src, dst)
+}
+
+func exit(msg string, err error) {
+	fmt.Fprintln(os.Stderr, msg, err)
+	os.Exit(1)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"dapp/repo"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestCopyToDatabase(t *testing.T) {
+	kek := bytes.Repeat([]byte{7}, 32)
+	identities := map[string]*gateway.X509Identity{
+		"User1": gateway.NewX509Identity("Org1MSP", "user cert", "user key"),
+		"Admin": gateway.NewX509Identity("Org1MSP", "admin cert", "admin key"),
+	}
+
+	for _, encrypted := range []bool{false, true} {
+		folder := filepath.Join(t.TempDir(), "wallet")
+		var src *gateway.Wallet
+		var err error
+		if encrypted {
+			src, err = repo.NewEncryptedFileSystemWallet(folder, kek)
+		} else {
+			src, err = gateway.NewFileSystemWallet(folder)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for label, identity := range identities {
+			if err = src.Put(label, identity); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "users.db")), &gorm.Config{Logger: logger.Discard})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = repo.Migrate(db); err != nil {
+			t.Fatal(err)
+		}
+
+		// copying twice replaces the identities, it does not fail on the existing labels
+		for i := 0; i < 2; i++ {
+			copied, err := copyToDatabase(folder, encrypted, db, kek)
+			if err != nil || len(copied) != len(identities) {
+				t.Fatalf("encrypted %v: copyToDatabase() = %v, %v", encrypted, copied, err)
+			}
+		}
+
+		dst, err := repo.NewDatabaseWallet(db, kek)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for label, want := range identities {
+			got, err := dst.Get(label)
+			if err != nil {
+				t.Fatalf("encrypted %v: Get(%s) = %v", encrypted, label, err)
+			}
+			x509, ok := got.(*gateway.X509Identity)
+			if !ok || x509.MspID != want.MspID || x509.Certificate() != want.Certificate() || x509.Key() != want.Key() {
+				t.Errorf("encrypted %v: Get(%s) = %+v, want %+v", encrypted, label, got, want)
+			}
+		}
+	}
+
+	// the plaintext identities of a folder are not taken for encrypted ones
+	folder := filepath.Join(t.TempDir(), "wallet")
+	plain, _ := gateway.NewFileSystemWallet(folder)
+	_ = plain.Put("User1", identities["User1"])
+	db, _ := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "users.db")), &gorm.Config{Logger: logger.Discard})
+	if err := repo.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := copyToDatabase(folder, true, db, kek); err == nil {
+		t.Error("copyToDatabase() read a plaintext wallet as an encrypted one")
+	}
+}
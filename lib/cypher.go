@@ -1,15 +1,19 @@
 package lib
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/kataras/iris/v12/middleware/jwt"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"hash"
 	"io"
+	"os"
 	"strings"
 	"time"
 
@@ -17,8 +21,17 @@ import (
 )
 
 // MkAccessToken create a signed JTW token with the specified data. This could be used for authentication purpose by a middleware
-func MkAccessToken(data *dto.AccessTokenData, sigKey []byte, tkAge uint8) ([]byte, error) { // https://github.com/kataras/iris/blob/master/_examples/auth/jwt/middleware/main.go | https://github.com/iris-contrib/examples/blob/master/auth/jwt/basic/main.go
-	tk, err := jwt.Sign(jwt.HS256, sigKey, data, jwt.MaxAge(time.Duration(tkAge)*time.Minute))
+func MkAccessToken(data *dto.AccessTokenData, keys *JWTKeys, tkAge uint8) ([]byte, error) { // https://github.com/kataras/iris/blob/master/_examples/auth/jwt/middleware/main.go | https://github.com/iris-contrib/examples/blob/master/auth/jwt/basic/main.go
+	// the token ID (jti) and the subject (sub) let the blocklist revoke a single token or all the tokens of a user
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return nil, err
+	}
+	standardClaims := jwt.Claims{ID: hex.EncodeToString(jti), Subject: data.Claims.Username}
+	claims := *data
+	claims.IssuedAtMs = time.Now().UnixMilli()
+
+	tk, err := keys.Sign(claims, jwt.MaxAge(time.Duration(tkAge)*time.Minute), standardClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +66,79 @@ func Checksum(algorithm string, data []byte) (checksum string, err error) {
 	return str, nil
 }
 
+// KeySize size in bytes of the AES-256 keys used by Seal and Open
+const KeySize = 32
+
+// Seal encrypts and authenticates the plaintext with AES-256-GCM. The additional data is authenticated but not
+// encrypted, it must be the same when opening. The random nonce is prepended to the returned ciphertext
+func Seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts and verifies a ciphertext created by Seal with the same key and additional data
+func Open(key, ciphertext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+	return gcm.Open(nil, nonce, sealed, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key size %d, must be %d bytes", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadKey read a base64 encoded AES-256 key from the environment variable or, if it is not set, from the file
+//
+// - env [string] ~ Environment variable holding the key
+//
+// - file [string] ~ Path to the file holding the key
+func LoadKey(env, file string) ([]byte, error) {
+	encoded, found := os.LookupEnv(env)
+	if !found {
+		if file == "" {
+			return nil, fmt.Errorf("no key found, set the %s environment variable or the key file", env)
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(content)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("the key must be base64 encoded: %s", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key size %d, must be %d bytes", len(key), KeySize)
+	}
+	return key, nil
+}
+
 // GenerateUUIDBytes returns a UUID based on RFC 4122 returning the generated bytes
 func GenerateUUIDBytes() []byte {
 	uuid := make([]byte, 16)
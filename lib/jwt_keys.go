@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"dapp/schema/dto"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kataras/jwt"
+)
+
+// JWTKeyConf configuration of a JWT key. The keys without private key only verify tokens, e.g. the previous signing
+// key during a rotation, so the tokens it signed stay valid until they expire
+type JWTKeyConf struct {
+	ID      string // kid
+	Alg     string // HS256, RS256 or EdDSA
+	Private string // file with the PEM private key, or the secret for HS256
+	Public  string // file with the PEM public key, derived from the private key when empty
+}
+
+// JWTKeys the keys that sign and verify the access tokens, by kid
+type JWTKeys struct {
+	SignKID string
+	Keys    jwt.Keys
+}
+
+var jwtAlgs = map[string]jwt.Alg{
+	jwt.HS256.Name(): jwt.HS256,
+	jwt.RS256.Name(): jwt.RS256,
+	jwt.EdDSA.Name(): jwt.EdDSA,
+}
+
+// LoadJWTKeys load the JWT keys. The private key of the signing key can also be set in the given environment
+// variable, which takes precedence over its file. Without keys configured, a single HS256 key, with the secret taken
+// from the environment variable, is used
+//
+// - confs [[]JWTKeyConf] ~ Keys configuration
+//
+// - signKID [string] ~ kid of the key that signs the new tokens
+//
+// - env [string] ~ Environment variable with the private key (PEM) or the secret of the signing key
+func LoadJWTKeys(confs []JWTKeyConf, signKID string, env string) (*JWTKeys, error) {
+	if len(confs) == 0 {
+		confs = []JWTKeyConf{{ID: "default", Alg: jwt.HS256.Name()}}
+		signKID = "default"
+	}
+
+	k := &JWTKeys{SignKID: signKID, Keys: make(jwt.Keys, len(confs))}
+	for _, conf := range confs {
+		alg, ok := jwtAlgs[conf.Alg]
+		if !ok {
+			return nil, fmt.Errorf("the %s JWT key has the unsupported algorithm %q, use HS256, RS256 or EdDSA", conf.ID, conf.Alg)
+		}
+
+		private, err := readKeyFile(conf.Private)
+		if err != nil {
+			return nil, fmt.Errorf("the %s JWT key: %s", conf.ID, err)
+		}
+		if fromEnv := os.Getenv(env); fromEnv != "" && conf.ID == signKID {
+			private = []byte(fromEnv)
+		}
+		public, err := readKeyFile(conf.Public)
+		if err != nil {
+			return nil, fmt.Errorf("the %s JWT key: %s", conf.ID, err)
+		}
+
+		key, err := parseJWTKey(conf.ID, alg, private, public)
+		if err != nil {
+			return nil, err
+		}
+		k.Keys[conf.ID] = key
+	}
+
+	signKey, ok := k.Keys[signKID]
+	if !ok {
+		return nil, fmt.Errorf("the JWT signing key %q is not configured", signKID)
+	}
+	if signKey.Private == nil {
+		return nil, fmt.Errorf("the JWT signing key %q has no private key, set it in its file or in %s", signKID, env)
+	}
+	return k, nil
+}
+
+// Sign the claims with the signing key, the kid is added to the token header
+func (k *JWTKeys) Sign(claims interface{}, opts ...jwt.SignOption) ([]byte, error) {
+	return k.Keys.SignToken(k.SignKID, claims, opts...)
+}
+
+// Verify the token with the key of its kid
+func (k *JWTKeys) Verify(token []byte, validators ...jwt.TokenValidator) (*jwt.VerifiedToken, error) {
+	return jwt.VerifyWithHeaderValidator(nil, nil, token, k.Keys.ValidateHeader, validators...)
+}
+
+// JWKS the public keys as a JSON Web Key Set (RFC 7517), so other services can verify our tokens. The HS256 keys are
+// secrets, they are never published
+func (k *JWTKeys) JWKS() dto.JWKSet {
+	set := dto.JWKSet{Keys: []dto.JWK{}}
+	for kid, key := range k.Keys {
+		jwk := dto.JWK{Kid: kid, Alg: key.Alg.Name(), Use: "sig"}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+func parseJWTKey(kid string, alg jwt.Alg, private, public []byte) (*jwt.Key, error) {
+	key := &jwt.Key{ID: kid, Alg: alg}
+
+	if alg == jwt.HS256 {
+		if len(private) < 32 {
+			return nil, fmt.Errorf("the %s JWT key: the HS256 secret must have at least 32 bytes", kid)
+		}
+		key.Private, key.Public = private, private
+		return key, nil
+	}
+
+	var err error
+	key.Private, key.Public, err = alg.(jwt.AlgParser).Parse(private, public)
+	if err != nil {
+		return nil, fmt.Errorf("the %s JWT key: %s", kid, err)
+	}
+	if key.Public == nil && key.Private != nil {
+		key.Public = key.Private.(crypto.Signer).Public()
+	}
+	if key.Public == nil {
+		return nil, fmt.Errorf("the %s JWT key has no key", kid)
+	}
+	return key, nil
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, errors.New("reading the key file: " + err.Error())
+	}
+	return []byte(strings.TrimSpace(string(content))), nil
+}
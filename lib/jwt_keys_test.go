@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kataras/jwt"
+)
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJWTKeysRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	oldPrivate := writePEM(t, dir, "old.key", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	oldPublic := writePEM(t, dir, "old.pub", "PUBLIC KEY", rsaPub)
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	newPrivate := writePEM(t, dir, "new.key", "PRIVATE KEY", edDER)
+
+	// before the rotation the old key signs
+	before, err := LoadJWTKeys([]JWTKeyConf{{ID: "old", Alg: "RS256", Private: oldPrivate}}, "old", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := before.Sign(map[string]string{"user": "richard"}, jwt.MaxAge(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// after the rotation the new key signs and the old one only verifies
+	after, err := LoadJWTKeys([]JWTKeyConf{
+		{ID: "new", Alg: "EdDSA", Private: newPrivate},
+		{ID: "old", Alg: "RS256", Public: oldPublic},
+	}, "new", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := after.Sign(map[string]string{"user": "richard"}, jwt.MaxAge(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string][]byte{"old key": oldToken, "new key": newToken} {
+		if _, err := after.Verify(token); err != nil {
+			t.Errorf("the token signed with the %s must verify: %v", name, err)
+		}
+	}
+	if _, err := before.Verify(newToken); err == nil {
+		t.Error("the token signed with an unknown kid must not verify")
+	}
+
+	jwks := after.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Kid != "new" || jwks.Keys[0].Kty != "OKP" || jwks.Keys[1].Kty != "RSA" {
+		t.Errorf("unexpected JWKS %+v", jwks)
+	}
+}
+
+func TestLoadJWTKeysFromEnv(t *testing.T) {
+	const env = "TEST_JWT_SIGN_KEY"
+
+	t.Setenv(env, "")
+	if _, err := LoadJWTKeys(nil, "", env); err == nil {
+		t.Error("a HS256 key without secret must fail")
+	}
+
+	t.Setenv(env, "secret__sample__with__32__chars_")
+	keys, err := LoadJWTKeys(nil, "", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.SignKID != "default" || keys.Keys["default"].Alg != jwt.HS256 {
+		t.Errorf("unexpected default key %+v", keys)
+	}
+	if jwks := keys.JWKS(); len(jwks.Keys) != 0 {
+		t.Error("the HS256 secret must not be published")
+	}
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// PasswordParams argon2id cost of the password hashes
+type PasswordParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+const (
+	passwordSaltSize = 16
+	passwordKeySize  = 32
+)
+
+// DefaultPasswordParams argon2id cost recommended by RFC 9106 for memory constrained environments
+var DefaultPasswordParams = PasswordParams{Memory: 64 * 1024, Iterations: 3, Parallelism: 2}
+
+// HashPassword hash the password with argon2id and a random salt. The hash is encoded in the PHC string format, so
+// it carries its own version and cost:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<base64 salt>$<base64 key>
+func HashPassword(password string, params PasswordParams) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, passwordKeySize)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Iterations,
+		params.Parallelism, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword check the password against the stored hash. Besides the argon2id hashes, it accepts the legacy
+// unsalted SHA256 hex digests. rehash is true when the password matches but the hash must be upgraded: it is a legacy
+// hash or its cost differs from params
+func VerifyPassword(password, encoded string, params PasswordParams) (match bool, rehash bool, err error) {
+	if !strings.HasPrefix(encoded, "$") {
+		checksum, _ := Checksum("SHA256", []byte(password))
+		match = subtle.ConstantTimeCompare([]byte(checksum), []byte(strings.ToLower(encoded))) == 1
+		return match, match, nil
+	}
+
+	hashParams, salt, key, err := decodePasswordHash(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, hashParams.Iterations, hashParams.Memory, hashParams.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+	return true, hashParams != params, nil
+}
+
+func decodePasswordHash(encoded string) (params PasswordParams, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("unsupported password hash format")
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, err
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, err
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, err
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, nil, nil, err
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,40 @@
+package lib
+
+import "testing"
+
+func TestVerifyPassword(t *testing.T) {
+	params := PasswordParams{Memory: 1024, Iterations: 1, Parallelism: 1}
+	hash, err := HashPassword("password1", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, _ := Checksum("SHA256", []byte("password1"))
+	stronger := PasswordParams{Memory: 2048, Iterations: 2, Parallelism: 1}
+
+	tests := []struct {
+		name       string
+		password   string
+		hash       string
+		params     PasswordParams
+		wantMatch  bool
+		wantRehash bool
+	}{
+		{"argon2id", "password1", hash, params, true, false},
+		{"argon2id wrong password", "password2", hash, params, false, false},
+		{"argon2id outdated cost", "password1", hash, stronger, true, true},
+		{"legacy sha256", "password1", legacy, params, true, true},
+		{"legacy sha256 wrong password", "password2", legacy, params, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, rehash, err := VerifyPassword(tt.password, tt.hash, tt.params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if match != tt.wantMatch || rehash != tt.wantRehash {
+				t.Errorf("VerifyPassword() = %v, %v, want %v, %v", match, rehash, tt.wantMatch, tt.wantRehash)
+			}
+		})
+	}
+}
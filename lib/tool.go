@@ -6,8 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/kataras/iris/v12"
-	"github.com/kataras/iris/v12/middleware/jwt"
 
+	"net"
 	"os"
 	"reflect"
 	"strconv"
@@ -19,14 +19,22 @@ import (
 	"golang.org/x/text/unicode/norm"
 )
 
+// TokenClaimsKey context value key of the access token claims, set by the auth checker middleware
+const TokenClaimsKey = "dapp.jwt.claims"
+
 // DepObtainUserDid this tries to get the user DID store in the previously generated auth Bearer token.
 func DepObtainUserDid(ctx iris.Context) dto.InjectedParam {
-	tkData := jwt.Get(ctx).(*dto.AccessTokenData)
+	tkData := ctx.Values().Get(TokenClaimsKey).(*dto.AccessTokenData)
 
 	// returning the DID and Identifier (Username)
 	return tkData.Claims
 }
 
+// DepObtainTokenData the whole access token data, with the scope of the token besides the user claims
+func DepObtainTokenData(ctx iris.Context) *dto.AccessTokenData {
+	return ctx.Values().Get(TokenClaimsKey).(*dto.AccessTokenData)
+}
+
 func ParamsToStruct(ctx iris.Context, resStruct any) error {
 	paramsMap := ctx.URLParams()
 	paramsEncoded, err := json.Marshal(paramsMap)
@@ -48,6 +56,28 @@ func Contains(arr []string, elem string) bool {
 	return false
 }
 
+// SplitFunction splits a chaincode function addressed as "<contract>:<function>" at its first colon. A function without
+// a contract returns an empty contract
+func SplitFunction(function string) (string, string) {
+	if i := strings.Index(function, ":"); i != -1 {
+		return function[:i], function[i+1:]
+	}
+	return "", function
+}
+
+// ParseNetworks parses a list of CIDR networks, e.g. "10.0.0.0/8" or "::1/128"
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
 func SliceToMap(slice []string, dMap map[string]string) {
 	for _, data := range slice {
 		if _, ok := dMap[data]; !ok {
@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP (RFC 6238) parameters, the defaults of the authenticator apps: HMAC-SHA1, 6 digits codes and 30 seconds steps
+const (
+	TOTPDigits     = 6
+	TOTPPeriod     = 30 * time.Second
+	totpSecretSize = 20        // bytes, the HMAC-SHA1 output size recommended by RFC 4226
+	totpModulo     = 1_000_000 // 10^TOTPDigits
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random TOTP secret, base32 encoded as expected by the authenticator apps
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPStep time step of the given time
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod/time.Second)
+}
+
+// TOTPCode the code of the secret at the given time step (RFC 4226 HOTP with the step as counter)
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %s", err)
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTPDigits, value%totpModulo), nil
+}
+
+// ValidateTOTP check the code against the steps around the given time, so a small clock drift of the authenticator is
+// tolerated. Returns the matching step, the caller must refuse the steps already used so a code can't be replayed
+//
+// - secret [string] ~ Base32 encoded TOTP secret
+//
+// - code [string] ~ Code typed by the user
+//
+// - t [time.Time] ~ Current time
+//
+// - skew [int] ~ Steps accepted before and after the current one
+func ValidateTOTP(secret, code string, t time.Time, skew int) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+
+	current := TOTPStep(t)
+	for step := current - int64(skew); step <= current+int64(skew); step++ {
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPURI otpauth:// URI of the secret, usually shown as a QR code to be scanned by the authenticator app
+//
+// - issuer [string] ~ Name of the service shown by the authenticator
+//
+// - account [string] ~ Username shown by the authenticator
+//
+// - secret [string] ~ Base32 encoded TOTP secret
+func TOTPURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(int(TOTPPeriod/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret "12345678901234567890", the SHA1 secret of the RFC 6238 test vectors
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B vectors, truncated to 6 digits
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range vectors {
+		code, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil || code != tt.code {
+			t.Errorf("TOTPCode(T=%d) = %s, %v, want %s", tt.unix, code, err, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	previous, _ := TOTPCode(rfc6238Secret, TOTPStep(now)-1)
+	old, _ := TOTPCode(rfc6238Secret, TOTPStep(now)-2)
+
+	if step, ok := ValidateTOTP(rfc6238Secret, "081804", now, 1); !ok || step != TOTPStep(now) {
+		t.Errorf("the current code must validate, got step %d, %v", step, ok)
+	}
+	if step, ok := ValidateTOTP(rfc6238Secret, previous, now, 1); !ok || step != TOTPStep(now)-1 {
+		t.Errorf("the code of the previous step must validate within the skew, got step %d, %v", step, ok)
+	}
+	for _, code := range []string{old, "000000", "81804", ""} {
+		if _, ok := ValidateTOTP(rfc6238Secret, code, now, 1); ok {
+			t.Errorf("the code %q must not validate", code)
+		}
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TOTPCode(secret, 1); err != nil {
+		t.Errorf("the new secret %q must be usable: %v", secret, err)
+	}
+	uri := TOTPURI("dapp", "richard", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/dapp:richard?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("unexpected URI %s", uri)
+	}
+}
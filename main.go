@@ -5,6 +5,8 @@ import (
 	"dapp/api/middlewares"
 	"dapp/docs"
 	"dapp/lib"
+	"dapp/repo"
+	"dapp/service/auth"
 	"dapp/service/cron"
 	"dapp/service/utils"
 	"fmt"
@@ -66,14 +68,19 @@ func newApp() (*iris.Application, *utils.SvcConfig) {
 	app.UseRouter(crs) // Recovery middleware recovers from any panics and writes a 500 if there was one.
 
 	// custom middleware
-	mdwAuthChecker := middlewares.NewAuthCheckerMiddleware([]byte(svcConfig.JWTSignKey))
+	repoBlocklist := repo.NewRepoBlocklist(svcConfig)
+	mdwAuthChecker := middlewares.NewAuthCheckerMiddleware(svcConfig.JWT, repoBlocklist, repoBlocklist)
+	policy, err := auth.NewPolicy(repo.NewRepoUser(svcConfig), svcConfig) // permissions of the roles, checked per route
+	if err != nil {
+		panic(err.Error())
+	}
 
 	// endregion =============================================================================
 
 	// region ======== ENDPOINT REGISTRATIONS ================================================
 
-	endpoints.NewAuthHandler(app, &mdwAuthChecker, svcResponse, svcConfig, validate)
-	endpoints.NewDappHandler(app, &mdwAuthChecker, svcResponse, svcConfig, validate, universalTranslator) // Dapp request handlers
+	endpoints.NewAuthHandler(app, &mdwAuthChecker, policy, svcResponse, svcConfig, validate)
+	endpoints.NewDappHandler(app, &mdwAuthChecker, policy, svcResponse, svcConfig, validate, universalTranslator) // Dapp request handlers
 	// endregion =============================================================================
 
 	// region ======== SWAGGER REGISTRATION ==================================================
@@ -16,7 +16,6 @@ import (
 
 	//"reflect"
 	"runtime"
-	"strings"
 
 	"dapp/schema"
 	"os"
@@ -126,7 +125,7 @@ func TestListUsers(t *testing.T) {
 }
 
 func fakeAccessToken(username string) []byte {
-	tokenData := dto.AccessTokenData{Scope: strings.Fields("dapp.fabric"), Claims: dto.InjectedParam{Username: username, Role: username}}
-	accessToken, _ := lib.MkAccessToken(&tokenData, []byte(appConf.JWTSignKey), appConf.TkMaxAge)
+	tokenData := dto.AccessTokenData{Scope: schema.Scopes, Claims: dto.InjectedParam{Username: username, Role: username}}
+	accessToken, _ := lib.MkAccessToken(&tokenData, appConf.JWT, appConf.TkMaxAge)
 	return accessToken
 }
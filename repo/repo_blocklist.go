@@ -0,0 +1,200 @@
+package repo
+
+import (
+	"dapp/lib"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// region ======== SETUP =================================================================
+
+// RepoBlocklist implements the Iris JWT blocklist over the users database, so a revoked access token stays revoked
+// after a restart and on every API replica
+type RepoBlocklist struct {
+	DB       *gorm.DB
+	tkMaxAge time.Duration // access token max age, a user revocation is purged once all the tokens it blocks expired
+}
+
+var singletonRB *RepoBlocklist
+
+// using Go sync package to invoke a method exactly only once
+var onceRB sync.Once
+
+// blocklistGCEvery interval between purges of the expired entries, the same the Iris default blocklist uses
+const blocklistGCEvery = 30 * time.Minute
+
+// sessionTouchEvery the last use of a session is only written when older than this, not on every request
+const sessionTouchEvery = time.Minute
+
+var _ jwt.Blocklist = (*RepoBlocklist)(nil)
+
+// endregion =============================================================================
+
+func NewRepoBlocklist(svcConf *utils.SvcConfig) *RepoBlocklist {
+	onceRB.Do(func() {
+		singletonRB = &RepoBlocklist{
+			DB:       NewRepoUser(svcConf).DB,
+			tkMaxAge: time.Duration(svcConf.TkMaxAge) * time.Minute,
+		}
+		go singletonRB.runGC(blocklistGCEvery)
+	})
+	return singletonRB
+}
+
+// region ======== METHODS ===============================================================
+
+// ValidateToken completes the jwt.TokenValidator interface. Returns jwt.ErrBlocked if the token, or all the tokens
+// of its user, were revoked. The token is refused too when the revocations can't be read, e.g. with the database down
+func (r *RepoBlocklist) ValidateToken(token []byte, c jwt.Claims, err error) error {
+	if err != nil {
+		if err == jwt.ErrExpired {
+			_ = r.Del(blocklistKey(token, c))
+		}
+		return err // respect the previous error.
+	}
+
+	has, err := r.Has(blocklistKey(token, c))
+	if err != nil {
+		return err
+	}
+	if has {
+		return jwt.ErrBlocked
+	}
+	if c.Subject != "" {
+		var revocation models.TokenRevocation
+		result := r.DB.Limit(1).Find(&revocation, "username = ?", c.Subject)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 && issuedAtMs(token, c) < revocation.RevokedAt.UnixMilli() {
+			return jwt.ErrBlocked
+		}
+	}
+	return nil
+}
+
+// InvalidateToken block a verified access token until it expires
+func (r *RepoBlocklist) InvalidateToken(token []byte, c jwt.Claims) error {
+	if len(token) == 0 {
+		return jwt.ErrMissing
+	}
+
+	blocked := models.BlockedToken{Key: blocklistKey(token, c), Expiry: time.Unix(c.Expiry, 0)}
+	return r.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&blocked).Error
+}
+
+// RevokeUserTokens block all the access tokens issued to the user until now
+func (r *RepoBlocklist) RevokeUserTokens(username string) error {
+	return revokeAccessTokens(r.DB, username, time.Now())
+}
+
+// revokeAccessTokens record the revocation of the access tokens issued to the user before the given time
+func revokeAccessTokens(tx *gorm.DB, username string, revokedAt time.Time) error {
+	revocation := models.TokenRevocation{Username: username, RevokedAt: revokedAt}
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&revocation).Error
+}
+
+// ValidateSession returns jwt.ErrBlocked if the session of the access token was revoked, otherwise records its use
+func (r *RepoBlocklist) ValidateSession(sessionID string) error {
+	var session models.Session
+	result := r.DB.Limit(1).Find(&session, "id = ?", sessionID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 || session.RevokedAt != nil {
+		return jwt.ErrBlocked
+	}
+
+	if now := time.Now(); now.Sub(session.LastUsedAt) > sessionTouchEvery {
+		if err := r.DB.Model(&session).Update("last_used_at", now).Error; err != nil {
+			log.Printf("failed to record the use of the session of the user %s: %s", session.Username, err)
+		}
+	}
+	return nil
+}
+
+// Del removes a token, by its key, from the blocklist
+func (r *RepoBlocklist) Del(key string) error {
+	return r.DB.Delete(&models.BlockedToken{}, "key = ?", key).Error
+}
+
+// Has reports whether the token with the given key is blocked
+func (r *RepoBlocklist) Has(key string) (bool, error) {
+	if len(key) == 0 {
+		return false, jwt.ErrMissing
+	}
+
+	var count int64
+	result := r.DB.Model(&models.BlockedToken{}).Where("key = ?", key).Count(&count)
+	return count > 0, result.Error
+}
+
+// Count returns the total amount of blocked tokens
+func (r *RepoBlocklist) Count() (int64, error) {
+	var count int64
+	result := r.DB.Model(&models.BlockedToken{}).Count(&count)
+	return count, result.Error
+}
+
+// GC removes the expired blocked tokens and the user revocations older than the access token max age, when none of
+// the access tokens they block can be valid anymore
+func (r *RepoBlocklist) GC() (int64, error) {
+	now := time.Now()
+	result := r.DB.Where("expiry < ?", now).Delete(&models.BlockedToken{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	purged := result.RowsAffected
+
+	result = r.DB.Where("revoked_at < ?", now.Add(-r.tkMaxAge)).Delete(&models.TokenRevocation{})
+	return purged + result.RowsAffected, result.Error
+}
+
+func (r *RepoBlocklist) runGC(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := r.GC(); err != nil {
+			log.Printf("failed to purge the expired blocked tokens: %s", err)
+		}
+	}
+}
+
+// endregion =============================================================================
+
+// blocklistKey the token ID (jti) when present, otherwise the token itself. Only its SHA256 is stored
+func blocklistKey(token []byte, c jwt.Claims) string {
+	key := c.ID
+	if key == "" {
+		key = string(token)
+	}
+	checksum, _ := lib.Checksum(lib.SHA256, []byte(key))
+	return checksum
+}
+
+// issuedAtMs the issue time of the token in milliseconds, from its IssuedAtMs claim. A whole second isn't precise
+// enough, the token issued on a login right after a revocation would be revoked too. The tokens without the claim fall
+// back to their iat second
+func issuedAtMs(token []byte, c jwt.Claims) int64 {
+	var claims struct{ IssuedAtMs int64 }
+	if parts := strings.Split(string(token), "."); len(parts) == 3 {
+		if payload, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil {
+			_ = json.Unmarshal(payload, &claims)
+		}
+	}
+	if claims.IssuedAtMs == 0 {
+		return c.IssuedAt * 1000
+	}
+	return claims.IssuedAtMs
+}
@@ -0,0 +1,217 @@
+package repo
+
+import (
+	"dapp/schema"
+	"dapp/schema/models"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kataras/iris/v12/middleware/jwt"
+)
+
+// testToken a token with the IssuedAtMs claim, only its payload is read by the blocklist
+func testToken(issuedAt time.Time) []byte {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"IssuedAtMs":%d}`, issuedAt.UnixMilli())))
+	return []byte("e30." + payload + ".c2ln")
+}
+
+func TestRepoBlocklist(t *testing.T) {
+	r := &RepoBlocklist{DB: newTestDB(t), tkMaxAge: 15 * time.Minute}
+	now := time.Now()
+	claims := jwt.Claims{ID: "jti-1", Subject: "richard", IssuedAt: now.Unix(), Expiry: now.Add(time.Minute).Unix()}
+
+	token := testToken(now)
+	if err := r.ValidateToken(token, claims, nil); err != nil {
+		t.Fatalf("ValidateToken() = %v", err)
+	}
+	if err := r.InvalidateToken(token, claims); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ValidateToken(token, claims, nil); err != jwt.ErrBlocked {
+		t.Errorf("ValidateToken() of an invalidated token = %v, want ErrBlocked", err)
+	}
+	other := jwt.Claims{ID: "jti-2", Subject: "richard", IssuedAt: now.Unix()}
+	if err := r.ValidateToken(testToken(now), other, nil); err != nil {
+		t.Errorf("ValidateToken() of another token = %v, only the invalidated one is blocked", err)
+	}
+}
+
+// TestRepoBlocklistFailClosed a token is refused when the blocklist or the revocations can't be read
+func TestRepoBlocklistFailClosed(t *testing.T) {
+	now := time.Now()
+	claims := jwt.Claims{ID: "jti-1", Subject: "richard", IssuedAt: now.Unix(), Expiry: now.Add(time.Minute).Unix()}
+
+	tests := []struct {
+		name    string
+		breakDB func(r *RepoBlocklist) error
+	}{
+		{"database down", func(r *RepoBlocklist) error {
+			sqlDB, err := r.DB.DB()
+			if err != nil {
+				return err
+			}
+			return sqlDB.Close()
+		}},
+		{"revocations unreadable", func(r *RepoBlocklist) error {
+			return r.DB.Migrator().DropTable(&models.TokenRevocation{})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RepoBlocklist{DB: newTestDB(t), tkMaxAge: 15 * time.Minute}
+			if err := tt.breakDB(r); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.ValidateToken(testToken(now), claims, nil); err == nil {
+				t.Error("ValidateToken() accepted the token without reading the revocations")
+			}
+		})
+	}
+}
+
+func TestRepoBlocklistRevokeUser(t *testing.T) {
+	r := &RepoBlocklist{DB: newTestDB(t), tkMaxAge: 15 * time.Minute}
+	before := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	if err := r.RevokeUserTokens("richard"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	after := time.Now()
+
+	tests := []struct {
+		name    string
+		token   []byte
+		claims  jwt.Claims
+		blocked bool
+	}{
+		{"issued before", testToken(before), jwt.Claims{Subject: "richard", IssuedAt: before.Unix()}, true},
+		// a login right after the revocation, likely in the same second
+		{"issued after", testToken(after), jwt.Claims{Subject: "richard", IssuedAt: after.Unix()}, false},
+		{"without IssuedAtMs", []byte("e30.e30.c2ln"), jwt.Claims{Subject: "richard", IssuedAt: before.Unix()}, true},
+		{"another user", testToken(before), jwt.Claims{Subject: "tom", IssuedAt: before.Unix()}, false},
+	}
+	for _, tt := range tests {
+		err := r.ValidateToken(tt.token, tt.claims, nil)
+		if tt.blocked && err != jwt.ErrBlocked || !tt.blocked && err != nil {
+			t.Errorf("%s: ValidateToken() = %v, want blocked %t", tt.name, err, tt.blocked)
+		}
+	}
+}
+
+func TestRepoBlocklistGC(t *testing.T) {
+	r := &RepoBlocklist{DB: newTestDB(t), tkMaxAge: 15 * time.Minute}
+	now := time.Now()
+	r.DB.Create(&[]models.BlockedToken{{Key: "expired", Expiry: now.Add(-time.Second)}, {Key: "valid", Expiry: now.Add(time.Minute)}})
+	r.DB.Create(&[]models.TokenRevocation{{Username: "richard", RevokedAt: now.Add(-time.Hour)}, {Username: "tom", RevokedAt: now}})
+
+	purged, err := r.GC()
+	if err != nil || purged != 2 {
+		t.Fatalf("GC() = %d, %v, want the expired token and the old revocation", purged, err)
+	}
+	if has, _ := r.Has("valid"); !has {
+		t.Error("GC() purged a token not expired yet")
+	}
+	if count, _ := r.Count(); count != 1 {
+		t.Errorf("Count() = %d, want 1", count)
+	}
+}
+
+func TestRepoUserPurge(t *testing.T) {
+	r := &RepoUser{DB: newTestDB(t)}
+	now := time.Now()
+	revoked := now.Add(-time.Hour)
+	r.DB.Create(&[]models.LoginAttempt{
+		{Key: "user:stale", Failures: 3, LastFailureAt: now.Add(-48 * time.Hour), LockedUntil: now.Add(-47 * time.Hour)},
+		{Key: "user:locked", Failures: 5, LastFailureAt: now.Add(-48 * time.Hour), LockedUntil: now.Add(time.Hour)},
+	})
+	r.DB.Create(&[]models.OIDCLogin{{State: "abandoned", Nonce: "n", CodeVerifier: "v", ExpiresAt: now.Add(-time.Minute)}})
+	r.DB.Create(&[]models.Session{
+		{ID: "expired", Username: "richard", ExpiresAt: now.Add(-time.Hour)},
+		{ID: "revoked", Username: "richard", ExpiresAt: now.Add(time.Hour), RevokedAt: &revoked},
+		{ID: "active", Username: "richard", ExpiresAt: now.Add(time.Hour)},
+	})
+
+	used := now.Add(-2 * time.Hour)
+	r.DB.Create(&[]models.RefreshToken{
+		{TokenHash: "expired", FamilyID: "expired", Username: "richard", ExpiresAt: now.Add(-time.Hour), UsedAt: &used},
+		{TokenHash: "used", FamilyID: "active", Username: "richard", ExpiresAt: now.Add(time.Hour), UsedAt: &used},
+	})
+
+	purged, err := r.Purge(15 * time.Minute)
+	if err != nil || purged != 5 {
+		t.Fatalf("Purge() = %d, %v, want the stale attempt, the abandoned login, a refresh token and 2 sessions", purged, err)
+	}
+	if _, err = r.GetRefreshToken("used"); err != nil {
+		t.Error("Purge() removed a used refresh token not expired yet, its reuse would not be detected")
+	}
+	if attempts, _ := r.GetLoginAttempts("user:locked"); len(attempts) != 1 {
+		t.Error("Purge() removed a locked login attempt")
+	}
+	if sessions, _ := r.GetSessions("richard"); len(sessions) != 1 || sessions[0].ID != "active" {
+		t.Errorf("sessions = %+v, want the active one", sessions)
+	}
+}
+
+func TestRepoUserChangeRevokes(t *testing.T) {
+	r := &RepoUser{DB: newTestDB(t)}
+	revoked := func() bool {
+		var count int64
+		r.DB.Model(&models.TokenRevocation{}).Where("username = ?", "richard").Count(&count)
+		r.DB.Where("1 = 1").Delete(&models.TokenRevocation{})
+		return count == 1
+	}
+
+	user, _ := r.UpsertDirectoryUser(models.User{Username: "richard", Role: models.Role_Dean, Provider: schema.ProviderLDAP}, "ldap")
+	if _, err := r.UpsertDirectoryUser(models.User{Username: "richard", FirstName: "Richard", Role: models.Role_Dean, Provider: schema.ProviderLDAP}, "ldap"); err != nil || revoked() {
+		t.Errorf("UpsertDirectoryUser() = %v, a login with the same role must not revoke the tokens", err)
+	}
+	if _, err := r.UpsertDirectoryUser(models.User{Username: "richard", Role: models.Role_Rector, Provider: schema.ProviderLDAP}, "ldap"); err != nil || !revoked() {
+		t.Errorf("UpsertDirectoryUser() = %v, a role change must revoke the tokens", err)
+	}
+
+	user, _ = r.GetUser(user.ID)
+	user.LastName = "Roe"
+	if _, err := r.UpdateUser(user.ID, user, "admin"); err != nil || revoked() {
+		t.Errorf("UpdateUser() = %v, a profile change must not revoke the tokens", err)
+	}
+	user.Role = models.Role_Dean
+	if _, err := r.UpdateUser(user.ID, user, "admin"); err != nil || !revoked() {
+		t.Errorf("UpdateUser() = %v, a role change must revoke the tokens", err)
+	}
+}
+
+func TestRepoUserUpsertDirectoryUser(t *testing.T) {
+	r := &RepoUser{DB: newTestDB(t)}
+	r.DB.Create(&models.User{Username: "admin", Role: models.Role_SystemAdmin})
+	oidcUser := func(username, subject string) models.User {
+		return models.User{Username: username, Role: models.Role_Dean, Provider: schema.ProviderOIDC, Issuer: "https://idp", Subject: subject}
+	}
+
+	// a directory can't take over a local user
+	if _, err := r.UpsertDirectoryUser(models.User{Username: "admin", Role: models.Role_SystemAdmin, Provider: schema.ProviderLDAP}, "ldap"); err != ErrProviderMismatch {
+		t.Errorf("UpsertDirectoryUser() of a local username = %v, want ErrProviderMismatch", err)
+	}
+
+	// the single sign-on users are matched by subject, their username claim may change
+	created, err := r.UpsertDirectoryUser(oidcUser("rsargon", "sub-1"), "oidc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed, err := r.UpsertDirectoryUser(oidcUser("richard.sargon", "sub-1"), "oidc")
+	if err != nil || renamed.ID != created.ID || renamed.Username != "rsargon" {
+		t.Errorf("UpsertDirectoryUser() with a new username claim = %+v, %v, want the same user", renamed, err)
+	}
+	if _, err = r.UpsertDirectoryUser(oidcUser("rsargon", "sub-2"), "oidc"); err != ErrProviderMismatch {
+		t.Errorf("UpsertDirectoryUser() of another subject with the username = %v, want ErrProviderMismatch", err)
+	}
+
+	// a local user linked by an admin is bound to the subject of its first login
+	r.DB.Model(&models.User{}).Where("username = ?", "admin").Update("provider", schema.ProviderOIDC)
+	linked, err := r.UpsertDirectoryUser(oidcUser("admin", "sub-3"), "oidc")
+	if err != nil || linked.Subject != "sub-3" || linked.Role != models.Role_Dean {
+		t.Errorf("UpsertDirectoryUser() of a linked user = %+v, %v", linked, err)
+	}
+}
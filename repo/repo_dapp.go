@@ -1,6 +1,7 @@
 package repo
 
 import (
+	"bytes"
 	"dapp/lib"
 	"dapp/schema"
 	"dapp/schema/dto"
@@ -8,12 +9,21 @@ import (
 	"errors"
 	"fmt"
 	"github.com/cloudflare/cfssl/log"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	mspproto "github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel/invoke"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/errors/retry"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/context"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
-	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	fabctx "github.com/hyperledger/fabric-sdk-go/pkg/context"
 	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fab/txn"
 	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
 	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
 	jsoniter "github.com/json-iterator/go"
@@ -23,25 +33,29 @@ import (
 
 // region ======== SETUP =================================================================
 
-// the ccpClientWrapper implements the RPCClient interface using the fabric-sdk-go implementation
-// based on the static network description provided via the CCP yaml
-type ccpClientWrapper struct {
-	channelClient   *channel.Client
-	channelProvider context.ChannelProvider
-	signer          *msp.IdentityIdentifier
+// ErrInvalidArgs the payload of the transaction can't be turned into the arguments of the chaincode function
+var ErrInvalidArgs = errors.New("invalid transaction payload")
+
+// channelExecutor is the subset of *channel.Client used by the repository, so the client
+// created by the channelCreator hook can be replaced (e.g. in tests)
+type channelExecutor interface {
+	Query(request channel.Request, options ...channel.RequestOption) (channel.Response, error)
+	Execute(request channel.Request, options ...channel.RequestOption) (channel.Response, error)
+	InvokeHandler(handler invoke.Handler, request channel.Request, options ...channel.RequestOption) (channel.Response, error)
 }
 
 type RepoDapp struct {
-	CppPath           string          // CppPath path to the connection profile
-	WalletPath        string          // WalletPath path to the wallets folders
-	Wallet            *gateway.Wallet // Wallet with admin privilege identity for admins ops on the network
-	DappIdentityUser  string          // DappIdentityUser dapp user identity to authenticate normal dapp ops in the HLF network
-	DappIdentityAdmin string          // DappIdentityAdmin dapp admin identity to authenticate admin dapp ops in the HLF network
+	CppPath           string            // CppPath path to the connection profile
+	WalletPath        string            // WalletPath path to the wallets folders
+	Wallet            *gateway.Wallet   // Wallet with admin privilege identity for admins ops on the network
+	DappIdentityUser  string            // DappIdentityUser dapp user identity to authenticate normal dapp ops in the HLF network
+	DappIdentityAdmin string            // DappIdentityAdmin dapp admin identity to authenticate admin dapp ops in the HLF network
+	DefaultContract   string            // DefaultContract contract used when neither the request nor FunctionContracts name one
+	FunctionContracts map[string]string // FunctionContracts maps a chaincode function to the contract that implements it
 
 	configProvider core.ConfigProvider
 	sdk            *fabsdk.FabricSDK
 	channelCreator channelCreator
-	channelClient  ccpClientWrapper
 }
 
 var singleton *RepoDapp
@@ -53,7 +67,7 @@ var onceRepoDapp sync.Once
 
 func NewRepoDapp(svcConf *utils.SvcConfig) *RepoDapp {
 	onceRepoDapp.Do(func() {
-		wallet, err := gateway.NewFileSystemWallet(filepath.Join(svcConf.WalletFolder, schema.WalletStr))
+		wallet, err := newWallet(svcConf)
 		if err != nil {
 			panic(schema.ErrDetWalletProc + " ." + err.Error())
 		}
@@ -79,11 +93,12 @@ func NewRepoDapp(svcConf *utils.SvcConfig) *RepoDapp {
 			Wallet:            wallet,
 			DappIdentityUser:  svcConf.DappIdentityUser,
 			DappIdentityAdmin: svcConf.DappIdentityAdmin,
+			DefaultContract:   svcConf.DefaultContract,
+			FunctionContracts: svcConf.FunctionContracts,
 
 			configProvider: configProvider,
 			sdk:            sdk,
 			channelCreator: createChannelClient,
-			channelClient:  ccpClientWrapper{},
 		}
 	})
 	return singleton
@@ -92,42 +107,22 @@ func NewRepoDapp(svcConf *utils.SvcConfig) *RepoDapp {
 // region ======== METHODS ===============================================================
 
 func (r *RepoDapp) Query(query dto.Transaction, did string) ([]byte, error) {
-	var err error
-	var args_ []string
-
-	if query.Headers.PayloadType == "object" {
-		// if a isSchema is true, the payload property in the body must be a JSON structure
-		argsMap, ok := query.Payload.(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("the \"payload\" property must be JSON if a payloadType property is \"object\"")
-		}
-
-		res, err := jsoniter.MarshalToString(argsMap)
-		if err != nil {
-			return nil, err
-		}
-		args_ = append(args_, res)
-	} else {
-		argVals, ok := query.Payload.([]interface{})
-		if !ok {
-			return nil, fmt.Errorf("no payload schema is specified in the payload's \"headers\", the \"args\" property must be an array of strings")
-		}
-
-		args_ = make([]string, len(argVals))
-		for i, v := range query.Payload.([]interface{}) {
-			args_[i] = v.(string)
-		}
+	args_, err := txArgs(query)
+	if err != nil {
+		return nil, err
 	}
 
+	contractName, function := r.resolveFunction(query)
+
 	if query.StrongRead {
 		// getting bc components instance
-		_, contract, err := r.getSDKComponents(query, false)
+		_, contract, err := r.getSDKComponents(query, contractName, query.AsAdmin)
 		if err != nil {
 			return nil, err
 		}
 
 		// Getting the identities list
-		res, err := contract.EvaluateTransaction(query.Function, args_...)
+		res, err := contract.EvaluateTransaction(function, args_...)
 		if err != nil {
 			return nil, err
 		}
@@ -142,27 +137,18 @@ func (r *RepoDapp) Query(query dto.Transaction, did string) ([]byte, error) {
 
 	req := channel.Request{
 		ChaincodeID: query.Headers.ChaincodeID,
-		Fcn:         query.Function,
+		Fcn:         qualifiedFunction(contractName, function),
 		Args:        convert(args_...),
 	}
 
-	//TODO: then move to struct
-	// prepare contexts
-	// TODO: move to getChannelClient func (uncreated)
-	channelContext := r.sdk.ChannelContext(query.Headers.ChannelID, fabsdk.WithUser(query.Headers.Signer), fabsdk.WithOrg(org))
-
-	cClient, err := r.channelCreator(channelContext)
+	cClient, err := r.getChannelClient(query, org)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create new channel client: %s", err)
+		return nil, err
 	}
 
-	// TODO: move to getChannelClient func (uncreated)
-	r.channelClient.channelClient = cClient
-	r.channelClient.channelProvider = channelContext
-
-	result, err := r.channelClient.channelClient.Query(req, channel.WithRetry(retry.DefaultChannelOpts), channel.WithTargetEndpoints(peerEndpoint))
+	result, err := cClient.Query(req, channel.WithRetry(retry.DefaultChannelOpts), channel.WithTargetEndpoints(peerEndpoint))
 	if err != nil {
-		log.Errorf("Failed to send query [%s:%s:%s]. %s", query.Headers.ChannelID, query.Headers.ContractName, query.Function, err)
+		log.Errorf("Failed to send query [%s:%s:%s]. %s", query.Headers.ChannelID, contractName, function, err)
 		return nil, err
 	}
 
@@ -170,42 +156,22 @@ func (r *RepoDapp) Query(query dto.Transaction, did string) ([]byte, error) {
 }
 
 func (r *RepoDapp) Invoke(query dto.Transaction, did string) ([]byte, error) {
-	var err error
-	var args_ []string
-
-	if query.Headers.PayloadType == "object" {
-		// if a payloadType is array, the payload property in the body must be a JSON structure
-		argsMap, ok := query.Payload.(map[string]any)
-		if !ok {
-			return nil, fmt.Errorf("the \"payload\" property must be JSON if a payloadType property is \"object\"")
-		}
-
-		res, err := jsoniter.MarshalToString(argsMap)
-		if err != nil {
-			return nil, err
-		}
-		args_ = append(args_, res)
-	} else {
-		argVals, ok := query.Payload.([]interface{})
-		if !ok {
-			return nil, fmt.Errorf("no payload schema is specified in the payload's \"headers\", the \"args\" property must be an array of strings")
-		}
-
-		args_ = make([]string, len(argVals))
-		for i, v := range query.Payload.([]interface{}) {
-			args_[i] = v.(string)
-		}
+	args_, err := txArgs(query)
+	if err != nil {
+		return nil, err
 	}
 
+	contractName, function := r.resolveFunction(query)
+
 	if query.StrongRead {
 		// getting bc components instance
-		_, contract, err := r.getSDKComponents(query, false)
+		_, contract, err := r.getSDKComponents(query, contractName, query.AsAdmin)
 		if err != nil {
 			return nil, err
 		}
 
 		// invoking the contract
-		res, err := contract.SubmitTransaction(query.Function, args_...)
+		res, err := contract.SubmitTransaction(function, args_...)
 		if err != nil {
 			return nil, err
 		}
@@ -220,41 +186,140 @@ func (r *RepoDapp) Invoke(query dto.Transaction, did string) ([]byte, error) {
 
 	req := channel.Request{
 		ChaincodeID: query.Headers.ChaincodeID,
-		Fcn:         query.Function,
+		Fcn:         qualifiedFunction(contractName, function),
 		Args:        convert(args_...),
 	}
 
-	//TODO: then move to struct
-	// prepare contexts
-	// TODO: move to getChannelClient func (uncreated)
-	channelContext := r.sdk.ChannelContext(query.Headers.ChannelID, fabsdk.WithUser(query.Headers.Signer), fabsdk.WithOrg(org))
-
-	cClient, err := r.channelCreator(channelContext)
+	cClient, err := r.getChannelClient(query, org)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create new channel client: %s", err)
+		return nil, err
 	}
-	// TODO: move to getChannelClient func (uncreated)
-	r.channelClient.channelClient = cClient
-	r.channelClient.channelProvider = channelContext
 
-	result, err := r.channelClient.channelClient.Execute(req, channel.WithRetry(retry.DefaultChannelOpts), channel.WithTargetEndpoints(peerEndpoint))
+	result, err := cClient.Execute(req, channel.WithRetry(retry.DefaultChannelOpts), channel.WithTargetEndpoints(peerEndpoint))
 	if err != nil {
-		log.Errorf("Failed to send query [%s:%s:%s]. %s", query.Headers.ChannelID, query.Headers.ContractName, query.Function, err)
+		log.Errorf("Failed to send query [%s:%s:%s]. %s", query.Headers.ChannelID, contractName, function, err)
 		return nil, err
 	}
 
 	return result.Payload, nil
 }
 
-type channelCreator func(context.ChannelProvider) (*channel.Client, error)
+// Simulate collects the endorsements of the transaction, but never sends it to the orderer (dry run). It returns the
+// chaincode response payload and a summary of the simulation: endorsers and the read/write set
+func (r *RepoDapp) Simulate(query dto.Transaction, did string) ([]byte, *dto.TxSimulation, error) {
+	args_, err := txArgs(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contractName, function := r.resolveFunction(query)
+
+	peerEndpoint, org, err := getFirstPeerEndpointFromConfig(r.configProvider)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req := channel.Request{
+		ChaincodeID: query.Headers.ChaincodeID,
+		Fcn:         qualifiedFunction(contractName, function),
+		Args:        convert(args_...),
+	}
+
+	cClient, err := r.getChannelClient(query, org)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// same chain as channel.Client.Execute without the commit handler, so nothing reach the orderer
+	handler := invoke.NewSelectAndEndorseHandler(
+		invoke.NewEndorsementValidationHandler(
+			invoke.NewSignatureValidationHandler(),
+		),
+	)
+
+	result, err := cClient.InvokeHandler(handler, req, channel.WithRetry(retry.DefaultChannelOpts), channel.WithTargetEndpoints(peerEndpoint))
+	if err != nil {
+		log.Errorf("Failed to simulate transaction [%s:%s:%s]. %s", query.Headers.ChannelID, contractName, function, err)
+		return nil, nil, err
+	}
+
+	simulation := &dto.TxSimulation{
+		TransactionID:   string(result.TransactionID),
+		ChaincodeStatus: result.ChaincodeStatus,
+		Endorsers:       make([]string, 0, len(result.Responses)),
+	}
+	for _, res := range result.Responses {
+		simulation.Endorsers = append(simulation.Endorsers, res.Endorser)
+	}
+	// the endorsement validation handler already checked that every endorser simulated the same read/write set
+	if len(result.Responses) > 0 {
+		simulation.ReadWriteSet, err = summarizeRWSet(result.Responses[0].ProposalResponse)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return result.Payload, simulation, nil
+}
+
+type channelCreator func(context.ChannelProvider) (channelExecutor, error)
 
-func createChannelClient(channelProvider context.ChannelProvider) (*channel.Client, error) {
+func createChannelClient(channelProvider context.ChannelProvider) (channelExecutor, error) {
 	return channel.New(channelProvider)
 }
 
-func (r *RepoDapp) getSDKComponents(query dto.Transaction, withAdminIdentity bool) (*gateway.Network, *gateway.Contract, error) {
+// getChannelClient prepare the channel context for the request signer and create the channel client through
+// the channelCreator hook. The client is created for every request and never shared, the requests run concurrently
+func (r *RepoDapp) getChannelClient(query dto.Transaction, org string) (channelExecutor, error) {
+	channelContext := r.sdk.ChannelContext(query.Headers.ChannelID, fabsdk.WithUser(r.SignerIdentity(query)), fabsdk.WithOrg(org))
+
+	cClient, err := r.channelCreator(channelContext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new channel client: %s", err)
+	}
+	return cClient, nil
+}
+
+// SignerIdentity returns the identity that signs the transaction: the dapp admin identity for the administrative
+// operations, otherwise the dapp user identity on the gateway (strong read) path or the request signer
+func (r *RepoDapp) SignerIdentity(query dto.Transaction) string {
+	if query.AsAdmin {
+		return r.DappIdentityAdmin
+	}
+	if query.StrongRead {
+		return r.DappIdentityUser
+	}
+	return query.Headers.Signer
+}
+
+// resolveFunction returns the contract name and the bare function name addressed by the transaction.
+// The contract is taken, in order, from a "<contract>:" prefix in the function, the ContractName header,
+// the FunctionContracts configuration and the DefaultContract configuration. An empty contract means the
+// chaincode default contract.
+func (r *RepoDapp) resolveFunction(query dto.Transaction) (string, string) {
+	if contractName, function := lib.SplitFunction(query.Function); contractName != "" {
+		return contractName, function
+	}
+	if query.Headers.ContractName != "" {
+		return query.Headers.ContractName, query.Function
+	}
+	if contractName, ok := r.FunctionContracts[query.Function]; ok {
+		return contractName, query.Function
+	}
+	return r.DefaultContract, query.Function
+}
+
+// qualifiedFunction address a function as "<contract>:<function>", the same way the gateway contract does
+func qualifiedFunction(contractName, function string) string {
+	if contractName == "" {
+		return function
+	}
+	return contractName + ":" + function
+}
+
+func (r *RepoDapp) getSDKComponents(query dto.Transaction, contractName string, withAdminIdentity bool) (*gateway.Network, *gateway.Contract, error) {
 	chID := query.Headers.ChannelID
-	cName := query.Headers.ContractName
+	ccID := query.Headers.ChaincodeID
 
 	var identityLabel = r.DappIdentityUser
 
@@ -282,7 +347,7 @@ func (r *RepoDapp) getSDKComponents(query dto.Transaction, withAdminIdentity boo
 		return nil, nil, e
 	}
 	// trying to get the contract
-	contract := nt.GetContractWithName(cName, "")
+	contract := nt.GetContractWithName(ccID, contractName)
 	if contract == nil {
 		return nil, nil, errors.New(schema.ErrDetContractNotFound)
 	}
@@ -327,6 +392,80 @@ func getFirstPeerEndpointFromConfig(config core.ConfigProvider) (string, string,
 	return peers[0].(string), org, nil
 }
 
+// txArgs build the chaincode arguments from the transaction payload, according to the payloadType header
+func txArgs(query dto.Transaction) ([]string, error) {
+	var args_ []string
+
+	if query.Headers.PayloadType == "object" {
+		// if a payloadType is object, the payload property in the body must be a JSON structure
+		argsMap, ok := query.Payload.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: the \"payload\" property must be JSON if a payloadType property is \"object\"", ErrInvalidArgs)
+		}
+
+		res, err := jsoniter.MarshalToString(argsMap)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidArgs, err)
+		}
+		args_ = append(args_, res)
+	} else {
+		argVals, ok := query.Payload.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: no payload schema is specified in the payload's \"headers\", the \"args\" property must be an array of strings", ErrInvalidArgs)
+		}
+
+		args_ = make([]string, len(argVals))
+		for i, v := range argVals {
+			arg, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: the argument %d of the \"args\" property is not a string", ErrInvalidArgs, i)
+			}
+			args_[i] = arg
+		}
+	}
+
+	return args_, nil
+}
+
+// summarizeRWSet extract the keys read, written and deleted, by namespace, from an endorsed proposal response
+func summarizeRWSet(proposalResponse *pb.ProposalResponse) ([]dto.NsReadWriteSet, error) {
+	prp := &pb.ProposalResponsePayload{}
+	if err := proto.Unmarshal(proposalResponse.GetPayload(), prp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the proposal response payload: %s", err)
+	}
+	ccAction := &pb.ChaincodeAction{}
+	if err := proto.Unmarshal(prp.GetExtension(), ccAction); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the chaincode action: %s", err)
+	}
+	txRWSet := &rwset.TxReadWriteSet{}
+	if err := proto.Unmarshal(ccAction.GetResults(), txRWSet); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the read/write set: %s", err)
+	}
+
+	summary := make([]dto.NsReadWriteSet, 0, len(txRWSet.GetNsRwset()))
+	for _, nsRWSet := range txRWSet.GetNsRwset() {
+		kvRWSet := &kvrwset.KVRWSet{}
+		if err := proto.Unmarshal(nsRWSet.GetRwset(), kvRWSet); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal the read/write set of namespace %s: %s", nsRWSet.GetNamespace(), err)
+		}
+
+		ns := dto.NsReadWriteSet{Namespace: nsRWSet.GetNamespace(), Reads: []string{}, Writes: []string{}, Deletes: []string{}}
+		for _, read := range kvRWSet.GetReads() {
+			ns.Reads = append(ns.Reads, read.GetKey())
+		}
+		for _, write := range kvRWSet.GetWrites() {
+			if write.GetIsDelete() {
+				ns.Deletes = append(ns.Deletes, write.GetKey())
+				continue
+			}
+			ns.Writes = append(ns.Writes, write.GetKey())
+		}
+		summary = append(summary, ns)
+	}
+
+	return summary, nil
+}
+
 func convert(args ...string) [][]byte {
 	bytes := make([][]byte, len(args))
 	for i, v := range args {
@@ -335,6 +474,338 @@ func convert(args ...string) [][]byte {
 	return bytes
 }
 
+// region ======== OFFLINE SIGNING ===========================================================
+
+// PrepareProposal build the unsigned proposal of the transaction, created by the client enrolled identity (mspID and
+// PEM certificate). The client must sign the returned proposal bytes with its own private key
+func (r *RepoDapp) PrepareProposal(query dto.Transaction, mspID string, certificate []byte) (*dto.OfflineProposal, error) {
+	args_, err := txArgs(query)
+	if err != nil {
+		return nil, err
+	}
+
+	contractName, function := r.resolveFunction(query)
+
+	chCtx, _, err := r.getChannelContext(query)
+	if err != nil {
+		return nil, err
+	}
+
+	creator, err := proto.Marshal(&mspproto.SerializedIdentity{Mspid: mspID, IdBytes: certificate})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize the client identity: %s", err)
+	}
+
+	txh, err := txn.NewHeader(chCtx, query.Headers.ChannelID, fab.WithCreator(creator))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the transaction header: %s", err)
+	}
+
+	proposal, err := txn.CreateChaincodeInvokeProposal(txh, fab.ChaincodeInvokeRequest{
+		ChaincodeID: query.Headers.ChaincodeID,
+		Fcn:         qualifiedFunction(contractName, function),
+		Args:        convert(args_...),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the proposal: %s", err)
+	}
+
+	proposalBytes, err := proto.Marshal(proposal.Proposal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the proposal: %s", err)
+	}
+
+	return &dto.OfflineProposal{TransactionID: string(proposal.TxnID), Proposal: proposalBytes}, nil
+}
+
+// EndorseSignedProposal send the proposal signed by the client to the endorsing peers of the channel. It returns the
+// chaincode response payload and the unsigned transaction payload that the client must sign before it is sent to the
+// orderer
+func (r *RepoDapp) EndorseSignedProposal(query dto.Transaction, proposalBytes, signature []byte) ([]byte, *dto.OfflineTransaction, error) {
+	proposal := &pb.Proposal{}
+	if err := proto.Unmarshal(proposalBytes, proposal); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal the proposal: %s", err)
+	}
+	txID, err := proposalTxID(proposal)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chCtx, peerEndpoint, err := r.getChannelContext(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	peers, err := endorsingPeers(chCtx, query.Headers.ChannelID, peerEndpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reqCtx, cancel := fabctx.NewRequest(chCtx, fabctx.WithTimeoutType(fab.PeerResponse))
+	defer cancel()
+
+	signedProposal := &pb.SignedProposal{ProposalBytes: proposalBytes, Signature: signature}
+	responses := make([]*fab.TransactionProposalResponse, 0, len(peers))
+	endorsers := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		response, err := peer.ProcessTransactionProposal(reqCtx, fab.ProcessProposalRequest{SignedProposal: signedProposal})
+		if err != nil {
+			log.Errorf("Failed to endorse the signed proposal [%s:%s] on %s. %s", query.Headers.ChannelID, txID, peer.URL(), err)
+			return nil, nil, err
+		}
+		responses = append(responses, response)
+		endorsers = append(endorsers, response.Endorser)
+	}
+
+	payloadBytes, err := transactionPayload(proposal, txID, responses)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	offlineTx := &dto.OfflineTransaction{
+		TransactionID: txID,
+		Endorsers:     endorsers,
+		Transaction:   payloadBytes,
+	}
+	return responses[0].GetResponse().GetPayload(), offlineTx, nil
+}
+
+// SubmitSignedTransaction broadcast the transaction payload signed by the client to the orderers of the connection
+// profile. It returns the transaction ID and the orderer that accepted it
+func (r *RepoDapp) SubmitSignedTransaction(query dto.Transaction, payloadBytes, signature []byte) (string, string, error) {
+	payload := &common.Payload{}
+	if err := proto.Unmarshal(payloadBytes, payload); err != nil {
+		return "", "", fmt.Errorf("failed to unmarshal the transaction payload: %s", err)
+	}
+	chHeader := &common.ChannelHeader{}
+	if err := proto.Unmarshal(payload.GetHeader().GetChannelHeader(), chHeader); err != nil {
+		return "", "", fmt.Errorf("failed to unmarshal the channel header: %s", err)
+	}
+
+	chCtx, _, err := r.getChannelContext(query)
+	if err != nil {
+		return "", "", err
+	}
+
+	ordererCfgs := chCtx.EndpointConfig().OrderersConfig()
+	if len(ordererCfgs) == 0 {
+		return "", "", fmt.Errorf("no orderers found in the connection profile")
+	}
+
+	envelope := &fab.SignedEnvelope{Payload: payloadBytes, Signature: signature}
+	var errResp error
+	for i := range ordererCfgs {
+		orderer, err := chCtx.InfraProvider().CreateOrdererFromConfig(&ordererCfgs[i])
+		if err != nil {
+			errResp = err
+			continue
+		}
+
+		reqCtx, cancel := fabctx.NewRequest(chCtx, fabctx.WithTimeoutType(fab.OrdererResponse))
+		_, err = orderer.SendBroadcast(reqCtx, envelope)
+		cancel()
+		if err != nil {
+			errResp = fmt.Errorf("calling orderer '%s' failed: %s", orderer.URL(), err)
+			continue
+		}
+
+		return chHeader.TxId, orderer.URL(), nil
+	}
+
+	log.Errorf("Failed to submit the signed transaction [%s:%s]. %s", query.Headers.ChannelID, chHeader.TxId, errResp)
+	return "", "", errResp
+}
+
+// getChannelContext create the channel context of the offline signing, and return it along with the endorsing peer.
+// The client signs with its own identity, the context of the dapp user identity only reaches the peers and orderers
+func (r *RepoDapp) getChannelContext(query dto.Transaction) (context.Channel, string, error) {
+	peerEndpoint, org, err := getFirstPeerEndpointFromConfig(r.configProvider)
+	if err != nil {
+		return nil, "", err
+	}
+
+	chCtx, err := r.sdk.ChannelContext(query.Headers.ChannelID, fabsdk.WithUser(r.DappIdentityUser), fabsdk.WithOrg(org))()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create the channel context: %s", err)
+	}
+
+	return chCtx, peerEndpoint, nil
+}
+
+// endorsingPeers the endorsing peers of the channel in the connection profile, or the first peer of the organization
+// when the profile lists none for the channel
+func endorsingPeers(chCtx context.Channel, channelID, peerEndpoint string) ([]fab.Peer, error) {
+	var peerCfgs []fab.NetworkPeer
+	for _, channelPeer := range chCtx.EndpointConfig().ChannelPeers(channelID) {
+		if channelPeer.EndorsingPeer {
+			peerCfgs = append(peerCfgs, channelPeer.NetworkPeer)
+		}
+	}
+	if len(peerCfgs) == 0 {
+		peerCfg, ok := chCtx.EndpointConfig().PeerConfig(peerEndpoint)
+		if !ok {
+			return nil, fmt.Errorf("peer %s not found in the connection profile", peerEndpoint)
+		}
+		peerCfgs = append(peerCfgs, fab.NetworkPeer{PeerConfig: *peerCfg})
+	}
+
+	peers := make([]fab.Peer, len(peerCfgs))
+	for i := range peerCfgs {
+		peer, err := chCtx.InfraProvider().CreatePeerFromConfig(&peerCfgs[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the peer %s: %s", peerCfgs[i].URL, err)
+		}
+		peers[i] = peer
+	}
+	return peers, nil
+}
+
+// transactionPayload build the unsigned transaction payload of the endorsed proposal. The peers must have endorsed the
+// same results, otherwise the transaction would be invalidated on commit
+func transactionPayload(proposal *pb.Proposal, txID string, responses []*fab.TransactionProposalResponse) ([]byte, error) {
+	for _, response := range responses[1:] {
+		if !bytes.Equal(response.GetPayload(), responses[0].GetPayload()) {
+			return nil, fmt.Errorf("the peers %s and %s endorsed different results", responses[0].Endorser, response.Endorser)
+		}
+	}
+
+	tx, err := txn.New(fab.TransactionRequest{
+		Proposal:          &fab.TransactionProposal{TxnID: fab.TransactionID(txID), Proposal: proposal},
+		ProposalResponses: responses,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the transaction payload keeps the proposal header, so the client signs the same identity it used for the proposal
+	header := &common.Header{}
+	if err := proto.Unmarshal(proposal.Header, header); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the proposal header: %s", err)
+	}
+	txBytes, err := proto.Marshal(tx.Transaction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the transaction: %s", err)
+	}
+	payloadBytes, err := proto.Marshal(&common.Payload{Header: header, Data: txBytes})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the transaction payload: %s", err)
+	}
+	return payloadBytes, nil
+}
+
+// Invocation what a proposal, or a transaction, signed offline invokes
+type Invocation struct {
+	ChannelID   string
+	ChaincodeID string
+	Function    string // without its contract
+}
+
+// ProposalInvocation the channel, chaincode and function invoked by the proposal bytes
+func ProposalInvocation(proposalBytes []byte) (Invocation, error) {
+	proposal := &pb.Proposal{}
+	if err := proto.Unmarshal(proposalBytes, proposal); err != nil {
+		return Invocation{}, fmt.Errorf("failed to unmarshal the proposal: %s", err)
+	}
+	header := &common.Header{}
+	if err := proto.Unmarshal(proposal.GetHeader(), header); err != nil {
+		return Invocation{}, fmt.Errorf("failed to unmarshal the proposal header: %s", err)
+	}
+	return invocation(header, proposal.GetPayload())
+}
+
+// TransactionInvocation the channel, chaincode and function invoked by the transaction payload bytes
+func TransactionInvocation(payloadBytes []byte) (Invocation, error) {
+	payload := &common.Payload{}
+	if err := proto.Unmarshal(payloadBytes, payload); err != nil {
+		return Invocation{}, fmt.Errorf("failed to unmarshal the transaction payload: %s", err)
+	}
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(payload.GetData(), tx); err != nil {
+		return Invocation{}, fmt.Errorf("failed to unmarshal the transaction: %s", err)
+	}
+	if len(tx.GetActions()) != 1 {
+		return Invocation{}, fmt.Errorf("the transaction must have one action, it has %d", len(tx.GetActions()))
+	}
+	actionPayload := &pb.ChaincodeActionPayload{}
+	if err := proto.Unmarshal(tx.GetActions()[0].GetPayload(), actionPayload); err != nil {
+		return Invocation{}, fmt.Errorf("failed to unmarshal the chaincode action payload: %s", err)
+	}
+	return invocation(payload.GetHeader(), actionPayload.GetChaincodeProposalPayload())
+}
+
+// invocation read the channel and the chaincode from the header, and the function from the chaincode proposal payload
+// bytes. The chaincode of the header, the one the peers run, must be the one of the invocation spec
+func invocation(header *common.Header, ccProposalPayload []byte) (Invocation, error) {
+	chHeader := &common.ChannelHeader{}
+	if err := proto.Unmarshal(header.GetChannelHeader(), chHeader); err != nil {
+		return Invocation{}, fmt.Errorf("failed to unmarshal the channel header: %s", err)
+	}
+	extension := &pb.ChaincodeHeaderExtension{}
+	if err := proto.Unmarshal(chHeader.GetExtension(), extension); err != nil {
+		return Invocation{}, fmt.Errorf("failed to unmarshal the chaincode header extension: %s", err)
+	}
+
+	payload := &pb.ChaincodeProposalPayload{}
+	if err := proto.Unmarshal(ccProposalPayload, payload); err != nil {
+		return Invocation{}, fmt.Errorf("failed to unmarshal the chaincode proposal payload: %s", err)
+	}
+	spec := &pb.ChaincodeInvocationSpec{}
+	if err := proto.Unmarshal(payload.GetInput(), spec); err != nil {
+		return Invocation{}, fmt.Errorf("failed to unmarshal the chaincode invocation spec: %s", err)
+	}
+	args := spec.GetChaincodeSpec().GetInput().GetArgs()
+	if len(args) == 0 || len(args[0]) == 0 {
+		return Invocation{}, fmt.Errorf("the proposal invokes no chaincode function")
+	}
+	chaincodeID := extension.GetChaincodeId().GetName()
+	if chaincodeID == "" || chaincodeID != spec.GetChaincodeSpec().GetChaincodeId().GetName() {
+		return Invocation{}, fmt.Errorf("the header and the invocation spec name different chaincodes")
+	}
+
+	_, function := lib.SplitFunction(string(args[0]))
+	return Invocation{ChannelID: chHeader.GetChannelId(), ChaincodeID: chaincodeID, Function: function}, nil
+}
+
+// proposalTxID read the transaction ID from the proposal channel header
+func proposalTxID(proposal *pb.Proposal) (string, error) {
+	header := &common.Header{}
+	if err := proto.Unmarshal(proposal.GetHeader(), header); err != nil {
+		return "", fmt.Errorf("failed to unmarshal the proposal header: %s", err)
+	}
+	chHeader := &common.ChannelHeader{}
+	if err := proto.Unmarshal(header.GetChannelHeader(), chHeader); err != nil {
+		return "", fmt.Errorf("failed to unmarshal the channel header: %s", err)
+	}
+	return chHeader.TxId, nil
+}
+
+// endregion =============================================================================
+
 // region ======== Dapp ======================================================
 
+// WalletIdentities returns the X.509 identities of the wallet by label. An identity that can't be read gets a nil
+// value and its error in the second map, so one broken identity doesn't hide the rest
+func (r *RepoDapp) WalletIdentities() (map[string]*gateway.X509Identity, map[string]error, error) {
+	labels, err := r.Wallet.List()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	identities := make(map[string]*gateway.X509Identity, len(labels))
+	failed := make(map[string]error)
+	for _, label := range labels {
+		identity, err := r.Wallet.Get(label)
+		if err != nil {
+			failed[label] = err
+			continue
+		}
+		x509Identity, ok := identity.(*gateway.X509Identity)
+		if !ok {
+			failed[label] = fmt.Errorf("the %s identity is not an X.509 identity", label)
+			continue
+		}
+		identities[label] = x509Identity
+	}
+	return identities, failed, nil
+}
+
 // endregion =============================================================================
@@ -0,0 +1,521 @@
+package repo
+
+import (
+	reqContext "context"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel/invoke"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/context"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fab/txn"
+)
+
+// fakeConfigBackend resolves the connection profile keys used by the direct channel-client path
+type fakeConfigBackend map[string]interface{}
+
+func (b fakeConfigBackend) Lookup(key string) (interface{}, bool) {
+	v, ok := b[key]
+	return v, ok
+}
+
+// fakeChannelClient records the requests that would be sent to the peers
+type fakeChannelClient struct {
+	requests []channel.Request
+}
+
+func (c *fakeChannelClient) Query(request channel.Request, _ ...channel.RequestOption) (channel.Response, error) {
+	c.requests = append(c.requests, request)
+	return channel.Response{Payload: []byte("{}")}, nil
+}
+
+func (c *fakeChannelClient) Execute(request channel.Request, _ ...channel.RequestOption) (channel.Response, error) {
+	c.requests = append(c.requests, request)
+	return channel.Response{Payload: []byte("{}")}, nil
+}
+
+func (c *fakeChannelClient) InvokeHandler(_ invoke.Handler, request channel.Request, _ ...channel.RequestOption) (channel.Response, error) {
+	c.requests = append(c.requests, request)
+	return channel.Response{Payload: []byte("{}")}, nil
+}
+
+func newTestRepoDapp(client *fakeChannelClient, defaultContract string, functionContracts map[string]string) *RepoDapp {
+	backend := fakeConfigBackend{
+		"client.organization":      "Org1",
+		"organizations.Org1.peers": []interface{}{"peer0.org1.example.com"},
+	}
+
+	return &RepoDapp{
+		DefaultContract:   defaultContract,
+		FunctionContracts: functionContracts,
+		configProvider: func() ([]core.ConfigBackend, error) {
+			return []core.ConfigBackend{backend}, nil
+		},
+		channelCreator: func(context.ChannelProvider) (channelExecutor, error) {
+			return client, nil
+		},
+	}
+}
+
+func newTestTransaction(function, contractName string) dto.Transaction {
+	return dto.Transaction{
+		RequestCommon: dto.RequestCommon{Headers: dto.RequestHeaders{CommonHeaders: dto.CommonHeaders{
+			PayloadType:  "array",
+			Signer:       "User1",
+			ChannelID:    "mychannel",
+			ChaincodeID:  "certificate",
+			ContractName: contractName,
+		}}},
+		Function: function,
+		Payload:  []interface{}{"CERT1"},
+	}
+}
+
+func TestRepoDappFcnAddressing(t *testing.T) {
+	functionContracts := map[string]string{schema.QueryAssetsWithPag: schema.CommonContract}
+
+	tests := []struct {
+		name            string
+		defaultContract string
+		function        string
+		contractName    string
+		want            string
+	}{
+		{"no contract", "", schema.ReadAsset, "", "ReadAsset"},
+		{"contract header", "", schema.ReadAsset, "certificate", "certificate:ReadAsset"},
+		{"configured function", "", schema.QueryAssetsWithPag, "", "common:QueryAssetsWithPagination"},
+		{"contract header over configuration", "", schema.QueryAssetsWithPag, "other", "other:QueryAssetsWithPagination"},
+		{"default contract", "certificate", schema.ReadAsset, "", "certificate:ReadAsset"},
+		{"configured function over default contract", "certificate", schema.QueryAssetsWithPag, "", "common:QueryAssetsWithPagination"},
+		{"qualified function", "certificate", "other:ReadAsset", "ignored", "other:ReadAsset"},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []string{"query", "invoke", "simulate"} {
+			t.Run(fmt.Sprintf("%s/%s", tt.name, mode), func(t *testing.T) {
+				client := &fakeChannelClient{}
+				r := newTestRepoDapp(client, tt.defaultContract, functionContracts)
+				tx := newTestTransaction(tt.function, tt.contractName)
+
+				var err error
+				switch mode {
+				case "query":
+					_, err = r.Query(tx, "did")
+				case "invoke":
+					_, err = r.Invoke(tx, "did")
+				case "simulate":
+					_, _, err = r.Simulate(tx, "did")
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if len(client.requests) != 1 {
+					t.Fatalf("expected 1 request sent, got %d", len(client.requests))
+				}
+				if got := client.requests[0].Fcn; got != tt.want {
+					t.Errorf("Fcn = %q, want %q", got, tt.want)
+				}
+				if got := client.requests[0].ChaincodeID; got != "certificate" {
+					t.Errorf("ChaincodeID = %q, want %q", got, "certificate")
+				}
+			})
+		}
+	}
+}
+
+func TestRepoDappInvalidArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		payloadType string
+		payload     interface{}
+	}{
+		{"argument not a string", "array", []interface{}{"CERT1", 7}},
+		{"object args", "array", map[string]interface{}{"ID": "CERT1"}},
+		{"array payload", "object", []interface{}{"CERT1"}},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []string{"query", "invoke", "simulate"} {
+			client := &fakeChannelClient{}
+			r := newTestRepoDapp(client, "", nil)
+			tx := newTestTransaction(schema.ReadAsset, "")
+			tx.Headers.PayloadType, tx.Payload = tt.payloadType, tt.payload
+
+			var err error
+			switch mode {
+			case "query":
+				_, err = r.Query(tx, "did")
+			case "invoke":
+				_, err = r.Invoke(tx, "did")
+			case "simulate":
+				_, _, err = r.Simulate(tx, "did")
+			}
+			if !errors.Is(err, ErrInvalidArgs) {
+				t.Errorf("%s/%s: error = %v, want ErrInvalidArgs", tt.name, mode, err)
+			}
+			if len(client.requests) != 0 {
+				t.Errorf("%s/%s: %d requests sent, want none", tt.name, mode, len(client.requests))
+			}
+		}
+	}
+}
+
+func TestRepoDappSignerIdentity(t *testing.T) {
+	r := &RepoDapp{DappIdentityUser: "dappUser", DappIdentityAdmin: "Admin"}
+
+	tests := []struct {
+		name       string
+		strongRead bool
+		asAdmin    bool
+		want       string
+	}{
+		{"request signer", false, false, "User1"},
+		{"gateway user identity", true, false, "dappUser"},
+		{"admin operation", false, true, "Admin"},
+		{"admin operation on the gateway", true, true, "Admin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newTestTransaction(schema.DeleteAsset, "")
+			tx.StrongRead = tt.strongRead
+			tx.AsAdmin = tt.asAdmin
+			if got := r.SignerIdentity(tx); got != tt.want {
+				t.Errorf("SignerIdentity() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeTxHeader transaction header of a client identity, without a channel context
+type fakeTxHeader struct {
+	txID string
+}
+
+func (h fakeTxHeader) TransactionID() fab.TransactionID { return fab.TransactionID(h.txID) }
+func (h fakeTxHeader) Creator() []byte                  { return []byte("creator") }
+func (h fakeTxHeader) Nonce() []byte                    { return []byte("nonce") }
+func (h fakeTxHeader) ChannelID() string                { return "mychannel" }
+
+// newTestProposal the proposal of the function, as PrepareProposal builds it
+func newTestProposal(t *testing.T, txID, function string) *pb.Proposal {
+	t.Helper()
+	proposal, err := txn.CreateChaincodeInvokeProposal(fakeTxHeader{txID}, fab.ChaincodeInvokeRequest{
+		ChaincodeID: "certificate",
+		Fcn:         function,
+		Args:        convert("CERT1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proposal.Proposal
+}
+
+// endorsement successful proposal response of the peer with the given results
+func endorsement(peer, results string) *fab.TransactionProposalResponse {
+	return &fab.TransactionProposalResponse{Endorser: peer, ProposalResponse: &pb.ProposalResponse{
+		Response:    &pb.Response{Status: 200},
+		Payload:     []byte(results),
+		Endorsement: &pb.Endorsement{Endorser: []byte(peer)},
+	}}
+}
+
+func TestRepoDappOfflineRoundTrip(t *testing.T) {
+	proposal := newTestProposal(t, "tx-1", "certificate:DeleteAsset")
+	proposalBytes, err := proto.Marshal(proposal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// endorse step: the prepared proposal is decoded again
+	want := Invocation{ChannelID: "mychannel", ChaincodeID: "certificate", Function: schema.DeleteAsset}
+	if got, err := ProposalInvocation(proposalBytes); err != nil || got != want {
+		t.Errorf("ProposalInvocation() = %+v, %v, want %+v", got, err, want)
+	}
+	if txID, err := proposalTxID(proposal); err != nil || txID != "tx-1" {
+		t.Errorf("proposalTxID() = %q, %v, want tx-1", txID, err)
+	}
+
+	// submit step: the transaction keeps the header and the function of the proposal
+	payloadBytes, err := transactionPayload(proposal, "tx-1", []*fab.TransactionProposalResponse{endorsement("peer0", "rw"), endorsement("peer1", "rw")})
+	if err != nil {
+		t.Fatalf("transactionPayload() = %v", err)
+	}
+	if got, err := TransactionInvocation(payloadBytes); err != nil || got != want {
+		t.Errorf("TransactionInvocation() = %+v, %v, want %+v", got, err, want)
+	}
+	payload := &common.Payload{}
+	chHeader := &common.ChannelHeader{}
+	if err = proto.Unmarshal(payloadBytes, payload); err == nil {
+		err = proto.Unmarshal(payload.GetHeader().GetChannelHeader(), chHeader)
+	}
+	if err != nil || chHeader.TxId != "tx-1" || chHeader.ChannelId != "mychannel" {
+		t.Errorf("transaction channel header = %+v, %v, want the one of the proposal", chHeader, err)
+	}
+
+	tx := &pb.Transaction{}
+	_ = proto.Unmarshal(payload.GetData(), tx)
+	actionPayload := &pb.ChaincodeActionPayload{}
+	_ = proto.Unmarshal(tx.GetActions()[0].GetPayload(), actionPayload)
+	if endorsements := actionPayload.GetAction().GetEndorsements(); len(endorsements) != 2 {
+		t.Errorf("endorsements = %d, want the one of every peer", len(endorsements))
+	}
+}
+
+func TestRepoDappOfflineRejected(t *testing.T) {
+	proposal := newTestProposal(t, "tx-1", "CreateAsset")
+
+	if _, err := transactionPayload(proposal, "tx-1", []*fab.TransactionProposalResponse{endorsement("peer0", "rw"), endorsement("peer1", "other")}); err == nil {
+		t.Error("transactionPayload() of different results must fail")
+	}
+	if _, err := ProposalInvocation([]byte("not a proposal")); err == nil {
+		t.Error("ProposalInvocation() of garbage must fail")
+	}
+	if _, err := TransactionInvocation([]byte("not a transaction")); err == nil {
+		t.Error("TransactionInvocation() of garbage must fail")
+	}
+
+	// a proposal without a chaincode invocation
+	empty, _ := proto.Marshal(&pb.Proposal{Header: proposal.Header})
+	if _, err := ProposalInvocation(empty); err == nil {
+		t.Error("ProposalInvocation() of a proposal without function must fail")
+	}
+
+	// the peers run the chaincode of the header, the spec can't name another one
+	other, err := txn.CreateChaincodeInvokeProposal(fakeTxHeader{"tx-2"}, fab.ChaincodeInvokeRequest{ChaincodeID: "other", Fcn: "CreateAsset"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mixed, _ := proto.Marshal(&pb.Proposal{Header: other.Proposal.Header, Payload: proposal.Payload})
+	if _, err := ProposalInvocation(mixed); err == nil {
+		t.Error("ProposalInvocation() of a header and a spec of different chaincodes must fail")
+	}
+}
+
+// nsRWSet the marshalled read/write set of a namespace, the keys prefixed with "-" are deleted
+func nsRWSet(t *testing.T, namespace string, reads []string, writes ...string) *rwset.NsReadWriteSet {
+	t.Helper()
+	kv := &kvrwset.KVRWSet{}
+	for _, key := range reads {
+		kv.Reads = append(kv.Reads, &kvrwset.KVRead{Key: key})
+	}
+	for _, key := range writes {
+		if strings.HasPrefix(key, "-") {
+			kv.Writes = append(kv.Writes, &kvrwset.KVWrite{Key: key[1:], IsDelete: true})
+			continue
+		}
+		kv.Writes = append(kv.Writes, &kvrwset.KVWrite{Key: key, Value: []byte("{}")})
+	}
+	raw, err := proto.Marshal(kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &rwset.NsReadWriteSet{Namespace: namespace, Rwset: raw}
+}
+
+// proposalResponsePayload the payload endorsed by a peer that simulated the read/write sets
+func proposalResponsePayload(t *testing.T, nsRWSets ...*rwset.NsReadWriteSet) []byte {
+	t.Helper()
+	results, err := proto.Marshal(&rwset.TxReadWriteSet{DataModel: rwset.TxReadWriteSet_KV, NsRwset: nsRWSets})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extension, err := proto.Marshal(&pb.ChaincodeAction{Results: results, Response: &pb.Response{Status: 200, Payload: []byte(`{"ID":"CERT1"}`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := proto.Marshal(&pb.ProposalResponsePayload{Extension: extension})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestSummarizeRWSet(t *testing.T) {
+	garbageNs := &rwset.NsReadWriteSet{Namespace: "certificate", Rwset: []byte("not a read/write set")}
+	garbageResults, _ := proto.Marshal(&pb.ChaincodeAction{Results: []byte("not a read/write set")})
+	garbageExtension, _ := proto.Marshal(&pb.ProposalResponsePayload{Extension: []byte("not a chaincode action")})
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    []dto.NsReadWriteSet
+		wantErr bool
+	}{
+		{"nothing read nor written", proposalResponsePayload(t), []dto.NsReadWriteSet{}, false},
+		{"reads, writes and deletes", proposalResponsePayload(t, nsRWSet(t, "certificate", []string{"CERT1", "CERT2"}, "CERT1", "-CERT2")),
+			[]dto.NsReadWriteSet{{Namespace: "certificate", Reads: []string{"CERT1", "CERT2"}, Writes: []string{"CERT1"}, Deletes: []string{"CERT2"}}}, false},
+		{"read only", proposalResponsePayload(t, nsRWSet(t, "certificate", []string{"CERT1"})),
+			[]dto.NsReadWriteSet{{Namespace: "certificate", Reads: []string{"CERT1"}, Writes: []string{}, Deletes: []string{}}}, false},
+		// the lifecycle namespace read by every invocation is kept, in the order of the peer
+		{"several namespaces", proposalResponsePayload(t, nsRWSet(t, "_lifecycle", []string{"namespaces/fields/certificate/Sequence"}), nsRWSet(t, "certificate", nil, "CERT1")),
+			[]dto.NsReadWriteSet{
+				{Namespace: "_lifecycle", Reads: []string{"namespaces/fields/certificate/Sequence"}, Writes: []string{}, Deletes: []string{}},
+				{Namespace: "certificate", Reads: []string{}, Writes: []string{"CERT1"}, Deletes: []string{}},
+			}, false},
+		{"garbage payload", []byte("not a payload"), nil, true},
+		{"garbage chaincode action", garbageExtension, nil, true},
+		{"garbage results", func() []byte {
+			payload, _ := proto.Marshal(&pb.ProposalResponsePayload{Extension: garbageResults})
+			return payload
+		}(), nil, true},
+		{"garbage namespace read/write set", proposalResponsePayload(t, garbageNs), nil, true},
+	}
+	for _, tt := range tests {
+		got, err := summarizeRWSet(&pb.ProposalResponse{Payload: tt.payload})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: summarizeRWSet() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: summarizeRWSet() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// fakeLedgerClient runs the handler chains of the channel client against fake endorsers, orderer and event
+// service, counting what reaches each of them
+type fakeLedgerClient struct {
+	results   []byte // proposal response payload of the endorsers
+	endorsed  int
+	ordered   int
+	committed int
+}
+
+func (c *fakeLedgerClient) Query(request channel.Request, _ ...channel.RequestOption) (channel.Response, error) {
+	return c.run(invoke.NewQueryHandler(), request)
+}
+
+func (c *fakeLedgerClient) Execute(request channel.Request, _ ...channel.RequestOption) (channel.Response, error) {
+	return c.run(invoke.NewExecuteHandler(), request)
+}
+
+func (c *fakeLedgerClient) InvokeHandler(handler invoke.Handler, request channel.Request, _ ...channel.RequestOption) (channel.Response, error) {
+	return c.run(handler, request)
+}
+
+func (c *fakeLedgerClient) run(handler invoke.Handler, request channel.Request) (channel.Response, error) {
+	ctx, cancel := reqContext.WithTimeout(reqContext.Background(), time.Second)
+	defer cancel()
+	requestContext := &invoke.RequestContext{
+		Request: invoke.Request(request),
+		Opts:    invoke.Opts{Targets: []fab.Peer{fakePeer{}}},
+		Ctx:     ctx,
+	}
+	clientContext := &invoke.ClientContext{
+		Transactor:   fakeTransactor{c},
+		EventService: fakeEventService{ledger: c},
+		Membership:   fakeMembership{},
+	}
+	handler.Handle(requestContext, clientContext)
+	return channel.Response(requestContext.Response), requestContext.Error
+}
+
+// fakePeer endorsement target, the proposals are processed by the fakeTransactor
+type fakePeer struct {
+	fab.Peer
+}
+
+// fakeTransactor endorses the proposals on two peers and counts the transactions sent to the orderer
+type fakeTransactor struct {
+	ledger *fakeLedgerClient
+}
+
+func (f fakeTransactor) CreateTransactionHeader(...fab.TxnHeaderOpt) (fab.TransactionHeader, error) {
+	return fakeTxHeader{"tx-sim"}, nil
+}
+
+func (f fakeTransactor) SendTransactionProposal(*fab.TransactionProposal, []fab.ProposalProcessor) ([]*fab.TransactionProposalResponse, error) {
+	f.ledger.endorsed++
+	var responses []*fab.TransactionProposalResponse
+	for _, peer := range []string{"peer0.org1.example.com", "peer1.org1.example.com"} {
+		res := endorsement(peer, string(f.ledger.results))
+		res.ChaincodeStatus = 200
+		responses = append(responses, res)
+	}
+	return responses, nil
+}
+
+func (f fakeTransactor) CreateTransaction(request fab.TransactionRequest) (*fab.Transaction, error) {
+	return &fab.Transaction{Proposal: request.Proposal}, nil
+}
+
+func (f fakeTransactor) SendTransaction(*fab.Transaction) (*fab.TransactionResponse, error) {
+	f.ledger.ordered++
+	return &fab.TransactionResponse{Orderer: "orderer.example.com"}, nil
+}
+
+// fakeEventService notifies the commit of every awaited transaction
+type fakeEventService struct {
+	fab.EventService
+	ledger *fakeLedgerClient
+}
+
+func (f fakeEventService) RegisterTxStatusEvent(txID string) (fab.Registration, <-chan *fab.TxStatusEvent, error) {
+	f.ledger.committed++
+	events := make(chan *fab.TxStatusEvent, 1)
+	events <- &fab.TxStatusEvent{TxID: txID, TxValidationCode: pb.TxValidationCode_VALID}
+	return nil, events, nil
+}
+
+func (f fakeEventService) Unregister(fab.Registration) {}
+
+// fakeMembership trusts every endorser signature
+type fakeMembership struct{}
+
+func (fakeMembership) Validate([]byte) error               { return nil }
+func (fakeMembership) Verify([]byte, []byte, []byte) error { return nil }
+func (fakeMembership) ContainsMSP(string) bool             { return true }
+
+func TestRepoDappSimulateNeverCommits(t *testing.T) {
+	results := proposalResponsePayload(t, nsRWSet(t, "certificate", []string{"CERT1"}, "CERT1"))
+	tx := newTestTransaction(schema.UpdateAsset, "")
+
+	// the same fake sees the invocation reach the orderer and wait for the commit
+	invoked := &fakeLedgerClient{results: results}
+	r := newTestRepoDapp(nil, "", nil)
+	r.channelCreator = func(context.ChannelProvider) (channelExecutor, error) { return invoked, nil }
+	if _, err := r.Invoke(tx, "did"); err != nil {
+		t.Fatalf("Invoke() = %v", err)
+	}
+	if invoked.ordered != 1 || invoked.committed != 1 {
+		t.Fatalf("Invoke() ordered %d and awaited %d commits, want 1 and 1", invoked.ordered, invoked.committed)
+	}
+
+	simulated := &fakeLedgerClient{results: results}
+	r.channelCreator = func(context.ChannelProvider) (channelExecutor, error) { return simulated, nil }
+	payload, simulation, err := r.Simulate(tx, "did")
+	if err != nil {
+		t.Fatalf("Simulate() = %v", err)
+	}
+	if simulated.endorsed != 1 || simulated.ordered != 0 || simulated.committed != 0 {
+		t.Errorf("Simulate() endorsed %d, ordered %d and awaited %d commits, want 1, 0 and 0", simulated.endorsed, simulated.ordered, simulated.committed)
+	}
+
+	if string(payload) != `{"ID":"CERT1"}` {
+		t.Errorf("Simulate() payload = %s", payload)
+	}
+	want := &dto.TxSimulation{
+		TransactionID:   "tx-sim",
+		ChaincodeStatus: 200,
+		Endorsers:       []string{"peer0.org1.example.com", "peer1.org1.example.com"},
+		ReadWriteSet:    []dto.NsReadWriteSet{{Namespace: "certificate", Reads: []string{"CERT1"}, Writes: []string{"CERT1"}, Deletes: []string{}}},
+	}
+	if !reflect.DeepEqual(simulation, want) {
+		t.Errorf("Simulate() simulation = %+v, want %+v", simulation, want)
+	}
+}
@@ -0,0 +1,76 @@
+package repo
+
+import (
+	"dapp/schema/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AddInvitedUser create the pending user along with its invitation, changedBy is recorded in its history
+func (r *RepoUser) AddInvitedUser(user models.User, invitation models.Invitation, changedBy string) (models.User, error) {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := usernameAvailable(tx, user.Username); err != nil {
+			return err
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		invitation.Username = user.Username
+		if err := tx.Create(&invitation).Error; err != nil {
+			return err
+		}
+		return recordChanges(tx, userAction(user, models.Change_Created, changedBy))
+	})
+	return user, err
+}
+
+// RenewInvitation replace the pending invitations of the user with a new one, the previous links stop working
+func (r *RepoUser) RenewInvitation(invitation models.Invitation) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("username = ? AND used_at IS NULL", invitation.Username).Delete(&models.Invitation{})
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Create(&invitation).Error
+	})
+}
+
+// ActivateUser use the invitation to set the passphrase of the pending user, which proves its email too. Returns false
+// if the invitation doesn't exist, expired or was already used, or the user is not pending anymore
+func (r *RepoUser) ActivateUser(tokenHash, passphrase string) (models.User, bool, error) {
+	var user models.User
+	activated := false
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var invitation models.Invitation
+		result := tx.Limit(1).Find(&invitation, "token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		result = tx.Model(&models.Invitation{}).Where("id = ? AND used_at IS NULL", invitation.ID).Update("used_at", now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		result = tx.Model(&models.User{}).
+			Where("username = ? AND pending", invitation.Username).
+			Updates(map[string]interface{}{"passphrase": passphrase, "pending": false, "email_verified": true})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		activated = true
+		if err := tx.First(&user, "username = ?", invitation.Username).Error; err != nil {
+			return err
+		}
+		return recordChanges(tx, userAction(user, models.Change_Activated, user.Username))
+	})
+	return user, activated, err
+}
+
+// GetInvitation the unused and unexpired invitation with the token hash, found is false otherwise
+func (r *RepoUser) GetInvitation(tokenHash string) (models.Invitation, bool, error) {
+	var invitation models.Invitation
+	result := r.DB.Limit(1).Find(&invitation, "token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now())
+	return invitation, result.RowsAffected > 0, result.Error
+}
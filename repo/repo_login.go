@@ -0,0 +1,77 @@
+package repo
+
+import (
+	"dapp/schema/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// loginAttemptsTTL the unlocked failed login attempts older than this are purged
+const loginAttemptsTTL = 24 * time.Hour
+
+// GetLoginAttempts get the failed login attempts of the given keys, the keys without failures are not returned
+func (r *RepoUser) GetLoginAttempts(keys ...string) ([]models.LoginAttempt, error) {
+	var attempts []models.LoginAttempt
+	result := r.DB.Where("key IN ?", keys).Find(&attempts)
+	return attempts, result.Error
+}
+
+// AddLoginFailure count a failed login attempt of the key and returns its failures. The failures before forgetAfter
+// are forgotten, so the count starts again
+func (r *RepoUser) AddLoginFailure(key string, forgetAfter time.Time) (int, error) {
+	now := time.Now()
+	attempt := models.LoginAttempt{Key: key, Failures: 1, LastFailureAt: now}
+	// single upsert, so the concurrent attempts of all the API replicas are all counted
+	result := r.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"failures":        gorm.Expr("CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END", forgetAfter),
+			"last_failure_at": now,
+		}),
+	}).Create(&attempt)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	var failures int
+	result = r.DB.Model(&models.LoginAttempt{}).Where("key = ?", key).Pluck("failures", &failures)
+	return failures, result.Error
+}
+
+// LockLogin refuse the login attempts of the key until the given time
+func (r *RepoUser) LockLogin(key string, until time.Time) error {
+	return r.DB.Model(&models.LoginAttempt{}).Where("key = ?", key).Update("locked_until", until).Error
+}
+
+// ResetLoginAttempts forget the failed login attempts of the keys, unlocking them
+func (r *RepoUser) ResetLoginAttempts(keys ...string) error {
+	return r.DB.Delete(&models.LoginAttempt{}, "key IN ?", keys).Error
+}
+
+// AddOIDCLogin store a pending OpenID Connect login
+func (r *RepoUser) AddOIDCLogin(login models.OIDCLogin) error {
+	return r.DB.Create(&login).Error
+}
+
+// TakeOIDCLogin get and remove the pending OpenID Connect login of the state, so a state can only be used once.
+// Returns false if there is no such login
+func (r *RepoUser) TakeOIDCLogin(state string) (models.OIDCLogin, bool, error) {
+	var login models.OIDCLogin
+	result := r.DB.Clauses(clause.Returning{}).Where("state = ?", state).Delete(&login)
+	return login, result.RowsAffected == 1, result.Error
+}
+
+// PurgeLoginAttempts remove the failed login attempts unlocked and older than loginAttemptsTTL
+func (r *RepoUser) PurgeLoginAttempts() (int64, error) {
+	now := time.Now()
+	result := r.DB.Where("last_failure_at < ? AND locked_until < ?", now.Add(-loginAttemptsTTL), now).Delete(&models.LoginAttempt{})
+	return result.RowsAffected, result.Error
+}
+
+// PurgeOIDCLogins remove the OpenID Connect logins abandoned before the callback, once expired
+func (r *RepoUser) PurgeOIDCLogins() (int64, error) {
+	result := r.DB.Where("expires_at < ?", time.Now()).Delete(&models.OIDCLogin{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,44 @@
+package repo
+
+import (
+	"dapp/schema/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GetUsersByEmail the users with the email, case-insensitive. The email is not unique, a person can have several
+// accounts
+func (r *RepoUser) GetUsersByEmail(email string) ([]models.User, error) {
+	var users []models.User
+	result := r.DB.Where("LOWER(email) = LOWER(?)", email).Find(&users)
+	return users, result.Error
+}
+
+// AddPasswordReset store a new password reset token
+func (r *RepoUser) AddPasswordReset(reset models.PasswordReset) error {
+	return r.DB.Create(&reset).Error
+}
+
+// UsePasswordReset mark the password reset token as used, along with the other pending tokens of its user. Returns
+// false if the token doesn't exist, expired or was already used, so two concurrent requests can't both succeed
+func (r *RepoUser) UsePasswordReset(tokenHash string) (models.PasswordReset, bool, error) {
+	var reset models.PasswordReset
+	used := false
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Limit(1).Find(&reset, "token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		result = tx.Model(&models.PasswordReset{}).Where("id = ? AND used_at IS NULL", reset.ID).Update("used_at", now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		used = true
+		return tx.Model(&models.PasswordReset{}).
+			Where("username = ? AND used_at IS NULL", reset.Username).
+			Update("used_at", now).Error
+	})
+	return reset, used, err
+}
@@ -0,0 +1,84 @@
+package repo
+
+import (
+	"dapp/schema/models"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrRoleInUse the role is assigned to users, the deleted ones included since they can be restored
+var ErrRoleInUse = errors.New("the role is assigned to users")
+
+// GetRole get the role with the label
+func (r *RepoUser) GetRole(label string) (models.Role, error) {
+	var role models.Role
+	result := r.DB.First(&role, "label = ?", label)
+	return role, result.Error
+}
+
+// AddRole create the role along with its permissions
+func (r *RepoUser) AddRole(role models.Role, permissions []string) (models.Role, error) {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&role).Error; err != nil {
+			return err
+		}
+		return replaceRolePermissions(tx, role.Label, permissions)
+	})
+	return role, err
+}
+
+// UpdateRole update the name and description of the role, and replace its permissions unless they are nil
+func (r *RepoUser) UpdateRole(role models.Role, permissions []string) (models.Role, error) {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&role).Select("name", "description").Updates(role).Error; err != nil {
+			return err
+		}
+		if permissions == nil {
+			return nil
+		}
+		return replaceRolePermissions(tx, role.Label, permissions)
+	})
+	return role, err
+}
+
+// RemoveRole delete the role and its permissions. Returns ErrRoleInUse if a user still has it
+func (r *RepoUser) RemoveRole(label string) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		var users int64
+		if err := tx.Unscoped().Model(&models.User{}).Where("role = ?", label).Count(&users).Error; err != nil {
+			return err
+		}
+		if users > 0 {
+			return ErrRoleInUse
+		}
+		if err := tx.Where("role = ?", label).Delete(&models.RolePermission{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("label = ?", label).Delete(&models.Role{})
+		if result.Error == nil && result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return result.Error
+	})
+}
+
+// replaceRolePermissions store the permissions of the role in the role_permissions table. No permissions are stored
+// as the models.NoPermission row, the role must not fall back to its configured or built-in permissions
+func replaceRolePermissions(tx *gorm.DB, label string, permissions []string) error {
+	if err := tx.Where("role = ?", label).Delete(&models.RolePermission{}).Error; err != nil {
+		return err
+	}
+	if len(permissions) == 0 {
+		return tx.Create(&models.RolePermission{Role: label, Permission: models.NoPermission}).Error
+	}
+	rows := make([]models.RolePermission, 0, len(permissions))
+	seen := make(map[string]bool)
+	for _, permission := range permissions {
+		if !seen[permission] {
+			rows = append(rows, models.RolePermission{Role: label, Permission: permission})
+			seen[permission] = true
+		}
+	}
+	return tx.Create(&rows).Error
+}
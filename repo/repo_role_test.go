@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"dapp/schema"
+	"dapp/schema/models"
+	"reflect"
+	"testing"
+)
+
+func TestRepoRolePermissions(t *testing.T) {
+	r := &RepoUser{DB: newTestDB(t)}
+	role, err := r.AddRole(models.Role{Label: "auditor", Name: "Auditor", Description: "Reads the users"}, []string{schema.PermUsersRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := func() []models.RolePermission {
+		var rows []models.RolePermission
+		if err := r.DB.Order("permission").Find(&rows, "role = ?", "auditor").Error; err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+
+	tests := []struct {
+		name        string
+		permissions []string
+		want        []models.RolePermission
+	}{
+		{"nil keeps the permissions", nil, []models.RolePermission{{Role: "auditor", Permission: schema.PermUsersRead}}},
+		// an explicit empty set, the role must not fall back to its configured permissions
+		{"empty set", []string{}, []models.RolePermission{{Role: "auditor", Permission: models.NoPermission}}},
+		{"replaced", []string{schema.PermRolesRead, schema.PermRolesRead}, []models.RolePermission{{Role: "auditor", Permission: schema.PermRolesRead}}},
+	}
+	for _, tt := range tests {
+		if _, err = r.UpdateRole(role, tt.permissions); err != nil {
+			t.Fatalf("%s: UpdateRole() = %v", tt.name, err)
+		}
+		if got := stored(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: stored permissions = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if err = r.RemoveRole("auditor"); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored(); len(got) != 0 {
+		t.Errorf("the permissions of the removed role are kept: %+v", got)
+	}
+}
@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"dapp/schema/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AddSession store the session of a new login
+func (r *RepoUser) AddSession(session models.Session) error {
+	return r.DB.Create(&session).Error
+}
+
+// ExtendSession set the expiry of the session to the one of its new refresh token
+func (r *RepoUser) ExtendSession(sessionID string, expiresAt time.Time) error {
+	return r.DB.Model(&models.Session{}).Where("id = ?", sessionID).Update("expires_at", expiresAt).Error
+}
+
+// GetSessions the active sessions of the user, not revoked nor expired, the last used first
+func (r *RepoUser) GetSessions(username string) ([]models.Session, error) {
+	var sessions []models.Session
+	result := r.DB.Where("username = ? AND revoked_at IS NULL AND expires_at > ?", username, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions)
+	return sessions, result.Error
+}
+
+// RevokeSession revoke the session of the user and its refresh tokens. Returns false if the user has no such active
+// session
+func (r *RepoUser) RevokeSession(username, sessionID string) (bool, error) {
+	revoked := false
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.Session{}).
+			Where("id = ? AND username = ? AND revoked_at IS NULL", sessionID, username).
+			Update("revoked_at", now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		revoked = true
+		return tx.Model(&models.RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", sessionID).
+			Update("revoked_at", now).Error
+	})
+	return revoked, err
+}
+
+// PurgeSessions remove the sessions expired or revoked before the given time. It has to be older than the access
+// token max age, so none of their access tokens can be valid anymore
+func (r *RepoUser) PurgeSessions(before time.Time) (int64, error) {
+	result := r.DB.Where("expires_at < ? OR revoked_at < ?", before, before).Delete(&models.Session{})
+	return result.RowsAffected, result.Error
+}
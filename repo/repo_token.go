@@ -0,0 +1,81 @@
+package repo
+
+import (
+	"dapp/schema/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AddRefreshToken store a new refresh token
+func (r *RepoUser) AddRefreshToken(token models.RefreshToken) (models.RefreshToken, error) {
+	result := r.DB.Create(&token)
+	return token, result.Error
+}
+
+// GetRefreshToken get the refresh token with the given hash
+func (r *RepoUser) GetRefreshToken(tokenHash string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	if result := r.DB.First(&token, "token_hash = ?", tokenHash); result.Error != nil {
+		return models.RefreshToken{}, result.Error
+	}
+	return token, nil
+}
+
+// UseRefreshToken mark the refresh token as used. Returns false if it was already used or revoked, so two concurrent
+// requests with the same token can't both succeed
+func (r *RepoUser) UseRefreshToken(tokenID int) (bool, error) {
+	result := r.DB.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", tokenID).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// RevokeTokenFamily revoke all the refresh tokens of the family, and its session
+func (r *RepoUser) RevokeTokenFamily(familyID string) error {
+	now := time.Now()
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", familyID).
+			Update("revoked_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Model(&models.Session{}).Where("id = ? AND revoked_at IS NULL", familyID).Update("revoked_at", now).Error
+	})
+}
+
+// RevokeUserRefreshTokens revoke all the refresh tokens and the sessions of the user
+func (r *RepoUser) RevokeUserRefreshTokens(username string) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		return revokeRefreshTokens(tx, username, time.Now())
+	})
+}
+
+// revokeRefreshTokens revoke the refresh tokens and the sessions of the user at the given time
+func revokeRefreshTokens(tx *gorm.DB, username string, revokedAt time.Time) error {
+	result := tx.Model(&models.RefreshToken{}).
+		Where("username = ? AND revoked_at IS NULL", username).
+		Update("revoked_at", revokedAt)
+	if result.Error != nil {
+		return result.Error
+	}
+	return tx.Model(&models.Session{}).Where("username = ? AND revoked_at IS NULL", username).Update("revoked_at", revokedAt).Error
+}
+
+// revokeUser revoke every token of the user within the transaction of the change that requires it, so the change is
+// not committed without the revocation. It goes last, the tokens issued until the commit are the fewest possible
+func revokeUser(tx *gorm.DB, username string) error {
+	now := time.Now()
+	if err := revokeRefreshTokens(tx, username, now); err != nil {
+		return err
+	}
+	return revokeAccessTokens(tx, username, now)
+}
+
+// PurgeRefreshTokens remove the refresh tokens expired before the given time, used or not. A used token is kept until
+// it expires, so its reuse is detected as long as it could have been used
+func (r *RepoUser) PurgeRefreshTokens(before time.Time) (int64, error) {
+	result := r.DB.Where("expires_at < ?", before).Delete(&models.RefreshToken{})
+	return result.RowsAffected, result.Error
+}
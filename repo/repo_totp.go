@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"dapp/schema/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetTOTP get the TOTP second factor of the user. Returns false if the user never started the enrolment
+func (r *RepoUser) GetTOTP(username string) (models.UserTOTP, bool, error) {
+	var totp models.UserTOTP
+	result := r.DB.Limit(1).Find(&totp, "username = ?", username)
+	return totp, result.RowsAffected == 1, result.Error
+}
+
+// SavePendingTOTP store the pending TOTP enrolment of the user, replacing the previous pending one. Returns false if
+// the user has the second factor enabled, it is left untouched
+func (r *RepoUser) SavePendingTOTP(totp models.UserTOTP) (bool, error) {
+	totp.Enabled = false
+	result := r.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "username"}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret", "created_at"}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "NOT user_totps.enabled"}}},
+	}).Create(&totp)
+	return result.RowsAffected == 1, result.Error
+}
+
+// EnableTOTP confirm the pending TOTP enrolment of the user with the given secret, storing the step of the code that
+// confirmed it and the hashes of the new recovery codes. Returns false if that enrolment is no longer pending, e.g. it
+// was replaced by a new one
+func (r *RepoUser) EnableTOTP(username, secret string, step int64, codeHashes []string) (bool, error) {
+	enabled := false
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.UserTOTP{}).Where("username = ? AND secret = ? AND NOT enabled", username, secret).
+			Updates(map[string]interface{}{"enabled": true, "last_step": step})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		enabled = true
+		return replaceRecoveryCodes(tx, username, codeHashes)
+	})
+	return enabled && err == nil, err
+}
+
+// UseTOTPStep record the step of the code accepted for the user. Returns false if that step, or a later one, was
+// already used, so two concurrent logins with the same code can't both succeed
+func (r *RepoUser) UseTOTPStep(username string, step int64) (bool, error) {
+	result := r.DB.Model(&models.UserTOTP{}).
+		Where("username = ? AND enabled AND last_step < ?", username, step).
+		Update("last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// ReplaceRecoveryCodes discard the recovery codes of the user, used or not, and store the new ones
+func (r *RepoUser) ReplaceRecoveryCodes(username string, codeHashes []string) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, username, codeHashes)
+	})
+}
+
+// UseRecoveryCode mark the recovery code of the user as used. Returns false if there is no such unused code
+func (r *RepoUser) UseRecoveryCode(username, codeHash string) (bool, error) {
+	result := r.DB.Model(&models.RecoveryCode{}).
+		Where("username = ? AND code_hash = ? AND used_at IS NULL", username, codeHash).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// RemoveTOTP remove the TOTP second factor and the recovery codes of the user
+func (r *RepoUser) RemoveTOTP(username string) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.UserTOTP{}, "username = ?", username).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.RecoveryCode{}, "username = ?", username).Error
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, username string, codeHashes []string) error {
+	if err := tx.Delete(&models.RecoveryCode{}, "username = ?", username).Error; err != nil {
+		return err
+	}
+	codes := make([]models.RecoveryCode, 0, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes = append(codes, models.RecoveryCode{Username: username, CodeHash: hash})
+	}
+	return tx.Create(&codes).Error
+}
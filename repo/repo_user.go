@@ -5,13 +5,16 @@ import (
 	"dapp/schema/dto"
 	"dapp/schema/models"
 	"dapp/service/utils"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"sync"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // region ======== SETUP =================================================================
@@ -26,13 +29,27 @@ var singletonRU *RepoUser
 // using Go sync package to invoke a method exactly only once
 var onceRU sync.Once
 
+// purgeEvery interval between purges of the rows kept only until they expire, e.g. the sessions
+const purgeEvery = 30 * time.Minute
+
+// ErrUserDeleted the user was soft deleted, it has to be restored first
+var ErrUserDeleted = errors.New("the user was deleted")
+
+// ErrUsernameTaken another user, maybe a deleted one, has the username
+var ErrUsernameTaken = errors.New("the username is already taken")
+
+// ErrProviderMismatch the user logs in with another authentication provider, or is another single sign-on subject. An
+// admin has to link it to the provider first
+var ErrProviderMismatch = errors.New("the user logs in with another authentication provider")
+
 // endregion =============================================================================
 
 func NewRepoUser(svcConf *utils.SvcConfig) *RepoUser {
 	onceRU.Do(func() {
 		singletonRU = &RepoUser{DBLocation: svcConf.StoreDBPath}
 		singletonRU.InitDB(svcConf.UsersDBUrl)
-		singletonRU.PopulateDB()
+		singletonRU.PopulateDB(svcConf.PasswordParams())
+		go singletonRU.runPurge(purgeEvery, time.Duration(svcConf.TkMaxAge)*time.Minute)
 	})
 	return singletonRU
 }
@@ -55,10 +72,21 @@ func (r *RepoUser) GetUserByUsername(username string) (models.User, error) {
 	return modelUser, nil
 }
 
-// GetUsers return a list of dto.User
-func (r *RepoUser) GetUsers(pagination *dto.Pagination) (*dto.Pagination, error) {
+// GetUsers return a list of dto.User, with the filters. With filter.Deleted, the list of the soft deleted users
+// instead. Returns ErrInvalidSort if the pagination sort isn't a sortable column of the users
+func (r *RepoUser) GetUsers(pagination *dto.Pagination, filter dto.UserFilter) (*dto.Pagination, error) {
+	order, err := orderBy(pagination.GetSort(), userSortColumns)
+	if err != nil {
+		return &dto.Pagination{}, err
+	}
+	db := r.DB.Model(&models.User{})
+	if filter.Deleted {
+		db = db.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	db = filterUsers(db, filter).Session(&gorm.Session{})
+
 	var users []models.User
-	result := r.DB.Scopes(paginate(users, pagination, r.DB)).Find(&users)
+	result := db.Scopes(paginate(pagination, db, order)).Find(&users)
 	if result.Error != nil {
 		return &dto.Pagination{}, result.Error
 	}
@@ -66,33 +94,167 @@ func (r *RepoUser) GetUsers(pagination *dto.Pagination) (*dto.Pagination, error)
 	return pagination, nil
 }
 
-// AddUser Add the user to database
+// AddUser Add the user to database, changedBy is recorded in its history
 // Returns nil if user was added correctly, otherwise return error found
-func (r *RepoUser) AddUser(user models.User) (models.User, error) {
-	result := r.DB.Create(&user)
-	return user, result.Error
+func (r *RepoUser) AddUser(user models.User, changedBy string) (models.User, error) {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := usernameAvailable(tx, user.Username); err != nil {
+			return err
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return recordChanges(tx, userAction(user, models.Change_Created, changedBy))
+	})
+	return user, err
 }
 
-// UpdateUser Update user with id UserID to new data in database
+// UpdateUser Update user with id UserID to new data in database, every changed field is recorded in its history.
+// A change of the username, the passphrase, the role or the provider revokes the tokens of the user in the same
+// transaction. A new username must be available, as in AddUser
 // Returns nil if user was updated correctly, otherwise return error found
-func (r *RepoUser) UpdateUser(userID int, user models.User) (models.User, error) {
-	var userInDB models.User
-	if result := r.DB.First(&userInDB, userID); result.Error != nil {
-		return models.User{}, result.Error
+func (r *RepoUser) UpdateUser(userID int, user models.User, changedBy string) (models.User, error) {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var userInDB models.User
+		if result := tx.First(&userInDB, userID); result.Error != nil {
+			return result.Error
+		}
+		if user.Username != userInDB.Username {
+			if err := usernameAvailable(tx, user.Username); err != nil {
+				return err
+			}
+		}
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		if err := recordChanges(tx, userChanges(userInDB, user, changedBy)...); err != nil {
+			return err
+		}
+		if user.Username != userInDB.Username || user.Passphrase != userInDB.Passphrase || user.Role != userInDB.Role ||
+			user.Provider != userInDB.Provider {
+			return revokeUser(tx, userInDB.Username)
+		}
+		return nil
+	})
+	if err != nil {
+		return models.User{}, err
 	}
-	result := r.DB.Save(&user)
-	return user, result.Error
+	return user, nil
 }
 
-// RemoveUser Remove user from database
+// RemoveUser Soft delete the user and revoke its tokens, it can be restored. The certificates keep referencing its
+// username
 // Returns nil if user was removed correctly, otherwise return error found
-func (r *RepoUser) RemoveUser(userID int) (models.User, error) {
+func (r *RepoUser) RemoveUser(userID int, changedBy string) (models.User, error) {
 	var modelUser models.User
-	if result := r.DB.First(&modelUser, userID); result.Error != nil {
-		return models.User{}, result.Error
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.First(&modelUser, userID); result.Error != nil {
+			return result.Error
+		}
+		if err := tx.Delete(&modelUser).Error; err != nil {
+			return err
+		}
+		if err := recordChanges(tx, userAction(modelUser, models.Change_Deleted, changedBy)); err != nil {
+			return err
+		}
+		return revokeUser(tx, modelUser.Username)
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return modelUser, nil
+}
+
+// RestoreUser undo the soft delete of the user
+// Returns gorm.ErrRecordNotFound if there is no deleted user with the id
+func (r *RepoUser) RestoreUser(userID int, changedBy string) (models.User, error) {
+	var modelUser models.User
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&models.User{}).Where("id = ? AND deleted_at IS NOT NULL", userID).Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.First(&modelUser, userID).Error; err != nil {
+			return err
+		}
+		return recordChanges(tx, userAction(modelUser, models.Change_Restored, changedBy))
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return modelUser, nil
+}
+
+// UpdatePassphrase replace the passphrase hash of the user, the rest of the user is left untouched
+func (r *RepoUser) UpdatePassphrase(userID int, passphrase string) error {
+	result := r.DB.Model(&models.User{ID: userID}).Update("passphrase", passphrase)
+	return result.Error
+}
+
+// UpsertDirectoryUser create the user authenticated by an external directory, or update its profile and role with
+// the directory ones. The single sign-on users are matched by issuer and subject, the rest by username. The passphrase
+// is only set on creation, and an invalidated user stays invalidated. A role change revokes the tokens of the user. A
+// deleted user is not recreated, ErrUserDeleted is returned until it is restored. ErrProviderMismatch is returned for
+// a user of another provider, e.g. a local user with the same username, so a directory can't take it over
+func (r *RepoUser) UpsertDirectoryUser(user models.User, changedBy string) (models.User, error) {
+	var modelUser models.User
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var result *gorm.DB
+		if user.Subject != "" { // the username claim of a single sign-on user may change, its subject doesn't
+			result = tx.Unscoped().Limit(1).Find(&modelUser, "issuer = ? AND subject = ?", user.Issuer, user.Subject)
+		}
+		if result == nil || result.Error == nil && result.RowsAffected == 0 {
+			result = tx.Unscoped().Limit(1).Find(&modelUser, "username = ?", user.Username)
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			modelUser = user
+			return recordChanges(tx, userAction(user, models.Change_Created, changedBy))
+		}
+		if modelUser.DeletedAt.Valid {
+			return ErrUserDeleted
+		}
+		linked := modelUser.Subject == "" || modelUser.Issuer == user.Issuer && modelUser.Subject == user.Subject
+		if modelUser.Provider != user.Provider || !linked {
+			return ErrProviderMismatch
+		}
+
+		before := modelUser
+		modelUser.Issuer, modelUser.Subject = user.Issuer, user.Subject // a user linked by an admin is bound on its first login
+		modelUser.FirstName, modelUser.LastName, modelUser.Email = user.FirstName, user.LastName, user.Email
+		if modelUser.Role != models.Role_Invalid {
+			modelUser.Role = user.Role
+		}
+		if err := tx.Save(&modelUser).Error; err != nil {
+			return err
+		}
+		if err := recordChanges(tx, userChanges(before, modelUser, changedBy)...); err != nil {
+			return err
+		}
+		if modelUser.Role != before.Role {
+			return revokeUser(tx, modelUser.Username)
+		}
+		return nil
+	})
+	if err != nil {
+		return models.User{}, err
 	}
-	result := r.DB.Delete(&modelUser)
-	return modelUser, result.Error
+	return modelUser, nil
+}
+
+// GetRolePermissions get the permissions granted to the roles in the database
+func (r *RepoUser) GetRolePermissions() ([]models.RolePermission, error) {
+	var permissions []models.RolePermission
+	result := r.DB.Find(&permissions)
+	return permissions, result.Error
 }
 
 func (r *RepoUser) GetRoles() ([]models.Role, error) {
@@ -101,15 +263,27 @@ func (r *RepoUser) GetRoles() ([]models.Role, error) {
 	return roles, result.Error
 }
 
-// InvalidateUser Invalidate user, remove all access privileges
-func (r *RepoUser) InvalidateUser(userID int) (models.User, error) {
+// InvalidateUser Invalidate user, remove all access privileges and revoke its tokens
+func (r *RepoUser) InvalidateUser(userID int, changedBy string) (models.User, error) {
 	var modelUser models.User
-	if result := r.DB.First(&modelUser, userID); result.Error != nil {
-		return models.User{}, result.Error
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.First(&modelUser, userID); result.Error != nil {
+			return result.Error
+		}
+		before := modelUser
+		modelUser.Role = models.Role_Invalid
+		if err := tx.Save(&modelUser).Error; err != nil {
+			return err
+		}
+		if err := recordChanges(tx, userChanges(before, modelUser, changedBy)...); err != nil {
+			return err
+		}
+		return revokeUser(tx, modelUser.Username)
+	})
+	if err != nil {
+		return models.User{}, err
 	}
-	modelUser.Role = models.Role_Invalid
-	result := r.DB.Save(&modelUser)
-	return modelUser, result.Error
+	return modelUser, nil
 }
 
 func (r *RepoUser) InitDB(dbURL string) {
@@ -117,15 +291,52 @@ func (r *RepoUser) InitDB(dbURL string) {
 	if err != nil {
 		log.Fatalln(err)
 	}
-	db.AutoMigrate(&models.User{}, &models.Role{})
+	if err = Migrate(db); err != nil {
+		log.Println(err)
+	}
 	r.DB = db
 }
-func (r *RepoUser) PopulateDB() {
-	r.PopulateUserTable()
+
+// Migrate create or update the tables of the users database
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&models.User{}, &models.Role{}, &models.WalletIdentity{}, &models.RefreshToken{}, &models.BlockedToken{}, &models.TokenRevocation{}, &models.LoginAttempt{}, &models.OIDCLogin{}, &models.UserTOTP{}, &models.RecoveryCode{}, &models.RolePermission{}, &models.Session{}, &models.PasswordReset{}, &models.Invitation{}, &models.UserChange{})
+}
+
+// Purge remove the failed login attempts, the OpenID Connect logins, the refresh tokens and the sessions kept only
+// until they expire. The sessions stay until the access tokens they issued, tkMaxAge long, expired too
+func (r *RepoUser) Purge(tkMaxAge time.Duration) (int64, error) {
+	attempts, err := r.PurgeLoginAttempts()
+	if err != nil {
+		return 0, err
+	}
+	logins, err := r.PurgeOIDCLogins()
+	if err != nil {
+		return attempts, err
+	}
+	tokens, err := r.PurgeRefreshTokens(time.Now())
+	if err != nil {
+		return attempts + logins, err
+	}
+	sessions, err := r.PurgeSessions(time.Now().Add(-tkMaxAge))
+	return attempts + logins + tokens + sessions, err
+}
+
+func (r *RepoUser) runPurge(every, tkMaxAge time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := r.Purge(tkMaxAge); err != nil {
+			log.Printf("failed to purge the expired logins, refresh tokens and sessions: %s", err)
+		}
+	}
+}
+func (r *RepoUser) PopulateDB(passwordParams lib.PasswordParams) {
+	r.PopulateUserTable(passwordParams)
 	r.PopulateRolTable()
 }
 
-func (r *RepoUser) PopulateUserTable() {
+func (r *RepoUser) PopulateUserTable(passwordParams lib.PasswordParams) {
 	var usersInDB []models.User
 	if result := r.DB.Find(&usersInDB); result.Error != nil {
 		fmt.Println(result.Error)
@@ -134,7 +345,10 @@ func (r *RepoUser) PopulateUserTable() {
 		return
 	}
 
-	p1, _ := lib.Checksum("SHA256", []byte("password1"))
+	p1, err := lib.HashPassword("password1", passwordParams)
+	if err != nil {
+		log.Fatalln(err)
+	}
 	users := []models.User{
 		{
 			Username:   "richard",
@@ -226,14 +440,32 @@ func (r *RepoUser) PopulateRolTable() {
 	})
 }
 
-func paginate(value interface{}, pagination *dto.Pagination, db *gorm.DB) func(db *gorm.DB) *gorm.DB {
+// paginate the page of the rows, db has the filters of the rows so the total count uses them too
+func paginate(pagination *dto.Pagination, db *gorm.DB, order clause.OrderByColumn) func(db *gorm.DB) *gorm.DB {
 	var totalRows int64
-	db.Model(value).Count(&totalRows)
+	db.Count(&totalRows)
 	pagination.TotalRows = totalRows
-	totalPages := int(math.Ceil(float64(totalRows) / float64(pagination.Limit)))
+	totalPages := int(math.Ceil(float64(totalRows) / float64(pagination.GetLimit())))
 	pagination.TotalPages = totalPages
 
 	return func(db *gorm.DB) *gorm.DB {
-		return db.Offset(pagination.GetOffset()).Limit(pagination.GetLimit()).Order(pagination.GetSort())
+		return db.Offset(pagination.GetOffset()).Limit(pagination.GetLimit()).Order(order)
+	}
+}
+
+// usernameAvailable returns ErrUserDeleted or ErrUsernameTaken when a user, deleted or not, has the username. The
+// deleted users keep their username, their certificates reference it
+func usernameAvailable(tx *gorm.DB, username string) error {
+	var user models.User
+	result := tx.Unscoped().Limit(1).Find(&user, "username = ?", username)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	if user.DeletedAt.Valid {
+		return ErrUserDeleted
 	}
+	return ErrUsernameTaken
 }
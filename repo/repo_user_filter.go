@@ -0,0 +1,102 @@
+package repo
+
+import (
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidSort the sort isn't a sortable column followed by an optional asc or desc
+var ErrInvalidSort = errors.New("invalid sort, use a sortable column followed by asc or desc")
+
+// userSortColumns the sortable columns of the users list, by the name of the field in the responses
+var userSortColumns = map[string]string{
+	"id":        "id",
+	"username":  "username",
+	"firstname": "first_name",
+	"lastname":  "last_name",
+	"email":     "email",
+	"rol":       "role",
+	"createdat": "created_at",
+}
+
+// orderBy the column and direction of the sort, e.g. "createdAt desc". Only the columns of the whitelist are allowed,
+// the sort comes straight from the query parameters
+func orderBy(sort string, columns map[string]string) (clause.OrderByColumn, error) {
+	fields := strings.Fields(sort)
+	if len(fields) == 0 || len(fields) > 2 {
+		return clause.OrderByColumn{}, ErrInvalidSort
+	}
+	column, ok := columns[strings.ToLower(fields[0])]
+	if !ok {
+		return clause.OrderByColumn{}, ErrInvalidSort
+	}
+	desc := false
+	if len(fields) == 2 {
+		switch strings.ToLower(fields[1]) {
+		case "asc":
+		case "desc":
+			desc = true
+		default:
+			return clause.OrderByColumn{}, ErrInvalidSort
+		}
+	}
+	return clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: desc}, nil
+}
+
+// filterUsers add the conditions of the filter, the values are always bound parameters
+func filterUsers(db *gorm.DB, filter dto.UserFilter) *gorm.DB {
+	if filter.Role != "" {
+		db = db.Where("role = ?", filter.Role)
+	}
+	if filter.Name != "" {
+		name := containsPattern(filter.Name)
+		db = db.Where("username ILIKE ? OR first_name ILIKE ? OR last_name ILIKE ?", name, name, name)
+	}
+	if filter.Email != "" {
+		db = db.Where("email ILIKE ?", containsPattern(filter.Email))
+	}
+	switch filter.Status {
+	case models.Status_Active:
+		db = db.Where("NOT pending AND role <> ?", models.Role_Invalid)
+	case models.Status_Pending:
+		db = db.Where("pending")
+	case models.Status_Invalid:
+		db = db.Where("role = ?", models.Role_Invalid)
+	}
+	if from, err := time.Parse("2006-01-02", filter.CreatedFrom); err == nil {
+		db = db.Where("created_at >= ?", from)
+	}
+	if to, err := time.Parse("2006-01-02", filter.CreatedTo); err == nil {
+		db = db.Where("created_at < ?", to.AddDate(0, 0, 1))
+	}
+	return db
+}
+
+// containsPattern the ILIKE pattern matching the substring, its wildcards are escaped
+func containsPattern(substring string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(substring) + "%"
+}
+
+// FindUsers every user matching the filter, ordered by id. Used by the export, without pagination
+func (r *RepoUser) FindUsers(filter dto.UserFilter) ([]models.User, error) {
+	db := r.DB.Model(&models.User{})
+	if filter.Deleted {
+		db = db.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	var users []models.User
+	result := filterUsers(db, filter).Order("id").Find(&users)
+	return users, result.Error
+}
+
+// GetUsersByUsernames the users with the usernames, the deleted ones included
+func (r *RepoUser) GetUsersByUsernames(usernames []string) ([]models.User, error) {
+	var users []models.User
+	result := r.DB.Unscoped().Where("username IN ?", usernames).Find(&users)
+	return users, result.Error
+}
@@ -0,0 +1,35 @@
+package repo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOrderBy(t *testing.T) {
+	tests := []struct {
+		sort   string
+		column string
+		desc   bool
+		err    error
+	}{
+		{"Id desc", "id", true, nil},
+		{"createdAt", "created_at", false, nil},
+		{"LASTNAME ASC", "last_name", false, nil},
+		{"passphrase desc", "", false, ErrInvalidSort},
+		{"id; DROP TABLE users", "", false, ErrInvalidSort},
+		{"id sideways", "", false, ErrInvalidSort},
+		{"", "", false, ErrInvalidSort},
+	}
+	for _, tt := range tests {
+		order, err := orderBy(tt.sort, userSortColumns)
+		if !errors.Is(err, tt.err) || order.Column.Name != tt.column || order.Desc != tt.desc {
+			t.Errorf("orderBy(%q) = %+v, %v", tt.sort, order, err)
+		}
+	}
+}
+
+func TestContainsPattern(t *testing.T) {
+	if got := containsPattern(`50%_off\`); got != `%50\%\_off\\%` {
+		t.Errorf("containsPattern() = %s", got)
+	}
+}
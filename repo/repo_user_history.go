@@ -0,0 +1,58 @@
+package repo
+
+import (
+	"dapp/schema/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GetUserChanges the change history of the user, the last change first
+func (r *RepoUser) GetUserChanges(userID int) ([]models.UserChange, error) {
+	var changes []models.UserChange
+	result := r.DB.Where("user_id = ?", userID).Order("changed_at DESC, id DESC").Find(&changes)
+	return changes, result.Error
+}
+
+// recordChanges store the changes, in the transaction of the user operation
+func recordChanges(tx *gorm.DB, changes ...models.UserChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	return tx.Create(&changes).Error
+}
+
+// userAction the change of an action on the whole user
+func userAction(user models.User, action, changedBy string) models.UserChange {
+	return models.UserChange{UserID: user.ID, Action: action, ChangedBy: changedBy, ChangedAt: time.Now()}
+}
+
+// userChanges one change per updated field of the user. The passphrase values are not recorded
+func userChanges(before, after models.User, changedBy string) []models.UserChange {
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"username", before.Username, after.Username},
+		{"firstname", before.FirstName, after.FirstName},
+		{"lastname", before.LastName, after.LastName},
+		{"email", before.Email, after.Email},
+		{"role", before.Role, after.Role},
+		{"provider", before.Provider, after.Provider},
+		{"passphrase", before.Passphrase, after.Passphrase},
+	}
+
+	now := time.Now()
+	var changes []models.UserChange
+	for _, f := range fields {
+		if f.old == f.new {
+			continue
+		}
+		change := models.UserChange{UserID: after.ID, Action: models.Change_Updated, Field: f.name, ChangedBy: changedBy, ChangedAt: now}
+		if f.name != "passphrase" {
+			change.OldValue, change.NewValue = f.old, f.new
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
@@ -0,0 +1,29 @@
+package repo
+
+import (
+	"dapp/schema/models"
+	"testing"
+)
+
+func TestUserChanges(t *testing.T) {
+	before := models.User{ID: 3, Username: "tom", Passphrase: "hash1", FirstName: "Tom", Email: "tom@example.edu", Role: models.Role_Secretary}
+
+	after := before
+	if changes := userChanges(before, after, "richard"); len(changes) != 0 {
+		t.Errorf("userChanges() of an unchanged user = %+v", changes)
+	}
+
+	after.Role, after.Passphrase = models.Role_Dean, "hash2"
+	changes := userChanges(before, after, "richard")
+	if len(changes) != 2 {
+		t.Fatalf("userChanges() = %+v, want the role and the passphrase", changes)
+	}
+	role, passphrase := changes[0], changes[1]
+	if role.Field != "role" || role.OldValue != models.Role_Secretary || role.NewValue != models.Role_Dean ||
+		role.UserID != 3 || role.Action != models.Change_Updated || role.ChangedBy != "richard" {
+		t.Errorf("role change = %+v", role)
+	}
+	if passphrase.Field != "passphrase" || passphrase.OldValue != "" || passphrase.NewValue != "" {
+		t.Errorf("passphrase change = %+v, the hashes must not be recorded", passphrase)
+	}
+}
@@ -0,0 +1,270 @@
+package repo
+
+import (
+	"bytes"
+	"dapp/lib"
+	"dapp/schema"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// region ======== SETUP =================================================================
+
+const (
+	walletFileExt      = ".id"     // same extension used by the plaintext gateway file system wallet
+	walletSealedPrefix = "sealed:" // sealed identities start with this prefix, plaintext ones are JSON documents
+)
+
+// encryptedWalletStore implements the gateway.WalletStore interface. The identities are stored in the wallet folder,
+// like the gateway file system wallet, but sealed with the key-encryption key (kek). The identity label is used as
+// additional data, so a sealed identity can't be moved to another label.
+type encryptedWalletStore struct {
+	path string
+	kek  []byte
+}
+
+// dbWalletStore implements the gateway.WalletStore interface over the users database, so all the API replicas share
+// the same identities. Every row is sealed with the key-encryption key, using the identity label as additional data.
+type dbWalletStore struct {
+	db  *gorm.DB
+	kek []byte
+}
+
+// endregion =============================================================================
+
+// NewEncryptedFileSystemWallet creates a wallet stored in the given folder, with the identities encrypted at rest
+//
+// - path [string] ~ Wallet folder
+//
+// - kek [[]byte] ~ AES-256 key-encryption key
+func NewEncryptedFileSystemWallet(path string, kek []byte) (*gateway.Wallet, error) {
+	if len(kek) != lib.KeySize {
+		return nil, fmt.Errorf("invalid wallet key-encryption key size %d, must be %d bytes", len(kek), lib.KeySize)
+	}
+	cleanPath := filepath.Clean(path)
+	if err := os.MkdirAll(cleanPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return gateway.NewWalletWithStore(&encryptedWalletStore{path: cleanPath, kek: kek}), nil
+}
+
+// NewDatabaseWallet creates a wallet stored in the wallet_identities table, with the identities encrypted per row
+//
+// - db [*gorm.DB] ~ Database connection, the table is migrated by RepoUser.InitDB
+//
+// - kek [[]byte] ~ AES-256 key-encryption key
+func NewDatabaseWallet(db *gorm.DB, kek []byte) (*gateway.Wallet, error) {
+	if len(kek) != lib.KeySize {
+		return nil, fmt.Errorf("invalid wallet key-encryption key size %d, must be %d bytes", len(kek), lib.KeySize)
+	}
+	return gateway.NewWalletWithStore(&dbWalletStore{db: db, kek: kek}), nil
+}
+
+// newWallet open the wallet of the dapp identities from the store selected in the configuration
+func newWallet(svcConf *utils.SvcConfig) (*gateway.Wallet, error) {
+	switch svcConf.WalletStore {
+	case schema.WalletStoreFS:
+		path := filepath.Join(svcConf.WalletFolder, schema.WalletStr)
+		if !svcConf.WalletEncrypted {
+			return gateway.NewFileSystemWallet(path)
+		}
+		kek, err := lib.LoadKey(schema.EnvWalletKEK, svcConf.WalletKEKFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewEncryptedFileSystemWallet(path, kek)
+	case schema.WalletStoreDB:
+		kek, err := lib.LoadKey(schema.EnvWalletKEK, svcConf.WalletKEKFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewDatabaseWallet(NewRepoUser(svcConf).DB, kek)
+	default:
+		return nil, fmt.Errorf("unknown wallet store %q, must be %q or %q", svcConf.WalletStore, schema.WalletStoreFS, schema.WalletStoreDB)
+	}
+}
+
+// CopyWallet puts every identity of the src wallet into the dst wallet, overwriting the ones with the same label.
+// Returns the labels of the copied identities
+func CopyWallet(src, dst *gateway.Wallet) ([]string, error) {
+	labels, err := src.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var copied []string
+	for _, label := range labels {
+		identity, err := src.Get(label)
+		if err != nil {
+			return copied, fmt.Errorf("failed to read the %s identity: %s", label, err)
+		}
+		if err := dst.Put(label, identity); err != nil {
+			return copied, fmt.Errorf("failed to write the %s identity: %s", label, err)
+		}
+		copied = append(copied, label)
+	}
+
+	return copied, nil
+}
+
+// EncryptWallet encrypts in place the plaintext identities of the wallet folder. The identities already encrypted are
+// left untouched, so it can be run more than once. Returns the labels of the encrypted identities
+func EncryptWallet(path string, kek []byte) ([]string, error) {
+	if len(kek) != lib.KeySize {
+		return nil, fmt.Errorf("invalid wallet key-encryption key size %d, must be %d bytes", len(kek), lib.KeySize)
+	}
+	es := &encryptedWalletStore{path: filepath.Clean(path), kek: kek}
+
+	labels, err := es.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var migrated []string
+	for _, label := range labels {
+		content, err := os.ReadFile(es.pathname(label))
+		if err != nil {
+			return migrated, err
+		}
+		if isSealedIdentity(content) {
+			continue
+		}
+		if err := es.Put(label, content); err != nil {
+			return migrated, fmt.Errorf("failed to encrypt the %s identity: %s", label, err)
+		}
+		migrated = append(migrated, label)
+	}
+
+	return migrated, nil
+}
+
+// region ======== METHODS ===============================================================
+
+// Put seal the identity and write it into the wallet folder
+func (s *encryptedWalletStore) Put(label string, content []byte) error {
+	sealed, err := lib.Seal(s.kek, content, []byte(label))
+	if err != nil {
+		return err
+	}
+	data := []byte(walletSealedPrefix + base64.StdEncoding.EncodeToString(sealed))
+
+	// writing to a temporary file first, so the identity is never left half written
+	tmp := s.pathname(label) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.pathname(label))
+}
+
+// Get read the identity from the wallet folder and open it
+func (s *encryptedWalletStore) Get(label string) ([]byte, error) {
+	content, err := os.ReadFile(s.pathname(label))
+	if err != nil {
+		return nil, err
+	}
+	if !isSealedIdentity(content) {
+		return nil, fmt.Errorf("the %s identity is not encrypted, run the wallet migration command", label)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(string(content), walletSealedPrefix))
+	if err != nil {
+		return nil, err
+	}
+	identity, err := lib.Open(s.kek, sealed, []byte(label))
+	if err != nil {
+		return nil, errors.New("failed to decrypt the " + label + " identity, check the wallet key-encryption key")
+	}
+	return identity, nil
+}
+
+// List all the labels in the wallet folder
+func (s *encryptedWalletStore) List() ([]string, error) {
+	files, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var labels []string
+	for _, file := range files {
+		name := file.Name()
+		if filepath.Ext(name) == walletFileExt {
+			labels = append(labels, strings.TrimSuffix(name, walletFileExt))
+		}
+	}
+	return labels, nil
+}
+
+// Exists tests the existence of an identity in the wallet folder
+func (s *encryptedWalletStore) Exists(label string) bool {
+	_, err := os.Stat(s.pathname(label))
+	return err == nil
+}
+
+// Remove an identity from the wallet folder. If the identity does not exist, this method does nothing.
+func (s *encryptedWalletStore) Remove(label string) error {
+	_ = os.Remove(s.pathname(label))
+	return nil
+}
+
+func (s *encryptedWalletStore) pathname(label string) string {
+	return filepath.Clean(filepath.Join(s.path, label) + walletFileExt)
+}
+
+// Put seal the identity and insert or replace its row
+func (s *dbWalletStore) Put(label string, content []byte) error {
+	sealed, err := lib.Seal(s.kek, content, []byte(label))
+	if err != nil {
+		return err
+	}
+	row := models.WalletIdentity{Label: label, Content: sealed}
+	return s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&row).Error
+}
+
+// Get read the identity row and open it
+func (s *dbWalletStore) Get(label string) ([]byte, error) {
+	var row models.WalletIdentity
+	if result := s.db.First(&row, "label = ?", label); result.Error != nil {
+		return nil, result.Error
+	}
+	identity, err := lib.Open(s.kek, row.Content, []byte(label))
+	if err != nil {
+		return nil, errors.New("failed to decrypt the " + label + " identity, check the wallet key-encryption key")
+	}
+	return identity, nil
+}
+
+// List all the labels in the wallet table
+func (s *dbWalletStore) List() ([]string, error) {
+	var labels []string
+	result := s.db.Model(&models.WalletIdentity{}).Order("label").Pluck("label", &labels)
+	return labels, result.Error
+}
+
+// Exists tests the existence of an identity in the wallet table
+func (s *dbWalletStore) Exists(label string) bool {
+	var count int64
+	s.db.Model(&models.WalletIdentity{}).Where("label = ?", label).Count(&count)
+	return count > 0
+}
+
+// Remove an identity from the wallet table. If the identity does not exist, this method does nothing.
+func (s *dbWalletStore) Remove(label string) error {
+	return s.db.Delete(&models.WalletIdentity{}, "label = ?", label).Error
+}
+
+func isSealedIdentity(content []byte) bool {
+	return bytes.HasPrefix(content, []byte(walletSealedPrefix))
+}
+
+// endregion =============================================================================
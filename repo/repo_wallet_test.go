@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"bytes"
+	"dapp/schema/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptWallet(t *testing.T) {
+	dir := t.TempDir()
+	kek := bytes.Repeat([]byte{7}, 32)
+	identity := []byte(`{"version":1,"mspId":"Org1MSP","type":"X.509"}`)
+	if err := os.WriteFile(filepath.Join(dir, "User1.id"), identity, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := EncryptWallet(dir, kek)
+	if err != nil || len(migrated) != 1 || migrated[0] != "User1" {
+		t.Fatalf("EncryptWallet() = %v, %v", migrated, err)
+	}
+	raw, _ := os.ReadFile(filepath.Join(dir, "User1.id"))
+	if !isSealedIdentity(raw) || bytes.Contains(raw, []byte("Org1MSP")) {
+		t.Fatalf("identity left in plaintext: %s", raw)
+	}
+
+	// running it again must not seal twice
+	if migrated, err = EncryptWallet(dir, kek); err != nil || len(migrated) != 0 {
+		t.Fatalf("second EncryptWallet() = %v, %v", migrated, err)
+	}
+
+	store := &encryptedWalletStore{path: dir, kek: kek}
+	got, err := store.Get("User1")
+	if err != nil || !bytes.Equal(got, identity) {
+		t.Fatalf("Get() = %s, %v", got, err)
+	}
+
+	// the label is bound to the sealed content
+	if err := os.Rename(filepath.Join(dir, "User1.id"), filepath.Join(dir, "Admin.id")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("Admin"); err == nil {
+		t.Fatal("Get() opened an identity moved to another label")
+	}
+}
+
+func TestDatabaseWallet(t *testing.T) {
+	db := newTestDB(t)
+	kek := bytes.Repeat([]byte{7}, 32)
+	store := &dbWalletStore{db: db, kek: kek}
+	identity := []byte(`{"version":1,"mspId":"Org1MSP","type":"X.509"}`)
+
+	if _, err := NewDatabaseWallet(db, kek[:16]); err == nil {
+		t.Fatal("NewDatabaseWallet() accepted a short key-encryption key")
+	}
+	if store.Exists("User1") {
+		t.Fatal("Exists() of a missing identity")
+	}
+	if err := store.Put("User1", identity); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("Admin", []byte(`{"version":1,"mspId":"Org1MSP"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var row models.WalletIdentity
+	db.First(&row, "label = ?", "User1")
+	if bytes.Contains(row.Content, []byte("Org1MSP")) {
+		t.Fatalf("identity stored in plaintext: %s", row.Content)
+	}
+	got, err := store.Get("User1")
+	if err != nil || !bytes.Equal(got, identity) {
+		t.Fatalf("Get() = %s, %v", got, err)
+	}
+	if labels, err := store.List(); err != nil || len(labels) != 2 || labels[0] != "Admin" || labels[1] != "User1" {
+		t.Fatalf("List() = %v, %v", labels, err)
+	}
+
+	// putting a label again replaces its identity
+	renewed := []byte(`{"version":1,"mspId":"Org1MSP","type":"X.509","renewed":true}`)
+	if err = store.Put("User1", renewed); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = store.Get("User1"); err != nil || !bytes.Equal(got, renewed) {
+		t.Fatalf("Get() after the replacement = %s, %v", got, err)
+	}
+
+	// another key-encryption key can't open the identities
+	if _, err = (&dbWalletStore{db: db, kek: bytes.Repeat([]byte{8}, 32)}).Get("User1"); err == nil {
+		t.Fatal("Get() opened an identity with another key-encryption key")
+	}
+	// the label is bound to the sealed content
+	db.Model(&models.WalletIdentity{}).Where("label = ?", "Admin").Update("content", row.Content)
+	if _, err = store.Get("Admin"); err == nil {
+		t.Fatal("Get() opened an identity moved to another label")
+	}
+
+	if err = store.Remove("User1"); err != nil || store.Exists("User1") {
+		t.Fatalf("Remove() = %v, the identity exists: %v", err, store.Exists("User1"))
+	}
+	if err = store.Remove("User1"); err != nil {
+		t.Fatalf("Remove() of a missing identity = %v", err)
+	}
+	if _, err = store.Get("User1"); err == nil {
+		t.Fatal("Get() of a removed identity")
+	}
+}
@@ -32,25 +32,68 @@ const (
 	ErrCryptProcMissing       = "err.crypt_material_processing.missing_files"
 	ErrParamURL               = "err.query_parameter"
 	ErrValidationField        = "err.validation_field"
+	ErrTooManyAttempts        = "err.too_many_login_attempts"
+	ErrOTPRequired            = "err.otp_required"
+	ErrMFARequired            = "err.mfa_required"
+	ErrForbidden              = "err.forbidden"
+	ErrInsufficientScope      = "err.insufficient_scope"
+	ErrInvalidScope           = "err.invalid_scope"
 )
 
 // endregion =============================================================================
 
 // region ======== ERROR DETAILS =========================================================
 const (
-	ErrCredsNotFound       = "The provided credentials don't seems to be valid"
-	ErrDetNotFound         = "resource not found"
-	ErrDetContractNotFound = "contract function not found"
-	ErrDetHttpResError     = "there is an error on http request"
-	ErrDetInvalidType      = "invalid interface type (type assertion)"
-	ErrDetInvalidCred      = "something was wrong with the provided user credentials"
-	ErrDetInvalidProvider  = "wrong or invalid provider"
-	ErrDetInvalidFile      = "the given file seems suspicious"
-	ErrDetInvalidField     = "the given field is invalid"
-	ErrDetWalletProc       = "failed to create wallet"
-	ErrEmailProc           = "failed to send email"
-	ErrDetIdentityCreate   = "failed to create the x509 identity"
-	ErrDetSDKInit          = "failed to initialize a new SDK instance"
+	ErrCredsNotFound        = "The provided credentials don't seems to be valid"
+	ErrDetNotFound          = "resource not found"
+	ErrDetContractNotFound  = "contract function not found"
+	ErrDetHttpResError      = "there is an error on http request"
+	ErrDetInvalidType       = "invalid interface type (type assertion)"
+	ErrDetInvalidCred       = "something was wrong with the provided user credentials"
+	ErrDetInvalidProvider   = "wrong or invalid provider"
+	ErrDetInvalidFile       = "the given file seems suspicious"
+	ErrDetInvalidField      = "the given field is invalid"
+	ErrDetWalletProc        = "failed to create wallet"
+	ErrEmailProc            = "failed to send email"
+	ErrDetIdentityCreate    = "failed to create the x509 identity"
+	ErrDetSDKInit           = "failed to initialize a new SDK instance"
+	ErrDetInvalidCert       = "the given certificate is not a PEM encoded x509 certificate"
+	ErrDetOfflineTarget     = "the signed proposal targets another channel or chaincode than the request"
+	ErrDetInvalidRefreshTk  = "the refresh token is invalid, expired or already used"
+	ErrDetLoginLocked       = "too many failed login attempts, try again later"
+	ErrDetDirectory         = "the user directory is unavailable"
+	ErrDetIdP               = "the identity provider is unavailable"
+	ErrDetOIDCState         = "the login state is invalid, expired or already used"
+	ErrDetOTPRequired       = "the code of the second factor is required"
+	ErrDetInvalidOTP        = "the code of the second factor is invalid or already used"
+	ErrDetMFARequired       = "log in with the second factor to use this resource, enrol it first if needed"
+	ErrDetTOTPEnrolled      = "the TOTP second factor is already enrolled"
+	ErrDetTOTPNotEnrolled   = "the TOTP second factor is not enrolled, or its enrolment is not pending"
+	ErrDetForbidden         = "the role of the user lacks the permission"
+	ErrDetInsufficientScope = "the scope of the access token doesn't cover the permission"
+	ErrDetInvalidScope      = "unknown scope requested"
+	ErrDetSessionNotFound   = "the user has no such active session"
+	ErrDetWrongPassword     = "the current password is wrong"
+	ErrDetInvalidResetTk    = "the password reset token is invalid, expired or already used"
+	ErrDetInvalidInvitation = "the invitation is invalid, expired or already used"
+	ErrDetUsernameTaken     = "the username is already taken"
+	ErrDetUserActive        = "the user already activated the account"
+	ErrDetUserNotDeleted    = "there is no deleted user with the id"
+	ErrDetDateRange         = "created_from is after created_to"
+	ErrDetImportSize        = "the import has no users, or more than the limit"
+	ErrDetImportDuplicate   = "the username is repeated in the import"
+	ErrDetUserDeleted       = "the user was deleted, restore it first"
+	ErrDetUserInvalidated   = "the user was invalidated, it is not updated by an import"
+	ErrDetUnknownRole       = "unknown role"
+	ErrDetPassphraseMissing = "a new user needs a passphrase, or to be invited"
+	ErrDetRoleNotFound      = "role not found"
+	ErrDetRoleTaken         = "the role already exists"
+	ErrDetBuiltInRole       = "the built-in roles can't be deleted"
+	ErrDetRoleInUse         = "the role is assigned to users"
+	ErrDetUnknownPermission = "unknown permission"
+	ErrDetRolePermissions   = "the invalid role has no permissions, and the sysadmin role keeps " + PermRolesWrite
+	ErrDetProviderMismatch  = "the user logs in with another authentication provider, an admin has to link it first"
+	ErrDetProviderPassword  = "the password of the user is managed by its authentication provider"
 )
 
 // endregion =============================================================================
@@ -61,15 +104,69 @@ const (
 	// ENV VARS
 
 	EnvConfigPath = "SERVER_CONFIG"
-	EnvJWTSignKey = "SERVER_JWT_SIGN_KEY"
+	EnvJWTSignKey = "SERVER_JWT_SIGN_KEY" // HS256 secret or PEM private key of the JWT signing key
+	EnvWalletKEK  = "SERVER_WALLET_KEK"   // base64 encoded key-encryption key of the wallet identities
 
 	// CRYPTO MATERIALS
 
-	WalletStr = "wallet"
+	WalletStr     = "wallet"
+	WalletStoreFS = "fs" // identities in the WalletFolder
+	WalletStoreDB = "db" // identities in the users database, shared by all the API replicas
+
+	// AUTH PROVIDERS
+
+	ProviderDapp = "dapp_provider" // users and passphrases in the users database
+	ProviderLDAP = "ldap_provider" // users bound against the LDAP / Active Directory
+	ProviderOIDC = "oidc_provider" // single sign-on through the OpenID Connect identity provider
+
+	// USER IMPORT, action of every row in the report
+
+	ImportCreated   = "created"
+	ImportInvited   = "invited" // created pending, with an emailed invitation
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+	ImportFailed    = "failed"
+)
+
+// endregion =============================================================================
+
+// region ======== PERMISSIONS ===========================================================
+
+// Permissions checked by the policy middleware on every protected endpoint, granted to the roles in sets
+const (
+	PermDappQuery       = "dapp.query"              // query the ledger
+	PermDappTransaction = "dapp.transaction"        // submit any chaincode transaction
+	PermCertCreate      = "certificates.create"     // create certificates
+	PermCertUpdate      = "certificates.update"     // update certificates
+	PermCertValidate    = "certificates.validate"   // sign the validation of certificates
+	PermCertInvalidate  = "certificates.invalidate" // invalidate certificates
+	PermCertDelete      = "certificates.delete"     // delete certificates
+	PermOfflineSign     = "certificates.offline"    // endorse and submit the transactions signed offline
+	PermIdentitiesRead  = "identities.read"         // expiry of the wallet identities
+	PermUsersRead       = "users.read"              // list and read the users
+	PermUsersWrite      = "users.write"             // create, update, delete, invalidate, unlock the users and revoke their tokens
+	PermRolesRead       = "roles.read"              // list the roles
+	PermRolesWrite      = "roles.write"             // create, update and delete the roles and their permissions
 )
 
 // endregion =============================================================================
 
+// region ======== SCOPES ================================================================
+
+// Scopes of the access tokens. The users can request a subset at login, e.g. for the token of an integration script,
+// and the token only keeps the permissions of the role that its scopes cover
+const (
+	ScopeCertificatesRead     = "certificates:read"     // query the ledger
+	ScopeCertificatesWrite    = "certificates:write"    // create, update, invalidate and delete the certificates
+	ScopeCertificatesValidate = "certificates:validate" // validate and invalidate the certificates
+	ScopeUsersAdmin           = "users:admin"           // manage the users, the roles and the wallet identities
+)
+
+// Scopes all the scopes, granted when the login doesn't request any
+var Scopes = []string{ScopeCertificatesRead, ScopeCertificatesWrite, ScopeCertificatesValidate, ScopeUsersAdmin}
+
+// endregion =============================================================================
+
 // region ========= CERTIFICATES =========================================================
 
 const (
@@ -80,8 +177,13 @@ const (
 	CreateAsset        = "CreateAsset"
 	UpdateAsset        = "UpdateAsset"
 	DeleteAsset        = "DeleteAsset"
-	QueryAssetsWithPag = "common:QueryAssetsWithPagination"
+	QueryAssetsWithPag = "QueryAssetsWithPagination"
 	GuestUser          = "GuestUser"
+
+	CommonContract = "common" // contract of the certificate chaincode holding the shared query functions
+
+	OfflineProposalKey    = "proposal"    // dto.TxDataRequest payload key of the proposal signed by the client
+	OfflineTransactionKey = "transaction" // dto.TxDataRequest payload key of the transaction signed by the client
 )
 
 // endregion =============================================================================
@@ -1,21 +1,30 @@
 package dto
 
+import "time"
+
 // UserCredIn Is a example declaring the validation for tech struct. It will be used when the
 // struct is in the endpoint parameters
 type UserCredIn struct {
 	Username string `example:"richard" validate:"required,ascii,gte=3,lte=60"`
 	Password string `example:"password1" validate:"required,ascii,gte=3,lte=20"`
+	Provider string `example:"dapp_provider" validate:"omitempty,oneof=dapp_provider ldap_provider"` // dapp_provider when empty
+	OTP      string `example:"287082" validate:"omitempty,ascii,lte=20"`                             // TOTP or recovery code, required once the second factor is enrolled
+	Scope    string `example:"certificates:read" validate:"omitempty,ascii,lte=200"`                 // space separated scopes of the access token, all of them when empty
 }
 
 type GrantIntentResponse struct {
 	Username string // if we use `json:"<source_name>"` we can map any source to a common particular / internal struct field as Identifier used here
 	Role     string
+	MFA      bool     // the user proved a second factor
+	Scope    []string // scopes of the access token, all of them when empty
 }
 
 // AccessTokenData using by this REST Api (HLF client node) to grant access to the resources
 type AccessTokenData struct {
-	Scope  []string
-	Claims InjectedParam
+	Scope      []string
+	Session    string // ID of the session, the login, the token was issued to
+	IssuedAtMs int64  // issue time in milliseconds, the iat claim has whole seconds. Compared with the revocations
+	Claims     InjectedParam
 }
 
 // Claims user claims
@@ -27,4 +36,102 @@ type Claims struct {
 type InjectedParam struct {
 	Username string
 	Role     string
+	MFA      bool // the token was issued after a second factor
+}
+
+// TokenPair short-lived access token and the single use refresh token to get a new pair
+type TokenPair struct {
+	AccessToken      string `json:"accessToken"`
+	TokenType        string `json:"tokenType" example:"Bearer"`
+	ExpiresIn        int    `json:"expiresIn" example:"900"` // seconds
+	RefreshToken     string `json:"refreshToken"`
+	RefreshExpiresIn int    `json:"refreshExpiresIn" example:"604800"` // seconds
+}
+
+// RefreshTokenIn refresh token to be exchanged for a new token pair
+type RefreshTokenIn struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+// PasswordChangeIn new password of the logged user, along with the current one
+type PasswordChangeIn struct {
+	CurrentPassword string `json:"currentPassword" example:"password1" validate:"required"`
+	NewPassword     string `json:"newPassword" example:"password2" validate:"required,ascii,gte=3,lte=20,nefield=CurrentPassword"`
+}
+
+// PasswordForgotIn email of the account whose password is forgotten
+type PasswordForgotIn struct {
+	Email string `json:"email" example:"richard@example.edu" validate:"required,email"`
+}
+
+// PasswordResetIn new password, with the reset token received by email
+type PasswordResetIn struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"newPassword" example:"password2" validate:"required,ascii,gte=3,lte=20"`
+}
+
+// OIDCCallbackIn authorization response of the OpenID Connect identity provider
+type OIDCCallbackIn struct {
+	Code         string `json:"code" validate:"required"`
+	State        string `json:"state" validate:"required"`
+	BrowserState string `json:"-"` // state of the login cookie, the login must have been started by the same browser
+}
+
+// OIDCSecondFactorIn TOTP code of a single sign-on user, whose callback was answered with err.otp_required
+type OIDCSecondFactorIn struct {
+	State        string `json:"state" validate:"required"`
+	OTP          string `json:"otp" example:"287082" validate:"required,ascii,lte=20"` // TOTP or recovery code
+	BrowserState string `json:"-"`                                                     // state of the login cookie
+}
+
+// TOTPEnrolment pending TOTP second factor, to be added to the authenticator app. The base32 secret can be typed in
+// the app, the otpauth URI is usually shown as a QR code
+type TOTPEnrolment struct {
+	Secret string `json:"secret" example:"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+	URI    string `json:"uri" example:"otpauth://totp/dapp:richard?algorithm=SHA1&digits=6&issuer=dapp&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+}
+
+// TOTPCodeIn code of the authenticator app
+type TOTPCodeIn struct {
+	Code string `json:"code" example:"287082" validate:"required,numeric,len=6"`
+}
+
+// RecoveryCodes single use codes replacing the TOTP code when the authenticator is lost. They are only shown once
+type RecoveryCodes struct {
+	Codes []string `json:"recoveryCodes" example:"7KQ2M-XW4TZ"`
+}
+
+// PermissionsResponse effective permissions of the logged user, the ones of the role covered by the token scope
+type PermissionsResponse struct {
+	Role        string   `json:"role" example:"rector"`
+	Scope       []string `json:"scope" example:"certificates:validate"`
+	Permissions []string `json:"permissions" example:"certificates.validate"`
+}
+
+// SessionResponse active session of the user, a login with its refreshed tokens
+type SessionResponse struct {
+	ID         string    `json:"id" example:"pZ0aH3cWq8..."`
+	IP         string    `json:"ip" example:"10.0.0.7"`
+	UserAgent  string    `json:"userAgent" example:"Mozilla/5.0 (X11; Linux x86_64)"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	Current    bool      `json:"current"` // the session of the request
+}
+
+// JWK public key of a JSON Web Key Set (RFC 7517), the members not used by the key type are omitted
+type JWK struct {
+	Kty string `json:"kty" example:"OKP"`
+	Kid string `json:"kid" example:"2024-01"`
+	Alg string `json:"alg" example:"EdDSA"`
+	Use string `json:"use" example:"sig"`
+	Crv string `json:"crv,omitempty" example:"Ed25519"` // OKP and EC
+	X   string `json:"x,omitempty"`                     // OKP and EC
+	Y   string `json:"y,omitempty"`                     // EC
+	N   string `json:"n,omitempty"`                     // RSA modulus
+	E   string `json:"e,omitempty"`                     // RSA exponent
+}
+
+// JWKSet public keys that verify the access tokens, by their kid
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
 }
@@ -78,6 +78,9 @@ type QueryParamChaincode struct {
 	Signer    string `query:"signer"`
 	Bookmark  string `query:"bookmark"`
 	PageLimit int    `query:"page_limit"`
+	DryRun    bool   `json:"-"` // only collect endorsements, nothing is sent to the orderer. Set by the handlers from the dryRun query parameter
+
+	Offline *OfflineIdentity `json:"-"` // when set, the proposal is only prepared, to be signed by this client identity
 }
 type GetRequestCC struct {
 	ID string `json:"id" mapstructure:"id"`
@@ -1,5 +1,10 @@
 package dto
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type StatusMsg struct {
 	OK bool `json:"ok"`
 }
@@ -58,6 +63,7 @@ type Transaction struct {
 	Function   string `json:"func" validate:"required"`
 	Payload    any    `json:"payload,omitempty" swaggertype:"object,string" example:"id:sampleID"`
 	StrongRead bool   `json:"strongRead" binding:"required,boolean" example:"false"`
+	AsAdmin    bool   `json:"-"` // administrative operation, signed with the dapp admin identity
 }
 
 type QueryResult struct {
@@ -80,5 +86,80 @@ type TxReceipt struct {
 	// Status          pb.TxValidationCode `json:"status"`
 	// SourcePeer      string              `json:"peer"`
 	ReplyCommon
-	ResponsePayload any `json:"responsePayload"`
+	Identity        string `json:"identity" example:"User1"` // wallet identity that signed the transaction
+	ResponsePayload any    `json:"responsePayload"`
+}
+
+// TxSimulation result of a dry run: the transaction was endorsed, but it was never sent to the orderer
+type TxSimulation struct {
+	ReplyCommon
+	TransactionID   string           `json:"transactionID"`
+	Identity        string           `json:"identity" example:"User1"` // wallet identity that signed the proposal
+	ChaincodeStatus int32            `json:"chaincodeStatus"`
+	ResponsePayload any              `json:"responsePayload"`
+	Endorsers       []string         `json:"endorsers"`
+	ReadWriteSet    []NsReadWriteSet `json:"readWriteSet"`
+}
+
+// NsReadWriteSet keys read, written and deleted by a simulated transaction in a chaincode namespace
+type NsReadWriteSet struct {
+	Namespace string   `json:"namespace"`
+	Reads     []string `json:"reads"`
+	Writes    []string `json:"writes"`
+	Deletes   []string `json:"deletes"`
+}
+
+// OfflineIdentity enrolled identity of a client that signs its own transactions (offline signing)
+type OfflineIdentity struct {
+	MspID       string `json:"mspId" validate:"required" example:"Org1MSP"`
+	Certificate string `json:"certificate" validate:"required" example:"-----BEGIN CERTIFICATE-----..."` // PEM encoded
+}
+
+// OfflinePrepareRequest certificate operation to be prepared for offline signing. Data holds the request body of the
+// same operation on the certificate endpoints
+type OfflinePrepareRequest struct {
+	Identity OfflineIdentity `json:"identity" validate:"required"`
+	Data     json.RawMessage `json:"data" validate:"required" swaggertype:"object"`
+}
+
+// OfflineProposal unsigned proposal, the client signs the proposal bytes and posts the signature to the endorse step
+type OfflineProposal struct {
+	ReplyCommon
+	TransactionID string `json:"transactionID"`
+	Proposal      []byte `json:"proposal" swaggertype:"string" format:"base64"`
+}
+
+// OfflineTransaction endorsed but unsigned transaction, the client signs the transaction bytes and posts the signature
+// to the submit step
+type OfflineTransaction struct {
+	ReplyCommon
+	TransactionID   string   `json:"transactionID"`
+	ResponsePayload any      `json:"responsePayload"`
+	Endorsers       []string `json:"endorsers"`
+	Transaction     []byte   `json:"transaction" swaggertype:"string" format:"base64"`
+}
+
+// OfflineReceipt transaction signed by the client and accepted by the orderer
+type OfflineReceipt struct {
+	ReplyCommon
+	TransactionID string `json:"transactionID"`
+	Orderer       string `json:"orderer"`
+}
+
+// IdentityExpiry expiry state of the X.509 certificate of a wallet identity
+type IdentityExpiry struct {
+	Label        string    `json:"label" example:"User1"`
+	MspID        string    `json:"mspId" example:"Org1MSP"`
+	Subject      string    `json:"subject" example:"CN=User1@org1.example.com,OU=client"`
+	NotAfter     time.Time `json:"notAfter"`
+	DaysToExpiry int       `json:"daysToExpiry" example:"120"` // negative when already expired
+	Expiring     bool      `json:"expiring"`                   // DaysToExpiry below the configured threshold
+	Error        string    `json:"error,omitempty"`            // the identity or its certificate could not be read
+}
+
+// IdentitiesExpiry result of the last expiry check of the wallet identities
+type IdentitiesExpiry struct {
+	CheckedAt     time.Time        `json:"checkedAt"`
+	ThresholdDays int              `json:"thresholdDays" example:"30"`
+	Identities    []IdentityExpiry `json:"identities"`
 }
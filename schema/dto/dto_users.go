@@ -1,5 +1,7 @@
 package dto
 
+import "time"
+
 // User struct
 type User struct {
 	ID         int    `json:"id"`
@@ -12,12 +14,28 @@ type User struct {
 }
 
 type UserResponse struct {
-	ID        int    `json:"id"`
-	Username  string `json:"username" validate:"required"`
-	FirstName string `json:"firstname" validate:"required"`
-	LastName  string `json:"lastname" validate:"required"`
-	Email     string `json:"email" validate:"required,email"`
-	Role      string `json:"rol" validate:"required"`
+	ID            int        `json:"id"`
+	Username      string     `json:"username" validate:"required"`
+	FirstName     string     `json:"firstname" validate:"required"`
+	LastName      string     `json:"lastname" validate:"required"`
+	Email         string     `json:"email" validate:"required,email"`
+	Role          string     `json:"rol" validate:"required"`
+	Pending       bool       `json:"pending"` // invited, until the user activates the account
+	EmailVerified bool       `json:"emailVerified"`
+	Provider      string     `json:"provider" example:"dapp_provider"` // authentication provider the user logs in with
+	CreatedAt     *time.Time `json:"createdAt,omitempty"`
+	DeletedAt     *time.Time `json:"deletedAt,omitempty"` // soft deleted, it can be restored
+}
+
+// UserFilter filters of the users list, from the query parameters. They are all optional and combined
+type UserFilter struct {
+	Role        string `json:"rol,omitempty" query:"rol" validate:"omitempty,lte=60"`
+	Name        string `json:"name,omitempty" query:"name" validate:"omitempty,lte=60"`    // substring of the username, the first or the last name
+	Email       string `json:"email,omitempty" query:"email" validate:"omitempty,lte=100"` // substring of the email
+	Status      string `json:"status,omitempty" query:"status" validate:"omitempty,oneof=active pending invalid"`
+	CreatedFrom string `json:"created_from,omitempty" query:"created_from" validate:"omitempty,datetime=2006-01-02"`
+	CreatedTo   string `json:"created_to,omitempty" query:"created_to" validate:"omitempty,datetime=2006-01-02"` // inclusive
+	Deleted     bool   `json:"deleted,string,omitempty" query:"deleted"`                                         // the deleted users instead
 }
 
 type UserData struct {
@@ -29,6 +47,40 @@ type UserData struct {
 	Role       string `json:"rol" validate:"required"`
 }
 
+// InvitationData user invited by email, it sets its own password activating the account
+type InvitationData struct {
+	Username  string `json:"username" validate:"required,ascii,gte=3,lte=60"`
+	FirstName string `json:"firstname" validate:"required"`
+	LastName  string `json:"lastname" validate:"required"`
+	Email     string `json:"email" validate:"required,email"`
+	Role      string `json:"rol" validate:"required"`
+}
+
+// ActivationIn password of the invited user, with the activation token received by email
+type ActivationIn struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" example:"password1" validate:"required,ascii,gte=3,lte=20"`
+}
+
+// ImportReport result of a users import, with a result per row
+type ImportReport struct {
+	DryRun    bool              `json:"dryRun"` // nothing was written, the actions are the ones an import would take
+	Created   int               `json:"created"`
+	Invited   int               `json:"invited"`
+	Updated   int               `json:"updated"`
+	Unchanged int               `json:"unchanged"`
+	Failed    int               `json:"failed"`
+	Rows      []ImportRowResult `json:"rows"`
+}
+
+// ImportRowResult result of a row of the import
+type ImportRowResult struct {
+	Row      int    `json:"row" example:"1"` // position of the user in the import, from 1
+	Username string `json:"username" example:"tom"`
+	Action   string `json:"action" example:"created"` // created | invited | updated | unchanged | failed
+	Error    string `json:"error,omitempty"`
+}
+
 type EditUserData struct {
 	Username   string `json:"username,omitempty"`
 	Passphrase string `json:"passphrase,omitempty"`
@@ -36,4 +88,40 @@ type EditUserData struct {
 	LastName   string `json:"lastname,omitempty"`
 	Email      string `json:"email,omitempty"`
 	Role       string `json:"rol,omitempty"`
+	Provider   string `json:"provider,omitempty" example:"ldap_provider"` // links the user to the provider, it logs in there from then on
+}
+
+// UserChangeResponse change of the history of the user. The values of the passphrase changes are not recorded
+type UserChangeResponse struct {
+	Action    string    `json:"action" example:"updated"`
+	Field     string    `json:"field,omitempty" example:"rol"`
+	OldValue  string    `json:"oldValue,omitempty" example:"secretary"`
+	NewValue  string    `json:"newValue,omitempty" example:"dean"`
+	ChangedBy string    `json:"changedBy" example:"richard"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// RoleResponse role with its effective permissions
+type RoleResponse struct {
+	Label       string   `json:"label" example:"vicedean"`
+	Name        string   `json:"name" example:"Vicedecano de Facultad"`
+	Description string   `json:"description"`
+	BuiltIn     bool     `json:"builtIn"` // relied on by the API, it can't be deleted
+	Permissions []string `json:"permissions" example:"certificates.validate"`
+}
+
+// RoleData new role, with its permissions
+type RoleData struct {
+	Label       string   `json:"label" validate:"required,lowercase,alphanum,gte=3,lte=30"`
+	Name        string   `json:"name" validate:"required,lte=100"`
+	Description string   `json:"description" validate:"required,lte=255"`
+	Permissions []string `json:"permissions" validate:"required"`
+}
+
+// EditRoleData changes of the role. The fields that are not passed are not modified, and an empty permission set
+// falls back to the configured or built-in permissions of the role
+type EditRoleData struct {
+	Name        string   `json:"name,omitempty" validate:"lte=100"`
+	Description string   `json:"description,omitempty" validate:"lte=255"`
+	Permissions []string `json:"permissions,omitempty"`
 }
@@ -1,5 +1,13 @@
 package dto
 
+import (
+	"net/url"
+	"strconv"
+)
+
+// MaxLimit rows of a page at most, a bigger limit is lowered to it
+const MaxLimit = 100
+
 type Pagination struct {
 	Limit      int         `json:"limit,string,omitempty" query:"limit"`
 	Page       int         `json:"page,string,omitempty" query:"page"`
@@ -7,6 +15,8 @@ type Pagination struct {
 	TotalRows  int64       `json:"total_rows"`
 	TotalPages int         `json:"total_pages,string"`
 	Rows       interface{} `json:"rows"`
+	Next       string      `json:"next,omitempty"` // link of the next page, with the same filters
+	Prev       string      `json:"prev,omitempty"` // link of the previous page, with the same filters
 }
 
 func (p *Pagination) GetOffset() int {
@@ -14,14 +24,17 @@ func (p *Pagination) GetOffset() int {
 }
 
 func (p *Pagination) GetLimit() int {
-	if p.Limit == 0 {
+	if p.Limit <= 0 {
 		p.Limit = 10
 	}
+	if p.Limit > MaxLimit {
+		p.Limit = MaxLimit
+	}
 	return p.Limit
 }
 
 func (p *Pagination) GetPage() int {
-	if p.Page == 0 {
+	if p.Page <= 0 {
 		p.Page = 1
 	}
 	return p.Page
@@ -33,3 +46,25 @@ func (p *Pagination) GetSort() string {
 	}
 	return p.Sort
 }
+
+// SetLinks set the next and previous page links from the URL of the request, keeping its query parameters. A page
+// past the last one links back to the last page
+func (p *Pagination) SetLinks(u url.URL) {
+	page := p.GetPage()
+	if page < p.TotalPages {
+		p.Next = pageLink(u, page+1)
+	}
+	if page > p.TotalPages+1 {
+		page = p.TotalPages + 1
+	}
+	if page > 1 {
+		p.Prev = pageLink(u, page-1)
+	}
+}
+
+// pageLink relative link of the page, the host of the request is not trusted
+func pageLink(u url.URL, page int) string {
+	query := u.Query()
+	query.Set("page", strconv.Itoa(page))
+	return (&url.URL{Path: u.Path, RawQuery: query.Encode()}).String()
+}
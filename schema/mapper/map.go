@@ -1,9 +1,9 @@
 package mapper
 
 import (
+	"dapp/schema"
 	"dapp/schema/dto"
 	"encoding/json"
-	"strings"
 )
 
 // TIP ref https://hellokoding.com/crud-restful-apis-with-go-modules-wire-gin-gorm-and-mysql/
@@ -14,9 +14,13 @@ import (
 // TODO: ground the rol idea, according to the DApp app logic
 func ToAccessTokenDataV(obj *dto.GrantIntentResponse) *dto.AccessTokenData {
 	// claims := dto.Claims{ Sub: obj.Identifier, Rol: "undefined" }
-	claims := dto.InjectedParam{Username: obj.Username, Role: obj.Role}
+	claims := dto.InjectedParam{Username: obj.Username, Role: obj.Role, MFA: obj.MFA}
 
-	return &dto.AccessTokenData{Scope: strings.Fields("dapp.fabric"), Claims: claims}
+	scope := obj.Scope
+	if len(scope) == 0 {
+		scope = schema.Scopes
+	}
+	return &dto.AccessTokenData{Scope: scope, Claims: claims}
 }
 
 func DecodePayload(payload []byte) interface{} {
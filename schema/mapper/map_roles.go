@@ -0,0 +1,50 @@
+package mapper
+
+import (
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"sync"
+)
+
+// roleNames display names of the roles by label. The access control policy loads them from the database on every
+// reload, so the names edited through another API replica are shown too
+var roleNames = struct {
+	sync.RWMutex
+	byLabel map[string]string
+}{byLabel: map[string]string{}}
+
+// SetRoleNames replace the display names of the roles, used by the mapped users
+func SetRoleNames(roles []models.Role) {
+	byLabel := make(map[string]string, len(roles))
+	for _, role := range roles {
+		byLabel[role.Label] = role.Name
+	}
+	roleNames.Lock()
+	roleNames.byLabel = byLabel
+	roleNames.Unlock()
+}
+
+// MapModelRole2DtoRoleResponse the role with its permissions
+func MapModelRole2DtoRoleResponse(role models.Role, permissions []string) dto.RoleResponse {
+	builtIn := false
+	for _, label := range models.BuiltInRoles {
+		builtIn = builtIn || label == role.Label
+	}
+	return dto.RoleResponse{
+		Label:       role.Label,
+		Name:        role.Name,
+		Description: role.Description,
+		BuiltIn:     builtIn,
+		Permissions: permissions,
+	}
+}
+
+// roleLabel2RoleName the display name of the role, or the label for a role missing in the database
+func roleLabel2RoleName(roleLabel string) string {
+	roleNames.RLock()
+	defer roleNames.RUnlock()
+	if name, ok := roleNames.byLabel[roleLabel]; ok {
+		return name
+	}
+	return roleLabel
+}
@@ -0,0 +1,18 @@
+package mapper
+
+import (
+	"dapp/schema/dto"
+	"dapp/schema/models"
+)
+
+// MapModelSession2DtoSessionResponse the session, flagged as current if it is the one of the request
+func MapModelSession2DtoSessionResponse(session models.Session, current string) dto.SessionResponse {
+	return dto.SessionResponse{
+		ID:         session.ID,
+		IP:         session.IP,
+		UserAgent:  session.UserAgent,
+		CreatedAt:  session.CreatedAt,
+		LastUsedAt: session.LastUsedAt,
+		Current:    session.ID == current,
+	}
+}
@@ -3,16 +3,22 @@ package mapper
 import (
 	"dapp/schema/dto"
 	"dapp/schema/models"
+	"time"
 )
 
 func MapModelUser2DtoUserResponse(user models.User) dto.UserResponse {
 	return dto.UserResponse{
-		ID:        user.ID,
-		Username:  user.Username,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		Email:     user.Email,
-		Role:      roleLabel2RoleName(user.Role),
+		ID:            user.ID,
+		Username:      user.Username,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		Email:         user.Email,
+		Role:          roleLabel2RoleName(user.Role),
+		Pending:       user.Pending,
+		EmailVerified: user.EmailVerified,
+		Provider:      user.Provider,
+		CreatedAt:     createdAt(user),
+		DeletedAt:     deletedAt(user),
 	}
 }
 
@@ -74,24 +80,43 @@ func MapUserData2UserResponse(userID int, user dto.UserData) dto.UserResponse {
 	}
 }
 
-func roleLabel2RoleName(roleLabel string) string {
-	if roleLabel == models.Role_Invalid {
-		return "Usuario Invalidado"
-	}
-	if roleLabel == models.Role_SystemAdmin {
-		return "Administrador de Sistemas"
-	}
-	if roleLabel == models.Role_CertificateAdmin {
-		return "Administrador de Certificados"
+// MapInvitationData2ModelUser the pending user of the invitation, with the passphrase that nobody knows
+func MapInvitationData2ModelUser(user dto.InvitationData, passphrase string) models.User {
+	return models.User{
+		Username:   user.Username,
+		Passphrase: passphrase,
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		Email:      user.Email,
+		Role:       user.Role,
+		Pending:    true,
 	}
-	if roleLabel == models.Role_Secretary {
-		return "Secretario General"
+}
+
+// MapModelUserChange2DtoUserChangeResponse the change of the history of the user
+func MapModelUserChange2DtoUserChangeResponse(change models.UserChange) dto.UserChangeResponse {
+	return dto.UserChangeResponse{
+		Action:    change.Action,
+		Field:     change.Field,
+		OldValue:  change.OldValue,
+		NewValue:  change.NewValue,
+		ChangedBy: change.ChangedBy,
+		ChangedAt: change.ChangedAt,
 	}
-	if roleLabel == models.Role_Dean {
-		return "Decano de Facultad"
+}
+
+// createdAt the creation time of the user, nil if it wasn't recorded
+func createdAt(user models.User) *time.Time {
+	if user.CreatedAt.IsZero() {
+		return nil
 	}
-	if roleLabel == models.Role_Rector {
-		return "Rector de Universidad"
+	return &user.CreatedAt
+}
+
+// deletedAt the time of the soft delete of the user, nil if it wasn't deleted
+func deletedAt(user models.User) *time.Time {
+	if !user.DeletedAt.Valid {
+		return nil
 	}
-	return "Rol no encontrado"
+	return &user.DeletedAt.Time
 }
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// LoginAttempt failed login attempts of an account ("user:<username>") or of a client IP ("ip:<address>"). The next
+// attempt is refused until LockedUntil
+type LoginAttempt struct {
+	Key           string `gorm:"primaryKey"`
+	Failures      int    `gorm:"not null"`
+	LastFailureAt time.Time
+	LockedUntil   time.Time `gorm:"index"`
+}
+
+// OIDCLogin pending OpenID Connect login, between the redirection to the identity provider and its callback. The
+// State is single use and the CodeVerifier (PKCE) never leaves the API. Once the identity provider authenticated a
+// user with the TOTP second factor enrolled, the login waits under the same State for its code, with the Username set
+type OIDCLogin struct {
+	State        string    `gorm:"primaryKey"`
+	Nonce        string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"`
+	Scope        string    // space separated scopes requested for the access token, all of them when empty
+	Username     string    // user authenticated by the identity provider, waiting for the second factor
+	Role         string    // role of the user waiting for the second factor
+	MFA          bool      // the identity provider already reported a second factor
+	ExpiresAt    time.Time `gorm:"index"`
+}
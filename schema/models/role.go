@@ -7,6 +7,20 @@ type Role struct {
 	Description string `json:"description" validate:"required"`
 }
 
+// RolePermission permission granted to a role. A role with rows in this table gets exactly these permissions, instead
+// of the configured or built-in ones
+type RolePermission struct {
+	Role       string `json:"role" gorm:"primaryKey"`
+	Permission string `json:"permission" gorm:"primaryKey"`
+}
+
+// NoPermission the permission of the only row of a role whose stored permissions are none. Without rows the role would
+// fall back to its configured or built-in permissions
+const NoPermission = ""
+
+// BuiltInRoles the roles the API relies on, they can be edited but not deleted
+var BuiltInRoles = []string{Role_Invalid, Role_SystemAdmin, Role_CertificateAdmin, Role_Secretary, Role_Dean, Role_Rector}
+
 const (
 	Role_Invalid          = "invalid"
 	Role_SystemAdmin      = "sysadmin"
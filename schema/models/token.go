@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// RefreshToken single use refresh token. Only the SHA256 of the token is stored. The tokens issued by refreshing
+// share the FamilyID of the token issued on login, so the whole family is revoked when a used token is presented again
+type RefreshToken struct {
+	ID        int    `gorm:"primaryKey"`
+	TokenHash string `gorm:"uniqueIndex;not null"`
+	FamilyID  string `gorm:"index;not null"`
+	Username  string `gorm:"index;not null"`
+	MFA       bool   // the family was issued after a second factor, the refreshed access tokens keep it
+	Scope     string // space separated scopes of the family, the refreshed access tokens keep them
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// BlockedToken access token revoked before its expiration, e.g. on logout. Key is the SHA256 of the token ID
+type BlockedToken struct {
+	Key    string    `gorm:"primaryKey"`
+	Expiry time.Time `gorm:"index"`
+}
+
+// TokenRevocation all the access tokens of the user issued up to RevokedAt are revoked
+type TokenRevocation struct {
+	Username  string `gorm:"primaryKey"`
+	RevokedAt time.Time
+}
+
+// Session login of a user, with the access and refresh tokens issued on the login and by refreshing them. ID is the
+// FamilyID of its refresh tokens, and the access tokens carry it so the revoked sessions are refused right away
+type Session struct {
+	ID         string `gorm:"primaryKey"`
+	Username   string `gorm:"index;not null"`
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time // expiry of its last refresh token
+	RevokedAt  *time.Time
+}
+
+// PasswordReset single use token, sent by email, to set a new password without the current one. Only the SHA256 of
+// the token is stored
+type PasswordReset struct {
+	ID        int    `gorm:"primaryKey"`
+	TokenHash string `gorm:"uniqueIndex;not null"`
+	Username  string `gorm:"index;not null"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// Invitation single use token, sent by email, activating the account of an invited user. Only the SHA256 of the token
+// is stored
+type Invitation struct {
+	ID        int    `gorm:"primaryKey"`
+	TokenHash string `gorm:"uniqueIndex;not null"`
+	Username  string `gorm:"index;not null"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
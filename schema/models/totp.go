@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// UserTOTP TOTP second factor of the user. It stays pending, and the logins don't ask for it, until the user
+// confirms the enrolment with a first code
+type UserTOTP struct {
+	Username  string `gorm:"primaryKey"`
+	Secret    string `gorm:"not null"` // base32 secret sealed with the wallet key-encryption key, base64 encoded
+	Enabled   bool
+	LastStep  int64 // time step of the last accepted code, so a code can't be used twice
+	CreatedAt time.Time
+}
+
+// RecoveryCode single use code replacing the TOTP code when the authenticator is lost. Only its SHA256 is stored
+type RecoveryCode struct {
+	ID       int    `gorm:"primaryKey"`
+	Username string `gorm:"index;not null"`
+	CodeHash string `gorm:"not null"`
+	UsedAt   *time.Time
+}
@@ -1,11 +1,31 @@
 package models
 
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
 type User struct {
-	ID         int    `json:"id" gorm:"primaryKey"`
-	Username   string `json:"username" gorm:"uniqueIndex" validate:"required"`
-	Passphrase string `json:"passphrase" validate:"required"`
-	FirstName  string `json:"firstname" validate:"required"`
-	LastName   string `json:"lastname" validate:"required"`
-	Email      string `json:"email" validate:"required,email"`
-	Role        string `json:"rol_id" validate:"required"`
+	ID            int            `json:"id" gorm:"primaryKey"`
+	Username      string         `json:"username" gorm:"uniqueIndex" validate:"required"`
+	Passphrase    string         `json:"passphrase" validate:"required"`
+	FirstName     string         `json:"firstname" validate:"required"`
+	LastName      string         `json:"lastname" validate:"required"`
+	Email         string         `json:"email" validate:"required,email"`
+	Role          string         `json:"rol_id" validate:"required"`
+	Pending       bool           `json:"pending"`                                        // invited, it can't log in until it activates the account
+	EmailVerified bool           `json:"email_verified"`                                 // the user proved the email, e.g. activating the account
+	Provider      string         `json:"provider" gorm:"not null;default:dapp_provider"` // authentication provider the user logs in with
+	Issuer        string         `json:"-" gorm:"index:idx_users_oidc"`                  // OIDC issuer, with the subject it identifies the single sign-on user
+	Subject       string         `json:"-" gorm:"index:idx_users_oidc"`                  // OIDC subject, stable unlike the username claim
+	CreatedAt     time.Time      `json:"created_at" gorm:"index"`                        // null for the users created before it was recorded
+	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`                                 // soft delete, the certificates keep referencing the username
 }
+
+// Status of the users, filters of the users list
+const (
+	Status_Active  = "active"  // it can log in
+	Status_Pending = "pending" // invited, until it activates the account
+	Status_Invalid = "invalid" // invalidated, it has the invalid role
+)
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Actions recorded in the change history of the users
+const (
+	Change_Created   = "created"
+	Change_Updated   = "updated" // one change per updated field
+	Change_Deleted   = "deleted"
+	Change_Restored  = "restored"
+	Change_Activated = "activated" // the invited user set its password
+)
+
+// UserChange change history of the users, who made every change and when. The passphrase changes are recorded
+// without their values
+type UserChange struct {
+	ID        int    `gorm:"primaryKey"`
+	UserID    int    `gorm:"index;not null"`
+	Action    string `gorm:"not null"`
+	Field     string // updated field, empty for the other actions
+	OldValue  string
+	NewValue  string
+	ChangedBy string // username of the author, or the auth provider for the changes made by the directory on login
+	ChangedAt time.Time
+}
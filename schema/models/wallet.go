@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// WalletIdentity is a Fabric identity of the dapp wallet kept in the database. The content is sealed with the wallet
+// key-encryption key, using the label as additional data
+type WalletIdentity struct {
+	Label     string `gorm:"primaryKey"`
+	Content   []byte `gorm:"not null"`
+	UpdatedAt time.Time
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/kataras/iris/v12"
+)
+
+// region ======== LDAP AUTHENTICATION PROVIDER ==========================================
+
+// ldapTimeout of the connection and of every request to the directory
+const ldapTimeout = 10 * time.Second
+
+// errLDAPInvalidCreds the user is unknown, ambiguous, has no role or the password is wrong. They all get the same
+// response, so the directory contents can't be probed
+var errLDAPInvalidCreds = errors.New("invalid directory credentials")
+
+// ProviderLDAP authenticates the users binding them against the LDAP / Active Directory. The directory groups of the
+// user are mapped to its role, and the user is created or updated in the users database on every login
+type ProviderLDAP struct {
+	repo           *repo.RepoUser
+	conf           *utils.SvcConfig
+	passwordParams lib.PasswordParams
+	tlsConfig      *tls.Config
+}
+
+// directoryUser user found in the directory, with the role of its groups
+type directoryUser struct {
+	DN        string
+	FirstName string
+	LastName  string
+	Email     string
+	Role      string
+}
+
+// NewProviderLDAP creates the LDAP provider from the LDAP* configuration
+func NewProviderLDAP(repoUser *repo.RepoUser, svcConf *utils.SvcConfig) *ProviderLDAP {
+	serverName := ""
+	if u, err := url.Parse(svcConf.LDAPUrl); err == nil {
+		serverName = u.Hostname()
+	}
+	return &ProviderLDAP{
+		repo:           repoUser,
+		conf:           svcConf,
+		passwordParams: svcConf.PasswordParams(),
+		tlsConfig:      &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12},
+	}
+}
+
+// GrantIntent bind the user credentials against the directory
+func (p *ProviderLDAP) GrantIntent(uCred *dto.UserCredIn, options interface{}) (*dto.GrantIntentResponse, *dto.Problem) {
+	dirUser, err := p.authenticate(uCred.Username, uCred.Password)
+	if errors.Is(err, errLDAPInvalidCreds) {
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrCredsNotFound)
+	}
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusBadGateway, schema.ErrBadGateway, schema.ErrDetDirectory+": "+err.Error())
+	}
+
+	// the passphrase of a directory user is random, it can only log in through the directory
+	passphrase, err := randomPassphrase(p.passwordParams)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
+	user, err := p.repo.UpsertDirectoryUser(models.User{
+		Username:   uCred.Username,
+		Passphrase: passphrase,
+		FirstName:  dirUser.FirstName,
+		LastName:   dirUser.LastName,
+		Email:      dirUser.Email,
+		Role:       dirUser.Role,
+		Provider:   schema.ProviderLDAP,
+	}, schema.ProviderLDAP)
+	if errors.Is(err, repo.ErrUserDeleted) {
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrCredsNotFound)
+	}
+	if errors.Is(err, repo.ErrProviderMismatch) {
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrDetProviderMismatch)
+	}
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if user.Role == models.Role_Invalid {
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrCredsNotFound)
+	}
+
+	return &dto.GrantIntentResponse{Username: user.Username, Role: user.Role}, nil
+}
+
+// authenticate search the user with the service account, then bind as the user to check the password
+func (p *ProviderLDAP) authenticate(username, password string) (*directoryUser, error) {
+	if username == "" || password == "" { // an empty password is an unauthenticated bind, it always succeeds
+		return nil, errLDAPInvalidCreds
+	}
+
+	conn, err := ldap.DialURL(p.conf.LDAPUrl, ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}), ldap.DialWithTLSConfig(p.tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetTimeout(ldapTimeout)
+
+	if p.conf.LDAPStartTLS {
+		if err = conn.StartTLS(p.tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+	if p.conf.LDAPBindDN != "" {
+		if err = conn.Bind(p.conf.LDAPBindDN, p.conf.LDAPBindPassword); err != nil {
+			return nil, fmt.Errorf("service account bind: %s", err)
+		}
+	}
+
+	search := ldap.NewSearchRequest(p.conf.LDAPBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false,
+		fmt.Sprintf(p.conf.LDAPUserFilter, ldap.EscapeFilter(username)),
+		[]string{"givenName", "sn", "mail", p.conf.LDAPGroupAttr}, nil)
+	result, err := conn.Search(search)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+	if result == nil || len(result.Entries) != 1 { // unknown or ambiguous username
+		return nil, errLDAPInvalidCreds
+	}
+	entry := result.Entries[0]
+
+	if err = conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errLDAPInvalidCreds
+		}
+		return nil, err
+	}
+
+	role := p.groupsRole(entry.GetAttributeValues(p.conf.LDAPGroupAttr))
+	if role == "" { // the user isn't in any group allowed to use the dapp
+		return nil, errLDAPInvalidCreds
+	}
+
+	return &directoryUser{
+		DN:        entry.DN,
+		FirstName: entry.GetAttributeValue("givenName"),
+		LastName:  entry.GetAttributeValue("sn"),
+		Email:     entry.GetAttributeValue("mail"),
+		Role:      role,
+	}, nil
+}
+
+// groupsRole the role of the first configured group the user belongs to. The groups match by DN, or by CN
+func (p *ProviderLDAP) groupsRole(groups []string) string {
+	for _, mapping := range p.conf.LDAPGroupRoles {
+		for _, group := range groups {
+			if strings.EqualFold(group, mapping.Group) || strings.EqualFold(groupCN(group), mapping.Group) {
+				return mapping.Role
+			}
+		}
+	}
+	return ""
+}
+
+// groupCN the common name of a group DN, e.g. "rectors" for "cn=rectors,ou=groups,dc=example,dc=edu"
+func groupCN(group string) string {
+	dn, err := ldap.ParseDN(group)
+	if err != nil || len(dn.RDNs) == 0 {
+		return ""
+	}
+	for _, attr := range dn.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, "cn") {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func randomPassphrase(params lib.PasswordParams) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return lib.HashPassword(base64.RawURLEncoding.EncodeToString(secret), params)
+}
+
+// endregion =============================================================================
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// region ======== LDAP STAND-IN =========================================================
+
+// ldapStandIn in-process directory speaking the subset of LDAPv3 used by ProviderLDAP: simple bind, search and unbind
+type ldapStandIn struct {
+	passwords map[string]string // DN -> password
+	entries   []ldapEntry
+}
+
+type ldapEntry struct {
+	uid   string
+	dn    string
+	attrs map[string][]string
+}
+
+func (d *ldapStandIn) serve(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.handle(conn)
+		}
+	}()
+	return "ldap://" + listener.Addr().String()
+}
+
+func (d *ldapStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+	bound := ""
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if expected, ok := d.passwords[dn]; ok && password != "" && password == expected {
+				code, bound = ldap.LDAPResultSuccess, dn
+			}
+			_, _ = conn.Write(ldapResult(id, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			if bound == "" {
+				_, _ = conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights).Bytes())
+				continue
+			}
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			for _, entry := range d.entries {
+				if strings.Contains(filter, "(uid="+entry.uid+")") {
+					_, _ = conn.Write(ldapSearchEntry(id, entry).Bytes())
+				}
+			}
+			_, _ = conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		default: // unbind
+			return
+		}
+	}
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	message := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	message.AppendChild(op)
+	return message
+}
+
+func ldapResult(id int64, tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return ldapMessage(id, op)
+}
+
+func ldapSearchEntry(id int64, entry ldapEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "objectName"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, values := range entry.attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, value := range values {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+		}
+		attr.AppendChild(vals)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return ldapMessage(id, op)
+}
+
+// endregion =============================================================================
+
+func TestProviderLDAPAuthenticate(t *testing.T) {
+	const rectorDN = "uid=rsargon,ou=people,dc=uni,dc=edu"
+	directory := &ldapStandIn{
+		passwords: map[string]string{
+			"cn=dapp,dc=uni,dc=edu":             "service-secret",
+			rectorDN:                            "rector-secret",
+			"uid=guest,ou=people,dc=uni,dc=edu": "guest-secret",
+		},
+		entries: []ldapEntry{
+			{uid: "rsargon", dn: rectorDN, attrs: map[string][]string{
+				"givenName": {"Richard"}, "sn": {"Sargon"}, "mail": {"richard.sargon@uni.edu"},
+				"memberOf": {"cn=staff,ou=groups,dc=uni,dc=edu", "cn=Rectors,ou=groups,dc=uni,dc=edu"},
+			}},
+			{uid: "guest", dn: "uid=guest,ou=people,dc=uni,dc=edu", attrs: map[string][]string{
+				"memberOf": {"cn=students,ou=groups,dc=uni,dc=edu"},
+			}},
+		},
+	}
+
+	conf := &utils.SvcConfig{}
+	conf.LDAPUrl = directory.serve(t)
+	conf.LDAPBindDN = "cn=dapp,dc=uni,dc=edu"
+	conf.LDAPBindPassword = "service-secret"
+	conf.LDAPBaseDN = "dc=uni,dc=edu"
+	conf.LDAPUserFilter = "(&(objectClass=person)(uid=%s))"
+	conf.LDAPGroupAttr = "memberOf"
+	conf.LDAPGroupRoles = []utils.LDAPGroupRole{
+		{Group: "cn=it,ou=groups,dc=uni,dc=edu", Role: models.Role_SystemAdmin},
+		{Group: "rectors", Role: models.Role_Rector},
+	}
+	provider := NewProviderLDAP(nil, conf)
+
+	user, err := provider.authenticate("rsargon", "rector-secret")
+	if err != nil {
+		t.Fatalf("authenticate() error: %v", err)
+	}
+	if user.DN != rectorDN || user.Role != models.Role_Rector || user.FirstName != "Richard" || user.Email != "richard.sargon@uni.edu" {
+		t.Errorf("authenticate() = %+v", user)
+	}
+
+	invalid := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"wrong password", "rsargon", "guest-secret"},
+		{"empty password", "rsargon", ""},
+		{"unknown user", "nobody", "rector-secret"},
+		{"filter injection", "*", "rector-secret"},
+		{"no mapped group", "guest", "guest-secret"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := provider.authenticate(tt.username, tt.password); !errors.Is(err, errLDAPInvalidCreds) {
+				t.Errorf("authenticate() error = %v, want %v", err, errLDAPInvalidCreds)
+			}
+		})
+	}
+
+	// a directory failure is not a wrong password, it must not count as a failed login
+	conf.LDAPBindPassword = "wrong"
+	if _, err := provider.authenticate("rsargon", "rector-secret"); err == nil || errors.Is(err, errLDAPInvalidCreds) {
+		t.Errorf("authenticate() with a wrong service account = %v", err)
+	}
+}
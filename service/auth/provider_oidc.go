@@ -0,0 +1,469 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/jwt"
+)
+
+// region ======== OIDC AUTHENTICATION PROVIDER ==========================================
+
+// OIDCLoginMaxAge time the user has to log in at the identity provider
+const OIDCLoginMaxAge = 10 * time.Minute
+
+// errOIDCInvalidGrant the identity provider refused the authorization code, or its ID token is not valid for us
+var errOIDCInvalidGrant = errors.New("invalid OpenID Connect grant")
+
+// oidcRepo storage used by the OIDC provider, implemented by repo.RepoUser
+type oidcRepo interface {
+	AddOIDCLogin(login models.OIDCLogin) error
+	TakeOIDCLogin(state string) (models.OIDCLogin, bool, error)
+	UpsertDirectoryUser(user models.User, changedBy string) (models.User, error)
+}
+
+// ProviderOIDC single sign-on through the OpenID Connect identity provider, using the authorization code flow with
+// PKCE. The ID token claims are mapped to the role, and the user is created or updated on every login
+type ProviderOIDC struct {
+	repo           oidcRepo
+	conf           *utils.SvcConfig
+	passwordParams lib.PasswordParams
+	client         *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery // identity provider metadata, fetched on the first login
+	keys      jwt.Keys       // identity provider signing keys, fetched again when a token has an unknown kid
+}
+
+// oidcDiscovery the OpenID Provider Metadata used by the provider
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// NewProviderOIDC creates the OIDC provider from the OIDC* configuration
+func NewProviderOIDC(repoUser *repo.RepoUser, svcConf *utils.SvcConfig) *ProviderOIDC {
+	return newProviderOIDC(repoUser, svcConf)
+}
+
+func newProviderOIDC(repo oidcRepo, svcConf *utils.SvcConfig) *ProviderOIDC {
+	return &ProviderOIDC{
+		repo:           repo,
+		conf:           svcConf,
+		passwordParams: svcConf.PasswordParams(),
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// AuthorizationURL start a login: the user agent is redirected to the returned identity provider URL, which sends
+// it back to the callback with the authorization code. The returned state has to be kept by the user agent, e.g. in a
+// cookie, and passed to the callback as the browser state. The access token of the login gets the scope
+func (p *ProviderOIDC) AuthorizationURL(scope []string) (string, string, *dto.Problem) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", "", lib.NewProblem(iris.StatusBadGateway, schema.ErrBadGateway, schema.ErrDetIdP+": "+err.Error())
+	}
+
+	login := models.OIDCLogin{Scope: strings.Join(scope, " "), ExpiresAt: time.Now().Add(OIDCLoginMaxAge)}
+	for _, value := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		if *value, err = randomToken(); err != nil {
+			return "", "", lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+		}
+	}
+	if err = p.repo.AddOIDCLogin(login); err != nil {
+		return "", "", lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", lib.NewProblem(iris.StatusBadGateway, schema.ErrBadGateway, schema.ErrDetIdP+": "+err.Error())
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.conf.OIDCClientID)
+	query.Set("redirect_uri", p.conf.OIDCRedirectURL)
+	query.Set("scope", strings.Join(p.conf.OIDCScopes, " "))
+	query.Set("state", login.State)
+	query.Set("nonce", login.Nonce)
+	query.Set("code_challenge", pkceChallenge(login.CodeVerifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), login.State, nil
+}
+
+// GrantIntent complete the login with the authorization response of the identity provider, options must be a
+// *dto.OIDCCallbackIn. The user credentials are not used, the user logs in at the identity provider
+func (p *ProviderOIDC) GrantIntent(_ *dto.UserCredIn, options interface{}) (*dto.GrantIntentResponse, *dto.Problem) {
+	callback, ok := options.(*dto.OIDCCallbackIn)
+	if !ok {
+		return nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrWrongAuthProvider, schema.ErrDetInvalidProvider)
+	}
+
+	login, problem := p.takeLogin(callback.State, callback.BrowserState)
+	if problem != nil {
+		return nil, problem
+	}
+	if login.Username != "" { // the login waits for the second factor, its authorization code was already used
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrDetOIDCState)
+	}
+
+	claims, err := p.exchange(callback.Code, login)
+	if errors.Is(err, errOIDCInvalidGrant) {
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, err.Error())
+	}
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusBadGateway, schema.ErrBadGateway, schema.ErrDetIdP+": "+err.Error())
+	}
+
+	username, _ := claimValue(claims, p.conf.OIDCUsernameClaim).(string)
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	role := p.claimsRole(claims)
+	if username == "" || subject == "" || role == "" { // the user isn't allowed to use the dapp
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrCredsNotFound)
+	}
+
+	// the passphrase of a single sign-on user is random, it can only log in through the identity provider
+	passphrase, err := randomPassphrase(p.passwordParams)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
+	firstName, _ := claims["given_name"].(string)
+	lastName, _ := claims["family_name"].(string)
+	email, _ := claims["email"].(string)
+	user, err := p.repo.UpsertDirectoryUser(models.User{
+		Username:   username,
+		Passphrase: passphrase,
+		FirstName:  firstName,
+		LastName:   lastName,
+		Email:      email,
+		Role:       role,
+		Provider:   schema.ProviderOIDC,
+		Issuer:     issuer,
+		Subject:    subject,
+	}, schema.ProviderOIDC)
+	if errors.Is(err, repo.ErrUserDeleted) {
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrCredsNotFound)
+	}
+	if errors.Is(err, repo.ErrProviderMismatch) {
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrDetProviderMismatch)
+	}
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if user.Role == models.Role_Invalid {
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrCredsNotFound)
+	}
+
+	// the identity provider reports a login with a second factor in the amr claim (RFC 8176)
+	mfa := false
+	if methods, ok := claims["amr"].([]interface{}); ok {
+		for _, method := range methods {
+			mfa = mfa || method == "mfa"
+		}
+	}
+
+	return &dto.GrantIntentResponse{Username: user.Username, Role: user.Role, MFA: mfa, Scope: strings.Fields(login.Scope)}, nil
+}
+
+// AwaitSecondFactor keep the login of the user authenticated by the identity provider under its state, until the
+// user gives the code of its TOTP second factor
+func (p *ProviderOIDC) AwaitSecondFactor(state string, grant *dto.GrantIntentResponse) error {
+	return p.repo.AddOIDCLogin(models.OIDCLogin{
+		State:     state,
+		Scope:     strings.Join(grant.Scope, " "),
+		Username:  grant.Username,
+		Role:      grant.Role,
+		MFA:       grant.MFA,
+		ExpiresAt: time.Now().Add(OIDCLoginMaxAge),
+	})
+}
+
+// TakeSecondFactorLogin take the login waiting for the second factor, started by the same browser. The login is
+// removed, it has to be kept again with AwaitSecondFactor if the code is wrong
+func (p *ProviderOIDC) TakeSecondFactorLogin(state, browserState string) (*dto.GrantIntentResponse, *dto.Problem) {
+	login, problem := p.takeLogin(state, browserState)
+	if problem != nil {
+		return nil, problem
+	}
+	if login.Username == "" { // the identity provider has not authenticated the user yet
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrDetOIDCState)
+	}
+	return &dto.GrantIntentResponse{Username: login.Username, Role: login.Role, MFA: login.MFA, Scope: strings.Fields(login.Scope)}, nil
+}
+
+// takeLogin take the unexpired login of the state, which must be the state of the browser
+func (p *ProviderOIDC) takeLogin(state, browserState string) (models.OIDCLogin, *dto.Problem) {
+	// the state bound to the browser stops a login CSRF: an attacker can't make the victim complete the attacker login
+	if subtle.ConstantTimeCompare([]byte(browserState), []byte(state)) != 1 {
+		return models.OIDCLogin{}, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrDetOIDCState)
+	}
+	login, found, err := p.repo.TakeOIDCLogin(state)
+	if err != nil {
+		return models.OIDCLogin{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if !found || time.Now().After(login.ExpiresAt) {
+		return models.OIDCLogin{}, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrDetOIDCState)
+	}
+	return login, nil
+}
+
+// exchange the authorization code, with the PKCE code verifier, for the ID token. Returns its verified claims
+func (p *ProviderOIDC) exchange(code string, login models.OIDCLogin) (map[string]interface{}, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.conf.OIDCRedirectURL},
+		"client_id":     {p.conf.OIDCClientID},
+		"code_verifier": {login.CodeVerifier},
+	}
+	if p.conf.OIDCClientSecret != "" {
+		form.Set("client_secret", p.conf.OIDCClientSecret)
+	}
+	res, err := p.client.PostForm(discovery.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 400 && res.StatusCode < 500 { // invalid_grant, invalid_client...
+		return nil, fmt.Errorf("%w: %s", errOIDCInvalidGrant, body)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected token response status " + res.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = json.Unmarshal(body, &tokens); err != nil {
+		return nil, err
+	}
+	return p.verifyIDToken(discovery, tokens.IDToken, login.Nonce)
+}
+
+// verifyIDToken check the signature, issuer, audience, expiry and nonce of the ID token
+func (p *ProviderOIDC) verifyIDToken(discovery *oidcDiscovery, idToken, nonce string) (map[string]interface{}, error) {
+	keys, err := p.signingKeys(false)
+	if err != nil {
+		return nil, err
+	}
+	verified, err := jwt.VerifyWithHeaderValidator(nil, nil, []byte(idToken), keys.ValidateHeader)
+	if errors.Is(err, jwt.ErrUnknownKid) { // the identity provider rotated its keys
+		if keys, err = p.signingKeys(true); err != nil {
+			return nil, err
+		}
+		verified, err = jwt.VerifyWithHeaderValidator(nil, nil, []byte(idToken), keys.ValidateHeader)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errOIDCInvalidGrant, err)
+	}
+
+	std := verified.StandardClaims
+	if std.Issuer != discovery.Issuer || std.Expiry == 0 || !lib.Contains(std.Audience, p.conf.OIDCClientID) {
+		return nil, fmt.Errorf("%w: the ID token was not issued for this client", errOIDCInvalidGrant)
+	}
+	var claims map[string]interface{}
+	if err = verified.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %s", errOIDCInvalidGrant, err)
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, fmt.Errorf("%w: the ID token nonce does not match", errOIDCInvalidGrant)
+	}
+	return claims, nil
+}
+
+// claimsRole the role of the first rule matching the claims
+func (p *ProviderOIDC) claimsRole(claims map[string]interface{}) string {
+	for _, rule := range p.conf.OIDCRoleRules {
+		switch value := claimValue(claims, rule.Claim).(type) {
+		case []interface{}:
+			for _, item := range value {
+				if fmt.Sprint(item) == rule.Value {
+					return rule.Role
+				}
+			}
+		case nil:
+		default:
+			if fmt.Sprint(value) == rule.Value {
+				return rule.Role
+			}
+		}
+	}
+	return ""
+}
+
+// discover fetch once the identity provider metadata
+func (p *ProviderOIDC) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(strings.TrimSuffix(p.conf.OIDCIssuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != p.conf.OIDCIssuer {
+		return nil, fmt.Errorf("the discovered issuer %q is not the configured one", discovery.Issuer)
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// signingKeys the identity provider keys, fetched from its JWKS on the first use or when refresh is set
+func (p *ProviderOIDC) signingKeys(refresh bool) (jwt.Keys, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keys != nil && !refresh {
+		return p.keys, nil
+	}
+
+	var set struct {
+		Keys []dto.JWK `json:"keys"`
+	}
+	if err = p.getJSON(discovery.JwksURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(jwt.Keys)
+	for _, key := range set.Keys {
+		if parsed, err := parseJWK(key); err == nil {
+			keys[key.Kid] = parsed
+		}
+	}
+	p.keys = keys
+	return keys, nil
+}
+
+func (p *ProviderOIDC) getJSON(url string, dest interface{}) error {
+	res, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New("unexpected response status " + res.Status + " from " + url)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(dest)
+}
+
+// endregion =============================================================================
+
+// region ======== HELPERS ===============================================================
+
+var jwkAlgs = map[string]jwt.Alg{
+	jwt.RS256.Name(): jwt.RS256, jwt.RS384.Name(): jwt.RS384, jwt.RS512.Name(): jwt.RS512,
+	jwt.PS256.Name(): jwt.PS256, jwt.PS384.Name(): jwt.PS384, jwt.PS512.Name(): jwt.PS512,
+	jwt.ES256.Name(): jwt.ES256, jwt.ES384.Name(): jwt.ES384, jwt.ES512.Name(): jwt.ES512,
+	jwt.EdDSA.Name(): jwt.EdDSA,
+}
+
+// parseJWK the public signing key of a JSON Web Key (RFC 7517). The alg defaults to the usual one of the key type
+func parseJWK(key dto.JWK) (*jwt.Key, error) {
+	if key.Use != "" && key.Use != "sig" {
+		return nil, errors.New("not a signing key")
+	}
+
+	var public interface{}
+	defaultAlg := ""
+	switch key.Kty {
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(key.N)
+		e, errE := base64.RawURLEncoding.DecodeString(key.E)
+		if errN != nil || errE != nil {
+			return nil, errors.New("invalid RSA key")
+		}
+		public = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		defaultAlg = jwt.RS256.Name()
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		algs := map[string]string{"P-256": jwt.ES256.Name(), "P-384": jwt.ES384.Name(), "P-521": jwt.ES512.Name()}
+		curve, ok := curves[key.Crv]
+		x, errX := base64.RawURLEncoding.DecodeString(key.X)
+		y, errY := base64.RawURLEncoding.DecodeString(key.Y)
+		if !ok || errX != nil || errY != nil {
+			return nil, errors.New("invalid EC key")
+		}
+		public = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		defaultAlg = algs[key.Crv]
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if key.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP key")
+		}
+		public = ed25519.PublicKey(x)
+		defaultAlg = jwt.EdDSA.Name()
+	default:
+		return nil, errors.New("unsupported key type " + key.Kty)
+	}
+
+	algName := key.Alg
+	if algName == "" {
+		algName = defaultAlg
+	}
+	alg, ok := jwkAlgs[algName]
+	if !ok {
+		return nil, errors.New("unsupported algorithm " + algName)
+	}
+	return &jwt.Key{ID: key.Kid, Alg: alg, Public: public}, nil
+}
+
+// claimValue the claim value, the nested claims are addressed with dots
+func claimValue(claims map[string]interface{}, name string) interface{} {
+	var value interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+	return value
+}
+
+// pkceChallenge the S256 code challenge of the code verifier (RFC 7636)
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// endregion =============================================================================
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kataras/jwt"
+)
+
+// region ======== MOCK IDENTITY PROVIDER ================================================
+
+// mockIdP local OpenID Connect identity provider: discovery, JWKS and a token endpoint enforcing PKCE
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu    sync.Mutex
+	kid   string
+	key   *rsa.PrivateKey
+	codes map[string]mockGrant // authorization code -> grant, single use
+}
+
+type mockGrant struct {
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	idp := &mockIdP{t: t, codes: make(map[string]mockGrant)}
+	idp.rotateKey("idp-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JwksURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(dto.JWKSet{Keys: []dto.JWK{{
+			Kty: "RSA", Kid: idp.kid, Use: "sig",
+			N: base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) rotateKey(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.kid, idp.key = kid, key
+	idp.mu.Unlock()
+}
+
+// authorize emulates the user logging in at the identity provider, returns the authorization code
+func (idp *mockIdP) authorize(authURL string, claims map[string]interface{}) (code, state string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("response_type") != "code" {
+		idp.t.Fatalf("not an authorization code request with PKCE: %s", authURL)
+	}
+
+	if _, ok := claims["nonce"]; !ok {
+		claims["nonce"] = query.Get("nonce")
+	}
+	if _, ok := claims["sub"]; !ok {
+		claims["sub"] = fmt.Sprint("subject-", claims["preferred_username"])
+	}
+	claims["iss"] = idp.server.URL
+	claims["aud"] = query.Get("client_id")
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+
+	code, _ = randomToken()
+	idp.mu.Lock()
+	idp.codes[code] = mockGrant{challenge: query.Get("code_challenge"), claims: claims}
+	idp.mu.Unlock()
+	return code, query.Get("state")
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	grant, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	if !ok || pkceChallenge(r.PostFormValue("code_verifier")) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	keys := jwt.Keys{idp.kid: {ID: idp.kid, Alg: jwt.RS256, Private: idp.key, Public: &idp.key.PublicKey}}
+	idToken, err := keys.SignToken(idp.kid, grant.claims)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": string(idToken)})
+}
+
+// fakeOIDCRepo in memory oidcRepo
+type fakeOIDCRepo struct {
+	logins map[string]models.OIDCLogin
+	users  map[string]models.User
+}
+
+func (f *fakeOIDCRepo) AddOIDCLogin(login models.OIDCLogin) error {
+	f.logins[login.State] = login
+	return nil
+}
+
+func (f *fakeOIDCRepo) TakeOIDCLogin(state string) (models.OIDCLogin, bool, error) {
+	login, ok := f.logins[state]
+	delete(f.logins, state)
+	return login, ok, nil
+}
+
+func (f *fakeOIDCRepo) UpsertDirectoryUser(user models.User, changedBy string) (models.User, error) {
+	f.users[user.Username] = user
+	return user, nil
+}
+
+// endregion =============================================================================
+
+func TestProviderOIDC(t *testing.T) {
+	idp := newMockIdP(t)
+	store := &fakeOIDCRepo{logins: make(map[string]models.OIDCLogin), users: make(map[string]models.User)}
+
+	conf := &utils.SvcConfig{}
+	conf.OIDCIssuer = idp.server.URL
+	conf.OIDCClientID = "dapp"
+	conf.OIDCRedirectURL = "http://localhost:7001/api/v1/auth/oidc/callback"
+	conf.OIDCScopes = []string{"openid", "profile"}
+	conf.OIDCUsernameClaim = "preferred_username"
+	conf.OIDCRoleRules = []utils.OIDCRoleRule{
+		{Claim: "realm_access.roles", Value: "dapp-admin", Role: models.Role_SystemAdmin},
+		{Claim: "groups", Value: "rectors", Role: models.Role_Rector},
+	}
+	conf.PasswordHashMemory, conf.PasswordHashIterations, conf.PasswordHashParallelism = 1024, 1, 1
+	provider := newProviderOIDC(store, conf)
+
+	login := func(claims map[string]interface{}) (*dto.GrantIntentResponse, *dto.Problem, *dto.OIDCCallbackIn) {
+		authURL, browserState, problem := provider.AuthorizationURL(nil)
+		if problem != nil {
+			t.Fatalf("AuthorizationURL() problem: %+v", problem)
+		}
+		code, state := idp.authorize(authURL, claims)
+		callback := &dto.OIDCCallbackIn{Code: code, State: state, BrowserState: browserState}
+		grant, problem := provider.GrantIntent(nil, callback)
+		return grant, problem, callback
+	}
+
+	grant, problem, callback := login(map[string]interface{}{"preferred_username": "rsargon", "given_name": "Richard", "groups": []string{"staff", "rectors"}, "amr": []string{"pwd", "otp", "mfa"}})
+	if problem != nil || grant.Username != "rsargon" || grant.Role != models.Role_Rector || !grant.MFA {
+		t.Fatalf("GrantIntent() = %+v, %+v", grant, problem)
+	}
+	if user := store.users["rsargon"]; user.FirstName != "Richard" || user.Issuer != idp.server.URL || user.Subject != "subject-rsargon" || user.Provider != schema.ProviderOIDC {
+		t.Errorf("the user was not created with its issuer and subject: %+v", store.users)
+	}
+	if _, problem = provider.GrantIntent(nil, callback); problem == nil || problem.Status != http.StatusUnauthorized {
+		t.Errorf("a replayed state must be refused, got %+v", problem)
+	}
+
+	// nested claims, after a key rotation of the identity provider
+	idp.rotateKey("idp-2")
+	grant, problem, _ = login(map[string]interface{}{"preferred_username": "ariel", "realm_access": map[string]interface{}{"roles": []string{"dapp-admin"}}})
+	if problem != nil || grant.Role != models.Role_SystemAdmin || grant.MFA {
+		t.Fatalf("GrantIntent() after the key rotation = %+v, %+v", grant, problem)
+	}
+
+	refused := map[string]map[string]interface{}{
+		"no matching role": {"preferred_username": "guest", "groups": []string{"students"}},
+		"no username":      {"groups": []string{"rectors"}},
+		"wrong nonce":      {"preferred_username": "rsargon", "groups": []string{"rectors"}, "nonce": "replayed"},
+	}
+	for name, claims := range refused {
+		t.Run(name, func(t *testing.T) {
+			if _, problem, _ := login(claims); problem == nil || problem.Status != http.StatusUnauthorized {
+				t.Errorf("GrantIntent() problem = %+v, want 401", problem)
+			}
+		})
+	}
+
+	t.Run("code verifier of another login", func(t *testing.T) {
+		authURL, _, _ := provider.AuthorizationURL(nil)
+		code, _ := idp.authorize(authURL, map[string]interface{}{"preferred_username": "rsargon", "groups": []string{"rectors"}})
+		otherURL, _, _ := provider.AuthorizationURL(nil)
+		_, otherState := idp.authorize(otherURL, map[string]interface{}{})
+		if _, problem := provider.GrantIntent(nil, &dto.OIDCCallbackIn{Code: code, State: otherState, BrowserState: otherState}); problem == nil || problem.Status != http.StatusUnauthorized {
+			t.Errorf("GrantIntent() problem = %+v, want 401", problem)
+		}
+	})
+
+	// login CSRF: the attacker makes the browser of the victim complete the login the attacker started
+	t.Run("state of another browser", func(t *testing.T) {
+		attackerURL, attackerState, _ := provider.AuthorizationURL(nil)
+		code, state := idp.authorize(attackerURL, map[string]interface{}{"preferred_username": "attacker", "groups": []string{"rectors"}})
+		_, victimState, _ := provider.AuthorizationURL(nil)
+		for _, browserState := range []string{victimState, ""} {
+			if _, problem := provider.GrantIntent(nil, &dto.OIDCCallbackIn{Code: code, State: state, BrowserState: browserState}); problem == nil || problem.Status != http.StatusUnauthorized {
+				t.Errorf("GrantIntent() with the browser state %q = %+v, want 401", browserState, problem)
+			}
+		}
+		if _, ok := store.logins[attackerState]; !ok {
+			t.Error("a callback from another browser must not consume the login")
+		}
+	})
+}
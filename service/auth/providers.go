@@ -5,7 +5,12 @@ import (
 	"dapp/repo"
 	"dapp/schema"
 	"dapp/schema/dto"
+	"errors"
 	"github.com/kataras/iris/v12"
+	"log"
+	"sync"
+
+	"gorm.io/gorm"
 )
 
 type Provider interface {
@@ -16,21 +21,51 @@ type Provider interface {
 
 type ProviderDrone struct {
 	// walletLocations string
-	repo *repo.RepoUser
+	repo           *repo.RepoUser
+	passwordParams lib.PasswordParams
 }
 
+var (
+	// dummyPassphrase hash verified for the unknown users, so they take as long as a wrong password
+	dummyPassphrase     string
+	onceDummyPassphrase sync.Once
+)
+
+// GrantIntent check the user credentials. Unknown users and wrong passwords get the same response
 func (p *ProviderDrone) GrantIntent(uCred *dto.UserCredIn, options interface{}) (*dto.GrantIntentResponse, *dto.Problem) {
 	// getting the users
 	user, err := (*p.repo).GetUserByUsername(uCred.Username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		onceDummyPassphrase.Do(func() { dummyPassphrase, _ = lib.HashPassword("dummy", p.passwordParams) })
+		_, _, _ = lib.VerifyPassword(uCred.Password, dummyPassphrase, p.passwordParams)
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrCredsNotFound)
+	}
 	if err != nil {
-		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	match, rehash, err := lib.VerifyPassword(uCred.Password, user.Passphrase, p.passwordParams)
+	if err != nil {
+		// a corrupted hash is not told apart from a wrong password
+		log.Printf("failed to verify the passphrase of the user %s: %s", user.Username, err)
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrCredsNotFound)
+	}
+	// only the invited users activating the account know their password, and a user linked to a directory logs in there
+	if match && (user.Pending || !localPassword(user)) {
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrCredsNotFound)
 	}
-	checksum, _ := lib.Checksum("SHA256", []byte(uCred.Password))
-	if user.Passphrase == checksum {
+	if match {
+		// legacy SHA256 hashes and hashes with an outdated cost are upgraded now that we know the password
+		if rehash {
+			if passphrase, err := lib.HashPassword(uCred.Password, p.passwordParams); err == nil {
+				if err := (*p.repo).UpdatePassphrase(user.ID, passphrase); err != nil {
+					log.Printf("failed to upgrade the passphrase hash of the user %s: %s", user.Username, err)
+				}
+			}
+		}
 		return &dto.GrantIntentResponse{Username: user.Username, Role: user.Role}, nil
 	}
 
-	return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrFile, schema.ErrCredsNotFound)
+	return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrCredsNotFound)
 }
 
 // endregion =============================================================================
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"dapp/lib"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"net/http"
+	"testing"
+)
+
+func TestProviderDroneCorruptedHash(t *testing.T) {
+	repoUser, _ := newTestRepos(t)
+	repoUser.DB.Create(&models.User{Username: "richard", Passphrase: "$argon2id$broken", Role: models.Role_Rector})
+	params := lib.PasswordParams{Memory: 1024, Iterations: 1, Parallelism: 1}
+	provider := &ProviderDrone{repo: repoUser, passwordParams: params}
+
+	wrong, problem := provider.GrantIntent(&dto.UserCredIn{Username: "ghost", Password: "passphrase"}, nil)
+	if wrong != nil || problem == nil {
+		t.Fatalf("GrantIntent() of an unknown user = %+v, %+v", wrong, problem)
+	}
+	grant, corrupted := provider.GrantIntent(&dto.UserCredIn{Username: "richard", Password: "passphrase"}, nil)
+	if grant != nil || corrupted == nil || corrupted.Status != http.StatusUnauthorized || *corrupted != *problem {
+		t.Errorf("GrantIntent() with a corrupted hash = %+v, %+v, want the response of an unknown user %+v", grant, corrupted, problem)
+	}
+}
@@ -1,11 +1,21 @@
 package auth
 
 import (
+	"dapp/lib"
 	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/service/utils"
+	"log"
+	"time"
+
+	"github.com/kataras/iris/v12"
 )
 
 type SvcAuthentication struct {
 	AuthProviders map[string]Provider // similar to slices, maps are reference types.
+	guard         *loginGuard
+	totp          *svcTOTP
 }
 
 // NewSvcAuthentication creates the authentication service. It provides the methods to make the
@@ -13,15 +23,201 @@ type SvcAuthentication struct {
 //
 // - providers [Array] ~ Maps of providers string token / identifiers
 //
+// - repoUser [*RepoUser] ~ User repository instance pointer
+//
 // - conf [*SvcConfig] ~ App conf instance pointer
-func NewSvcAuthentication(providers map[string]bool, repoUser *repo.RepoUser) *SvcAuthentication {
-	k := &SvcAuthentication{AuthProviders: make(map[string]Provider)}
+func NewSvcAuthentication(providers map[string]bool, repoUser *repo.RepoUser, svcConf *utils.SvcConfig) *SvcAuthentication {
+	k := &SvcAuthentication{AuthProviders: make(map[string]Provider), guard: newLoginGuard(repoUser, svcConf), totp: newSvcTOTP(repoUser, svcConf)}
 
 	for v := range providers {
-		k.AuthProviders[v] = &ProviderDrone{
-			repo: repoUser,
+		switch v {
+		case schema.ProviderLDAP:
+			k.AuthProviders[v] = NewProviderLDAP(repoUser, svcConf)
+		case schema.ProviderOIDC:
+			k.AuthProviders[v] = NewProviderOIDC(repoUser, svcConf)
+		default:
+			k.AuthProviders[v] = &ProviderDrone{
+				repo:           repoUser,
+				passwordParams: svcConf.PasswordParams(),
+			}
 		}
 	}
 
 	return k
 }
+
+// GrantIntent request the authentication to the provider, protected against brute-force attacks. While the account
+// or the client IP is locked the credentials aren't checked, and the returned duration is the time left. The users
+// with the TOTP second factor enrolled must also send its code, or a recovery code. The access token gets the
+// requested scopes, or all of them
+//
+// - provider [string] ~ Registered auth provider
+//
+// - uCred [*dto.UserCredIn] ~ User credentials
+//
+// - clientIP [string] ~ Remote address of the request
+func (s *SvcAuthentication) GrantIntent(provider string, uCred *dto.UserCredIn, clientIP string) (*dto.GrantIntentResponse, time.Duration, *dto.Problem) {
+	p, ok := s.AuthProviders[provider]
+	if !ok {
+		return nil, 0, lib.NewProblem(iris.StatusBadRequest, schema.ErrWrongAuthProvider, schema.ErrDetInvalidProvider)
+	}
+	scope, problem := ParseScope(uCred.Scope)
+	if problem != nil {
+		return nil, 0, problem
+	}
+
+	if wait, problem := s.loginWait(uCred.Username, clientIP); problem != nil {
+		return nil, wait, problem
+	}
+
+	grant, problem := p.GrantIntent(uCred, nil)
+	if problem == nil {
+		grant.MFA, problem = s.totp.secondFactor(grant.Username, uCred.OTP)
+		grant.Scope = scope
+	}
+	if problem != nil {
+		// the missing second factor code is not a failure, the client asks the user for it and tries again
+		if problem.Status == iris.StatusUnauthorized && problem.Title != schema.ErrOTPRequired {
+			s.loginFailed(uCred.Username, clientIP)
+		}
+		return nil, 0, problem
+	}
+
+	if err := s.guard.reset(uCred.Username); err != nil {
+		log.Printf("failed to reset the failed logins of the user %s: %s", uCred.Username, err)
+	}
+	return grant, 0, nil
+}
+
+// OIDCAuthorizationURL start a single sign-on login, see ProviderOIDC.AuthorizationURL. The access token gets the
+// requested scopes, or all of them
+func (s *SvcAuthentication) OIDCAuthorizationURL(requestedScope string) (string, string, *dto.Problem) {
+	p, problem := s.oidcProvider()
+	if problem != nil {
+		return "", "", problem
+	}
+	scope, problem := ParseScope(requestedScope)
+	if problem != nil {
+		return "", "", problem
+	}
+	return p.AuthorizationURL(scope)
+}
+
+// GrantCallback complete the single sign-on with the authorization response of the identity provider, protected
+// like GrantIntent. The client IP is checked before the identity provider is called, the account once it is known.
+// A user with the TOTP second factor enrolled gets err.otp_required, and the login waits for the code in
+// GrantSecondFactor
+//
+// - callback [*dto.OIDCCallbackIn] ~ Authorization response of the identity provider
+//
+// - clientIP [string] ~ Remote address of the request
+func (s *SvcAuthentication) GrantCallback(callback *dto.OIDCCallbackIn, clientIP string) (*dto.GrantIntentResponse, time.Duration, *dto.Problem) {
+	p, problem := s.oidcProvider()
+	if problem != nil {
+		return nil, 0, problem
+	}
+	if wait, problem := s.loginWait("", clientIP); problem != nil {
+		return nil, wait, problem
+	}
+
+	grant, problem := p.GrantIntent(nil, callback)
+	if problem != nil {
+		if problem.Status == iris.StatusUnauthorized {
+			s.loginFailed("", clientIP)
+		}
+		return nil, 0, problem
+	}
+	return s.oidcSecondFactor(p, callback.State, grant, "", clientIP)
+}
+
+// GrantSecondFactor complete the single sign-on of a user with the TOTP second factor enrolled, with its code or a
+// recovery code. The login keeps waiting after a wrong code, until it expires or the account is locked
+//
+// - in [*dto.OIDCSecondFactorIn] ~ State of the login and code of the second factor
+//
+// - clientIP [string] ~ Remote address of the request
+func (s *SvcAuthentication) GrantSecondFactor(in *dto.OIDCSecondFactorIn, clientIP string) (*dto.GrantIntentResponse, time.Duration, *dto.Problem) {
+	p, problem := s.oidcProvider()
+	if problem != nil {
+		return nil, 0, problem
+	}
+	if wait, problem := s.loginWait("", clientIP); problem != nil {
+		return nil, wait, problem
+	}
+
+	grant, problem := p.TakeSecondFactorLogin(in.State, in.BrowserState)
+	if problem != nil {
+		if problem.Status == iris.StatusUnauthorized {
+			s.loginFailed("", clientIP)
+		}
+		return nil, 0, problem
+	}
+	return s.oidcSecondFactor(p, in.State, grant, in.OTP, clientIP)
+}
+
+// Unlock forget the failed logins of the account, so the user can log in again right away
+func (s *SvcAuthentication) Unlock(username string) *dto.Problem {
+	if err := s.guard.reset(username); err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return nil
+}
+
+// region ======== HELPERS ===============================================================
+
+// oidcSecondFactor check the second factor of the user authenticated by the identity provider. Without the code,
+// or with a wrong one, the login waits again under its state
+func (s *SvcAuthentication) oidcSecondFactor(p *ProviderOIDC, state string, grant *dto.GrantIntentResponse, otp, clientIP string) (*dto.GrantIntentResponse, time.Duration, *dto.Problem) {
+	if wait, problem := s.loginWait(grant.Username, clientIP); problem != nil {
+		return nil, wait, problem
+	}
+
+	mfa, problem := s.totp.secondFactor(grant.Username, otp)
+	if problem != nil {
+		if problem.Status == iris.StatusUnauthorized {
+			if problem.Title != schema.ErrOTPRequired {
+				s.loginFailed(grant.Username, clientIP)
+			}
+			if err := p.AwaitSecondFactor(state, grant); err != nil {
+				return nil, 0, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+			}
+		}
+		return nil, 0, problem
+	}
+	grant.MFA = grant.MFA || mfa
+
+	if err := s.guard.reset(grant.Username); err != nil {
+		log.Printf("failed to reset the failed logins of the user %s: %s", grant.Username, err)
+	}
+	return grant, 0, nil
+}
+
+// oidcProvider the registered single sign-on provider
+func (s *SvcAuthentication) oidcProvider() (*ProviderOIDC, *dto.Problem) {
+	p, ok := s.AuthProviders[schema.ProviderOIDC].(*ProviderOIDC)
+	if !ok {
+		return nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrWrongAuthProvider, schema.ErrDetInvalidProvider)
+	}
+	return p, nil
+}
+
+// loginWait a 429 problem, with the time left, while the account or the client IP is locked
+func (s *SvcAuthentication) loginWait(username, clientIP string) (time.Duration, *dto.Problem) {
+	wait, err := s.guard.wait(username, clientIP)
+	if err != nil {
+		return 0, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if wait > 0 {
+		return wait, lib.NewProblem(iris.StatusTooManyRequests, schema.ErrTooManyAttempts, schema.ErrDetLoginLocked)
+	}
+	return 0, nil
+}
+
+// loginFailed count the failed login, logging the error, the login is refused anyway
+func (s *SvcAuthentication) loginFailed(username, clientIP string) {
+	if err := s.guard.failed(username, clientIP); err != nil {
+		log.Printf("failed to count the failed login of the user %s from %s: %s", username, clientIP, err)
+	}
+}
+
+// endregion =============================================================================
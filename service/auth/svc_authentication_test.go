@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"bytes"
+	"dapp/lib"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestSvcAuthenticationOIDCSecondFactor a single sign-on user with the TOTP second factor enrolled gets no token
+// until it gives the code
+func TestSvcAuthenticationOIDCSecondFactor(t *testing.T) {
+	idp := newMockIdP(t)
+	repoUser, _ := newTestRepos(t)
+
+	conf := &utils.SvcConfig{}
+	conf.OIDCIssuer = idp.server.URL
+	conf.OIDCClientID = "dapp"
+	conf.OIDCRedirectURL = "http://localhost:7001/api/v1/auth/oidc/callback"
+	conf.OIDCScopes = []string{"openid", "profile"}
+	conf.OIDCUsernameClaim = "preferred_username"
+	conf.OIDCRoleRules = []utils.OIDCRoleRule{{Claim: "groups", Value: "rectors", Role: models.Role_Rector}}
+	conf.PasswordHashMemory, conf.PasswordHashIterations, conf.PasswordHashParallelism = 1024, 1, 1
+	conf.LoginMaxAttempts, conf.LoginMaxAttemptsIP, conf.LoginLockout = 5, 20, 60
+	totp := &svcTOTP{repo: repoUser, kek: bytes.Repeat([]byte{7}, lib.KeySize)}
+	svc := &SvcAuthentication{
+		AuthProviders: map[string]Provider{schema.ProviderOIDC: newProviderOIDC(repoUser, conf)},
+		guard:         newLoginGuard(repoUser, conf),
+		totp:          totp,
+	}
+
+	login := func(scope string) (*dto.GrantIntentResponse, *dto.Problem, string) {
+		authURL, state, problem := svc.OIDCAuthorizationURL(scope)
+		if problem != nil {
+			t.Fatalf("OIDCAuthorizationURL() problem: %+v", problem)
+		}
+		code, _ := idp.authorize(authURL, map[string]interface{}{"preferred_username": "rsargon", "groups": []string{"rectors"}})
+		grant, _, problem := svc.GrantCallback(&dto.OIDCCallbackIn{Code: code, State: state, BrowserState: state}, "10.0.0.1")
+		return grant, problem, state
+	}
+
+	// without the second factor the token is issued right away, with the requested scope
+	grant, problem, _ := login("certificates:read")
+	if problem != nil || grant.Username != "rsargon" || grant.MFA || len(grant.Scope) != 1 || grant.Scope[0] != "certificates:read" {
+		t.Fatalf("GrantCallback() = %+v, %+v", grant, problem)
+	}
+
+	enrolment, problem := totp.Enrol("rsargon")
+	if problem != nil {
+		t.Fatalf("Enrol() problem: %+v", problem)
+	}
+	now := time.Now()
+	firstCode, _ := lib.TOTPCode(enrolment.Secret, lib.TOTPStep(now)-1)
+	if _, problem = totp.Confirm("rsargon", firstCode); problem != nil {
+		t.Fatalf("Confirm() problem: %+v", problem)
+	}
+
+	grant, problem, state := login("")
+	if problem == nil || problem.Title != schema.ErrOTPRequired || grant != nil {
+		t.Fatalf("GrantCallback() of a user with the second factor = %+v, %+v, want err.otp_required", grant, problem)
+	}
+
+	refused := []struct {
+		name string
+		in   dto.OIDCSecondFactorIn
+	}{
+		{"wrong code", dto.OIDCSecondFactorIn{State: state, OTP: "000000", BrowserState: state}},
+		{"state of another browser", dto.OIDCSecondFactorIn{State: state, OTP: "000000", BrowserState: "other"}},
+		{"unknown state", dto.OIDCSecondFactorIn{State: "unknown", OTP: "000000", BrowserState: "unknown"}},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			if grant, _, problem := svc.GrantSecondFactor(&tt.in, "10.0.0.1"); problem == nil || problem.Status != http.StatusUnauthorized || grant != nil {
+				t.Errorf("GrantSecondFactor() = %+v, %+v, want 401", grant, problem)
+			}
+		})
+	}
+
+	code, _ := lib.TOTPCode(enrolment.Secret, lib.TOTPStep(now))
+	grant, _, problem = svc.GrantSecondFactor(&dto.OIDCSecondFactorIn{State: state, OTP: code, BrowserState: state}, "10.0.0.1")
+	if problem != nil || grant.Username != "rsargon" || !grant.MFA || len(grant.Scope) != len(schema.Scopes) {
+		t.Fatalf("GrantSecondFactor() with the code = %+v, %+v", grant, problem)
+	}
+	if _, _, problem = svc.GrantSecondFactor(&dto.OIDCSecondFactorIn{State: state, OTP: code, BrowserState: state}, "10.0.0.1"); problem == nil {
+		t.Error("the completed login must not be completed again")
+	}
+
+	attempts, _ := repoUser.GetLoginAttempts(accountKey("rsargon"))
+	if len(attempts) != 0 {
+		t.Errorf("the failed logins of the account must be reset after the login, got %+v", attempts)
+	}
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/mapper"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+// region ======== SETUP =================================================================
+
+// ISvcInvitation user invitation and account activation service interface
+type ISvcInvitation interface {
+	Invite(user dto.InvitationData, invitedBy string) (dto.UserResponse, *dto.Problem)
+	Resend(userID int) *dto.Problem
+	Activate(token, password string) *dto.Problem
+}
+
+// invitationRepo storage used by the invitation service, implemented by repo.RepoUser
+type invitationRepo interface {
+	GetUser(userID int) (models.User, error)
+	GetRole(label string) (models.Role, error)
+	AddInvitedUser(user models.User, invitation models.Invitation, changedBy string) (models.User, error)
+	RenewInvitation(invitation models.Invitation) error
+	GetInvitation(tokenHash string) (models.Invitation, bool, error)
+	ActivateUser(tokenHash, passphrase string) (models.User, bool, error)
+}
+
+type svcInvitation struct {
+	repo           invitationRepo
+	mailer         utils.Mailer
+	passwordParams lib.PasswordParams
+	activationURL  string
+	ttl            time.Duration
+}
+
+// endregion =============================================================================
+
+// NewSvcInvitation instantiate the invitation services
+//
+// - repoUser [*RepoUser] ~ User repository instance pointer
+//
+// - mailer [utils.Mailer] ~ Sends the invitation emails
+//
+// - svcConf [*SvcConfig] ~ App conf instance pointer
+func NewSvcInvitation(repoUser *repo.RepoUser, mailer utils.Mailer, svcConf *utils.SvcConfig) ISvcInvitation {
+	return newSvcInvitation(repoUser, mailer, svcConf)
+}
+
+func newSvcInvitation(repo invitationRepo, mailer utils.Mailer, svcConf *utils.SvcConfig) *svcInvitation {
+	return &svcInvitation{
+		repo:           repo,
+		mailer:         mailer,
+		passwordParams: svcConf.PasswordParams(),
+		activationURL:  svcConf.InvitationURL,
+		ttl:            time.Duration(svcConf.InvitationTTL) * time.Hour,
+	}
+}
+
+// region ======== METHODS ===============================================================
+
+// Invite create the pending user, without a password, and email it the activation link. If the email fails the user
+// is kept, and the invitation can be sent again. invitedBy is recorded in the history of the user
+func (s *svcInvitation) Invite(user dto.InvitationData, invitedBy string) (dto.UserResponse, *dto.Problem) {
+	_, err := s.repo.GetRole(user.Role)
+	if errors.Is(err, gorm.ErrRecordNotFound) || user.Role == models.Role_Invalid {
+		return dto.UserResponse{}, lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, schema.ErrDetUnknownRole+": "+user.Role)
+	}
+	if err != nil {
+		return dto.UserResponse{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+
+	passphrase, err := randomPassphrase(s.passwordParams) // nobody knows it, the user sets its own on activation
+	if err != nil {
+		return dto.UserResponse{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
+	token, invitation, problem := s.newInvitation(user.Username)
+	if problem != nil {
+		return dto.UserResponse{}, problem
+	}
+	created, err := s.repo.AddInvitedUser(mapper.MapInvitationData2ModelUser(user, passphrase), invitation, invitedBy)
+	if errors.Is(err, repo.ErrUserDeleted) {
+		return dto.UserResponse{}, lib.NewProblem(iris.StatusConflict, schema.ErrDuplicateKey, schema.ErrDetUserDeleted)
+	}
+	if errors.Is(err, repo.ErrUsernameTaken) {
+		return dto.UserResponse{}, lib.NewProblem(iris.StatusConflict, schema.ErrDuplicateKey, schema.ErrDetUsernameTaken)
+	}
+	if err != nil {
+		return dto.UserResponse{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+
+	return mapper.MapModelUser2DtoUserResponse(created), s.send(created, token)
+}
+
+// Resend a new invitation to the pending user, the previous links stop working
+func (s *svcInvitation) Resend(userID int) *dto.Problem {
+	user, err := s.repo.GetUser(userID)
+	if err != nil {
+		return lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	}
+	if !user.Pending {
+		return lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, schema.ErrDetUserActive)
+	}
+
+	token, invitation, problem := s.newInvitation(user.Username)
+	if problem != nil {
+		return problem
+	}
+	if err = s.repo.RenewInvitation(invitation); err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return s.send(user, token)
+}
+
+// Activate set the password of the invited user with the token of its invitation, the user can log in from now on. The
+// invitation is checked before hashing the password, an unknown token costs no hashing
+func (s *svcInvitation) Activate(token, password string) *dto.Problem {
+	tokenHash := hashToken(token)
+	_, found, err := s.repo.GetInvitation(tokenHash)
+	if err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if !found {
+		return lib.NewProblem(iris.StatusBadRequest, schema.ErrVal, schema.ErrDetInvalidInvitation)
+	}
+
+	passphrase, err := lib.HashPassword(password, s.passwordParams)
+	if err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
+	_, activated, err := s.repo.ActivateUser(tokenHash, passphrase) // the invitation may be used meanwhile
+	if err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if !activated {
+		return lib.NewProblem(iris.StatusBadRequest, schema.ErrVal, schema.ErrDetInvalidInvitation)
+	}
+	return nil
+}
+
+// endregion =============================================================================
+
+// region ======== HELPERS ===============================================================
+
+// newInvitation returns the activation token and its invitation
+func (s *svcInvitation) newInvitation(username string) (string, models.Invitation, *dto.Problem) {
+	token, err := randomToken()
+	if err != nil {
+		return "", models.Invitation{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
+	return token, models.Invitation{TokenHash: hashToken(token), Username: username, ExpiresAt: time.Now().Add(s.ttl)}, nil
+}
+
+// send the invitation email, with the activation link of the client app when configured
+func (s *svcInvitation) send(user models.User, token string) *dto.Problem {
+	activation := tokenLink(s.activationURL, token)
+	body := fmt.Sprintf("Hello %s,\n\nAn account %s was created for you. Use this within %d hours to set your password "+
+		"and activate it:\n\n%s\n", user.FirstName, user.Username, int(s.ttl.Hours()), activation)
+
+	if err := s.mailer.Send(user.Email, "Account invitation", body); err != nil {
+		log.Printf("failed to send the invitation email of the user %s: %s", user.Username, err)
+		return lib.NewProblem(iris.StatusBadGateway, schema.ErrBadGateway, schema.ErrEmailProc)
+	}
+	return nil
+}
+
+// endregion =============================================================================
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fakeInvitationRepo in memory invitationRepo
+type fakeInvitationRepo struct {
+	users       map[string]models.User
+	invitations []models.Invitation
+}
+
+func (f *fakeInvitationRepo) GetUser(userID int) (models.User, error) {
+	for _, user := range f.users {
+		if user.ID == userID {
+			return user, nil
+		}
+	}
+	return models.User{}, gorm.ErrRecordNotFound
+}
+
+func (f *fakeInvitationRepo) GetRole(label string) (models.Role, error) {
+	if label != models.Role_Secretary && label != models.Role_Invalid {
+		return models.Role{}, gorm.ErrRecordNotFound
+	}
+	return models.Role{Label: label}, nil
+}
+
+func (f *fakeInvitationRepo) AddInvitedUser(user models.User, invitation models.Invitation, changedBy string) (models.User, error) {
+	if existing, ok := f.users[user.Username]; ok && existing.DeletedAt.Valid {
+		return models.User{}, repo.ErrUserDeleted
+	} else if ok {
+		return models.User{}, repo.ErrUsernameTaken
+	}
+	user.ID = len(f.users) + 1
+	f.users[user.Username] = user
+	f.invitations = append(f.invitations, invitation)
+	return user, nil
+}
+
+func (f *fakeInvitationRepo) RenewInvitation(invitation models.Invitation) error {
+	kept := f.invitations[:0]
+	for _, i := range f.invitations {
+		if i.Username != invitation.Username || i.UsedAt != nil {
+			kept = append(kept, i)
+		}
+	}
+	f.invitations = append(kept, invitation)
+	return nil
+}
+
+func (f *fakeInvitationRepo) GetInvitation(tokenHash string) (models.Invitation, bool, error) {
+	for _, invitation := range f.invitations {
+		if invitation.TokenHash == tokenHash && invitation.UsedAt == nil && invitation.ExpiresAt.After(time.Now()) {
+			return invitation, true, nil
+		}
+	}
+	return models.Invitation{}, false, nil
+}
+
+func (f *fakeInvitationRepo) ActivateUser(tokenHash, passphrase string) (models.User, bool, error) {
+	now := time.Now()
+	for i, invitation := range f.invitations {
+		if invitation.TokenHash == tokenHash && invitation.UsedAt == nil && invitation.ExpiresAt.After(now) {
+			f.invitations[i].UsedAt = &now
+			user := f.users[invitation.Username]
+			if !user.Pending {
+				return models.User{}, false, nil
+			}
+			user.Passphrase, user.Pending, user.EmailVerified = passphrase, false, true
+			f.users[user.Username] = user
+			return user, true, nil
+		}
+	}
+	return models.User{}, false, nil
+}
+
+func TestSvcInvitation(t *testing.T) {
+	conf := &utils.SvcConfig{}
+	conf.PasswordHashMemory, conf.PasswordHashIterations, conf.PasswordHashParallelism = 1024, 1, 1
+	conf.InvitationURL = "https://dapp.example.edu/activate"
+	conf.InvitationTTL = 72
+
+	store := &fakeInvitationRepo{users: make(map[string]models.User)}
+	mailer := &utils.MemoryMailer{}
+	svc := newSvcInvitation(store, mailer, conf)
+	tokenOf := func(mail utils.Mail) string {
+		return regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(mail.Body)[1]
+	}
+
+	invited := dto.InvitationData{Username: "tom", FirstName: "Tom", LastName: "Sawyer", Email: "tom@example.edu", Role: models.Role_Secretary}
+	user, problem := svc.Invite(invited, "richard")
+	if problem != nil || !user.Pending || user.EmailVerified {
+		t.Fatalf("Invite() = %+v, %+v", user, problem)
+	}
+	if _, problem = svc.Invite(invited, "richard"); problem == nil || problem.Status != http.StatusConflict {
+		t.Errorf("Invite() of a taken username = %+v, want 409", problem)
+	}
+	for _, role := range []string{"janitor", models.Role_Invalid} {
+		if _, problem = svc.Invite(dto.InvitationData{Username: "huck", Role: role}, "richard"); problem == nil || problem.Status != http.StatusBadRequest {
+			t.Errorf("Invite() with the %s role = %+v, want 400", role, problem)
+		}
+	}
+	store.users["ghost"] = models.User{ID: 99, Username: "ghost", DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}
+	if _, problem = svc.Invite(dto.InvitationData{Username: "ghost", Role: models.Role_Secretary}, "richard"); problem == nil || problem.Detail != schema.ErrDetUserDeleted {
+		t.Errorf("Invite() of the username of a deleted user = %+v, want %s", problem, schema.ErrDetUserDeleted)
+	}
+
+	// resending invalidates the first link
+	first := tokenOf(mailer.Sent()[0])
+	if problem = svc.Resend(user.ID); problem != nil {
+		t.Fatalf("Resend() = %+v", problem)
+	}
+	second := tokenOf(mailer.Sent()[1])
+	if problem = svc.Activate(first, "password1"); problem == nil || problem.Title != schema.ErrVal {
+		t.Errorf("Activate() with the replaced invitation = %+v", problem)
+	}
+	if problem = svc.Activate("unknown", ""); problem == nil || problem.Title != schema.ErrVal {
+		t.Errorf("Activate() with an unknown token = %+v, the token is checked before the password", problem)
+	}
+
+	if problem = svc.Activate(second, "password1"); problem != nil {
+		t.Fatalf("Activate() = %+v", problem)
+	}
+	activated := store.users["tom"]
+	if match, _, _ := lib.VerifyPassword("password1", activated.Passphrase, conf.PasswordParams()); !match || activated.Pending || !activated.EmailVerified {
+		t.Errorf("activated user = %+v", activated)
+	}
+	if problem = svc.Activate(second, "password2"); problem == nil {
+		t.Error("an invitation must be single use")
+	}
+	if problem = svc.Resend(user.ID); problem == nil || problem.Status != http.StatusBadRequest {
+		t.Errorf("Resend() to an active user = %+v, want 400", problem)
+	}
+}
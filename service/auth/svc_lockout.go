@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"dapp/repo"
+	"dapp/service/utils"
+	"time"
+)
+
+// region ======== SETUP =================================================================
+
+// loginGuard brute-force protection of the logins, shared by all the providers. The failed attempts are tracked per
+// account and per client IP in the users database, so every API replica applies the same limits. The unknown
+// usernames are tracked like the existing ones, so the responses don't tell them apart
+type loginGuard struct {
+	repo          *repo.RepoUser
+	maxAttempts   int
+	maxAttemptsIP int
+	backoff       time.Duration
+	lockout       time.Duration
+}
+
+// endregion =============================================================================
+
+func newLoginGuard(repoUser *repo.RepoUser, svcConf *utils.SvcConfig) *loginGuard {
+	return &loginGuard{
+		repo:          repoUser,
+		maxAttempts:   svcConf.LoginMaxAttempts,
+		maxAttemptsIP: svcConf.LoginMaxAttemptsIP,
+		backoff:       time.Duration(svcConf.LoginBackoff) * time.Second,
+		lockout:       time.Duration(svcConf.LoginLockout) * time.Second,
+	}
+}
+
+// region ======== METHODS ===============================================================
+
+// wait returns how long the account or the client IP must wait before the next login attempt, 0 if it can try now.
+// Only the client IP is checked when the username is still unknown, e.g. before the single sign-on callback
+func (g *loginGuard) wait(username, clientIP string) (time.Duration, error) {
+	keys := []string{ipKey(clientIP)}
+	if username != "" {
+		keys = append(keys, accountKey(username))
+	}
+	attempts, err := g.repo.GetLoginAttempts(keys...)
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	now := time.Now()
+	for _, attempt := range attempts {
+		if d := attempt.LockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// failed count a failed login of the account and of the client IP. The account waits exponentially longer after
+// every failure, the client IP, which can be shared by many users behind a NAT, is only locked out at its limit.
+// Only the client IP is counted when the username is unknown
+func (g *loginGuard) failed(username, clientIP string) error {
+	type limit struct {
+		key         string
+		maxAttempts int
+		backoff     time.Duration
+	}
+	forgetAfter := time.Now().Add(-g.lockout)
+	limits := []limit{{ipKey(clientIP), g.maxAttemptsIP, 0}}
+	if username != "" {
+		limits = append(limits, limit{accountKey(username), g.maxAttempts, g.backoff})
+	}
+
+	for _, limit := range limits {
+		failures, err := g.repo.AddLoginFailure(limit.key, forgetAfter)
+		if err != nil {
+			return err
+		}
+		if delay := loginDelay(failures, limit.maxAttempts, limit.backoff, g.lockout); delay > 0 {
+			if err := g.repo.LockLogin(limit.key, time.Now().Add(delay)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reset forget the failed logins of the account, after a successful login or when a sysadmin unlocks it
+func (g *loginGuard) reset(username string) error {
+	return g.repo.ResetLoginAttempts(accountKey(username))
+}
+
+// endregion =============================================================================
+
+// region ======== HELPERS ===============================================================
+
+// loginDelay the wait before the next attempt after the given failures: the backoff doubled on every failure, or the
+// lockout once the max attempts are reached
+func loginDelay(failures, maxAttempts int, backoff, lockout time.Duration) time.Duration {
+	if failures >= maxAttempts {
+		return lockout
+	}
+	if backoff <= 0 || failures <= 0 {
+		return 0
+	}
+	delay := backoff << (failures - 1)
+	if delay <= 0 || delay > lockout { // overflow or over the lockout
+		return lockout
+	}
+	return delay
+}
+
+func accountKey(username string) string {
+	return "user:" + username
+}
+
+func ipKey(clientIP string) string {
+	return "ip:" + clientIP
+}
+
+// endregion =============================================================================
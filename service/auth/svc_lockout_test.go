@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginDelay(t *testing.T) {
+	lockout := 15 * time.Minute
+
+	tests := []struct {
+		name        string
+		failures    int
+		maxAttempts int
+		backoff     time.Duration
+		want        time.Duration
+	}{
+		{"first failure", 1, 5, time.Second, time.Second},
+		{"doubled", 3, 5, time.Second, 4 * time.Second},
+		{"max attempts", 5, 5, time.Second, lockout},
+		{"over max attempts", 9, 5, time.Second, lockout},
+		{"capped by the lockout", 12, 50, time.Second, lockout},
+		{"shift overflow", 70, 100, time.Second, lockout},
+		{"no backoff under the limit", 19, 20, 0, 0},
+		{"no backoff at the limit", 20, 20, 0, lockout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loginDelay(tt.failures, tt.maxAttempts, tt.backoff, lockout); got != tt.want {
+				t.Errorf("loginDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
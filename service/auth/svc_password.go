@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+// region ======== SETUP =================================================================
+
+// ISvcPassword self-service password change and reset service interface
+type ISvcPassword interface {
+	Change(username, current, password string) *dto.Problem
+	Forgot(email string) *dto.Problem
+	Reset(token, password string) *dto.Problem
+}
+
+// passwordRepo storage used by the password service, implemented by repo.RepoUser
+type passwordRepo interface {
+	GetUserByUsername(username string) (models.User, error)
+	GetUsersByEmail(email string) ([]models.User, error)
+	UpdatePassphrase(userID int, passphrase string) error
+	AddPasswordReset(reset models.PasswordReset) error
+	UsePasswordReset(tokenHash string) (models.PasswordReset, bool, error)
+}
+
+// tokenRevoker revokes the tokens of the user whose password changed, implemented by ISvcToken
+type tokenRevoker interface {
+	RevokeUser(username string) *dto.Problem
+}
+
+type svcPassword struct {
+	repo           passwordRepo
+	tokens         tokenRevoker
+	mailer         utils.Mailer
+	passwordParams lib.PasswordParams
+	resetURL       string
+	resetTTL       time.Duration
+	sending        sync.WaitGroup // the password resets of Forgot still being sent
+}
+
+// endregion =============================================================================
+
+// NewSvcPassword instantiate the password services
+//
+// - repoUser [*RepoUser] ~ User repository instance pointer
+//
+// - svcToken [ISvcToken] ~ Token service, the tokens of the user are revoked when the password changes
+//
+// - mailer [utils.Mailer] ~ Sends the password reset emails
+//
+// - svcConf [*SvcConfig] ~ App conf instance pointer
+func NewSvcPassword(repoUser *repo.RepoUser, svcToken ISvcToken, mailer utils.Mailer, svcConf *utils.SvcConfig) ISvcPassword {
+	return newSvcPassword(repoUser, svcToken, mailer, svcConf)
+}
+
+func newSvcPassword(repo passwordRepo, tokens tokenRevoker, mailer utils.Mailer, svcConf *utils.SvcConfig) *svcPassword {
+	return &svcPassword{
+		repo:           repo,
+		tokens:         tokens,
+		mailer:         mailer,
+		passwordParams: svcConf.PasswordParams(),
+		resetURL:       svcConf.PasswordResetURL,
+		resetTTL:       time.Duration(svcConf.PasswordResetTTL) * time.Minute,
+	}
+}
+
+// region ======== METHODS ===============================================================
+
+// Change set a new password after checking the current one. The tokens of the user are revoked, it has to log in again
+func (s *svcPassword) Change(username, current, password string) *dto.Problem {
+	user, err := s.repo.GetUserByUsername(username)
+	if err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if !localPassword(user) {
+		return lib.NewProblem(iris.StatusBadRequest, schema.ErrVal, schema.ErrDetProviderPassword)
+	}
+	match, _, err := lib.VerifyPassword(current, user.Passphrase, s.passwordParams)
+	if err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
+	if !match {
+		return lib.NewProblem(iris.StatusBadRequest, schema.ErrVal, schema.ErrDetWrongPassword)
+	}
+	return s.setPassword(user, password)
+}
+
+// Forgot email a password reset token to every account with the email. The response is the same whether an account
+// has the email or not, so it can't be used to find out the registered emails: the resets are created and sent in the
+// background, a failure is only logged
+func (s *svcPassword) Forgot(email string) *dto.Problem {
+	users, err := s.repo.GetUsersByEmail(email)
+	if err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+
+	for _, user := range users {
+		// the pending users are activated with their invitation, the directory users reset the password there
+		if user.Role == models.Role_Invalid || user.Pending || !localPassword(user) {
+			continue
+		}
+		s.sending.Add(1)
+		go func(user models.User) {
+			defer s.sending.Done()
+			if err := s.sendReset(user); err != nil {
+				log.Printf("failed to send the password reset email of the user %s: %s", user.Username, err)
+			}
+		}(user)
+	}
+	return nil
+}
+
+// Reset set a new password with a token of Forgot. The token is single use, and the tokens of the user are revoked
+func (s *svcPassword) Reset(token, password string) *dto.Problem {
+	reset, used, err := s.repo.UsePasswordReset(hashToken(token))
+	if err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if !used {
+		return lib.NewProblem(iris.StatusBadRequest, schema.ErrVal, schema.ErrDetInvalidResetTk)
+	}
+
+	user, err := s.repo.GetUserByUsername(reset.Username)
+	if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && (user.Role == models.Role_Invalid || !localPassword(user)) {
+		return lib.NewProblem(iris.StatusBadRequest, schema.ErrVal, schema.ErrDetInvalidResetTk)
+	}
+	if err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return s.setPassword(user, password)
+}
+
+// endregion =============================================================================
+
+// region ======== HELPERS ===============================================================
+
+func (s *svcPassword) setPassword(user models.User, password string) *dto.Problem {
+	passphrase, err := lib.HashPassword(password, s.passwordParams)
+	if err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
+	// revoked first, a token issued with the previous password can't outlive the change
+	if problem := s.tokens.RevokeUser(user.Username); problem != nil {
+		return problem
+	}
+	if err = s.repo.UpdatePassphrase(user.ID, passphrase); err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return nil
+}
+
+// localPassword whether the user logs in with the password of the users database. The password of a directory or
+// single sign-on user is random, it is managed by its provider
+func localPassword(user models.User) bool {
+	return user.Provider == "" || user.Provider == schema.ProviderDapp
+}
+
+// sendReset create a password reset of the user and email it the token
+func (s *svcPassword) sendReset(user models.User) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	reset := models.PasswordReset{TokenHash: hashToken(token), Username: user.Username, ExpiresAt: time.Now().Add(s.resetTTL)}
+	if err = s.repo.AddPasswordReset(reset); err != nil {
+		return err
+	}
+	return s.mailer.Send(user.Email, "Password reset", s.resetMail(user, token))
+}
+
+// resetMail body of the password reset email, with the link of the client app when configured
+func (s *svcPassword) resetMail(user models.User, token string) string {
+	reset := tokenLink(s.resetURL, token)
+	return fmt.Sprintf("Hello %s,\n\nA password reset was requested for the account %s. Use this within %d minutes to "+
+		"set a new password:\n\n%s\n\nIf you didn't request it, ignore this email, your password is unchanged.\n",
+		user.FirstName, user.Username, int(s.resetTTL.Minutes()), reset)
+}
+
+// tokenLink the page of the client app with the emailed token as its "token" query parameter, or the bare token when
+// there is no page configured
+func tokenLink(page, token string) string {
+	u, err := url.Parse(page)
+	if err != nil || page == "" {
+		return token
+	}
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// endregion =============================================================================
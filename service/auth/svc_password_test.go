@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"dapp/lib"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fakePasswordRepo in memory passwordRepo
+type fakePasswordRepo struct {
+	users  map[string]models.User
+	resets []models.PasswordReset
+}
+
+func (f *fakePasswordRepo) GetUserByUsername(username string) (models.User, error) {
+	user, ok := f.users[username]
+	if !ok {
+		return models.User{}, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+func (f *fakePasswordRepo) GetUsersByEmail(email string) ([]models.User, error) {
+	var users []models.User
+	for _, user := range f.users {
+		if strings.EqualFold(user.Email, email) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (f *fakePasswordRepo) UpdatePassphrase(userID int, passphrase string) error {
+	for username, user := range f.users {
+		if user.ID == userID {
+			user.Passphrase = passphrase
+			f.users[username] = user
+		}
+	}
+	return nil
+}
+
+func (f *fakePasswordRepo) AddPasswordReset(reset models.PasswordReset) error {
+	f.resets = append(f.resets, reset)
+	return nil
+}
+
+func (f *fakePasswordRepo) UsePasswordReset(tokenHash string) (models.PasswordReset, bool, error) {
+	now := time.Now()
+	for i, reset := range f.resets {
+		if reset.TokenHash == tokenHash && reset.UsedAt == nil && reset.ExpiresAt.After(now) {
+			f.resets[i].UsedAt = &now
+			return reset, true, nil
+		}
+	}
+	return models.PasswordReset{}, false, nil
+}
+
+// fakeRevoker records the users whose tokens were revoked
+type fakeRevoker []string
+
+func (f *fakeRevoker) RevokeUser(username string) *dto.Problem {
+	*f = append(*f, username)
+	return nil
+}
+
+func TestSvcPassword(t *testing.T) {
+	conf := &utils.SvcConfig{}
+	conf.PasswordHashMemory, conf.PasswordHashIterations, conf.PasswordHashParallelism = 1024, 1, 1
+	conf.PasswordResetURL = "https://dapp.example.edu/reset?lang=en"
+	conf.PasswordResetTTL = 30
+
+	passphrase, _ := lib.HashPassword("password1", conf.PasswordParams())
+	store := &fakePasswordRepo{users: map[string]models.User{
+		"richard": {ID: 1, Username: "richard", Passphrase: passphrase, Email: "richard@example.edu", Role: models.Role_Rector},
+		"ghost":   {ID: 2, Username: "ghost", Passphrase: passphrase, Email: "richard@example.edu", Role: models.Role_Invalid},
+		"rsargon": {ID: 3, Username: "rsargon", Passphrase: passphrase, Email: "richard@example.edu", Role: models.Role_Rector, Provider: schema.ProviderLDAP},
+	}}
+	revoked := &fakeRevoker{}
+	mailer := &utils.MemoryMailer{}
+	svc := newSvcPassword(store, revoked, mailer, conf)
+
+	checkPassword := func(password string) bool {
+		match, _, _ := lib.VerifyPassword(password, store.users["richard"].Passphrase, conf.PasswordParams())
+		return match
+	}
+
+	// change
+	if problem := svc.Change("richard", "wrong", "password2"); problem == nil || problem.Title != schema.ErrVal {
+		t.Errorf("Change() with a wrong current password = %+v", problem)
+	}
+	if problem := svc.Change("richard", "password1", "password2"); problem != nil || !checkPassword("password2") {
+		t.Fatalf("Change() = %+v", problem)
+	}
+	if problem := svc.Change("rsargon", "password1", "password2"); problem == nil || problem.Detail != schema.ErrDetProviderPassword {
+		t.Errorf("Change() of a directory user = %+v, its password is managed by the directory", problem)
+	}
+
+	// forgot, the invalidated and the directory accounts with the same email get nothing
+	if problem := svc.Forgot("RICHARD@example.edu"); problem != nil {
+		t.Fatalf("Forgot() = %+v", problem)
+	}
+	if problem := svc.Forgot("nobody@example.edu"); problem != nil {
+		t.Errorf("Forgot() of an unknown email = %+v, want the same response", problem)
+	}
+	svc.sending.Wait()
+	sent := mailer.Sent()
+	if len(sent) != 1 || sent[0].To != "richard@example.edu" {
+		t.Fatalf("sent emails = %+v", sent)
+	}
+	link := regexp.MustCompile(`https://\S+`).FindString(sent[0].Body)
+	u, err := url.Parse(link)
+	if err != nil || u.Query().Get("lang") != "en" || u.Query().Get("token") == "" {
+		t.Fatalf("no reset link in the email: %s", sent[0].Body)
+	}
+	token := u.Query().Get("token")
+
+	// reset
+	if problem := svc.Reset("forged", "password3"); problem == nil || problem.Title != schema.ErrVal {
+		t.Errorf("Reset() with a forged token = %+v", problem)
+	}
+	if problem := svc.Reset(token, "password3"); problem != nil || !checkPassword("password3") {
+		t.Fatalf("Reset() = %+v", problem)
+	}
+	if problem := svc.Reset(token, "password4"); problem == nil || !checkPassword("password3") {
+		t.Errorf("Reset() with a used token = %+v", problem)
+	}
+
+	if len(*revoked) != 2 || (*revoked)[0] != "richard" || (*revoked)[1] != "richard" {
+		t.Errorf("revoked users = %v, want richard after the change and the reset", *revoked)
+	}
+
+	// a failing mailer doesn't tell the registered emails apart
+	failing := newSvcPassword(store, revoked, utils.NewMailer(&utils.SvcConfig{}), conf)
+	if problem := failing.Forgot("richard@example.edu"); problem != nil {
+		t.Errorf("Forgot() with a failing mailer = %+v, want the response of an unknown email", problem)
+	}
+	failing.sending.Wait()
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/mapper"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+// region ======== SETUP =================================================================
+
+// DefaultRolePermissions permissions of the built-in roles. They are replaced, role by role, by the RolePermissions
+// configuration and by the role_permissions table
+var DefaultRolePermissions = map[string][]string{
+	models.Role_SystemAdmin: {
+		schema.PermDappQuery, schema.PermDappTransaction, schema.PermIdentitiesRead,
+		schema.PermUsersRead, schema.PermUsersWrite, schema.PermRolesRead, schema.PermRolesWrite,
+	},
+	models.Role_CertificateAdmin: {
+		schema.PermDappQuery, schema.PermDappTransaction, schema.PermCertCreate, schema.PermCertUpdate,
+		schema.PermCertInvalidate, schema.PermCertDelete, schema.PermOfflineSign,
+	},
+	models.Role_Secretary: {schema.PermDappQuery, schema.PermCertValidate, schema.PermCertInvalidate, schema.PermOfflineSign},
+	models.Role_Dean:      {schema.PermDappQuery, schema.PermCertValidate, schema.PermCertInvalidate, schema.PermOfflineSign},
+	models.Role_Rector:    {schema.PermDappQuery, schema.PermCertValidate, schema.PermCertInvalidate, schema.PermOfflineSign},
+	models.Role_Invalid:   {},
+}
+
+// PermissionScopes scopes covering each permission, the access token must carry one of them to use the permission
+var PermissionScopes = map[string][]string{
+	schema.PermDappQuery:       {schema.ScopeCertificatesRead},
+	schema.PermDappTransaction: {schema.ScopeCertificatesWrite},
+	schema.PermCertCreate:      {schema.ScopeCertificatesWrite},
+	schema.PermCertUpdate:      {schema.ScopeCertificatesWrite},
+	schema.PermCertValidate:    {schema.ScopeCertificatesValidate},
+	schema.PermCertInvalidate:  {schema.ScopeCertificatesWrite, schema.ScopeCertificatesValidate},
+	schema.PermCertDelete:      {schema.ScopeCertificatesWrite},
+	schema.PermOfflineSign:     {schema.ScopeCertificatesWrite, schema.ScopeCertificatesValidate},
+	schema.PermIdentitiesRead:  {schema.ScopeUsersAdmin},
+	schema.PermUsersRead:       {schema.ScopeUsersAdmin},
+	schema.PermUsersWrite:      {schema.ScopeUsersAdmin},
+	schema.PermRolesRead:       {schema.ScopeUsersAdmin},
+	schema.PermRolesWrite:      {schema.ScopeUsersAdmin},
+}
+
+// Policy role based access control, the permissions granted to every role
+type Policy struct {
+	repo    *repo.RepoUser
+	conf    map[string][]string
+	mfa     map[string]bool // permissions only used with an access token issued with the second factor
+	mfaRole map[string]bool // roles only acting with an access token issued with the second factor
+	mu      sync.RWMutex
+	granted map[string]map[string]bool // role -> permissions
+}
+
+// endregion =============================================================================
+
+// NewPolicy creates the access control policy of the roles, loading the role_permissions table and the role names.
+// They are read again every PolicyReloadEvery seconds, so the changes made through another API replica are applied.
+// The second factor is required on the TOTPEnforcedPermissions, and on every permission of the TOTPEnforcedRoles
+//
+// - repoUser [*RepoUser] ~ User repository instance pointer
+//
+// - svcConf [*SvcConfig] ~ App conf instance pointer
+func NewPolicy(repoUser *repo.RepoUser, svcConf *utils.SvcConfig) (*Policy, error) {
+	p := &Policy{
+		repo:    repoUser,
+		conf:    svcConf.RolePermissions,
+		mfa:     make(map[string]bool, len(svcConf.TOTPEnforcedPermissions)),
+		mfaRole: make(map[string]bool, len(svcConf.TOTPEnforcedRoles)),
+	}
+	for _, permission := range svcConf.TOTPEnforcedPermissions {
+		if !knownPermission(p.conf, permission) {
+			return nil, fmt.Errorf("TOTPEnforcedPermissions: %s: %s", schema.ErrDetUnknownPermission, permission)
+		}
+		p.mfa[permission] = true
+	}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	// a misspelled role would silently disable the second factor, like a misspelled permission
+	for _, role := range svcConf.TOTPEnforcedRoles {
+		known, err := p.knownRole(role)
+		if err != nil {
+			return nil, err
+		}
+		if !known {
+			return nil, fmt.Errorf("TOTPEnforcedRoles: %s: %s", schema.ErrDetUnknownRole, role)
+		}
+		p.mfaRole[role] = true
+	}
+
+	if repoUser != nil && svcConf.PolicyReloadEvery > 0 {
+		go p.runReload(time.Duration(svcConf.PolicyReloadEvery) * time.Second)
+	}
+	return p, nil
+}
+
+// region ======== METHODS ===============================================================
+
+// Reload read again the role_permissions table, e.g. after it was edited, and the names of the roles shown by the
+// mapped users
+func (p *Policy) Reload() error {
+	var stored []models.RolePermission
+	if p.repo != nil {
+		var err error
+		if stored, err = p.repo.GetRolePermissions(); err != nil {
+			return err
+		}
+		roles, err := p.repo.GetRoles()
+		if err != nil {
+			return err
+		}
+		mapper.SetRoleNames(roles)
+	}
+
+	granted := buildGrants(p.conf, stored)
+	p.mu.Lock()
+	p.granted = granted
+	p.mu.Unlock()
+	return nil
+}
+
+// Allowed whether the role has the permission
+func (p *Policy) Allowed(role, permission string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.granted[role][permission]
+}
+
+// Check returns a 403 problem if the role of the user lacks the permission, the scope of the access token doesn't
+// cover it, or the permission or the role requires the second factor and the token was issued without it
+func (p *Policy) Check(claims *dto.AccessTokenData, permission string) *dto.Problem {
+	if !p.Allowed(claims.Claims.Role, permission) {
+		return lib.NewProblem(iris.StatusForbidden, schema.ErrForbidden, schema.ErrDetForbidden+": "+permission)
+	}
+	if !InScope(claims.Scope, permission) {
+		return lib.NewProblem(iris.StatusForbidden, schema.ErrInsufficientScope, schema.ErrDetInsufficientScope+": "+permission)
+	}
+	if (p.mfa[permission] || p.mfaRole[claims.Claims.Role]) && !claims.Claims.MFA {
+		return lib.NewProblem(iris.StatusForbidden, schema.ErrMFARequired, schema.ErrDetMFARequired)
+	}
+	return nil
+}
+
+// Permissions the permissions of the role covered by the scope, sorted
+func (p *Policy) Permissions(role string, scope []string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	permissions := make([]string, 0, len(p.granted[role]))
+	for permission := range p.granted[role] {
+		if InScope(scope, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+// knownRole whether the role is built-in, configured or stored
+func (p *Policy) knownRole(role string) (bool, error) {
+	p.mu.RLock()
+	_, granted := p.granted[role]
+	p.mu.RUnlock()
+	if granted || p.repo == nil {
+		return granted, nil
+	}
+	_, err := p.repo.GetRole(role)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (p *Policy) runReload(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := p.Reload(); err != nil {
+			log.Printf("failed to reload the roles and their permissions: %s", err)
+		}
+	}
+}
+
+// endregion =============================================================================
+
+// region ======== HELPERS ===============================================================
+
+// InScope whether one of the scopes covers the permission. The permissions without scopes, e.g. the ones added by
+// configuration, are only covered by a token with all the scopes
+func InScope(scope []string, permission string) bool {
+	covering, ok := PermissionScopes[permission]
+	if !ok {
+		for _, s := range schema.Scopes {
+			if !lib.Contains(scope, s) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, s := range covering {
+		if lib.Contains(scope, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScope the scopes requested at login, space separated as in OAuth 2.0. All the scopes when none is requested
+func ParseScope(requested string) ([]string, *dto.Problem) {
+	fields := strings.Fields(requested)
+	if len(fields) == 0 {
+		return schema.Scopes, nil
+	}
+
+	scope := make([]string, 0, len(fields))
+	for _, s := range fields {
+		if !lib.Contains(schema.Scopes, s) {
+			return nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrInvalidScope, schema.ErrDetInvalidScope+": "+s)
+		}
+		if !lib.Contains(scope, s) {
+			scope = append(scope, s)
+		}
+	}
+	sort.Strings(scope)
+	return scope, nil
+}
+
+// knownPermission whether the permission is a built-in one or is granted by the configuration
+func knownPermission(conf map[string][]string, permission string) bool {
+	if _, ok := PermissionScopes[permission]; ok {
+		return true
+	}
+	for _, permissions := range conf {
+		if lib.Contains(permissions, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildGrants the permissions of every role: the stored ones, or else the configured ones, or else the built-in ones
+func buildGrants(conf map[string][]string, stored []models.RolePermission) map[string]map[string]bool {
+	sets := make(map[string][]string, len(DefaultRolePermissions))
+	for role, permissions := range DefaultRolePermissions {
+		sets[role] = permissions
+	}
+	for role, permissions := range conf {
+		sets[role] = permissions
+	}
+	fromDB := make(map[string][]string)
+	for _, row := range stored {
+		if row.Permission == models.NoPermission { // stored without permissions
+			fromDB[row.Role] = append(fromDB[row.Role], []string{}...)
+			continue
+		}
+		fromDB[row.Role] = append(fromDB[row.Role], row.Permission)
+	}
+	for role, permissions := range fromDB {
+		sets[role] = permissions
+	}
+
+	granted := make(map[string]map[string]bool, len(sets))
+	for role, permissions := range sets {
+		granted[role] = make(map[string]bool, len(permissions))
+		for _, permission := range permissions {
+			granted[role][permission] = true
+		}
+	}
+	granted[models.Role_Invalid] = map[string]bool{} // whatever is configured, the invalidated users can't do anything
+	return granted
+}
+
+// endregion =============================================================================
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/mapper"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPolicyGrants(t *testing.T) {
+	conf := map[string][]string{
+		models.Role_Dean:    {schema.PermDappQuery},        // configured, replaces the built-in set
+		models.Role_Invalid: {schema.PermUsersWrite},       // ignored, the invalidated users can't do anything
+		"auditor":           {schema.PermDappQuery, "x.y"}, // new role
+		"operator":          {"x.y"},                       // new role with a permission without scopes
+		"viewer":            {schema.PermDappQuery},
+	}
+	stored := []models.RolePermission{
+		{Role: "auditor", Permission: schema.PermIdentitiesRead}, // the stored ones win over the configured ones
+		{Role: "auditor", Permission: schema.PermUsersRead},
+		{Role: "viewer", Permission: models.NoPermission}, // stored without permissions, none of the configured ones
+	}
+	policy := &Policy{conf: conf}
+	policy.granted = buildGrants(policy.conf, stored)
+
+	tests := []struct {
+		role       string
+		permission string
+		want       bool
+	}{
+		{models.Role_Rector, schema.PermCertValidate, true},
+		{models.Role_Rector, schema.PermCertCreate, false},
+		{models.Role_CertificateAdmin, schema.PermCertCreate, true},
+		{models.Role_SystemAdmin, schema.PermUsersWrite, true},
+		{models.Role_SystemAdmin, schema.PermCertValidate, false},
+		{models.Role_Dean, schema.PermDappQuery, true},
+		{models.Role_Dean, schema.PermCertValidate, false},
+		{models.Role_Invalid, schema.PermUsersWrite, false},
+		{"auditor", schema.PermUsersRead, true},
+		{"auditor", schema.PermDappQuery, false},
+		{"viewer", schema.PermDappQuery, false},
+		{"unknown", schema.PermDappQuery, false},
+	}
+	for _, tt := range tests {
+		if got := policy.Allowed(tt.role, tt.permission); got != tt.want {
+			t.Errorf("Allowed(%s, %s) = %v, want %v", tt.role, tt.permission, got, tt.want)
+		}
+	}
+
+	if got := policy.Permissions("auditor", schema.Scopes); !reflect.DeepEqual(got, []string{schema.PermIdentitiesRead, schema.PermUsersRead}) {
+		t.Errorf("Permissions(auditor) = %v", got)
+	}
+	if got := policy.Permissions(models.Role_Rector, []string{schema.ScopeCertificatesRead}); !reflect.DeepEqual(got, []string{schema.PermDappQuery}) {
+		t.Errorf("Permissions(rector, certificates:read) = %v", got)
+	}
+
+	checks := []struct {
+		name  string
+		role  string
+		scope []string
+		perm  string
+		want  string // problem title, empty when allowed
+	}{
+		{"role lacks the permission", models.Role_Rector, schema.Scopes, schema.PermUsersRead, schema.ErrForbidden},
+		{"covered by the scope", models.Role_Rector, []string{schema.ScopeCertificatesValidate}, schema.PermCertValidate, ""},
+		{"any covering scope", models.Role_Rector, []string{schema.ScopeCertificatesValidate}, schema.PermCertInvalidate, ""},
+		{"script token can't manage users", models.Role_SystemAdmin, []string{schema.ScopeCertificatesRead}, schema.PermUsersWrite, schema.ErrInsufficientScope},
+		{"unscoped permission with all the scopes", "operator", schema.Scopes, "x.y", ""},
+		{"unscoped permission needs all the scopes", "operator", []string{schema.ScopeUsersAdmin}, "x.y", schema.ErrInsufficientScope},
+	}
+	for _, tt := range checks {
+		t.Run(tt.name, func(t *testing.T) {
+			problem := policy.Check(&dto.AccessTokenData{Scope: tt.scope, Claims: dto.InjectedParam{Username: "tom", Role: tt.role}}, tt.perm)
+			if tt.want == "" && problem != nil || tt.want != "" && (problem == nil || problem.Status != 403 || problem.Title != tt.want) {
+				t.Errorf("Check() = %+v, want %q", problem, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		requested string
+		want      []string
+		invalid   bool
+	}{
+		{"", schema.Scopes, false},
+		{"users:admin certificates:read users:admin", []string{schema.ScopeCertificatesRead, schema.ScopeUsersAdmin}, false},
+		{"certificates:read dapp.fabric", nil, true},
+	}
+	for _, tt := range tests {
+		got, problem := ParseScope(tt.requested)
+		if tt.invalid != (problem != nil) || !tt.invalid && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseScope(%q) = %v, %+v", tt.requested, got, problem)
+		}
+	}
+}
+
+func TestPolicyMFAPermissions(t *testing.T) {
+	conf := &utils.SvcConfig{}
+	conf.RolePermissions = map[string][]string{"auditor": {schema.PermCertValidate, "x.y"}} // a role created after the configuration
+	conf.TOTPEnforcedPermissions = []string{schema.PermCertValidate, "x.y"}
+	conf.TOTPEnforcedRoles = []string{models.Role_Dean}
+	policy, err := NewPolicy(nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		role string
+		perm string
+		mfa  bool
+		want string // problem title, empty when allowed
+	}{
+		{"enforced permission without the second factor", models.Role_Rector, schema.PermCertValidate, false, schema.ErrMFARequired},
+		{"enforced permission with the second factor", models.Role_Rector, schema.PermCertValidate, true, ""},
+		{"any role holding the enforced permission", "auditor", schema.PermCertValidate, false, schema.ErrMFARequired},
+		{"configured enforced permission", "auditor", "x.y", false, schema.ErrMFARequired},
+		{"permission not enforced", models.Role_Rector, schema.PermDappQuery, false, ""},
+		{"enforced role on a permission not enforced", models.Role_Dean, schema.PermDappQuery, false, schema.ErrMFARequired},
+		{"enforced role with the second factor", models.Role_Dean, schema.PermDappQuery, true, ""},
+		// the grant is checked first, the second factor doesn't open anything
+		{"role lacks the permission", models.Role_Rector, schema.PermUsersWrite, true, schema.ErrForbidden},
+	}
+	for _, tt := range tests {
+		problem := policy.Check(&dto.AccessTokenData{Scope: schema.Scopes, Claims: dto.InjectedParam{Username: "tom", Role: tt.role, MFA: tt.mfa}}, tt.perm)
+		if tt.want == "" && problem != nil || tt.want != "" && (problem == nil || problem.Status != 403 || problem.Title != tt.want) {
+			t.Errorf("%s: Check() = %+v, want %q", tt.name, problem, tt.want)
+		}
+	}
+
+	// a misspelled permission would silently disable the second factor
+	conf.TOTPEnforcedPermissions = []string{"certificate.validate"}
+	if _, err = NewPolicy(nil, conf); err == nil {
+		t.Error("NewPolicy() accepted an unknown enforced permission")
+	}
+	conf.TOTPEnforcedPermissions, conf.TOTPEnforcedRoles = nil, []string{"deans"}
+	if _, err = NewPolicy(nil, conf); err == nil {
+		t.Error("NewPolicy() accepted an unknown enforced role")
+	}
+}
+
+func TestPolicyPeriodicReload(t *testing.T) {
+	repoUser, _ := newTestRepos(t)
+	conf := &utils.SvcConfig{}
+	conf.PolicyReloadEvery = 1
+	policy, err := NewPolicy(repoUser, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Allowed("auditor", schema.PermUsersRead) {
+		t.Fatal("the auditor role does not exist yet")
+	}
+
+	// another replica creates the role, this one never calls Reload
+	other := &repo.RepoUser{DB: repoUser.DB}
+	if _, err = other.AddRole(models.Role{Label: "auditor", Name: "Auditor"}, []string{schema.PermUsersRead}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !policy.Allowed("auditor", schema.PermUsersRead) {
+		if time.Now().After(deadline) {
+			t.Fatal("the policy was not reloaded after the change of another replica")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if got := mapper.MapModelUser2DtoUserResponse(models.User{Role: "auditor"}).Role; got != "Auditor" {
+		t.Fatalf("the role name was not reloaded, got %q", got)
+	}
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"crypto/rand"
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/mapper"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"encoding/base64"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+// region ======== SETUP =================================================================
+
+// ISvcToken access and refresh tokens service interface
+type ISvcToken interface {
+	IssueTokens(grant *dto.GrantIntentResponse, ip, userAgent string) (*dto.TokenPair, *dto.Problem)
+	Refresh(refreshToken string) (*dto.TokenPair, *dto.Problem)
+	RevokeUser(username string) *dto.Problem
+	Sessions(username, current string) ([]dto.SessionResponse, *dto.Problem)
+	RevokeSession(username, sessionID string) *dto.Problem
+}
+
+// tokenRepo storage of the refresh tokens and the sessions, implemented by repo.RepoUser
+type tokenRepo interface {
+	GetUserByUsername(username string) (models.User, error)
+	AddRefreshToken(token models.RefreshToken) (models.RefreshToken, error)
+	GetRefreshToken(tokenHash string) (models.RefreshToken, error)
+	UseRefreshToken(tokenID int) (bool, error)
+	RevokeTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(username string) error
+	AddSession(session models.Session) error
+	ExtendSession(sessionID string, expiresAt time.Time) error
+	GetSessions(username string) ([]models.Session, error)
+	RevokeSession(username, sessionID string) (bool, error)
+}
+
+// userBlocklist revokes the access tokens of a user, implemented by repo.RepoBlocklist
+type userBlocklist interface {
+	RevokeUserTokens(username string) error
+}
+
+type svcToken struct {
+	repoUser      tokenRepo
+	repoBlocklist userBlocklist
+	appConf       *utils.SvcConfig
+}
+
+// endregion =============================================================================
+
+// NewSvcToken instantiate the token services
+func NewSvcToken(repoUser *repo.RepoUser, repoBlocklist *repo.RepoBlocklist, svcConf *utils.SvcConfig) ISvcToken {
+	return newSvcToken(repoUser, repoBlocklist, svcConf)
+}
+
+func newSvcToken(repoUser tokenRepo, repoBlocklist userBlocklist, svcConf *utils.SvcConfig) *svcToken {
+	return &svcToken{repoUser, repoBlocklist, svcConf}
+}
+
+// region ======== METHODS ======================================================
+
+// IssueTokens create the access token and the refresh token of a new token family, after a successful login. The
+// family is recorded as a new session of the user
+//
+// - grant [*dto.GrantIntentResponse] ~ Authenticated user
+//
+// - ip [string] ~ Remote address of the login request
+//
+// - userAgent [string] ~ User-Agent header of the login request
+func (s *svcToken) IssueTokens(grant *dto.GrantIntentResponse, ip, userAgent string) (*dto.TokenPair, *dto.Problem) {
+	familyID, err := randomToken()
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrJwtGen, err.Error())
+	}
+	now := time.Now()
+	session := models.Session{
+		ID:         familyID,
+		Username:   grant.Username,
+		IP:         ip,
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(time.Duration(s.appConf.RefreshTkMaxAge) * time.Hour),
+	}
+	if err = s.repoUser.AddSession(session); err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return s.issue(grant, familyID)
+}
+
+// Refresh exchange a refresh token for a new token pair of the same family. The refresh tokens are single use: if a
+// used token is presented again it was stolen or leaked, so the whole family is revoked
+func (s *svcToken) Refresh(refreshToken string) (*dto.TokenPair, *dto.Problem) {
+	token, err := s.repoUser.GetRefreshToken(hashToken(refreshToken))
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrDetInvalidRefreshTk)
+	}
+	if token.RevokedAt != nil || token.UsedAt != nil {
+		s.revokeFamily(token, "reused refresh token")
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrDetInvalidRefreshTk)
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrDetInvalidRefreshTk)
+	}
+
+	used, err := s.repoUser.UseRefreshToken(token.ID)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if !used { // a concurrent request used it first
+		s.revokeFamily(token, "concurrently reused refresh token")
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrDetInvalidRefreshTk)
+	}
+
+	// the claims are read again, so a role change or an invalidation reach the new access token
+	user, err := s.repoUser.GetUserByUsername(token.Username)
+	if err != nil || user.Role == models.Role_Invalid {
+		s.revokeFamily(token, "user removed or invalidated")
+		return nil, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrDetInvalidRefreshTk)
+	}
+
+	pair, problem := s.issue(&dto.GrantIntentResponse{Username: user.Username, Role: user.Role, MFA: token.MFA, Scope: strings.Fields(token.Scope)}, token.FamilyID)
+	if problem != nil {
+		return nil, problem
+	}
+	if err = s.repoUser.ExtendSession(token.FamilyID, time.Now().Add(time.Duration(pair.RefreshExpiresIn)*time.Second)); err != nil {
+		log.Printf("failed to extend the session of the user %s: %s", token.Username, err)
+	}
+	return pair, nil
+}
+
+// RevokeUser revoke all the access and refresh tokens, and so the sessions, of the user on every API replica
+func (s *svcToken) RevokeUser(username string) *dto.Problem {
+	if err := s.repoBlocklist.RevokeUserTokens(username); err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if err := s.repoUser.RevokeUserRefreshTokens(username); err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return nil
+}
+
+// Sessions the active sessions of the user, the last used first
+//
+// - username [string] ~ User of the sessions
+//
+// - current [string] ~ Session of the request, flagged in the response
+func (s *svcToken) Sessions(username, current string) ([]dto.SessionResponse, *dto.Problem) {
+	sessions, err := s.repoUser.GetSessions(username)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	res := make([]dto.SessionResponse, len(sessions))
+	for i, session := range sessions {
+		res[i] = mapper.MapModelSession2DtoSessionResponse(session, current)
+	}
+	return res, nil
+}
+
+// RevokeSession revoke the session of the user: its refresh tokens stop working and its access tokens are refused
+// right away
+func (s *svcToken) RevokeSession(username, sessionID string) *dto.Problem {
+	revoked, err := s.repoUser.RevokeSession(username, sessionID)
+	if err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if !revoked {
+		return lib.NewProblem(iris.StatusNotFound, schema.ErrNotFound, schema.ErrDetSessionNotFound)
+	}
+	return nil
+}
+
+// endregion =============================================================================
+
+// region ======== HELPERS ======================================================
+
+func (s *svcToken) issue(grant *dto.GrantIntentResponse, familyID string) (*dto.TokenPair, *dto.Problem) {
+	tokenData := mapper.ToAccessTokenDataV(grant)
+	tokenData.Session = familyID
+	accessToken, err := lib.MkAccessToken(tokenData, s.appConf.JWT, s.appConf.TkMaxAge)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrJwtGen, err.Error())
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrJwtGen, err.Error())
+	}
+	refreshMaxAge := time.Duration(s.appConf.RefreshTkMaxAge) * time.Hour
+	_, err = s.repoUser.AddRefreshToken(models.RefreshToken{
+		TokenHash: hashToken(refreshToken),
+		FamilyID:  familyID,
+		Username:  grant.Username,
+		MFA:       grant.MFA,
+		Scope:     strings.Join(grant.Scope, " "),
+		ExpiresAt: time.Now().Add(refreshMaxAge),
+	})
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+
+	return &dto.TokenPair{
+		AccessToken:      string(accessToken),
+		TokenType:        "Bearer",
+		ExpiresIn:        int(s.appConf.TkMaxAge) * 60,
+		RefreshToken:     refreshToken,
+		RefreshExpiresIn: int(refreshMaxAge.Seconds()),
+	}, nil
+}
+
+func (s *svcToken) revokeFamily(token models.RefreshToken, reason string) {
+	log.Printf("revoking the refresh token family of the user %s: %s", token.Username, reason)
+	if err := s.repoUser.RevokeTokenFamily(token.FamilyID); err != nil {
+		log.Printf("failed to revoke the refresh token family of the user %s: %s", token.Username, err)
+	}
+}
+
+// randomToken returns 32 random bytes, base64 url encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken only the hash of the refresh tokens is stored, a database leak doesn't leak usable tokens
+func hashToken(token string) string {
+	checksum, _ := lib.Checksum(lib.SHA256, []byte(token))
+	return checksum
+}
+
+// endregion =============================================================================
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestRepos the user repository and the blocklist over a migrated SQLite database of the test
+func newTestRepos(t *testing.T) (*repo.RepoUser, *repo.RepoBlocklist) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "users.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return &repo.RepoUser{DB: db}, &repo.RepoBlocklist{DB: db}
+}
+
+// newTestTokenConf configuration of the token service, signing with an HS256 key
+func newTestTokenConf(t *testing.T) *utils.SvcConfig {
+	t.Helper()
+	t.Setenv("TEST_JWT_KEY", strings.Repeat("s", 32))
+	keys, err := lib.LoadJWTKeys(nil, "", "TEST_JWT_KEY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &utils.SvcConfig{JWT: keys}
+	conf.TkMaxAge, conf.RefreshTkMaxAge = 15, 24
+	return conf
+}
+
+// racedRepo a concurrent request uses every refresh token right before this one
+type racedRepo struct {
+	*repo.RepoUser
+}
+
+func (r racedRepo) UseRefreshToken(tokenID int) (bool, error) {
+	_, _ = r.RepoUser.UseRefreshToken(tokenID)
+	return r.RepoUser.UseRefreshToken(tokenID)
+}
+
+func TestSvcTokenRefresh(t *testing.T) {
+	repoUser, blocklist := newTestRepos(t)
+	repoUser.DB.Create(&models.User{Username: "richard", Role: models.Role_Rector})
+	svc := newSvcToken(repoUser, blocklist, newTestTokenConf(t))
+	grant := &dto.GrantIntentResponse{Username: "richard", Role: models.Role_Rector, MFA: true, Scope: []string{"certificates:read"}}
+
+	first, problem := svc.IssueTokens(grant, "10.0.0.1", "test")
+	if problem != nil {
+		t.Fatalf("IssueTokens() = %+v", problem)
+	}
+
+	// rotation: the refresh token is exchanged for a new pair of the same session
+	second, problem := svc.Refresh(first.RefreshToken)
+	if problem != nil || second.RefreshToken == first.RefreshToken || second.AccessToken == "" {
+		t.Fatalf("Refresh() = %+v, %+v", second, problem)
+	}
+	rotated, _ := repoUser.GetRefreshToken(hashToken(second.RefreshToken))
+	if !rotated.MFA || rotated.Scope != "certificates:read" {
+		t.Errorf("refreshed token = %+v, it must keep the MFA and the scope of the family", rotated)
+	}
+	if sessions, _ := repoUser.GetSessions("richard"); len(sessions) != 1 || sessions[0].ID != rotated.FamilyID {
+		t.Fatalf("sessions = %+v, want the one of the family", sessions)
+	}
+
+	// reuse detection: the used token revokes the whole family, the last token included
+	if _, problem = svc.Refresh(first.RefreshToken); problem == nil || problem.Status != http.StatusUnauthorized {
+		t.Errorf("Refresh() of a used token = %+v, want 401", problem)
+	}
+	if _, problem = svc.Refresh(second.RefreshToken); problem == nil {
+		t.Error("Refresh() after a reuse must fail, the family is revoked")
+	}
+	if sessions, _ := repoUser.GetSessions("richard"); len(sessions) != 0 {
+		t.Errorf("sessions = %+v, the session of the revoked family must be revoked", sessions)
+	}
+
+	if _, problem = svc.Refresh("unknown"); problem == nil || problem.Status != http.StatusUnauthorized {
+		t.Errorf("Refresh() of an unknown token = %+v, want 401", problem)
+	}
+}
+
+func TestSvcTokenRefreshConcurrent(t *testing.T) {
+	repoUser, blocklist := newTestRepos(t)
+	repoUser.DB.Create(&models.User{Username: "richard", Role: models.Role_Rector})
+	svc := newSvcToken(racedRepo{repoUser}, blocklist, newTestTokenConf(t))
+
+	pair, problem := svc.IssueTokens(&dto.GrantIntentResponse{Username: "richard", Role: models.Role_Rector}, "10.0.0.1", "test")
+	if problem != nil {
+		t.Fatalf("IssueTokens() = %+v", problem)
+	}
+	if _, problem = svc.Refresh(pair.RefreshToken); problem == nil || problem.Status != http.StatusUnauthorized {
+		t.Errorf("Refresh() losing the race = %+v, want 401", problem)
+	}
+	token, _ := repoUser.GetRefreshToken(hashToken(pair.RefreshToken))
+	if token.RevokedAt == nil {
+		t.Error("the family of a token used twice at once must be revoked")
+	}
+}
+
+func TestSvcTokenRefreshRevoked(t *testing.T) {
+	repoUser, blocklist := newTestRepos(t)
+	repoUser.DB.Create(&models.User{Username: "richard", Role: models.Role_Rector})
+	svc := newSvcToken(repoUser, blocklist, newTestTokenConf(t))
+	grant := &dto.GrantIntentResponse{Username: "richard", Role: models.Role_Rector}
+
+	// expired
+	expired, _ := svc.IssueTokens(grant, "10.0.0.1", "test")
+	repoUser.DB.Model(&models.RefreshToken{}).Where("token_hash = ?", hashToken(expired.RefreshToken)).
+		Update("expires_at", time.Now().Add(-time.Minute))
+	if _, problem := svc.Refresh(expired.RefreshToken); problem == nil || problem.Status != http.StatusUnauthorized {
+		t.Errorf("Refresh() of an expired token = %+v, want 401", problem)
+	}
+
+	// the user was invalidated after the login
+	pair, _ := svc.IssueTokens(grant, "10.0.0.1", "test")
+	repoUser.DB.Model(&models.User{}).Where("username = ?", "richard").Update("role", models.Role_Invalid)
+	if _, problem := svc.Refresh(pair.RefreshToken); problem == nil || problem.Status != http.StatusUnauthorized {
+		t.Errorf("Refresh() of an invalidated user = %+v, want 401", problem)
+	}
+	token, _ := repoUser.GetRefreshToken(hashToken(pair.RefreshToken))
+	if token.RevokedAt == nil {
+		t.Error("the family of an invalidated user must be revoked")
+	}
+}
+
+func TestSvcTokenSessions(t *testing.T) {
+	repoUser, blocklist := newTestRepos(t)
+	repoUser.DB.Create(&[]models.User{{Username: "richard", Role: models.Role_Rector}, {Username: "tom", Role: models.Role_Dean}})
+	svc := newSvcToken(repoUser, blocklist, newTestTokenConf(t))
+	grant := &dto.GrantIntentResponse{Username: "richard", Role: models.Role_Rector}
+
+	laptop, _ := svc.IssueTokens(grant, "10.0.0.1", "laptop")
+	phone, _ := svc.IssueTokens(grant, "10.0.0.2", "phone")
+	other, _ := svc.IssueTokens(&dto.GrantIntentResponse{Username: "tom", Role: models.Role_Dean}, "10.0.0.3", "tom")
+	laptopSession := sessionOf(t, repoUser, laptop)
+	phoneSession := sessionOf(t, repoUser, phone)
+
+	sessions, problem := svc.Sessions("richard", laptopSession)
+	if problem != nil || len(sessions) != 2 {
+		t.Fatalf("Sessions() = %+v, %+v, want the 2 of richard", sessions, problem)
+	}
+	for _, session := range sessions {
+		if session.Current != (session.ID == laptopSession) {
+			t.Errorf("session %s current = %t, only the one of the request is current", session.UserAgent, session.Current)
+		}
+	}
+
+	// revoke one: only its tokens stop working
+	if problem = svc.RevokeSession("richard", phoneSession); problem != nil {
+		t.Fatalf("RevokeSession() = %+v", problem)
+	}
+	if err := blocklist.ValidateSession(phoneSession); err != jwt.ErrBlocked {
+		t.Errorf("ValidateSession() of the revoked session = %v, want ErrBlocked", err)
+	}
+	if _, problem = svc.Refresh(phone.RefreshToken); problem == nil {
+		t.Error("Refresh() of a revoked session must fail")
+	}
+	if err := blocklist.ValidateSession(laptopSession); err != nil {
+		t.Errorf("ValidateSession() of another session = %v", err)
+	}
+	if problem = svc.RevokeSession("richard", phoneSession); problem == nil || problem.Status != http.StatusNotFound {
+		t.Errorf("RevokeSession() twice = %+v, want 404", problem)
+	}
+
+	// the session of another user is not found, as the one of the path user for an admin
+	if problem = svc.RevokeSession("richard", sessionOf(t, repoUser, other)); problem == nil || problem.Status != http.StatusNotFound {
+		t.Errorf("RevokeSession() of the session of another user = %+v, want 404", problem)
+	}
+	if sessions, _ = svc.Sessions("tom", ""); len(sessions) != 1 || sessions[0].Current {
+		t.Errorf("Sessions() of tom = %+v, want its session, none current", sessions)
+	}
+
+	// revoke all
+	if problem = svc.RevokeUser("richard"); problem != nil {
+		t.Fatalf("RevokeUser() = %+v", problem)
+	}
+	if sessions, _ = svc.Sessions("richard", ""); len(sessions) != 0 {
+		t.Errorf("Sessions() after revoking all = %+v, want none", sessions)
+	}
+	if err := blocklist.ValidateSession(laptopSession); err != jwt.ErrBlocked {
+		t.Errorf("ValidateSession() after revoking all = %v, want ErrBlocked", err)
+	}
+	if sessions, _ = svc.Sessions("tom", ""); len(sessions) != 1 {
+		t.Error("revoking all the sessions of richard revoked the one of tom")
+	}
+}
+
+// sessionOf the session of the token pair, the family of its refresh token
+func sessionOf(t *testing.T, repoUser *repo.RepoUser, pair *dto.TokenPair) string {
+	t.Helper()
+	token, err := repoUser.GetRefreshToken(hashToken(pair.RefreshToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.FamilyID
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"crypto/rand"
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+// region ======== SETUP =================================================================
+
+const (
+	totpSkew          = 1  // steps accepted before and after the current one, for the clock drift of the authenticators
+	recoveryCodeCount = 10 // recovery codes given on enrolment
+	recoveryCodeSize  = 10 // characters of a recovery code, without the separator
+)
+
+// errTOTPKeyMissing the TOTP secrets are sealed with the wallet key-encryption key, it has to be configured
+var errTOTPKeyMissing = errors.New("no key-encryption key to seal the TOTP secrets, set " + schema.EnvWalletKEK + " or WalletKEKFile")
+
+// recoveryAlphabet 32 characters, without the ones easily confused when typed (0/O, 1/I). Every character of a
+// recovery code takes 5 random bits, so the 10 characters codes have 50 bits
+const recoveryAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// ISvcTOTP TOTP second factor service interface
+type ISvcTOTP interface {
+	Enrol(username string) (*dto.TOTPEnrolment, *dto.Problem)
+	Confirm(username, code string) (*dto.RecoveryCodes, *dto.Problem)
+	RegenerateRecoveryCodes(username string) (*dto.RecoveryCodes, *dto.Problem)
+	Reset(username string) *dto.Problem
+}
+
+type svcTOTP struct {
+	repo   *repo.RepoUser
+	issuer string
+	kek    []byte // key-encryption key of the wallet, seals the TOTP secrets at rest
+}
+
+// endregion =============================================================================
+
+// NewSvcTOTP instantiate the TOTP second factor services. It panics when the second factor is enforced, by the
+// TOTPEnforcedPermissions or the TOTPEnforcedRoles, and the wallet key-encryption key that seals the secrets is missing:
+// nobody could enrol, so the enforced endpoints would refuse everyone
+func NewSvcTOTP(repoUser *repo.RepoUser, svcConf *utils.SvcConfig) ISvcTOTP {
+	return newSvcTOTP(repoUser, svcConf)
+}
+
+func newSvcTOTP(repoUser *repo.RepoUser, svcConf *utils.SvcConfig) *svcTOTP {
+	kek, err := lib.LoadKey(schema.EnvWalletKEK, svcConf.WalletKEKFile)
+	if err != nil {
+		if len(svcConf.TOTPEnforcedPermissions) > 0 || len(svcConf.TOTPEnforcedRoles) > 0 {
+			panic(fmt.Errorf("the TOTP second factor is enforced (TOTPEnforcedPermissions, TOTPEnforcedRoles) but the wallet key-encryption key is missing: %s", err))
+		}
+		log.Printf("the TOTP second factor can't be used without the wallet key-encryption key: %s", err)
+	}
+	return &svcTOTP{repo: repoUser, issuer: svcConf.TOTPIssuer, kek: kek}
+}
+
+// region ======== METHODS ===============================================================
+
+// Enrol start the TOTP enrolment of the user with a new secret. Until it is confirmed the logins don't ask for the
+// code, and enrolling again replaces the pending secret
+func (s *svcTOTP) Enrol(username string) (*dto.TOTPEnrolment, *dto.Problem) {
+	secret, err := lib.NewTOTPSecret()
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
+	sealed, err := s.sealSecret(username, secret)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
+	saved, err := s.repo.SavePendingTOTP(models.UserTOTP{Username: username, Secret: sealed, CreatedAt: time.Now()})
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if !saved {
+		return nil, lib.NewProblem(iris.StatusConflict, schema.ErrDuplicateKey, schema.ErrDetTOTPEnrolled)
+	}
+
+	return &dto.TOTPEnrolment{Secret: secret, URI: lib.TOTPURI(s.issuer, username, secret)}, nil
+}
+
+// Confirm enable the pending TOTP enrolment of the user with a first code of the authenticator app. Returns the
+// recovery codes, they are only shown once
+func (s *svcTOTP) Confirm(username, code string) (*dto.RecoveryCodes, *dto.Problem) {
+	totp, found, err := s.repo.GetTOTP(username)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if !found || totp.Enabled {
+		return nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, schema.ErrDetTOTPNotEnrolled)
+	}
+	secret, err := s.openSecret(totp)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
+	step, ok := lib.ValidateTOTP(secret, code, time.Now(), totpSkew)
+	if !ok {
+		return nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrVal, schema.ErrDetInvalidOTP)
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
+	enabled, err := s.repo.EnableTOTP(username, totp.Secret, step, hashes)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if !enabled {
+		return nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, schema.ErrDetTOTPNotEnrolled)
+	}
+	return &dto.RecoveryCodes{Codes: codes}, nil
+}
+
+// RegenerateRecoveryCodes replace the recovery codes of the user, the previous ones stop working
+func (s *svcTOTP) RegenerateRecoveryCodes(username string) (*dto.RecoveryCodes, *dto.Problem) {
+	totp, found, err := s.repo.GetTOTP(username)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if !found || !totp.Enabled {
+		return nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, schema.ErrDetTOTPNotEnrolled)
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
+	if err = s.repo.ReplaceRecoveryCodes(username, hashes); err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return &dto.RecoveryCodes{Codes: codes}, nil
+}
+
+// Reset remove the TOTP second factor and the recovery codes of the user, e.g. when the authenticator and the
+// recovery codes are lost. The user logs in with the password only and can enrol again
+func (s *svcTOTP) Reset(username string) *dto.Problem {
+	if err := s.repo.RemoveTOTP(username); err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return nil
+}
+
+// secondFactor check the TOTP or recovery code of the user, if it has the second factor enrolled. Returns whether the
+// login proved a second factor
+func (s *svcTOTP) secondFactor(username, otp string) (bool, *dto.Problem) {
+	totp, found, err := s.repo.GetTOTP(username)
+	if err != nil {
+		return false, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if !found || !totp.Enabled {
+		return false, nil
+	}
+	if otp == "" {
+		return false, lib.NewProblem(iris.StatusUnauthorized, schema.ErrOTPRequired, schema.ErrDetOTPRequired)
+	}
+
+	secret, err := s.openSecret(totp)
+	if err != nil {
+		return false, lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
+
+	var used bool
+	if code, ok := normalizeRecoveryCode(otp); ok {
+		used, err = s.repo.UseRecoveryCode(username, hashToken(code))
+	} else if step, ok := lib.ValidateTOTP(secret, otp, time.Now(), totpSkew); ok {
+		used, err = s.repo.UseTOTPStep(username, step)
+	}
+	if err != nil {
+		return false, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if !used { // wrong code, or already used
+		return false, lib.NewProblem(iris.StatusUnauthorized, schema.ErrUnauthorized, schema.ErrDetInvalidOTP)
+	}
+	return true, nil
+}
+
+// endregion =============================================================================
+
+// region ======== HELPERS ===============================================================
+
+// sealSecret the TOTP secret sealed with the key-encryption key and base64 encoded. The username is authenticated
+// along, a sealed secret can't be moved to another user
+func (s *svcTOTP) sealSecret(username, secret string) (string, error) {
+	if s.kek == nil {
+		return "", errTOTPKeyMissing
+	}
+	sealed, err := lib.Seal(s.kek, []byte(secret), []byte(username))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openSecret the base32 TOTP secret of the sealed one stored for the user
+func (s *svcTOTP) openSecret(totp models.UserTOTP) (string, error) {
+	if s.kek == nil {
+		return "", errTOTPKeyMissing
+	}
+	sealed, err := base64.StdEncoding.DecodeString(totp.Secret)
+	if err != nil {
+		return "", err
+	}
+	secret, err := lib.Open(s.kek, sealed, []byte(totp.Username))
+	return string(secret), err
+}
+
+// newRecoveryCodes returns the recovery codes, formatted as XXXXX-XXXXX, and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		random := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, err
+		}
+		code := make([]byte, recoveryCodeSize)
+		for j, b := range random {
+			code[j] = recoveryAlphabet[int(b)%len(recoveryAlphabet)]
+		}
+		codes[i] = string(code[:recoveryCodeSize/2]) + "-" + string(code[recoveryCodeSize/2:])
+		hashes[i] = hashToken(string(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode the recovery code without separators and in upper case. Returns false if the code doesn't
+// look like a recovery code, e.g. it is a TOTP code
+func normalizeRecoveryCode(code string) (string, bool) {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != recoveryCodeSize {
+		return "", false
+	}
+	for _, c := range code {
+		if !strings.ContainsRune(recoveryAlphabet, c) {
+			return "", false
+		}
+	}
+	return code, true
+}
+
+// endregion =============================================================================
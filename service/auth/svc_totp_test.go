@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"bytes"
+	"dapp/lib"
+	"dapp/schema"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		// the code is typed as shown, or in lower case, or without the separator
+		for _, typed := range []string{code, strings.ToLower(code), strings.ReplaceAll(code, "-", "")} {
+			normalized, ok := normalizeRecoveryCode(typed)
+			if !ok || hashToken(normalized) != hashes[i] {
+				t.Errorf("the typed recovery code %q does not match its hash", typed)
+			}
+		}
+		if seen[code] {
+			t.Errorf("duplicated recovery code %s", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for _, otp := range []string{"287082", "", "ABCDE-FGHI", "ABCDE-FGHI0", "ABCDE-FGHIJK"} {
+		if _, ok := normalizeRecoveryCode(otp); ok {
+			t.Errorf("%q must not be taken as a recovery code", otp)
+		}
+	}
+}
+
+func TestSealTOTPSecret(t *testing.T) {
+	s := &svcTOTP{kek: bytes.Repeat([]byte{7}, lib.KeySize)}
+	secret, _ := lib.NewTOTPSecret()
+	sealed, err := s.sealSecret("richard", secret)
+	if err != nil || strings.Contains(sealed, secret) {
+		t.Fatalf("sealSecret() = %s, %v", sealed, err)
+	}
+	if opened, err := s.openSecret(models.UserTOTP{Username: "richard", Secret: sealed}); err != nil || opened != secret {
+		t.Errorf("openSecret() = %s, %v, want %s", opened, err, secret)
+	}
+	if _, err = s.openSecret(models.UserTOTP{Username: "tom", Secret: sealed}); err == nil {
+		t.Error("the sealed secret of a user must not open for another one")
+	}
+	if _, err = (&svcTOTP{}).sealSecret("richard", secret); err == nil {
+		t.Error("sealSecret() without the key-encryption key must fail")
+	}
+}
+
+func TestNewSvcTOTPRequiresKEK(t *testing.T) {
+	tests := []struct {
+		name        string
+		kek         string
+		permissions []string
+		roles       []string
+		panics      bool
+	}{
+		{"enforced permission", "", []string{schema.PermCertValidate}, nil, true},
+		{"enforced role", "", []string{}, []string{models.Role_Rector}, true},
+		{"not enforced", "", []string{}, nil, false},
+		{"enforced with the key", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, lib.KeySize)), []string{schema.PermCertValidate}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Setenv(schema.EnvWalletKEK, tt.kek) // an empty key is not a valid one
+		conf := &utils.SvcConfig{}
+		conf.TOTPEnforcedPermissions, conf.TOTPEnforcedRoles = tt.permissions, tt.roles
+		func() {
+			defer func() {
+				if panicked := recover() != nil; panicked != tt.panics {
+					t.Errorf("%s: newSvcTOTP() panicked = %v, want %v", tt.name, panicked, tt.panics)
+				}
+			}()
+			newSvcTOTP(nil, conf)
+		}()
+	}
+}
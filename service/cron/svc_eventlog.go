@@ -2,6 +2,7 @@ package cron
 
 import (
 	"dapp/repo"
+	"dapp/service"
 	"dapp/service/utils"
 	"github.com/go-co-op/gocron"
 	"log"
@@ -14,8 +15,9 @@ type ISvcEventLog interface {
 }
 
 type svcEventLogReqs struct {
-	svcConf  *utils.SvcConfig
-	repoDapp *repo.RepoDapp
+	svcConf     *utils.SvcConfig
+	repoDapp    *repo.RepoDapp
+	svcIdentity service.ISvcIdentity
 }
 
 // endregion =============================================================================
@@ -23,20 +25,36 @@ type svcEventLogReqs struct {
 // NewSvcRepoEventLog instantiate the Dapp request services
 func NewSvcRepoEventLog(svcConf *utils.SvcConfig) ISvcEventLog {
 	repoDapp := repo.NewRepoDapp(svcConf)
-	return &svcEventLogReqs{svcConf, repoDapp}
+	svcIdentity := service.NewSvcIdentityReqs(svcConf, repoDapp)
+	return &svcEventLogReqs{svcConf, repoDapp, svcIdentity}
 }
 
 // MeinerCronJob periodic task
 func (e svcEventLogReqs) MeinerCronJob() error {
+	cron := gocron.NewScheduler(time.UTC)
+	scheduled := false
+
 	// cron job is started only if it is active in configuration
 	if e.svcConf.CronEnabled {
 		log.Printf("schedules a new periodic Job with an interval: %d seconds", e.svcConf.EveryTime)
-		cron := gocron.NewScheduler(time.UTC)
-
 		_, err := cron.Every(e.svcConf.EveryTime).Seconds().WaitForSchedule().Do(e.doFunc)
 		if err != nil {
 			return err
 		}
+		scheduled = true
+	}
+
+	// the identities are checked right away, so an identity about to expire is reported on startup
+	if e.svcConf.IdentityCheckEnabled {
+		log.Printf("schedules the identity expiry check with an interval: %d seconds", e.svcConf.IdentityCheckEvery)
+		_, err := cron.Every(e.svcConf.IdentityCheckEvery).Seconds().Do(e.checkIdentities)
+		if err != nil {
+			return err
+		}
+		scheduled = true
+	}
+
+	if scheduled {
 		// starts the scheduler asynchronously
 		cron.StartAsync()
 	}
@@ -48,3 +66,9 @@ func (e svcEventLogReqs) doFunc() {
 
 	log.Println("cron job ending")
 }
+
+func (e svcEventLogReqs) checkIdentities() {
+	if _, problem := e.svcIdentity.CheckExpiry(); problem != nil {
+		log.Printf("identity expiry check failed: %s", problem.Detail)
+	}
+}
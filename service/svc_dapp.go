@@ -7,6 +7,10 @@ import (
 	"dapp/schema/dto"
 	"dapp/schema/mapper"
 	"dapp/schema/models"
+	"dapp/service/auth"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"time"
 
@@ -19,7 +23,8 @@ import (
 // ISvcDapp Dapp request service interface
 type ISvcDapp interface {
 	Query(query dto.Transaction, did string) (interface{}, *dto.Problem)
-	Invoke(req dto.Transaction, did string) (interface{}, *dto.Problem)
+	Invoke(req dto.Transaction, userParam *dto.InjectedParam) (interface{}, *dto.Problem)
+	Simulate(req dto.Transaction, userParam *dto.InjectedParam) (interface{}, *dto.Problem)
 	GetAsset(id string, did string, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem)
 	GetAssetsByState(status int, did string, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem)
 	GetAssetsByAccredited(accredited string, did string, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem)
@@ -27,18 +32,42 @@ type ISvcDapp interface {
 	UpdateAsset(req *dto.Asset, did string, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem)
 	ValidateAsset(req *dto.SignAsset, userParam *dto.InjectedParam, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem)
 	InvalidateAsset(req *dto.InvalidateAsset, userParam *dto.InjectedParam, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem)
-	DeleteAsset(id string, did string, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem)
+	DeleteAsset(id string, userParam *dto.InjectedParam, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem)
+	EndorseOffline(req *dto.TxDataRequest, tkData *dto.AccessTokenData, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem)
+	SubmitOffline(req *dto.TxDataRequest, tkData *dto.AccessTokenData, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem)
 }
 
 type svcDapp struct {
 	repoDapp *repo.RepoDapp
+	policy   *auth.Policy // checks the function of the transactions signed offline
+}
+
+// adminOperations roles whose requests run each administrative ledger operation with the dapp admin identity. The
+// generic transactions of a system admin always run with the dapp admin identity
+var adminOperations = map[string][]string{
+	schema.DeleteAsset:     {models.Role_CertificateAdmin},
+	schema.InvalidateAsset: {models.Role_Secretary, models.Role_Dean, models.Role_Rector, models.Role_CertificateAdmin},
+}
+
+// offlineFunctionPermissions permission required to sign offline each chaincode function, the same as its endpoint.
+// Any other function requires the permission of the generic transactions
+var offlineFunctionPermissions = map[string]string{
+	schema.CreateAsset:     schema.PermCertCreate,
+	schema.UpdateAsset:     schema.PermCertUpdate,
+	schema.ValidateAsset:   schema.PermCertValidate,
+	schema.InvalidateAsset: schema.PermCertInvalidate,
+	schema.DeleteAsset:     schema.PermCertDelete,
 }
 
 // endregion =============================================================================
 
 // NewSvcDappReqs instantiate the Dapp request services
-func NewSvcDappReqs(repoDapp *repo.RepoDapp) ISvcDapp {
-	return &svcDapp{repoDapp}
+//
+// - repoDapp [*RepoDapp] ~ Dapp repository instance pointer
+//
+// - policy [*auth.Policy] ~ Permissions of the roles, the transactions signed offline are checked against them
+func NewSvcDappReqs(repoDapp *repo.RepoDapp, policy *auth.Policy) ISvcDapp {
+	return &svcDapp{repoDapp, policy}
 }
 
 // region ======== METHODS ======================================================
@@ -47,18 +76,20 @@ func (s *svcDapp) Query(query dto.Transaction, did string) (interface{}, *dto.Pr
 	// requesting blockchain ledger
 	raw, e := s.repoDapp.Query(query, did)
 	if e != nil {
-		return nil, lib.NewProblem(iris.StatusBadGateway, schema.ErrBlockchainTxs, e.Error())
+		return nil, ledgerProblem(e)
 	}
 
 	result := mapper.DecodePayload(raw)
 	return result, nil
 }
 
-func (s *svcDapp) Invoke(req dto.Transaction, did string) (interface{}, *dto.Problem) {
+func (s *svcDapp) Invoke(req dto.Transaction, userParam *dto.InjectedParam) (interface{}, *dto.Problem) {
+	req.AsAdmin = useAdminIdentity(req.Function, userParam.Role)
+
 	// requesting blockchain ledger
-	result, e := (*s.repoDapp).Invoke(req, did)
+	result, e := (*s.repoDapp).Invoke(req, userParam.Username)
 	if e != nil {
-		return nil, lib.NewProblem(iris.StatusBadGateway, schema.ErrBlockchainTxs, e.Error())
+		return nil, ledgerProblem(e)
 	}
 	dPayload := mapper.DecodePayload(result)
 	qResult := dto.TxReceipt{
@@ -69,12 +100,135 @@ func (s *svcDapp) Invoke(req dto.Transaction, did string) (interface{}, *dto.Pro
 			ReqOffset:     "",
 			ReqID:         "",
 		}},
+		Identity:        s.repoDapp.SignerIdentity(req),
 		ResponsePayload: dPayload,
 	}
 
 	return qResult, nil
 }
 
+func (s *svcDapp) Simulate(req dto.Transaction, userParam *dto.InjectedParam) (interface{}, *dto.Problem) {
+	req.AsAdmin = useAdminIdentity(req.Function, userParam.Role)
+	return s.simulate(req, userParam.Username)
+}
+
+func (s *svcDapp) simulate(req dto.Transaction, did string) (interface{}, *dto.Problem) {
+	// requesting blockchain endorsements only (dry run)
+	result, simulation, e := (*s.repoDapp).Simulate(req, did)
+	if e != nil {
+		return nil, ledgerProblem(e)
+	}
+	simulation.ReplyCommon = dto.ReplyCommon{Headers: dto.ReplyHeaders{
+		CommonHeaders: req.Headers.CommonHeaders,
+	}}
+	simulation.Identity = s.repoDapp.SignerIdentity(req)
+	simulation.ResponsePayload = mapper.DecodePayload(result)
+
+	return *simulation, nil
+}
+
+// submit send the transaction to the ledger. According to the query params, it can also be only simulated (dry run)
+// or only prepared to be signed offline by the client. Set tx.AsAdmin before, to sign it with the dapp admin identity
+func (s *svcDapp) submit(tx dto.Transaction, did string, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem) {
+	if queryParams.Offline != nil {
+		return s.prepareOffline(tx, queryParams.Offline)
+	}
+	if queryParams.DryRun {
+		return s.simulate(tx, did)
+	}
+
+	// requesting blockchain ledger
+	result, e := (*s.repoDapp).Invoke(tx, did)
+	if e != nil {
+		return nil, ledgerProblem(e)
+	}
+	dPayload := mapper.DecodePayload(result)
+	qResult := dto.TxReceipt{
+		ReplyCommon: dto.ReplyCommon{Headers: dto.ReplyHeaders{
+			CommonHeaders: tx.Headers.CommonHeaders,
+		}},
+		Identity:        s.repoDapp.SignerIdentity(tx),
+		ResponsePayload: dPayload,
+	}
+	return qResult, nil
+}
+
+// prepareOffline build the unsigned proposal of the transaction for the client identity
+func (s *svcDapp) prepareOffline(tx dto.Transaction, identity *dto.OfflineIdentity) (interface{}, *dto.Problem) {
+	block, _ := pem.Decode([]byte(identity.Certificate))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrCryptProc, schema.ErrDetInvalidCert)
+	}
+
+	proposal, e := (*s.repoDapp).PrepareProposal(tx, identity.MspID, []byte(identity.Certificate))
+	if e != nil {
+		return nil, ledgerProblem(e)
+	}
+	proposal.ReplyCommon = dto.ReplyCommon{Headers: dto.ReplyHeaders{
+		CommonHeaders: tx.Headers.CommonHeaders,
+	}}
+
+	return *proposal, nil
+}
+
+// EndorseOffline endorse the proposal signed by the client. The user must have the permission of the chaincode function
+// it invokes, as on its endpoint
+func (s *svcDapp) EndorseOffline(req *dto.TxDataRequest, tkData *dto.AccessTokenData, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem) {
+	proposal, signature, problem := decodeTxDataRequest(req, schema.OfflineProposalKey)
+	if problem != nil {
+		return nil, problem
+	}
+	invocation, e := repo.ProposalInvocation(proposal)
+	if e != nil {
+		return nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, e.Error())
+	}
+	if problem = s.checkOfflineInvocation(invocation, tkData, queryParams); problem != nil {
+		return nil, problem
+	}
+
+	tx := offlineTransaction(queryParams)
+	result, offlineTx, e := (*s.repoDapp).EndorseSignedProposal(tx, proposal, signature)
+	if e != nil {
+		return nil, lib.NewProblem(iris.StatusBadGateway, schema.ErrBlockchainTxs, e.Error())
+	}
+	offlineTx.ReplyCommon = dto.ReplyCommon{Headers: dto.ReplyHeaders{
+		CommonHeaders: tx.Headers.CommonHeaders,
+	}}
+	offlineTx.ResponsePayload = mapper.DecodePayload(result)
+
+	return *offlineTx, nil
+}
+
+// SubmitOffline send the transaction signed by the client to the orderer. The function it invokes is checked again, the
+// client could sign a transaction other than the endorsed one
+func (s *svcDapp) SubmitOffline(req *dto.TxDataRequest, tkData *dto.AccessTokenData, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem) {
+	transaction, signature, problem := decodeTxDataRequest(req, schema.OfflineTransactionKey)
+	if problem != nil {
+		return nil, problem
+	}
+	invocation, e := repo.TransactionInvocation(transaction)
+	if e != nil {
+		return nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, e.Error())
+	}
+	if problem = s.checkOfflineInvocation(invocation, tkData, queryParams); problem != nil {
+		return nil, problem
+	}
+
+	tx := offlineTransaction(queryParams)
+	txID, orderer, e := (*s.repoDapp).SubmitSignedTransaction(tx, transaction, signature)
+	if e != nil {
+		return nil, lib.NewProblem(iris.StatusBadGateway, schema.ErrBlockchainTxs, e.Error())
+	}
+
+	return dto.OfflineReceipt{
+		ReplyCommon: dto.ReplyCommon{Headers: dto.ReplyHeaders{
+			CommonHeaders: tx.Headers.CommonHeaders,
+		}},
+		TransactionID: txID,
+		Orderer:       orderer,
+	}, nil
+}
+
 func (s *svcDapp) GenericGetAssets(payload interface{}, funcName string, did string, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem) {
 	var b map[string]interface{}
 	err := mapstructure.Decode(payload, &b)
@@ -98,7 +252,7 @@ func (s *svcDapp) GenericGetAssets(payload interface{}, funcName string, did str
 	// requesting blockchain ledger
 	result, e := (*s.repoDapp).Query(tx, did)
 	if e != nil {
-		return nil, lib.NewProblem(iris.StatusBadGateway, schema.ErrBlockchainTxs, e.Error())
+		return nil, ledgerProblem(e)
 	}
 	dPayload := mapper.DecodePayload(result)
 	qResult := dto.TxReceipt{
@@ -161,19 +315,7 @@ func (s *svcDapp) CreateAsset(req *dto.CreateAsset, did string, queryParams *dto
 		Payload:    b,
 		StrongRead: false,
 	}
-	// requesting blockchain ledger
-	result, e := (*s.repoDapp).Invoke(tx, did)
-	if e != nil {
-		return nil, lib.NewProblem(iris.StatusBadGateway, schema.ErrBlockchainTxs, e.Error())
-	}
-	dPayload := mapper.DecodePayload(result)
-	qResult := dto.TxReceipt{
-		ReplyCommon: dto.ReplyCommon{Headers: dto.ReplyHeaders{
-			CommonHeaders: tx.Headers.CommonHeaders,
-		}},
-		ResponsePayload: dPayload,
-	}
-	return qResult, nil
+	return s.submit(tx, did, queryParams)
 }
 
 func (s *svcDapp) UpdateAsset(req *dto.Asset, did string, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem) {
@@ -192,19 +334,7 @@ func (s *svcDapp) UpdateAsset(req *dto.Asset, did string, queryParams *dto.Query
 		Payload:    b,
 		StrongRead: false,
 	}
-	// requesting blockchain ledger
-	result, e := (*s.repoDapp).Invoke(tx, did)
-	if e != nil {
-		return nil, lib.NewProblem(iris.StatusBadGateway, schema.ErrBlockchainTxs, e.Error())
-	}
-	dPayload := mapper.DecodePayload(result)
-	qResult := dto.TxReceipt{
-		ReplyCommon: dto.ReplyCommon{Headers: dto.ReplyHeaders{
-			CommonHeaders: tx.Headers.CommonHeaders,
-		}},
-		ResponsePayload: dPayload,
-	}
-	return qResult, nil
+	return s.submit(tx, did, queryParams)
 }
 
 func (s *svcDapp) ValidateAsset(req *dto.SignAsset, userParam *dto.InjectedParam, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem) {
@@ -237,19 +367,7 @@ func (s *svcDapp) ValidateAsset(req *dto.SignAsset, userParam *dto.InjectedParam
 		Payload:    b,
 		StrongRead: false,
 	}
-	// requesting blockchain ledger
-	result, e := (*s.repoDapp).Invoke(tx, userParam.Username)
-	if e != nil {
-		return nil, lib.NewProblem(iris.StatusBadGateway, schema.ErrBlockchainTxs, e.Error())
-	}
-	dPayload := mapper.DecodePayload(result)
-	qResult := dto.TxReceipt{
-		ReplyCommon: dto.ReplyCommon{Headers: dto.ReplyHeaders{
-			CommonHeaders: tx.Headers.CommonHeaders,
-		}},
-		ResponsePayload: dPayload,
-	}
-	return qResult, nil
+	return s.submit(tx, userParam.Username, queryParams)
 }
 
 func (s *svcDapp) InvalidateAsset(req *dto.InvalidateAsset, userParam *dto.InjectedParam, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem) {
@@ -266,23 +384,12 @@ func (s *svcDapp) InvalidateAsset(req *dto.InvalidateAsset, userParam *dto.Injec
 		Function:   schema.InvalidateAsset,
 		Payload:    b,
 		StrongRead: false,
+		AsAdmin:    useAdminIdentity(schema.InvalidateAsset, userParam.Role),
 	}
-	// requesting blockchain ledger
-	result, e := (*s.repoDapp).Invoke(tx, userParam.Username)
-	if e != nil {
-		return nil, lib.NewProblem(iris.StatusBadGateway, schema.ErrBlockchainTxs, e.Error())
-	}
-	dPayload := mapper.DecodePayload(result)
-	qResult := dto.TxReceipt{
-		ReplyCommon: dto.ReplyCommon{Headers: dto.ReplyHeaders{
-			CommonHeaders: tx.Headers.CommonHeaders,
-		}},
-		ResponsePayload: dPayload,
-	}
-	return qResult, nil
+	return s.submit(tx, userParam.Username, queryParams)
 }
 
-func (s *svcDapp) DeleteAsset(id string, did string, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem) {
+func (s *svcDapp) DeleteAsset(id string, userParam *dto.InjectedParam, queryParams *dto.QueryParamChaincode) (interface{}, *dto.Problem) {
 	b, _ := lib.ToMap(&dto.GetRequestCC{ID: id}, "json")
 	tx := dto.Transaction{
 		RequestCommon: dto.RequestCommon{Headers: dto.RequestHeaders{CommonHeaders: dto.CommonHeaders{
@@ -295,18 +402,70 @@ func (s *svcDapp) DeleteAsset(id string, did string, queryParams *dto.QueryParam
 		Function:   schema.DeleteAsset,
 		Payload:    b,
 		StrongRead: false,
+		AsAdmin:    useAdminIdentity(schema.DeleteAsset, userParam.Role),
 	}
-	// requesting blockchain ledger
-	result, e := (*s.repoDapp).Invoke(tx, did)
-	if e != nil {
-		return nil, lib.NewProblem(iris.StatusBadGateway, schema.ErrBlockchainTxs, e.Error())
+	return s.submit(tx, userParam.Username, queryParams)
+}
+
+// region ======== HELPERS ===============================================================
+
+// ledgerProblem returns a 400 problem when the payload can't be turned into the chaincode arguments, otherwise the
+// ledger failed
+func ledgerProblem(e error) *dto.Problem {
+	if errors.Is(e, repo.ErrInvalidArgs) {
+		return lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, e.Error())
 	}
-	dPayload := mapper.DecodePayload(result)
-	qResult := dto.TxReceipt{
-		ReplyCommon: dto.ReplyCommon{Headers: dto.ReplyHeaders{
-			CommonHeaders: tx.Headers.CommonHeaders,
-		}},
-		ResponsePayload: dPayload,
+	return lib.NewProblem(iris.StatusBadGateway, schema.ErrBlockchainTxs, e.Error())
+}
+
+// useAdminIdentity tells if the caller role runs the function with the dapp admin identity, see adminOperations
+func useAdminIdentity(function string, role string) bool {
+	if role == models.Role_SystemAdmin {
+		return true
+	}
+	_, function = lib.SplitFunction(function) // the function may be addressed as "<contract>:<function>"
+	return lib.Contains(adminOperations[function], role)
+}
+
+// checkOfflineInvocation returns a 400 problem if the bytes signed offline target another channel or chaincode than
+// the request, and a 403 problem if the user lacks the permission of the chaincode function they invoke
+func (s *svcDapp) checkOfflineInvocation(invocation repo.Invocation, tkData *dto.AccessTokenData, queryParams *dto.QueryParamChaincode) *dto.Problem {
+	if invocation.ChannelID != queryParams.Channel || invocation.ChaincodeID != queryParams.Chaincode {
+		return lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, schema.ErrDetOfflineTarget)
+	}
+	permission, ok := offlineFunctionPermissions[invocation.Function]
+	if !ok {
+		permission = schema.PermDappTransaction
+	}
+	return s.policy.Check(tkData, permission)
+}
+
+// offlineTransaction the offline steps after prepare only need the channel and the chaincode of the request, the
+// client signs with its own identity
+func offlineTransaction(queryParams *dto.QueryParamChaincode) dto.Transaction {
+	return dto.Transaction{
+		RequestCommon: dto.RequestCommon{Headers: dto.RequestHeaders{CommonHeaders: dto.CommonHeaders{
+			ChannelID:   queryParams.Channel,
+			ChaincodeID: queryParams.Chaincode,
+		}}},
 	}
-	return qResult, nil
 }
+
+// decodeTxDataRequest decode the base64 signed bytes, stored in the payload under the given key, and the signature
+func decodeTxDataRequest(req *dto.TxDataRequest, key string) ([]byte, []byte, *dto.Problem) {
+	encoded, ok := req.Payload[key].(string)
+	if !ok || encoded == "" {
+		return nil, nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, fmt.Sprintf("the payload must have the base64 encoded \"%s\"", key))
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, err.Error())
+	}
+	signature, err := base64.StdEncoding.DecodeString(req.Signature)
+	if err != nil || len(signature) == 0 {
+		return nil, nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, "the \"signature\" must be base64 encoded")
+	}
+	return data, signature, nil
+}
+
+// endregion =============================================================================
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"dapp/service/auth"
+	"dapp/service/utils"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// offlineRequest the signed bytes, of a proposal or a transaction, invoking the function of the chaincode on the channel
+func offlineRequest(t *testing.T, key, channelID, chaincodeID, function string) *dto.TxDataRequest {
+	t.Helper()
+	chaincode := &pb.ChaincodeID{Name: chaincodeID}
+	spec, _ := proto.Marshal(&pb.ChaincodeInvocationSpec{ChaincodeSpec: &pb.ChaincodeSpec{
+		ChaincodeId: chaincode,
+		Input:       &pb.ChaincodeInput{Args: [][]byte{[]byte(function), []byte("CERT1")}},
+	}})
+	ccPayload, _ := proto.Marshal(&pb.ChaincodeProposalPayload{Input: spec})
+	extension, _ := proto.Marshal(&pb.ChaincodeHeaderExtension{ChaincodeId: chaincode})
+	chHeader, _ := proto.Marshal(&common.ChannelHeader{ChannelId: channelID, TxId: "tx-1", Extension: extension})
+	header := &common.Header{ChannelHeader: chHeader}
+
+	var signed []byte
+	var err error
+	if key == schema.OfflineProposalKey {
+		headerBytes, _ := proto.Marshal(header)
+		signed, err = proto.Marshal(&pb.Proposal{Header: headerBytes, Payload: ccPayload})
+	} else {
+		actionPayload, _ := proto.Marshal(&pb.ChaincodeActionPayload{ChaincodeProposalPayload: ccPayload})
+		tx, _ := proto.Marshal(&pb.Transaction{Actions: []*pb.TransactionAction{{Payload: actionPayload}}})
+		signed, err = proto.Marshal(&common.Payload{Header: header, Data: tx})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dto.TxDataRequest{
+		Signature: base64.StdEncoding.EncodeToString([]byte("signature")),
+		Payload:   map[string]interface{}{key: base64.StdEncoding.EncodeToString(signed)},
+	}
+}
+
+func TestSvcDappOfflineChecksFunction(t *testing.T) {
+	policy, err := auth.NewPolicy(nil, &utils.SvcConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the repository is never reached, the requests are refused before
+	svc := NewSvcDappReqs(nil, policy)
+	secretary := &dto.AccessTokenData{Scope: schema.Scopes, Claims: dto.InjectedParam{Username: "tom", Role: models.Role_Secretary}}
+	queryParams := &dto.QueryParamChaincode{Channel: "mychannel", Chaincode: "certificate", Signer: "User1"}
+
+	tests := []struct {
+		name      string
+		key       string
+		channel   string
+		chaincode string
+		function  string
+		want      uint
+		step      func(*dto.TxDataRequest, *dto.AccessTokenData, *dto.QueryParamChaincode) (interface{}, *dto.Problem)
+	}{
+		// the secretary signs offline, but can't delete nor submit generic transactions
+		{"endorse a delete", schema.OfflineProposalKey, "mychannel", "certificate", "certificate:" + schema.DeleteAsset, http.StatusForbidden, svc.EndorseOffline},
+		{"endorse a generic transaction", schema.OfflineProposalKey, "mychannel", "certificate", "Transfer", http.StatusForbidden, svc.EndorseOffline},
+		{"submit an update", schema.OfflineTransactionKey, "mychannel", "certificate", schema.UpdateAsset, http.StatusForbidden, svc.SubmitOffline},
+		// a validation, allowed to the secretary, signed for another channel or chaincode than the request
+		{"endorse on another channel", schema.OfflineProposalKey, "otherchannel", "certificate", schema.ValidateAsset, http.StatusBadRequest, svc.EndorseOffline},
+		{"endorse on another chaincode", schema.OfflineProposalKey, "mychannel", "other", schema.ValidateAsset, http.StatusBadRequest, svc.EndorseOffline},
+		{"submit on another channel", schema.OfflineTransactionKey, "otherchannel", "certificate", schema.ValidateAsset, http.StatusBadRequest, svc.SubmitOffline},
+	}
+	for _, tt := range tests {
+		req := offlineRequest(t, tt.key, tt.channel, tt.chaincode, tt.function)
+		if _, problem := tt.step(req, secretary, queryParams); problem == nil || problem.Status != tt.want {
+			t.Errorf("%s: problem = %+v, want %d", tt.name, problem, tt.want)
+		}
+	}
+
+	garbage := &dto.TxDataRequest{
+		Signature: base64.StdEncoding.EncodeToString([]byte("signature")),
+		Payload:   map[string]interface{}{schema.OfflineProposalKey: base64.StdEncoding.EncodeToString([]byte("not a proposal"))},
+	}
+	if _, problem := svc.EndorseOffline(garbage, secretary, queryParams); problem == nil || problem.Status != http.StatusBadRequest {
+		t.Errorf("EndorseOffline() of garbage = %+v, want 400", problem)
+	}
+}
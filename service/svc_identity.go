@@ -0,0 +1,226 @@
+package service
+
+import (
+	"bytes"
+	"crypto/x509"
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/service/utils"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// region ======== SETUP =================================================================
+
+// ISvcIdentity wallet identities service interface
+type ISvcIdentity interface {
+	CheckExpiry() (*dto.IdentitiesExpiry, *dto.Problem)
+	ExpiryStatus() (*dto.IdentitiesExpiry, *dto.Problem)
+}
+
+type svcIdentity struct {
+	svcConf  *utils.SvcConfig
+	repoDapp *repo.RepoDapp
+
+	mu       sync.RWMutex
+	last     *dto.IdentitiesExpiry // result of the last check
+	notified map[string]bool       // expiring identities already notified, so they are notified once
+}
+
+var (
+	// the cron job and the status endpoint share the same service, so the endpoint reports the last scheduled check
+	singletonSvcIdentity *svcIdentity
+	onceSvcIdentity      sync.Once
+
+	identityDaysToExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dapp",
+		Name:      "identity_certificate_days_to_expiry",
+		Help:      "Days until the X.509 certificate of the wallet identity expires, negative when expired.",
+	}, []string{"label", "msp_id"})
+	identityExpiring = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dapp",
+		Name:      "identity_certificate_expiring",
+		Help:      "1 when the certificate of the wallet identity is under the configured expiry threshold.",
+	}, []string{"label", "msp_id"})
+	identityCheckErrors = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "dapp",
+		Name:      "identity_check_errors",
+		Help:      "Wallet identities that could not be inspected in the last expiry check.",
+	})
+)
+
+// endregion =============================================================================
+
+// NewSvcIdentityReqs instantiate the wallet identities services
+func NewSvcIdentityReqs(svcConf *utils.SvcConfig, repoDapp *repo.RepoDapp) ISvcIdentity {
+	onceSvcIdentity.Do(func() {
+		prometheus.MustRegister(identityDaysToExpiry, identityExpiring, identityCheckErrors)
+		singletonSvcIdentity = &svcIdentity{
+			svcConf:  svcConf,
+			repoDapp: repoDapp,
+			notified: make(map[string]bool),
+		}
+	})
+	return singletonSvcIdentity
+}
+
+// region ======== METHODS ======================================================
+
+// CheckExpiry inspect the certificate of every wallet identity, update the metrics and notify the identities that
+// crossed the expiry threshold since the last check
+func (s *svcIdentity) CheckExpiry() (*dto.IdentitiesExpiry, *dto.Problem) {
+	identities, failed, e := s.repoDapp.WalletIdentities()
+	if e != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, e.Error())
+	}
+
+	now := time.Now()
+	status := &dto.IdentitiesExpiry{CheckedAt: now, ThresholdDays: s.svcConf.IdentityExpiryDays, Identities: []dto.IdentityExpiry{}}
+	for label, identity := range identities {
+		status.Identities = append(status.Identities, certificateExpiry(label, identity.MspID, identity.Certificate(), now, s.svcConf.IdentityExpiryDays))
+	}
+	for label, err := range failed {
+		status.Identities = append(status.Identities, dto.IdentityExpiry{Label: label, Error: err.Error()})
+	}
+	sort.Slice(status.Identities, func(i, j int) bool { return status.Identities[i].Label < status.Identities[j].Label })
+
+	s.updateMetrics(status)
+	s.notify(status)
+
+	s.mu.Lock()
+	s.last = status
+	s.mu.Unlock()
+	return status, nil
+}
+
+// ExpiryStatus returns the last expiry check, checking now if there is none yet
+func (s *svcIdentity) ExpiryStatus() (*dto.IdentitiesExpiry, *dto.Problem) {
+	s.mu.RLock()
+	last := s.last
+	s.mu.RUnlock()
+
+	if last == nil {
+		return s.CheckExpiry()
+	}
+	return last, nil
+}
+
+// endregion =============================================================================
+
+// region ======== HELPERS ======================================================
+
+// certificateExpiry compute the expiry state of a PEM encoded certificate
+func certificateExpiry(label, mspID, certPEM string, now time.Time, thresholdDays int) dto.IdentityExpiry {
+	expiry := dto.IdentityExpiry{Label: label, MspID: mspID}
+
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		expiry.Error = schema.ErrDetInvalidCert
+		return expiry
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		expiry.Error = err.Error()
+		return expiry
+	}
+
+	expiry.Subject = cert.Subject.String()
+	expiry.NotAfter = cert.NotAfter
+	expiry.DaysToExpiry = int(math.Floor(cert.NotAfter.Sub(now).Hours() / 24))
+	expiry.Expiring = expiry.DaysToExpiry < thresholdDays
+	return expiry
+}
+
+func (s *svcIdentity) updateMetrics(status *dto.IdentitiesExpiry) {
+	// the labels of removed identities must not linger in the metrics
+	identityDaysToExpiry.Reset()
+	identityExpiring.Reset()
+
+	errCount := 0
+	for _, identity := range status.Identities {
+		if identity.Error != "" {
+			errCount++
+			continue
+		}
+		identityDaysToExpiry.WithLabelValues(identity.Label, identity.MspID).Set(float64(identity.DaysToExpiry))
+		expiring := 0.0
+		if identity.Expiring {
+			expiring = 1
+		}
+		identityExpiring.WithLabelValues(identity.Label, identity.MspID).Set(expiring)
+	}
+	identityCheckErrors.Set(float64(errCount))
+}
+
+// notify log the identities that crossed the threshold and post them to the configured hook. Every identity is
+// notified once, until it is renewed and crosses the threshold again
+func (s *svcIdentity) notify(status *dto.IdentitiesExpiry) {
+	s.mu.Lock()
+	var crossed []dto.IdentityExpiry
+	for _, identity := range status.Identities {
+		if identity.Error != "" {
+			log.Printf("identity expiry check: the %s identity could not be inspected: %s", identity.Label, identity.Error)
+			continue
+		}
+		if !identity.Expiring {
+			delete(s.notified, identity.Label)
+			continue
+		}
+		if !s.notified[identity.Label] {
+			s.notified[identity.Label] = true
+			crossed = append(crossed, identity)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(crossed) == 0 {
+		return
+	}
+	for _, identity := range crossed {
+		log.Printf("identity expiry check: the certificate of the %s identity (%s) expires in %d days, on %s",
+			identity.Label, identity.MspID, identity.DaysToExpiry, identity.NotAfter.Format(time.RFC3339))
+	}
+
+	if s.svcConf.IdentityExpiryHook == "" {
+		return
+	}
+	if err := s.postHook(&dto.IdentitiesExpiry{CheckedAt: status.CheckedAt, ThresholdDays: status.ThresholdDays, Identities: crossed}); err != nil {
+		log.Printf("identity expiry check: notifying %s: %s", s.svcConf.IdentityExpiryHook, err)
+	}
+}
+
+func (s *svcIdentity) postHook(expiring *dto.IdentitiesExpiry) error {
+	body, err := json.Marshal(expiring)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.svcConf.IdentityExpiryHook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return errors.New("unexpected response status " + res.Status)
+	}
+	return nil
+}
+
+// endregion =============================================================================
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/service/utils"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// selfSignedPEM a PEM encoded certificate of the subject expiring on notAfter
+func selfSignedPEM(t *testing.T, subject string, notAfter time.Time) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: subject},
+		NotBefore:    notAfter.AddDate(-1, 0, 0),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestCertificateExpiry(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		certPEM  string
+		days     int
+		expiring bool
+		err      string
+	}{
+		{"far from the threshold", selfSignedPEM(t, "User1", now.AddDate(0, 0, 90)), 90, false, ""},
+		{"on the threshold", selfSignedPEM(t, "User1", now.AddDate(0, 0, 30)), 30, false, ""},
+		{"under the threshold", selfSignedPEM(t, "User1", now.AddDate(0, 0, 29)), 29, true, ""},
+		// a partial day left still counts as the day of the expiry
+		{"expiring today", selfSignedPEM(t, "User1", now.Add(time.Hour)), 0, true, ""},
+		{"expired", selfSignedPEM(t, "User1", now.AddDate(0, 0, -2)), -2, true, ""},
+		{"not a PEM", "not a certificate", 0, false, schema.ErrDetInvalidCert},
+	}
+	for _, tt := range tests {
+		expiry := certificateExpiry("User1", "Org1MSP", tt.certPEM, now, 30)
+		if expiry.Error != tt.err {
+			t.Errorf("%s: Error = %q, want %q", tt.name, expiry.Error, tt.err)
+			continue
+		}
+		if expiry.DaysToExpiry != tt.days || expiry.Expiring != tt.expiring {
+			t.Errorf("%s: DaysToExpiry, Expiring = %d, %v, want %d, %v", tt.name, expiry.DaysToExpiry, expiry.Expiring, tt.days, tt.expiring)
+		}
+		if tt.err == "" && (expiry.Subject != "CN=User1" || expiry.MspID != "Org1MSP") {
+			t.Errorf("%s: Subject, MspID = %q, %q", tt.name, expiry.Subject, expiry.MspID)
+		}
+	}
+
+	broken := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")})
+	if expiry := certificateExpiry("User1", "Org1MSP", string(broken), now, 30); expiry.Error == "" {
+		t.Error("certificateExpiry() of a PEM without a certificate must report an error")
+	}
+}
+
+func TestSvcIdentityNotifiesOnce(t *testing.T) {
+	var mu sync.Mutex
+	var posted [][]string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var expiring dto.IdentitiesExpiry
+		if err := json.NewDecoder(r.Body).Decode(&expiring); err != nil {
+			t.Error(err)
+		}
+		var labels []string
+		for _, identity := range expiring.Identities {
+			labels = append(labels, identity.Label)
+		}
+		mu.Lock()
+		posted = append(posted, labels)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	conf := &utils.SvcConfig{}
+	conf.IdentityExpiryHook = hook.URL
+	s := &svcIdentity{svcConf: conf, notified: make(map[string]bool)}
+	check := func(identities ...dto.IdentityExpiry) {
+		s.notify(&dto.IdentitiesExpiry{CheckedAt: time.Now(), ThresholdDays: 30, Identities: identities})
+	}
+	expiring := func(label string) dto.IdentityExpiry {
+		return dto.IdentityExpiry{Label: label, DaysToExpiry: 10, Expiring: true}
+	}
+	renewed := func(label string) dto.IdentityExpiry {
+		return dto.IdentityExpiry{Label: label, DaysToExpiry: 365}
+	}
+
+	check(expiring("User1"), renewed("Admin"), dto.IdentityExpiry{Label: "Broken", Error: "unreadable"})
+	check(expiring("User1"), renewed("Admin"))  // still expiring, already notified
+	check(expiring("User1"), expiring("Admin")) // only the new one
+	check(renewed("User1"), expiring("Admin"))  // User1 renewed
+	check(expiring("User1"), expiring("Admin")) // User1 crossed the threshold again
+
+	want := [][]string{{"User1"}, {"Admin"}, {"User1"}}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(posted) != len(want) {
+		t.Fatalf("hook posts = %v, want %v", posted, want)
+	}
+	for i := range want {
+		if len(posted[i]) != len(want[i]) || posted[i][0] != want[i][0] {
+			t.Errorf("hook post %d = %v, want %v", i, posted[i], want[i])
+		}
+	}
+}
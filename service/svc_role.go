@@ -0,0 +1,200 @@
+package service
+
+import (
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/mapper"
+	"dapp/schema/models"
+	"dapp/service/auth"
+	"errors"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+// region ======== SETUP =================================================================
+
+// ISvcRole roles and their permissions service interface
+type ISvcRole interface {
+	GetRolesSvc() (*dto.Pagination, *dto.Problem)
+	GetRoleSvc(label string) (dto.RoleResponse, *dto.Problem)
+	PostRoleSvc(role dto.RoleData) (dto.RoleResponse, *dto.Problem)
+	PutRoleSvc(label string, role dto.EditRoleData) (dto.RoleResponse, *dto.Problem)
+	DeleteRoleSvc(label string) *dto.Problem
+}
+
+// roleRepo storage used by the role service, implemented by repo.RepoUser
+type roleRepo interface {
+	GetRoles() ([]models.Role, error)
+	GetRole(label string) (models.Role, error)
+	AddRole(role models.Role, permissions []string) (models.Role, error)
+	UpdateRole(role models.Role, permissions []string) (models.Role, error)
+	RemoveRole(label string) error
+}
+
+// rolePolicy the access control policy, reloaded after the permissions of a role change. Implemented by auth.Policy
+type rolePolicy interface {
+	Reload() error
+	Permissions(role string, scope []string) []string
+}
+
+type svcRole struct {
+	repo   roleRepo
+	policy rolePolicy
+}
+
+// endregion =============================================================================
+
+// NewSvcRole instantiate the role services
+//
+// - repoUser [*RepoUser] ~ User repository instance pointer
+//
+// - policy [*auth.Policy] ~ Access control policy, reloaded when the permissions of a role change
+func NewSvcRole(repoUser *repo.RepoUser, policy *auth.Policy) ISvcRole {
+	return newSvcRole(repoUser, policy)
+}
+
+func newSvcRole(repo roleRepo, policy rolePolicy) *svcRole {
+	return &svcRole{repo, policy}
+}
+
+// region ======== METHODS ===============================================================
+
+// GetRolesSvc every role with its effective permissions
+func (s *svcRole) GetRolesSvc() (*dto.Pagination, *dto.Problem) {
+	roles, err := s.repo.GetRoles()
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
+	}
+	res := make([]dto.RoleResponse, 0, len(roles))
+	for _, role := range roles {
+		res = append(res, s.response(role))
+	}
+	return &dto.Pagination{Rows: res}, nil
+}
+
+// GetRoleSvc the role with its effective permissions
+func (s *svcRole) GetRoleSvc(label string) (dto.RoleResponse, *dto.Problem) {
+	role, problem := s.get(label)
+	if problem != nil {
+		return dto.RoleResponse{}, problem
+	}
+	return s.response(role), nil
+}
+
+// PostRoleSvc create the role with its permissions
+func (s *svcRole) PostRoleSvc(role dto.RoleData) (dto.RoleResponse, *dto.Problem) {
+	if _, err := s.repo.GetRole(role.Label); err == nil {
+		return dto.RoleResponse{}, lib.NewProblem(iris.StatusConflict, schema.ErrDuplicateKey, schema.ErrDetRoleTaken)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return dto.RoleResponse{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	if problem := checkPermissions(role.Label, role.Permissions); problem != nil {
+		return dto.RoleResponse{}, problem
+	}
+
+	created, err := s.repo.AddRole(models.Role{Label: role.Label, Name: role.Name, Description: role.Description}, role.Permissions)
+	if err != nil {
+		return dto.RoleResponse{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return s.changed(created)
+}
+
+// PutRoleSvc update the name and the description of the role, and its permissions when they are passed
+func (s *svcRole) PutRoleSvc(label string, role dto.EditRoleData) (dto.RoleResponse, *dto.Problem) {
+	modelRole, problem := s.get(label)
+	if problem != nil {
+		return dto.RoleResponse{}, problem
+	}
+	if role.Permissions != nil {
+		if problem = checkPermissions(label, role.Permissions); problem != nil {
+			return dto.RoleResponse{}, problem
+		}
+	}
+	if role.Name != "" {
+		modelRole.Name = role.Name
+	}
+	if role.Description != "" {
+		modelRole.Description = role.Description
+	}
+
+	updated, err := s.repo.UpdateRole(modelRole, role.Permissions)
+	if err != nil {
+		return dto.RoleResponse{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return s.changed(updated)
+}
+
+// DeleteRoleSvc delete the role and its permissions. The built-in roles and the roles of some user are kept
+func (s *svcRole) DeleteRoleSvc(label string) *dto.Problem {
+	if lib.Contains(models.BuiltInRoles, label) {
+		return lib.NewProblem(iris.StatusConflict, schema.ErrProcParam, schema.ErrDetBuiltInRole)
+	}
+	err := s.repo.RemoveRole(label)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return lib.NewProblem(iris.StatusNotFound, schema.ErrNotFound, schema.ErrDetRoleNotFound)
+	}
+	if errors.Is(err, repo.ErrRoleInUse) {
+		return lib.NewProblem(iris.StatusConflict, schema.ErrProcParam, schema.ErrDetRoleInUse)
+	}
+	if err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return s.reload()
+}
+
+// endregion =============================================================================
+
+// region ======== HELPERS ===============================================================
+
+func (s *svcRole) get(label string) (models.Role, *dto.Problem) {
+	role, err := s.repo.GetRole(label)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Role{}, lib.NewProblem(iris.StatusNotFound, schema.ErrNotFound, schema.ErrDetRoleNotFound)
+	}
+	if err != nil {
+		return models.Role{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return role, nil
+}
+
+// changed reload after a change of the role, returns the changed role
+func (s *svcRole) changed(role models.Role) (dto.RoleResponse, *dto.Problem) {
+	if problem := s.reload(); problem != nil {
+		return dto.RoleResponse{}, problem
+	}
+	return s.response(role), nil
+}
+
+// reload the policy, with the role names, after a change of the roles. The other API replicas apply the change on
+// their next periodic reload of the policy
+func (s *svcRole) reload() *dto.Problem {
+	if err := s.policy.Reload(); err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return nil
+}
+
+// response the role with the permissions granted by the policy, every scope covers them
+func (s *svcRole) response(role models.Role) dto.RoleResponse {
+	return mapper.MapModelRole2DtoRoleResponse(role, s.policy.Permissions(role.Label, schema.Scopes))
+}
+
+// checkPermissions returns a 400 problem for an unknown permission, a permission of the invalid role or a sysadmin
+// role that can't edit the roles anymore
+func checkPermissions(label string, permissions []string) *dto.Problem {
+	for _, permission := range permissions {
+		if _, ok := auth.PermissionScopes[permission]; !ok {
+			return lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, schema.ErrDetUnknownPermission+": "+permission)
+		}
+	}
+	if label == models.Role_Invalid && len(permissions) > 0 ||
+		label == models.Role_SystemAdmin && !lib.Contains(permissions, schema.PermRolesWrite) {
+		return lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, schema.ErrDetRolePermissions)
+	}
+	return nil
+}
+
+// endregion =============================================================================
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/mapper"
+	"dapp/schema/models"
+	"errors"
+	"net/http"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// fakeRoleRepo in memory roleRepo and rolePolicy, the stored permissions are the granted ones and a reload loads
+// the role names as the policy does
+type fakeRoleRepo struct {
+	roles       []models.Role
+	permissions map[string][]string
+	usedRoles   map[string]bool
+	reloads     int
+	err         error // returned by GetRole when set, e.g. the database is down
+}
+
+func (f *fakeRoleRepo) GetRoles() ([]models.Role, error) {
+	return f.roles, nil
+}
+
+func (f *fakeRoleRepo) GetRole(label string) (models.Role, error) {
+	if f.err != nil {
+		return models.Role{}, f.err
+	}
+	for _, role := range f.roles {
+		if role.Label == label {
+			return role, nil
+		}
+	}
+	return models.Role{}, gorm.ErrRecordNotFound
+}
+
+func (f *fakeRoleRepo) AddRole(role models.Role, permissions []string) (models.Role, error) {
+	f.roles = append(f.roles, role)
+	f.permissions[role.Label] = permissions
+	return role, nil
+}
+
+func (f *fakeRoleRepo) UpdateRole(role models.Role, permissions []string) (models.Role, error) {
+	for i := range f.roles {
+		if f.roles[i].Label == role.Label {
+			f.roles[i] = role
+		}
+	}
+	if permissions != nil {
+		f.permissions[role.Label] = permissions
+	}
+	return role, nil
+}
+
+func (f *fakeRoleRepo) RemoveRole(label string) error {
+	if f.usedRoles[label] {
+		return repo.ErrRoleInUse
+	}
+	for i, role := range f.roles {
+		if role.Label == label {
+			f.roles = append(f.roles[:i], f.roles[i+1:]...)
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (f *fakeRoleRepo) Reload() error {
+	f.reloads++
+	mapper.SetRoleNames(f.roles)
+	return nil
+}
+
+func (f *fakeRoleRepo) Permissions(role string, scope []string) []string {
+	return f.permissions[role]
+}
+
+func TestSvcRole(t *testing.T) {
+	store := &fakeRoleRepo{
+		roles:       []models.Role{{Label: models.Role_Dean, Name: "Decano de Facultad"}, {Label: models.Role_SystemAdmin, Name: "Administrador de Sistemas"}},
+		permissions: map[string][]string{models.Role_Dean: {schema.PermCertValidate}},
+		usedRoles:   map[string]bool{},
+	}
+	svc := newSvcRole(store, store)
+
+	vicedean := dto.RoleData{Label: "vicedean", Name: "Vicedecano", Description: "Validates the certificates", Permissions: []string{schema.PermCertValidate}}
+	role, problem := svc.PostRoleSvc(vicedean)
+	if problem != nil || role.BuiltIn || len(role.Permissions) != 1 || store.reloads != 1 {
+		t.Fatalf("PostRoleSvc() = %+v, %+v", role, problem)
+	}
+	if user := mapper.MapModelUser2DtoUserResponse(models.User{Role: "vicedean"}); user.Role != "Vicedecano" {
+		t.Errorf("role name = %s, want the name of the new role", user.Role)
+	}
+	if _, problem = svc.PostRoleSvc(vicedean); problem == nil || problem.Status != http.StatusConflict {
+		t.Errorf("PostRoleSvc() of an existing role = %+v, want 409", problem)
+	}
+	store.err = errors.New("connection refused")
+	if _, problem = svc.PostRoleSvc(dto.RoleData{Label: "secretary2", Name: "Secretaria"}); problem == nil || problem.Status != http.StatusInternalServerError {
+		t.Errorf("PostRoleSvc() with the database down = %+v, want 500", problem)
+	}
+	store.err = nil
+
+	tests := []struct {
+		name   string
+		label  string
+		edit   dto.EditRoleData
+		status uint
+	}{
+		{"unknown permission", "vicedean", dto.EditRoleData{Permissions: []string{"certificates.forge"}}, http.StatusBadRequest},
+		{"sysadmin locked out", models.Role_SystemAdmin, dto.EditRoleData{Permissions: []string{schema.PermUsersRead}}, http.StatusBadRequest},
+		{"unknown role", "janitor", dto.EditRoleData{Name: "Janitor"}, http.StatusNotFound},
+		{"name only", "vicedean", dto.EditRoleData{Name: "Vicedecana"}, 0},
+	}
+	for _, tt := range tests {
+		_, problem := svc.PutRoleSvc(tt.label, tt.edit)
+		if tt.status == 0 && problem != nil || tt.status != 0 && (problem == nil || problem.Status != tt.status) {
+			t.Errorf("%s: PutRoleSvc() = %+v, want %d", tt.name, problem, tt.status)
+		}
+	}
+	if role, _ = svc.GetRoleSvc("vicedean"); role.Name != "Vicedecana" || len(role.Permissions) != 1 {
+		t.Errorf("GetRoleSvc() = %+v, the permissions must be kept when not passed", role)
+	}
+
+	if problem = svc.DeleteRoleSvc(models.Role_Dean); problem == nil || problem.Status != http.StatusConflict {
+		t.Errorf("DeleteRoleSvc() of a built-in role = %+v, want 409", problem)
+	}
+	store.usedRoles["vicedean"] = true
+	if problem = svc.DeleteRoleSvc("vicedean"); problem == nil || problem.Detail != schema.ErrDetRoleInUse {
+		t.Errorf("DeleteRoleSvc() of a role in use = %+v", problem)
+	}
+	store.usedRoles["vicedean"] = false
+	if problem = svc.DeleteRoleSvc("vicedean"); problem != nil {
+		t.Errorf("DeleteRoleSvc() = %+v", problem)
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"dapp/schema/dto"
 	"dapp/schema/mapper"
 	"dapp/schema/models"
+	"dapp/service/utils"
+	"errors"
 
 	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
 )
 
 // region ======== SETUP =================================================================
@@ -17,37 +20,35 @@ import (
 type ISvcUser interface {
 	// user functions
 
-	GetRolesSvc() (*dto.Pagination, *dto.Problem)
 	GetUserSvc(userID int) (dto.UserResponse, *dto.Problem)
 	GetUserByUsernameSvc(username string) (dto.UserResponse, *dto.Problem)
-	GetUsersSvc(pagination *dto.Pagination) (*dto.Pagination, *dto.Problem)
-	PutUserSvc(userID int, user dto.EditUserData) (dto.UserResponse, *dto.Problem)
-	PostUserSvc(user dto.UserData) (dto.UserResponse, *dto.Problem)
-	DeleteUserSvc(userID int) (dto.UserResponse, *dto.Problem)
-	InvalidateUserSvc(userID int) (dto.UserResponse, *dto.Problem)
+	GetUsersSvc(pagination *dto.Pagination, filter dto.UserFilter) (*dto.Pagination, *dto.Problem)
+	PutUserSvc(userID int, user dto.EditUserData, changedBy string) (dto.UserResponse, *dto.Problem)
+	PostUserSvc(user dto.UserData, changedBy string) (dto.UserResponse, *dto.Problem)
+	DeleteUserSvc(userID int, changedBy string) (dto.UserResponse, *dto.Problem)
+	RestoreUserSvc(userID int, changedBy string) (dto.UserResponse, *dto.Problem)
+	InvalidateUserSvc(userID int, changedBy string) (dto.UserResponse, *dto.Problem)
+	GetUserHistorySvc(userID int) ([]dto.UserChangeResponse, *dto.Problem)
 }
 
 type svcUser struct {
-	repoUser *repo.RepoUser
+	repoUser       *repo.RepoUser
+	passwordParams lib.PasswordParams
 }
 
 // endregion =============================================================================
 
 // NewSvcUserReqs instantiate the User request services
-func NewSvcUserReqs(repoUser *repo.RepoUser) ISvcUser {
-	return &svcUser{repoUser}
+//
+// - repoUser [*RepoUser] ~ User repository instance pointer
+//
+// - svcConf [*SvcConfig] ~ App conf instance pointer
+func NewSvcUserReqs(repoUser *repo.RepoUser, svcConf *utils.SvcConfig) ISvcUser {
+	return &svcUser{repoUser, svcConf.PasswordParams()}
 }
 
 // region ======== METHODS ======================================================
 
-func (s *svcUser) GetRolesSvc() (*dto.Pagination, *dto.Problem) {
-	res, err := (*s.repoUser).GetRoles()
-	if err != nil {
-		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
-	}
-	return &dto.Pagination{Rows: res}, nil
-}
-
 func (s *svcUser) GetUserSvc(userID int) (dto.UserResponse, *dto.Problem) {
 	res, err := (*s.repoUser).GetUser(userID)
 	if err != nil {
@@ -64,8 +65,15 @@ func (s *svcUser) GetUserByUsernameSvc(username string) (dto.UserResponse, *dto.
 	return mapper.MapModelUser2DtoUserResponse(res), nil
 }
 
-func (s *svcUser) GetUsersSvc(pagination *dto.Pagination) (*dto.Pagination, *dto.Problem) {
-	res, err := (*s.repoUser).GetUsers(pagination)
+// GetUsersSvc the page of the users matching the filter, or of the soft deleted users with filter.Deleted
+func (s *svcUser) GetUsersSvc(pagination *dto.Pagination, filter dto.UserFilter) (*dto.Pagination, *dto.Problem) {
+	if problem := checkDateRange(filter); problem != nil {
+		return nil, problem
+	}
+	res, err := (*s.repoUser).GetUsers(pagination, filter)
+	if errors.Is(err, repo.ErrInvalidSort) {
+		return nil, lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, err.Error())
+	}
 	if err != nil {
 		return nil, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
 	}
@@ -78,7 +86,10 @@ func (s *svcUser) GetUsersSvc(pagination *dto.Pagination) (*dto.Pagination, *dto
 	return res, nil
 }
 
-func (s *svcUser) PutUserSvc(userID int, user dto.EditUserData) (dto.UserResponse, *dto.Problem) {
+// PutUserSvc update the user. A change of the username, the password, the role or the authentication provider revokes
+// the tokens issued with the previous ones. Every changed field is recorded in the history of the user, along with
+// changedBy
+func (s *svcUser) PutUserSvc(userID int, user dto.EditUserData, changedBy string) (dto.UserResponse, *dto.Problem) {
 	userInDB, err := s.repoUser.GetUser(userID)
 	if err != nil {
 		return dto.UserResponse{}, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
@@ -87,7 +98,10 @@ func (s *svcUser) PutUserSvc(userID int, user dto.EditUserData) (dto.UserRespons
 		userInDB.Username = user.Username
 	}
 	if user.Passphrase != "" {
-		passphraseEncoded, _ := lib.Checksum("SHA256", []byte(user.Passphrase))
+		passphraseEncoded, err := lib.HashPassword(user.Passphrase, s.passwordParams)
+		if err != nil {
+			return dto.UserResponse{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+		}
 		userInDB.Passphrase = passphraseEncoded
 	}
 	if user.FirstName != "" {
@@ -96,42 +110,124 @@ func (s *svcUser) PutUserSvc(userID int, user dto.EditUserData) (dto.UserRespons
 	if user.LastName != "" {
 		userInDB.LastName = user.LastName
 	}
-	if user.Email != "" {
+	if user.Email != "" && user.Email != userInDB.Email {
 		userInDB.Email = user.Email
+		userInDB.EmailVerified = false
 	}
-	if user.Role != "" {
+	if user.Role != "" && user.Role != userInDB.Role {
+		if problem := s.checkRole(user.Role); problem != nil {
+			return dto.UserResponse{}, problem
+		}
 		userInDB.Role = user.Role
 	}
-	resUser, err := s.repoUser.UpdateUser(userID, userInDB)
+	if user.Provider != "" && user.Provider != userInDB.Provider {
+		if !lib.Contains([]string{schema.ProviderDapp, schema.ProviderLDAP, schema.ProviderOIDC}, user.Provider) {
+			return dto.UserResponse{}, lib.NewProblem(iris.StatusBadRequest, schema.ErrVal, schema.ErrDetInvalidProvider)
+		}
+		// linked to another provider, a single sign-on user is bound to its subject on its next login
+		userInDB.Provider, userInDB.Issuer, userInDB.Subject = user.Provider, "", ""
+	}
+	resUser, err := s.repoUser.UpdateUser(userID, userInDB, changedBy)
+	if problem := usernameProblem(err); problem != nil {
+		return dto.UserResponse{}, problem
+	}
 	if err != nil {
 		return dto.UserResponse{}, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
 	}
 	return mapper.MapModelUser2DtoUserResponse(resUser), nil
 }
 
-func (s *svcUser) PostUserSvc(user dto.UserData) (dto.UserResponse, *dto.Problem) {
-	passphraseEncoded, _ := lib.Checksum("SHA256", []byte(user.Passphrase))
+func (s *svcUser) PostUserSvc(user dto.UserData, changedBy string) (dto.UserResponse, *dto.Problem) {
+	if problem := s.checkRole(user.Role); problem != nil {
+		return dto.UserResponse{}, problem
+	}
+	passphraseEncoded, err := lib.HashPassword(user.Passphrase, s.passwordParams)
+	if err != nil {
+		return dto.UserResponse{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrCryptProc, err.Error())
+	}
 	user.Passphrase = passphraseEncoded
 	modelUser := mapper.MapUserData2ModelUser(0, user)
-	resUser, err := s.repoUser.AddUser(modelUser)
+	resUser, err := s.repoUser.AddUser(modelUser, changedBy)
+	if problem := usernameProblem(err); problem != nil {
+		return dto.UserResponse{}, problem
+	}
 	if err != nil {
 		return dto.UserResponse{}, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
 	}
 	return mapper.MapModelUser2DtoUserResponse(resUser), nil
 }
 
-func (s *svcUser) DeleteUserSvc(userID int) (dto.UserResponse, *dto.Problem) {
-	user, err := s.repoUser.RemoveUser(userID)
+// DeleteUserSvc soft delete the user and revoke its tokens. It can be restored with RestoreUserSvc
+func (s *svcUser) DeleteUserSvc(userID int, changedBy string) (dto.UserResponse, *dto.Problem) {
+	user, err := s.repoUser.RemoveUser(userID, changedBy)
 	if err != nil {
 		return dto.UserResponse{}, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
 	}
 	return mapper.MapModelUser2DtoUserResponse(user), nil
 }
 
-func (s *svcUser) InvalidateUserSvc(userID int) (dto.UserResponse, *dto.Problem) {
-	user, err := s.repoUser.InvalidateUser(userID)
+// RestoreUserSvc undo the soft delete of the user, it logs in again with its previous password and role
+func (s *svcUser) RestoreUserSvc(userID int, changedBy string) (dto.UserResponse, *dto.Problem) {
+	user, err := s.repoUser.RestoreUser(userID, changedBy)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return dto.UserResponse{}, lib.NewProblem(iris.StatusNotFound, schema.ErrNotFound, schema.ErrDetUserNotDeleted)
+	}
+	if err != nil {
+		return dto.UserResponse{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return mapper.MapModelUser2DtoUserResponse(user), nil
+}
+
+// InvalidateUserSvc remove the permissions of the user and revoke its tokens, the user can't use the API anymore
+func (s *svcUser) InvalidateUserSvc(userID int, changedBy string) (dto.UserResponse, *dto.Problem) {
+	user, err := s.repoUser.InvalidateUser(userID, changedBy)
 	if err != nil {
 		return dto.UserResponse{}, lib.NewProblem(iris.StatusExpectationFailed, schema.ErrBuntdb, err.Error())
 	}
 	return mapper.MapModelUser2DtoUserResponse(user), nil
 }
+
+// GetUserHistorySvc the changes of the user, the last one first
+func (s *svcUser) GetUserHistorySvc(userID int) ([]dto.UserChangeResponse, *dto.Problem) {
+	changes, err := s.repoUser.GetUserChanges(userID)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	history := make([]dto.UserChangeResponse, 0, len(changes))
+	for _, change := range changes {
+		history = append(history, mapper.MapModelUserChange2DtoUserChangeResponse(change))
+	}
+	return history, nil
+}
+
+// checkDateRange returns a 400 problem when the filter created_from is after its created_to. Both are validated dates,
+// so they compare as strings
+func checkDateRange(filter dto.UserFilter) *dto.Problem {
+	if filter.CreatedFrom != "" && filter.CreatedTo != "" && filter.CreatedFrom > filter.CreatedTo {
+		return lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, schema.ErrDetDateRange)
+	}
+	return nil
+}
+
+// usernameProblem returns a 409 problem when the username is taken by another user, deleted or not
+func usernameProblem(err error) *dto.Problem {
+	if errors.Is(err, repo.ErrUserDeleted) {
+		return lib.NewProblem(iris.StatusConflict, schema.ErrDuplicateKey, schema.ErrDetUserDeleted)
+	}
+	if errors.Is(err, repo.ErrUsernameTaken) {
+		return lib.NewProblem(iris.StatusConflict, schema.ErrDuplicateKey, schema.ErrDetUsernameTaken)
+	}
+	return nil
+}
+
+// checkRole returns a 400 problem if the role doesn't exist, or is the invalid role, only given by InvalidateUserSvc
+func (s *svcUser) checkRole(role string) *dto.Problem {
+	_, err := s.repoUser.GetRole(role)
+	if errors.Is(err, gorm.ErrRecordNotFound) || role == models.Role_Invalid {
+		return lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, schema.ErrDetUnknownRole+": "+role)
+	}
+	if err != nil {
+		return lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package service
+
+import (
+	"bytes"
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"dapp/service/auth"
+	"dapp/service/utils"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/kataras/iris/v12"
+)
+
+// region ======== SETUP =================================================================
+
+// maxImportRows users allowed in an import, a faculty staff list is far smaller
+const maxImportRows = 1000
+
+// importColumns the CSV columns of the import and the export, the passphrase is only imported
+var importColumns = []string{"username", "passphrase", "firstname", "lastname", "email", "rol"}
+
+var exportColumns = []string{"id", "username", "firstname", "lastname", "email", "rol", "pending", "email_verified", "created_at"}
+
+// ISvcUserImport bulk import and export of the users service interface
+type ISvcUserImport interface {
+	Import(rows []dto.UserData, dryRun, invite bool, importedBy string) (dto.ImportReport, *dto.Problem)
+	Export(filter dto.UserFilter) ([]byte, *dto.Problem)
+}
+
+// importRepo storage used by the import, implemented by repo.RepoUser
+type importRepo interface {
+	GetRoles() ([]models.Role, error)
+	GetUsersByUsernames(usernames []string) ([]models.User, error)
+	FindUsers(filter dto.UserFilter) ([]models.User, error)
+}
+
+// userWriter creates and updates the imported users, implemented by ISvcUser. Its history and token revocation apply
+type userWriter interface {
+	PostUserSvc(user dto.UserData, changedBy string) (dto.UserResponse, *dto.Problem)
+	PutUserSvc(userID int, user dto.EditUserData, changedBy string) (dto.UserResponse, *dto.Problem)
+}
+
+// inviter invites the imported users without a passphrase, implemented by auth.ISvcInvitation
+type inviter interface {
+	Invite(user dto.InvitationData, invitedBy string) (dto.UserResponse, *dto.Problem)
+}
+
+type svcUserImport struct {
+	repo           importRepo
+	users          userWriter
+	invitations    inviter
+	validate       *validator.Validate
+	passwordParams lib.PasswordParams
+}
+
+// endregion =============================================================================
+
+// NewSvcUserImport instantiate the users import and export services
+//
+// - repoUser [*RepoUser] ~ User repository instance pointer
+//
+// - svcUser [ISvcUser] ~ User service, creates and updates the imported users
+//
+// - svcInvitation [auth.ISvcInvitation] ~ Invitation service, invites the imported users without a passphrase
+//
+// - validate [*validator.Validate] ~ Validates the imported rows against dto.UserData
+//
+// - svcConf [*SvcConfig] ~ App conf instance pointer
+func NewSvcUserImport(repoUser *repo.RepoUser, svcUser ISvcUser, svcInvitation auth.ISvcInvitation, validate *validator.Validate, svcConf *utils.SvcConfig) ISvcUserImport {
+	return newSvcUserImport(repoUser, svcUser, svcInvitation, validate, svcConf)
+}
+
+func newSvcUserImport(repo importRepo, users userWriter, invitations inviter, validate *validator.Validate, svcConf *utils.SvcConfig) *svcUserImport {
+	return &svcUserImport{repo, users, invitations, validate, svcConf.PasswordParams()}
+}
+
+// region ======== METHODS ===============================================================
+
+// Import create the new users and update the existing ones, matched by username. A new user without a passphrase is
+// invited when invite is set. Every row is validated and reported on its own, a failed row doesn't stop the others.
+// With dryRun nothing is written, the report has the actions the import would take
+func (s *svcUserImport) Import(rows []dto.UserData, dryRun, invite bool, importedBy string) (dto.ImportReport, *dto.Problem) {
+	if len(rows) == 0 || len(rows) > maxImportRows {
+		return dto.ImportReport{}, lib.NewProblem(iris.StatusBadRequest, schema.ErrProcParam, fmt.Sprintf("%s (%d)", schema.ErrDetImportSize, maxImportRows))
+	}
+
+	roles, err := s.repo.GetRoles()
+	if err != nil {
+		return dto.ImportReport{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	knownRoles := make(map[string]bool)
+	for _, role := range roles {
+		knownRoles[role.Label] = role.Label != models.Role_Invalid
+	}
+	usernames := make([]string, 0, len(rows))
+	for _, row := range rows {
+		usernames = append(usernames, row.Username)
+	}
+	existing, err := s.repo.GetUsersByUsernames(usernames)
+	if err != nil {
+		return dto.ImportReport{}, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+	users := make(map[string]models.User)
+	for _, user := range existing {
+		users[user.Username] = user
+	}
+
+	report := dto.ImportReport{DryRun: dryRun, Rows: make([]dto.ImportRowResult, 0, len(rows))}
+	seen := make(map[string]bool)
+	for i, row := range rows {
+		result := dto.ImportRowResult{Row: i + 1, Username: row.Username}
+		user, exists := users[row.Username]
+		invalid := s.validate.StructExcept(row, "Passphrase") // only a new user needs a passphrase, unless invited
+		switch {
+		case seen[row.Username]:
+			result.Error = schema.ErrDetImportDuplicate
+		case invalid != nil:
+			result.Error = invalid.Error()
+		case !knownRoles[row.Role]:
+			result.Error = schema.ErrDetUnknownRole + ": " + row.Role
+		case exists:
+			result.Action, result.Error = s.update(user, row, dryRun, importedBy)
+		default:
+			result.Action, result.Error = s.create(row, dryRun, invite, importedBy)
+		}
+		seen[row.Username] = true
+
+		if result.Error != "" {
+			result.Action = schema.ImportFailed
+		}
+		switch result.Action {
+		case schema.ImportCreated:
+			report.Created++
+		case schema.ImportInvited:
+			report.Invited++
+		case schema.ImportUpdated:
+			report.Updated++
+		case schema.ImportUnchanged:
+			report.Unchanged++
+		case schema.ImportFailed:
+			report.Failed++
+		}
+		report.Rows = append(report.Rows, result)
+	}
+	return report, nil
+}
+
+// Export the users matching the filter as CSV, without their passphrases. The columns of the import are kept so the
+// file can be edited and imported again
+func (s *svcUserImport) Export(filter dto.UserFilter) ([]byte, *dto.Problem) {
+	if problem := checkDateRange(filter); problem != nil {
+		return nil, problem
+	}
+	users, err := s.repo.FindUsers(filter)
+	if err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrRepositoryOps, err.Error())
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write(exportColumns)
+	for _, user := range users {
+		created := ""
+		if !user.CreatedAt.IsZero() {
+			created = user.CreatedAt.UTC().Format(time.RFC3339)
+		}
+		_ = w.Write([]string{
+			strconv.Itoa(user.ID),
+			csvCell(user.Username),
+			csvCell(user.FirstName),
+			csvCell(user.LastName),
+			csvCell(user.Email),
+			user.Role,
+			strconv.FormatBool(user.Pending),
+			strconv.FormatBool(user.EmailVerified),
+			created,
+		})
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return nil, lib.NewProblem(iris.StatusInternalServerError, schema.ErrGeneric, err.Error())
+	}
+	return buf.Bytes(), nil
+}
+
+// endregion =============================================================================
+
+// region ======== HELPERS ===============================================================
+
+// ReadUsersCSV the users of a CSV with a header row. The columns are matched by name, in any order: username,
+// passphrase, firstname, lastname, email and rol. The passphrase column is optional and the unknown ones are ignored
+func ReadUsersCSV(r io.Reader) ([]dto.UserData, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading the CSV header: %s", err)
+	}
+	index := make(map[string]int)
+	for i, column := range header {
+		index[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))] = i // spreadsheets add a BOM
+	}
+	for _, column := range importColumns {
+		if _, ok := index[column]; !ok && column != "passphrase" {
+			return nil, fmt.Errorf("the CSV has no %s column", column)
+		}
+	}
+
+	var rows []dto.UserData
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("%s (%d)", schema.ErrDetImportSize, maxImportRows)
+		}
+		value := func(column string) string {
+			if i, ok := index[column]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		rows = append(rows, dto.UserData{
+			Username:   value("username"),
+			Passphrase: value("passphrase"),
+			FirstName:  value("firstname"),
+			LastName:   value("lastname"),
+			Email:      value("email"),
+			Role:       value("rol"),
+		})
+	}
+}
+
+// create the user of the row, or invite it when it has no passphrase. Returns the action and the error of the row
+func (s *svcUserImport) create(row dto.UserData, dryRun, invite bool, importedBy string) (string, string) {
+	action := schema.ImportCreated
+	if row.Passphrase == "" {
+		if !invite {
+			return "", schema.ErrDetPassphraseMissing
+		}
+		action = schema.ImportInvited
+	}
+	if dryRun {
+		return action, ""
+	}
+
+	var problem *dto.Problem
+	if action == schema.ImportInvited {
+		_, problem = s.invitations.Invite(dto.InvitationData{Username: row.Username, FirstName: row.FirstName,
+			LastName: row.LastName, Email: row.Email, Role: row.Role}, importedBy)
+		if problem != nil && problem.Status == iris.StatusBadGateway { // the user was created, the invitation can be resent
+			return action, problem.Detail
+		}
+	} else {
+		_, problem = s.users.PostUserSvc(row, importedBy)
+	}
+	if problem != nil {
+		return "", problem.Detail
+	}
+	return action, ""
+}
+
+// update the existing user with the fields of the row that changed. Returns the action and the error of the row
+func (s *svcUserImport) update(user models.User, row dto.UserData, dryRun bool, importedBy string) (string, string) {
+	if user.DeletedAt.Valid {
+		return "", schema.ErrDetUserDeleted
+	}
+	if user.Role == models.Role_Invalid {
+		return "", schema.ErrDetUserInvalidated
+	}
+
+	var edit dto.EditUserData
+	if row.FirstName != user.FirstName {
+		edit.FirstName = row.FirstName
+	}
+	if row.LastName != user.LastName {
+		edit.LastName = row.LastName
+	}
+	if row.Email != user.Email {
+		edit.Email = row.Email
+	}
+	if row.Role != user.Role {
+		edit.Role = row.Role
+	}
+	if row.Passphrase != "" {
+		match, _, err := lib.VerifyPassword(row.Passphrase, user.Passphrase, s.passwordParams)
+		if err != nil || !match {
+			edit.Passphrase = row.Passphrase
+		}
+	}
+	if edit == (dto.EditUserData{}) {
+		return schema.ImportUnchanged, ""
+	}
+	if dryRun {
+		return schema.ImportUpdated, ""
+	}
+	if _, problem := s.users.PutUserSvc(user.ID, edit, importedBy); problem != nil {
+		return "", problem.Detail
+	}
+	return schema.ImportUpdated, ""
+}
+
+// csvCell the value of the cell, a leading formula character is escaped so the spreadsheets don't evaluate it
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// endregion =============================================================================
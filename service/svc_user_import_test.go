@@ -0,0 +1,142 @@
+package service
+
+import (
+	"dapp/lib"
+	"dapp/schema"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"dapp/service/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// fakeImportRepo in memory importRepo, userWriter and inviter
+type fakeImportRepo struct {
+	users   map[string]models.User
+	invited []string
+}
+
+func (f *fakeImportRepo) GetRoles() ([]models.Role, error) {
+	return []models.Role{{Label: models.Role_Invalid}, {Label: models.Role_Secretary}, {Label: models.Role_Dean}}, nil
+}
+
+func (f *fakeImportRepo) GetUsersByUsernames(usernames []string) ([]models.User, error) {
+	var users []models.User
+	for _, username := range usernames {
+		if user, ok := f.users[username]; ok {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (f *fakeImportRepo) FindUsers(dto.UserFilter) ([]models.User, error) {
+	return []models.User{
+		{ID: 1, Username: "tom", FirstName: "=HYPERLINK(\"http://evil\")", Email: "tom@example.edu", Role: models.Role_Dean, CreatedAt: time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)},
+	}, nil
+}
+
+func (f *fakeImportRepo) PostUserSvc(user dto.UserData, changedBy string) (dto.UserResponse, *dto.Problem) {
+	f.users[user.Username] = models.User{ID: len(f.users) + 1, Username: user.Username, Role: user.Role}
+	return dto.UserResponse{}, nil
+}
+
+func (f *fakeImportRepo) PutUserSvc(userID int, user dto.EditUserData, changedBy string) (dto.UserResponse, *dto.Problem) {
+	for username, u := range f.users {
+		if u.ID == userID && user.Role != "" {
+			u.Role = user.Role
+			f.users[username] = u
+		}
+	}
+	return dto.UserResponse{}, nil
+}
+
+func (f *fakeImportRepo) Invite(user dto.InvitationData, invitedBy string) (dto.UserResponse, *dto.Problem) {
+	f.invited = append(f.invited, user.Username)
+	return dto.UserResponse{}, nil
+}
+
+func TestSvcUserImport(t *testing.T) {
+	conf := &utils.SvcConfig{}
+	conf.PasswordHashMemory, conf.PasswordHashIterations, conf.PasswordHashParallelism = 1024, 1, 1
+	passphrase, _ := lib.HashPassword("password1", conf.PasswordParams())
+
+	store := &fakeImportRepo{users: map[string]models.User{
+		"richard": {ID: 1, Username: "richard", Passphrase: passphrase, FirstName: "Richard", LastName: "Roe", Email: "richard@example.edu", Role: models.Role_Secretary},
+		"ghost":   {ID: 2, Username: "ghost", Role: models.Role_Dean, DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}},
+	}}
+	svc := newSvcUserImport(store, store, store, validator.New(), conf)
+
+	rows, err := ReadUsersCSV(strings.NewReader("\ufeffUsername,firstname,lastname,email,rol,passphrase,faculty\n" +
+		"richard,Richard,Roe,richard@example.edu,secretary,password1,Law\n" +
+		"richard,Richard,Roe,richard@example.edu,dean,,Law\n" +
+		"tom,Tom,Sawyer,tom@example.edu,dean,,Arts\n" +
+		"huck,Huck,Finn,not-an-email,dean,password2,Arts\n" +
+		"ghost,Casper,Ghost,ghost@example.edu,dean,,Arts\n" +
+		"ann,Ann,Smith,ann@example.edu,invalid,password3,Law\n" +
+		"joe,Joe,Doe,joe@example.edu,dean,password4,Law\n"))
+	if err != nil || len(rows) != 7 {
+		t.Fatalf("ReadUsersCSV() = %+v, %v", rows, err)
+	}
+	if _, err = ReadUsersCSV(strings.NewReader("username,email\ntom,tom@example.edu\n")); err == nil {
+		t.Error("ReadUsersCSV() of a CSV without the required columns must fail")
+	}
+
+	wantActions := func(report dto.ImportReport, actions ...string) {
+		t.Helper()
+		for i, row := range report.Rows {
+			if row.Action != actions[i] {
+				t.Errorf("row %d %s = %s (%s), want %s", row.Row, row.Username, row.Action, row.Error, actions[i])
+			}
+		}
+	}
+
+	// without invitations tom has no passphrase, the dry run writes nothing
+	report, problem := svc.Import(rows, true, false, "admin")
+	if problem != nil || !report.DryRun {
+		t.Fatalf("Import() = %+v, %+v", report, problem)
+	}
+	wantActions(report, schema.ImportUnchanged, schema.ImportFailed, schema.ImportFailed, schema.ImportFailed,
+		schema.ImportFailed, schema.ImportFailed, schema.ImportCreated)
+	if _, ok := store.users["joe"]; ok {
+		t.Error("the dry run created a user")
+	}
+
+	report, _ = svc.Import(rows, false, true, "admin")
+	wantActions(report, schema.ImportUnchanged, schema.ImportFailed, schema.ImportInvited, schema.ImportFailed,
+		schema.ImportFailed, schema.ImportFailed, schema.ImportCreated)
+	if report.Unchanged != 1 || report.Invited != 1 || report.Created != 1 || report.Failed != 4 {
+		t.Errorf("report counts = %+v", report)
+	}
+	if report.Rows[1].Error != schema.ErrDetImportDuplicate || report.Rows[4].Error != schema.ErrDetUserDeleted {
+		t.Errorf("row errors = %+v", report.Rows)
+	}
+	if _, ok := store.users["joe"]; !ok || len(store.invited) != 1 || store.invited[0] != "tom" {
+		t.Errorf("users = %+v, invited = %v", store.users, store.invited)
+	}
+
+	// upsert by username
+	report, _ = svc.Import([]dto.UserData{{Username: "richard", FirstName: "Richard", LastName: "Roe", Email: "richard@example.edu", Role: models.Role_Dean}}, false, false, "admin")
+	wantActions(report, schema.ImportUpdated)
+	if store.users["richard"].Role != models.Role_Dean {
+		t.Errorf("the role of richard was not updated: %+v", store.users["richard"])
+	}
+
+	if _, problem = svc.Import(nil, false, false, "admin"); problem == nil {
+		t.Error("Import() of no users must fail")
+	}
+
+	export, problem := svc.Export(dto.UserFilter{})
+	want := "id,username,firstname,lastname,email,rol,pending,email_verified,created_at\n" +
+		"1,tom,\"'=HYPERLINK(\"\"http://evil\"\")\",,tom@example.edu,dean,false,false,2024-01-31T10:00:00Z\n"
+	if problem != nil || string(export) != want {
+		t.Errorf("Export() = %s, %+v", export, problem)
+	}
+	if _, problem = svc.Export(dto.UserFilter{CreatedFrom: "2024-02-01", CreatedTo: "2024-01-31"}); problem == nil || problem.Detail != schema.ErrDetDateRange {
+		t.Errorf("Export() of a reversed date range = %+v, want %s", problem, schema.ErrDetDateRange)
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"dapp/lib"
+	"dapp/repo"
+	"dapp/schema/dto"
+	"dapp/schema/models"
+	"dapp/service/auth"
+	"dapp/service/utils"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestRepos the user repository and the blocklist over a migrated SQLite database of the test
+func newTestRepos(t *testing.T) (*repo.RepoUser, *repo.RepoBlocklist) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "users.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	repoUser := &repo.RepoUser{DB: db}
+	repoUser.PopulateRolTable()
+	return repoUser, &repo.RepoBlocklist{DB: db}
+}
+
+func TestSvcUserRevokesTokens(t *testing.T) {
+	t.Setenv("TEST_JWT_KEY", strings.Repeat("s", 32))
+	keys, err := lib.LoadJWTKeys(nil, "", "TEST_JWT_KEY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &utils.SvcConfig{JWT: keys}
+	conf.TkMaxAge, conf.RefreshTkMaxAge = 15, 24
+	conf.PasswordHashMemory, conf.PasswordHashIterations, conf.PasswordHashParallelism = 1024, 1, 1
+
+	tests := []struct {
+		name   string
+		change func(s ISvcUser, userID int) *dto.Problem
+	}{
+		{"role change", func(s ISvcUser, userID int) *dto.Problem {
+			_, problem := s.PutUserSvc(userID, dto.EditUserData{Role: models.Role_Secretary}, "admin")
+			return problem
+		}},
+		{"password change", func(s ISvcUser, userID int) *dto.Problem {
+			_, problem := s.PutUserSvc(userID, dto.EditUserData{Passphrase: "another passphrase"}, "admin")
+			return problem
+		}},
+		{"invalidation", func(s ISvcUser, userID int) *dto.Problem {
+			_, problem := s.InvalidateUserSvc(userID, "admin")
+			return problem
+		}},
+		{"removal", func(s ISvcUser, userID int) *dto.Problem {
+			_, problem := s.DeleteUserSvc(userID, "admin")
+			return problem
+		}},
+	}
+	for _, tt := range tests {
+		repoUser, blocklist := newTestRepos(t)
+		user := models.User{Username: "richard", Passphrase: "hash", Role: models.Role_Dean}
+		repoUser.DB.Create(&user)
+		svcToken := auth.NewSvcToken(repoUser, blocklist, conf)
+		svc := NewSvcUserReqs(repoUser, conf)
+
+		pair, problem := svcToken.IssueTokens(&dto.GrantIntentResponse{Username: "richard", Role: models.Role_Dean}, "10.0.0.1", "test")
+		if problem != nil {
+			t.Fatalf("%s: IssueTokens() = %+v", tt.name, problem)
+		}
+		if _, err = keys.Verify([]byte(pair.AccessToken), blocklist); err != nil {
+			t.Fatalf("%s: Verify() before the change = %v", tt.name, err)
+		}
+
+		if problem = tt.change(svc, user.ID); problem != nil {
+			t.Fatalf("%s: %+v", tt.name, problem)
+		}
+		if _, err = keys.Verify([]byte(pair.AccessToken), blocklist); err != jwt.ErrBlocked {
+			t.Errorf("%s: Verify() of the access token = %v, want ErrBlocked", tt.name, err)
+		}
+		if _, problem = svcToken.Refresh(pair.RefreshToken); problem == nil {
+			t.Errorf("%s: Refresh() must fail, the refresh token is revoked", tt.name)
+		}
+	}
+}
+
+func TestSvcUserKeepsTokens(t *testing.T) {
+	repoUser, blocklist := newTestRepos(t)
+	user := models.User{Username: "richard", Passphrase: "hash", Role: models.Role_Dean}
+	repoUser.DB.Create(&user)
+	svc := NewSvcUserReqs(repoUser, &utils.SvcConfig{})
+
+	if _, problem := svc.PutUserSvc(user.ID, dto.EditUserData{FirstName: "Richard"}, "admin"); problem != nil {
+		t.Fatal(problem)
+	}
+	var revocations int64
+	blocklist.DB.Model(&models.TokenRevocation{}).Count(&revocations)
+	if revocations != 0 {
+		t.Error("a change of the profile only must not revoke the tokens of the user")
+	}
+}
+
+func TestSvcUserUnknownRole(t *testing.T) {
+	repoUser, _ := newTestRepos(t)
+	user := models.User{Username: "richard", Passphrase: "hash", Role: models.Role_Dean}
+	repoUser.DB.Create(&user)
+	conf := &utils.SvcConfig{}
+	conf.PasswordHashMemory, conf.PasswordHashIterations, conf.PasswordHashParallelism = 1024, 1, 1
+	svc := NewSvcUserReqs(repoUser, conf)
+
+	// the invalid role is only given by the invalidation
+	for _, role := range []string{"deans", models.Role_Invalid} {
+		if _, problem := svc.PostUserSvc(dto.UserData{Username: "tom", Passphrase: "passphrase", Role: role}, "admin"); problem == nil || problem.Status != http.StatusBadRequest {
+			t.Errorf("PostUserSvc() with the role %q = %+v, want 400", role, problem)
+		}
+		if _, problem := svc.PutUserSvc(user.ID, dto.EditUserData{Role: role}, "admin"); problem == nil || problem.Status != http.StatusBadRequest {
+			t.Errorf("PutUserSvc() with the role %q = %+v, want 400", role, problem)
+		}
+	}
+	if stored, _ := repoUser.GetUser(user.ID); stored.Role != models.Role_Dean {
+		t.Errorf("the role was changed to %q", stored.Role)
+	}
+	if _, problem := svc.PostUserSvc(dto.UserData{Username: "tom", Passphrase: "passphrase", Role: models.Role_Rector}, "admin"); problem != nil {
+		t.Errorf("PostUserSvc() with a known role = %+v", problem)
+	}
+}
+
+func TestSvcUserUsernameTaken(t *testing.T) {
+	repoUser, _ := newTestRepos(t)
+	richard := models.User{Username: "richard", Passphrase: "hash", Role: models.Role_Dean}
+	tom := models.User{Username: "tom", Passphrase: "hash", Role: models.Role_Dean}
+	deleted := models.User{Username: "ann", Passphrase: "hash", Role: models.Role_Dean}
+	repoUser.DB.Create(&richard)
+	repoUser.DB.Create(&tom)
+	repoUser.DB.Create(&deleted)
+	repoUser.DB.Delete(&deleted)
+	svc := NewSvcUserReqs(repoUser, &utils.SvcConfig{})
+
+	for _, username := range []string{"tom", "ann"} {
+		if _, problem := svc.PutUserSvc(richard.ID, dto.EditUserData{Username: username}, "admin"); problem == nil || problem.Status != http.StatusConflict {
+			t.Errorf("PutUserSvc() with the username %q = %+v, want 409", username, problem)
+		}
+	}
+	if stored, _ := repoUser.GetUser(richard.ID); stored.Username != "richard" {
+		t.Errorf("the username was changed to %q", stored.Username)
+	}
+	if _, problem := svc.PutUserSvc(richard.ID, dto.EditUserData{Username: "richard", FirstName: "Richard"}, "admin"); problem != nil {
+		t.Errorf("PutUserSvc() keeping the username = %+v", problem)
+	}
+}
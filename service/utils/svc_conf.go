@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"net"
 
 	"dapp/lib"
 	"dapp/schema"
@@ -19,8 +20,67 @@ type conf struct { //nolint:maligned
 	DappPort string
 
 	// Cryptographic conf
-	JWTSignKey string
-	TkMaxAge   uint8
+	JWTSigningKey   string           // kid of the key that signs the new access tokens
+	JWTKeys         []lib.JWTKeyConf // signing and verification keys, empty for a HS256 key from SERVER_JWT_SIGN_KEY
+	TkMaxAge        uint8            // access token max age in minutes
+	RefreshTkMaxAge int              // refresh token max age in hours
+
+	// Password hashing (argon2id) cost, the hashes made with another cost are upgraded on the next login
+	PasswordHashMemory      uint32 // KiB
+	PasswordHashIterations  uint32
+	PasswordHashParallelism uint8
+
+	// Brute-force protection, every failed login doubles the wait before the next attempt, up to a temporary lockout
+	LoginMaxAttempts   int // failed logins of an account before it is locked
+	LoginMaxAttemptsIP int // failed logins from a client IP before it is locked
+	LoginBackoff       int // seconds to wait after the first failed login
+	LoginLockout       int // lockout in seconds, the failures older than this are forgotten
+
+	// LDAP / Active Directory authentication provider ("ldap_provider")
+	LDAPEnabled      bool
+	LDAPUrl          string          // ldap://host:389 or ldaps://host:636
+	LDAPStartTLS     bool            // upgrade the ldap:// connection to TLS
+	LDAPBindDN       string          // service account searching the users, empty for an anonymous search
+	LDAPBindPassword string          // password of the service account
+	LDAPBaseDN       string          // where the users are searched
+	LDAPUserFilter   string          // user search filter, %s is replaced by the escaped username
+	LDAPGroupAttr    string          // user attribute listing its groups
+	LDAPGroupRoles   []LDAPGroupRole // directory group -> role, the first group of the user found wins
+
+	// OpenID Connect single sign-on ("oidc_provider"), authorization code flow with PKCE
+	OIDCEnabled       bool
+	OIDCIssuer        string         // issuer URL, the endpoints are discovered from its /.well-known/openid-configuration
+	OIDCClientID      string         // client registered in the identity provider
+	OIDCClientSecret  string         // only for confidential clients, the public ones rely on PKCE
+	OIDCRedirectURL   string         // our callback (GET /api/v1/auth/oidc/callback) as registered in the identity provider
+	OIDCScopes        []string       // requested scopes
+	OIDCUsernameClaim string         // ID token claim with the username
+	OIDCRoleRules     []OIDCRoleRule // ID token claim value -> role, the first matching rule wins
+
+	// TOTP second factor, the enrolled users must type the code of their authenticator app on every login
+	TOTPIssuer              string   // service name shown by the authenticator apps
+	TOTPEnforcedRoles       []string // roles whose tokens issued without the second factor are refused on every permission
+	TOTPEnforcedPermissions []string // permissions (schema.Perm*) refused to the tokens issued without the second factor, whatever the role. The validation and invalidation when not set, [] for none
+
+	// Role based access control, role -> permissions (schema.Perm*). The roles not listed keep their built-in
+	// permissions, and the roles listed in the role_permissions table get the stored ones
+	RolePermissions   map[string][]string
+	PolicyReloadEvery int // interval in seconds between reloads of the role_permissions table, edited by any API replica
+
+	// Outgoing email, e.g. the password reset links. Without SMTPHost no email is sent
+	SMTPHost     string
+	SMTPPort     int    // 587 by default, the connection is upgraded with STARTTLS when the server offers it
+	SMTPUsername string // empty to send without authentication
+	SMTPPassword string
+	SMTPFrom     string // sender address
+
+	// Password reset by email (POST /auth/password/forgot)
+	PasswordResetURL string // page of the client app, the reset token is added as its "token" query parameter
+	PasswordResetTTL int    // minutes a reset token is valid
+
+	// User invitations (POST /users/invite), the invited users set their password activating the account
+	InvitationURL string // page of the client app, the activation token is added as its "token" query parameter
+	InvitationTTL int    // hours an invitation is valid
 
 	// STORE DB
 	StoreDBPath string
@@ -31,17 +91,50 @@ type conf struct { //nolint:maligned
 	LogDBPath   string
 	EveryTime   int
 
+	// Wallet identities certificate expiry monitoring
+	IdentityCheckEnabled bool
+	IdentityCheckEvery   int    // interval in seconds between checks
+	IdentityExpiryDays   int    // days to expiry under which an identity is reported as expiring
+	IdentityExpiryHook   string // optional URL notified with a POST of the expiring identities
+
+	// Prometheus metrics
+	MetricsAllowedNets []string // networks, in CIDR notation, of the clients allowed to scrape GET /metrics
+
 	// HLF Network & Crypto Materials
 	CppPath           string
+	WalletStore       string // where the identities are kept: "fs" (WalletFolder) or "db" (users database, always encrypted)
 	WalletFolder      string
+	WalletEncrypted   bool   // identities encrypted at rest with the key-encryption key (SERVER_WALLET_KEK or WalletKEKFile)
+	WalletKEKFile     string // file holding the base64 encoded key-encryption key
 	DappIdentityUser  string
 	DappIdentityAdmin string
+
+	// Multi-contract chaincodes
+	DefaultContract   string            // contract used for the functions not listed in FunctionContracts
+	FunctionContracts map[string]string // function name -> contract name
+}
+
+// LDAPGroupRole maps the members of a directory group, by its DN or CN, to a role label (models.Role_*)
+type LDAPGroupRole struct {
+	Group string
+	Role  string
+}
+
+// OIDCRoleRule gives the role to the users whose ID token Claim, or one of its values when it is a list, equals
+// Value. Nested claims are addressed with dots, e.g. "realm_access.roles"
+type OIDCRoleRule struct {
+	Claim string
+	Value string
+	Role  string
 }
 
 // SvcConfig exported configuration service struct
 type SvcConfig struct {
 	Path string `string:"Path to the config YAML file"`
 	conf `conf:"Configuration object"`
+	JWT  *lib.JWTKeys `jwt:"Keys that sign and verify the access tokens"`
+
+	MetricsNets []*net.IPNet `metrics:"Parsed MetricsAllowedNets"`
 }
 
 // endregion =============================================================================
@@ -51,7 +144,6 @@ func NewSvcConfig() *SvcConfig {
 	c := conf{}
 
 	var configPath = lib.GetEnvOrError(schema.EnvConfigPath)
-	var jwtSignKey = "secret__sample__with__32__chars_" // lib.GetEnvOrError(schema.EnvJWTSignKey)
 
 	exist, err := lib.FileExists(configPath)
 	if err != nil || !exist {
@@ -63,7 +155,89 @@ func NewSvcConfig() *SvcConfig {
 		panic(err)
 	} // error check
 
-	c.JWTSignKey = jwtSignKey // saving the sign key into the configuration object
+	jwtKeys, err := lib.LoadJWTKeys(c.JWTKeys, c.JWTSigningKey, schema.EnvJWTSignKey)
+	if err != nil {
+		panic(err)
+	}
+
+	if c.RefreshTkMaxAge <= 0 {
+		c.RefreshTkMaxAge = 168
+	}
+	if c.PasswordHashMemory == 0 {
+		c.PasswordHashMemory = lib.DefaultPasswordParams.Memory
+	}
+	if c.PasswordHashIterations == 0 {
+		c.PasswordHashIterations = lib.DefaultPasswordParams.Iterations
+	}
+	if c.PasswordHashParallelism == 0 {
+		c.PasswordHashParallelism = lib.DefaultPasswordParams.Parallelism
+	}
+	if c.LoginMaxAttempts <= 0 {
+		c.LoginMaxAttempts = 5
+	}
+	if c.LoginMaxAttemptsIP <= 0 {
+		c.LoginMaxAttemptsIP = 20
+	}
+	if c.LoginBackoff <= 0 {
+		c.LoginBackoff = 1
+	}
+	if c.LoginLockout <= 0 {
+		c.LoginLockout = 900
+	}
+	if c.LDAPUserFilter == "" {
+		c.LDAPUserFilter = "(&(objectClass=person)(uid=%s))"
+	}
+	if c.LDAPGroupAttr == "" {
+		c.LDAPGroupAttr = "memberOf"
+	}
+	if len(c.OIDCScopes) == 0 {
+		c.OIDCScopes = []string{"openid", "profile", "email"}
+	}
+	if c.OIDCUsernameClaim == "" {
+		c.OIDCUsernameClaim = "preferred_username"
+	}
+	if c.TOTPIssuer == "" {
+		c.TOTPIssuer = "dapp"
+	}
+	if c.TOTPEnforcedPermissions == nil { // an explicit empty list disables it
+		c.TOTPEnforcedPermissions = []string{schema.PermCertValidate, schema.PermCertInvalidate}
+	}
+	if c.PolicyReloadEvery <= 0 {
+		c.PolicyReloadEvery = 60
+	}
+	if c.SMTPPort <= 0 {
+		c.SMTPPort = 587
+	}
+	if c.PasswordResetTTL <= 0 {
+		c.PasswordResetTTL = 30
+	}
+	if c.InvitationTTL <= 0 {
+		c.InvitationTTL = 72
+	}
+	if c.IdentityCheckEvery <= 0 {
+		c.IdentityCheckEvery = 3600
+	}
+	if c.IdentityExpiryDays <= 0 {
+		c.IdentityExpiryDays = 30
+	}
+	if len(c.MetricsAllowedNets) == 0 { // only scraped from the host by default
+		c.MetricsAllowedNets = []string{"127.0.0.0/8", "::1/128"}
+	}
+	metricsNets, err := lib.ParseNetworks(c.MetricsAllowedNets)
+	if err != nil {
+		panic(fmt.Errorf("invalid MetricsAllowedNets: %w", err))
+	}
+	if c.WalletStore == "" {
+		c.WalletStore = schema.WalletStoreFS
+	}
+	if c.FunctionContracts == nil { // the paginated query lives in the "common" contract of the certificate chaincode
+		c.FunctionContracts = map[string]string{schema.QueryAssetsWithPag: schema.CommonContract}
+	}
+
+	return &SvcConfig{configPath, c, jwtKeys, metricsNets} // We are using struct composition here. Hence, the anonymous field (https://golangbot.com/inheritance/)
+}
 
-	return &SvcConfig{configPath, c} // We are using struct composition here. Hence, the anonymous field (https://golangbot.com/inheritance/)
+// PasswordParams argon2id cost of the password hashes
+func (s *SvcConfig) PasswordParams() lib.PasswordParams {
+	return lib.PasswordParams{Memory: s.PasswordHashMemory, Iterations: s.PasswordHashIterations, Parallelism: s.PasswordHashParallelism}
 }
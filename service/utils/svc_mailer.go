@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// region ======== SETUP =================================================================
+
+// Mailer sends the emails of the API, e.g. the password reset links
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// Mail an email sent by the MemoryMailer
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// SMTPMailer sends the emails through the configured SMTP server. The connection is upgraded with STARTTLS when the
+// server offers it
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// MemoryMailer keeps the emails in memory instead of sending them, for the tests
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []Mail
+}
+
+// disabledMailer refuses to send, when there is no SMTP server configured
+type disabledMailer struct{}
+
+var errMailerDisabled = errors.New("outgoing email is not configured, set SMTPHost")
+
+// endregion =============================================================================
+
+// NewMailer the SMTP mailer of the configuration. Without SMTPHost the emails are refused
+//
+// - svcConf [*SvcConfig] ~ App conf instance pointer
+func NewMailer(svcConf *SvcConfig) Mailer {
+	if svcConf.SMTPHost == "" {
+		return disabledMailer{}
+	}
+	return NewSMTPMailer(svcConf)
+}
+
+// NewSMTPMailer mailer sending through the SMTP server of the configuration
+//
+// - svcConf [*SvcConfig] ~ App conf instance pointer
+func NewSMTPMailer(svcConf *SvcConfig) *SMTPMailer {
+	m := &SMTPMailer{addr: net.JoinHostPort(svcConf.SMTPHost, strconv.Itoa(svcConf.SMTPPort)), from: svcConf.SMTPFrom}
+	if svcConf.SMTPUsername != "" {
+		m.auth = smtp.PlainAuth("", svcConf.SMTPUsername, svcConf.SMTPPassword, svcConf.SMTPHost)
+	}
+	return m
+}
+
+// region ======== METHODS ===============================================================
+
+// Send a plain text email
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return errors.New("line breaks are not allowed in the recipient or the subject")
+	}
+
+	msg := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("sending the email to %s: %s", to, err)
+	}
+	return nil
+}
+
+// Send keep the email
+func (m *MemoryMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, Mail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// Sent the emails sent so far
+func (m *MemoryMailer) Sent() []Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Mail(nil), m.sent...)
+}
+
+// Send completes the Mailer interface, always failing
+func (disabledMailer) Send(string, string, string) error {
+	return errMailerDisabled
+}
+
+// endregion =============================================================================
@@ -1 +1,2 @@
-set SERVER_CONFIG="%cd%\conf\conf.yaml"
\ No newline at end of file
+set SERVER_CONFIG="%cd%\conf\conf.yaml"
+set SERVER_JWT_SIGN_KEY="secret__sample__with__32__chars_"
\ No newline at end of file